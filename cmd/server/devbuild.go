@@ -0,0 +1,6 @@
+//go:build dev
+
+package main
+
+// devBuild is set by building with -tags dev. Only dev builds log login codes.
+const devBuild = true
@@ -1,17 +1,29 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
 	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
-	"strings"
+	"os/signal"
+	"syscall"
 	"time"
 
 	// Internal packages
+	"github.com/jasonmichels/Market-Sentry/internal/auth"
+	"github.com/jasonmichels/Market-Sentry/internal/config"
+	"github.com/jasonmichels/Market-Sentry/internal/history"
+	"github.com/jasonmichels/Market-Sentry/internal/notify"
 	"github.com/jasonmichels/Market-Sentry/internal/prices"
 	"github.com/jasonmichels/Market-Sentry/internal/sse"
 	"github.com/jasonmichels/Market-Sentry/internal/storage"
+	"github.com/jasonmichels/Market-Sentry/internal/twilio"
+	"github.com/jasonmichels/Market-Sentry/internal/utils"
 
 	// Our new route packages
 	"github.com/jasonmichels/Market-Sentry/internal/routes"
@@ -20,76 +32,346 @@ import (
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
-// global adminPhones map
-var adminPhones = make(map[string]bool)
-
 func main() {
-	// Read ADMIN_PHONES from env (comma-separated)
-	adminEnv := os.Getenv("ADMIN_PHONES")
-	if adminEnv != "" {
-		for _, p := range strings.Split(adminEnv, ",") {
-			adminPhones[strings.TrimSpace(p)] = true
+	// Settings come from defaults, an optional YAML file, the environment and
+	// flags; anything invalid stops startup here.
+	cfg, opts, err := config.Load(os.Args[1:], os.Getenv)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if opts.PrintConfig {
+		fmt.Print(cfg.Redacted())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "\ninvalid configuration:\n%v\n", err)
+			os.Exit(1)
 		}
+		return
+	}
+	if err != nil {
+		log.Fatalf("Invalid configuration:\n%v", err)
+	}
+
+	adminPhones := make(map[string]bool)
+	for _, p := range cfg.Admin.Phones {
+		adminPhones[p] = true
+	}
+
+	codeSecret, err := newCodeSecret(cfg.Auth)
+	if err != nil {
+		log.Fatalf("Failed to create login code secret: %v", err)
+	}
+	if cfg.IsLocal() && !devBuild {
+		log.Println("[Auth] Login codes are only logged by dev builds (go build -tags dev)")
 	}
 
+	keys, err := newKeySet(cfg.Auth)
+	if err != nil {
+		log.Fatalf("Failed to load JWT keys: %v", err)
+	}
+	auth.Configure(keys, cfg.Auth.TokenLifetime)
+	utils.SetLoginLimit(cfg.Auth.LoginsPerDay, cfg.Auth.LoginBurst)
+	utils.SetAPIKeyLimit(cfg.Auth.APIKeyPerMinute, cfg.Auth.APIKeyBurst)
+	twilio.Configure(twilio.Config{
+		Enabled:    cfg.Twilio.Enabled,
+		AccountSID: cfg.Twilio.AccountSID,
+		AuthToken:  cfg.Twilio.AuthToken,
+		FromNumber: cfg.Twilio.FromNumber,
+	})
+
 	// Load supported coins from coins.json
-	supportedCoins, err := loadSupportedCoins("web/data/coins.json")
+	coins, err := loadCoins(cfg.Data.CoinsPath)
+	if err != nil {
+		log.Fatalf("Failed to load %s: %v", cfg.Data.CoinsPath, err)
+	}
+	supportedCoins := make(map[string]bool, len(coins))
+	for _, c := range coins {
+		supportedCoins[c.ID] = true
+	}
+
+	// Initialize the configured store ("memory", "bolt" or "journal")
+	store, err := openStore(cfg.Storage, supportedCoins)
+	if err != nil {
+		log.Fatalf("Failed to open store: %v", err)
+	}
+	defer store.Close()
+	auth.ConfigureSessions(store, cfg.Auth.SessionLifetime)
+	auth.ConfigureAPIKeys(store)
+
+	// Price history with 1m/1h/1d rollups; retention is configurable per series
+	priceHistory := history.New(history.RetentionPolicy{
+		Raw:          cfg.History.RawRetention,
+		Minute:       cfg.History.MinuteRetention,
+		Hour:         cfg.History.HourRetention,
+		Day:          cfg.History.DayRetention,
+		MaxRawPoints: cfg.History.MaxRawPoints,
+	})
+
+	// Register the price providers for each asset type
+	providers, err := newPriceRegistry(cfg.Prices)
 	if err != nil {
-		log.Fatalf("Failed to load coins.json: %v", err)
+		log.Fatalf("Failed to configure price providers: %v", err)
 	}
 
-	// Initialize global in-memory store (assume NewMemoryStore accepts supportedCoins)
-	store := storage.NewMemoryStore(supportedCoins)
+	// Alerts only act on prices fetched within prices.maxAge
+	freshness := prices.NewFreshness(cfg.Prices.MaxAge)
 
 	// Create SSE hub
 	hub := sse.NewSSEHub()
 
-	// Start background goroutine for price updates every 10 minutes
-	go func() {
-		ticker := time.NewTicker(1 * time.Minute)
-		defer ticker.Stop()
-		for {
-			prices.UpdatePriceStore(store, hub)
-			<-ticker.C
-		}
-	}()
+	// Register the notification channels alerts can fan out to
+	channels := newNotifierRegistry(hub, cfg.SMTP)
+
+	// Deliveries go through a persistent outbox with retries
+	outbox := notify.NewOutbox(store, channels, notify.OutboxConfig{
+		Workers:     cfg.Outbox.Workers,
+		MaxAttempts: cfg.Outbox.MaxAttempts,
+	})
+	outbox.Start()
+	dispatcher := notify.NewDispatcher(channels, outbox)
+
+	// Refresh prices on a per-asset-type schedule
+	scheduler := prices.NewScheduler(prices.ScheduleConfig{
+		Interval: cfg.Prices.Interval,
+		Intervals: map[string]time.Duration{
+			"crypto": cfg.Prices.Intervals.Crypto,
+			"metal":  cfg.Prices.Intervals.Metal,
+			"stock":  cfg.Prices.Intervals.Stock,
+		},
+		Jitter: cfg.Prices.Jitter,
+	}, func(ctx context.Context, assetType string) error {
+		return prices.UpdatePriceStore(ctx, store, priceHistory, providers, dispatcher, freshness, assetType)
+	})
+	scheduler.Start()
 
 	// Create a new ServeMux
 	mux := http.NewServeMux()
 
 	// Register routes from our route files
-	routes.RegisterAuthRoutes(mux, store)
-	routes.RegisterAlertsRoutes(mux, store, hub)
-	routes.RegisterAdminRoutes(mux, store, adminPhones)
+	routes.RegisterAuthRoutes(mux, store, routes.AuthSettings{
+		OTPLifetime:         cfg.Auth.OTPLifetime,
+		OTPMaxAttempts:      cfg.Auth.OTPMaxAttempts,
+		RateLimit:           !cfg.IsLocal() || cfg.Auth.RateLimitLocal,
+		CodeSecret:          codeSecret,
+		LogCodes:            devBuild && cfg.IsLocal(),
+		VerifyFailuresPerIP: cfg.Auth.VerifyFailuresPerIP,
+		VerifyLockout:       cfg.Auth.VerifyLockout,
+		VerifyMaxLockout:    cfg.Auth.VerifyMaxLockout,
+	})
+	routes.RegisterAlertsRoutes(mux, store, hub, channels, providers, freshness)
+	routes.RegisterAdminRoutes(mux, store, adminPhones, outbox, providers, scheduler)
+	routes.RegisterSessionRoutes(mux)
+	routes.RegisterAccountRoutes(mux)
+	routes.RegisterAPIRoutes(mux, store, routes.APIDeps{
+		Channels:  channels,
+		Providers: providers,
+		Fresh:     freshness,
+		Coins:     coins,
+	})
 
 	// Additional routes: static files and Prometheus metrics
 	mux.Handle("/metrics", promhttp.Handler())
+	mux.Handle("/.well-known/jwks.json", auth.JWKSHandler())
 	mux.Handle("/data/", http.StripPrefix("/data/", http.FileServer(http.Dir("./web/data"))))
 
-	log.Println("Starting server on :8080")
-	if err := http.ListenAndServe(":8080", mux); err != nil {
-		log.Fatal(err)
+	server := &http.Server{Addr: cfg.Server.Addr, Handler: mux}
+	serveErr := make(chan error, 1)
+	go func() {
+		log.Printf("Starting server on %s", cfg.Server.Addr)
+		serveErr <- server.ListenAndServe()
+	}()
+
+	// Run until SIGINT/SIGTERM, or until the listener fails
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	select {
+	case err := <-serveErr:
+		log.Printf("Server error: %v", err)
+	case <-ctx.Done():
+		log.Println("Shutting down...")
 	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+	defer cancel()
+	shutdown(shutdownCtx, server, hub, scheduler, outbox)
+}
+
+// shutdown stops the server in dependency order: no new price runs (cancelling
+// in-flight fetches), SSE streams told to reconnect later, HTTP requests drained,
+// then queued notifications flushed. The store is closed by main's defer.
+func shutdown(ctx context.Context, server *http.Server, hub *sse.SSEHub, scheduler *prices.Scheduler, outbox *notify.Outbox) {
+	scheduler.Stop()
+	log.Println("[Shutdown] Price scheduler stopped")
+
+	// SSE streams never finish on their own, so end them before draining HTTP
+	hub.Close(`{"type":"shutdown"}`)
+	if err := server.Shutdown(ctx); err != nil {
+		log.Printf("[Shutdown] HTTP server did not drain cleanly: %v", err)
+	} else {
+		log.Println("[Shutdown] HTTP server drained")
+	}
+
+	outbox.Stop(ctx)
+	log.Println("[Shutdown] Notification outbox stopped")
 }
 
-// loadSupportedCoins reads coins.json and returns a map of coin IDs to true.
-func loadSupportedCoins(path string) (map[string]bool, error) {
+// newCodeSecret is the key login codes are hashed with: auth.otpSecret, or a
+// random one locally, in which case codes don't survive a restart.
+func newCodeSecret(cfg config.AuthConfig) ([]byte, error) {
+	if cfg.OTPSecret != "" {
+		return []byte(cfg.OTPSecret), nil
+	}
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	log.Println("[Auth] No auth.otpSecret configured; using a random secret for this run")
+	return secret, nil
+}
+
+// newKeySet collects the JWT keys: the HMAC secret and any previous secrets,
+// then the key file. The key file's signingKey, if it names one, replaces the
+// secret as the signing key; the secret then only verifies older tokens.
+func newKeySet(cfg config.AuthConfig) (*auth.KeySet, error) {
+	keys := auth.NewKeySet()
+
+	secret := []byte(cfg.JWTSecret)
+	if len(secret) == 0 && cfg.KeyFile == "" {
+		// Only allowed locally: sessions won't survive a restart
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, err
+		}
+		log.Println("[Auth] No auth.jwtSecret configured; using a random secret for this run")
+	}
+	if len(secret) > 0 {
+		k, err := auth.HMACKey("", secret)
+		if err != nil {
+			return nil, err
+		}
+		if err := keys.Add(k); err != nil {
+			return nil, err
+		}
+		if err := keys.SetSigning(k.ID); err != nil {
+			return nil, err
+		}
+	}
+	for _, old := range cfg.PreviousSecrets {
+		k, err := auth.HMACKey("", []byte(old))
+		if err != nil {
+			return nil, err
+		}
+		if err := keys.Add(k); err != nil {
+			return nil, err
+		}
+	}
+
+	if cfg.KeyFile != "" {
+		if err := keys.LoadKeyFile(cfg.KeyFile); err != nil {
+			return nil, err
+		}
+	}
+	if keys.Signing() == nil {
+		return nil, errors.New("no signing key: set auth.jwtSecret or a signingKey in auth.keyFile")
+	}
+
+	var kids []string
+	for _, k := range keys.Keys() {
+		kids = append(kids, k.ID)
+	}
+	log.Printf("[Auth] Signing tokens with %s (%s); accepting %v", keys.Signing().ID, keys.Signing().Alg, kids)
+	return keys, nil
+}
+
+// newPriceRegistry registers the price providers with their configured
+// endpoints and sets each asset type's failover chain; an empty chain keeps
+// registration order.
+func newPriceRegistry(cfg config.PricesConfig) (*prices.Registry, error) {
+	registry := prices.NewRegistry(prices.BreakerConfig{
+		FailureThreshold: cfg.Breaker.Failures,
+		OpenFor:          cfg.Breaker.OpenFor,
+	})
+	registry.Register(prices.NewCoinGecko(providerConfig(cfg.CoinGecko)))
+	registry.Register(prices.NewCoinCap(providerConfig(cfg.CoinCap)))
+	registry.Register(prices.NewMetalsDev(providerConfig(cfg.MetalsDev)))
+	registry.Register(prices.NewFMPStocks(providerConfig(cfg.FMP)))
+
+	chains := map[string][]string{
+		"crypto": cfg.Chains.Crypto,
+		"metal":  cfg.Chains.Metal,
+		"stock":  cfg.Chains.Stock,
+	}
+	for _, assetType := range []string{"crypto", "metal", "stock"} {
+		if names := chains[assetType]; len(names) > 0 {
+			if err := registry.SetChain(assetType, names); err != nil {
+				return nil, err
+			}
+		}
+		log.Printf("[Prices] %s provider chain: %v", assetType, registry.Chain(assetType))
+	}
+	return registry, nil
+}
+
+func providerConfig(c config.ProviderConfig) prices.ProviderConfig {
+	return prices.ProviderConfig{BaseURL: c.BaseURL, APIKey: c.APIKey, Timeout: c.Timeout}
+}
+
+// newNotifierRegistry registers every channel that is configured.
+// SSE and webhooks need no server-side setup; SMS needs Twilio enabled and email an SMTP host.
+func newNotifierRegistry(hub *sse.SSEHub, smtp config.SMTPConfig) *notify.Registry {
+	registry := notify.NewRegistry()
+	registry.Register(notify.NewSSENotifier(hub))
+	registry.Register(notify.NewWebhookNotifier())
+	if twilio.Enabled() {
+		registry.Register(notify.NewSMSNotifier())
+	}
+	if smtp.Host != "" {
+		registry.Register(notify.NewEmailNotifier(notify.SMTPConfig{
+			Host:     smtp.Host,
+			Port:     smtp.Port,
+			Username: smtp.Username,
+			Password: smtp.Password,
+			From:     smtp.From,
+		}))
+	}
+	log.Printf("Notification channels: %v", registry.Names())
+	return registry
+}
+
+// openStore builds the storage backend. The in-memory store is the default;
+// "bolt" keeps data in an embedded database file at path, and "journal" keeps
+// an append-only journal plus periodic snapshots in the directory at path.
+func openStore(cfg config.StorageConfig, supportedCoins map[string]bool) (storage.Store, error) {
+	path := cfg.Path
+	switch cfg.Backend {
+	case "", "memory":
+		log.Println("Using in-memory store")
+		return storage.NewMemoryStore(supportedCoins), nil
+	case "bolt":
+		if path == "" {
+			path = "data/marketsentry.db"
+		}
+		log.Printf("Using bolt store at %s", path)
+		return storage.OpenBoltStore(path, supportedCoins)
+	case "journal":
+		if path == "" {
+			path = "data/journal"
+		}
+		snapshotEvery := cfg.SnapshotInterval
+		log.Printf("Using journal store in %s (snapshot every %s)", path, snapshotEvery)
+		return storage.OpenJournalStore(path, supportedCoins, snapshotEvery)
+	}
+	return nil, fmt.Errorf("unknown storage backend %q", cfg.Backend)
+}
+
+// loadCoins reads coins.json, the list of supported crypto coins.
+func loadCoins(path string) ([]routes.Coin, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
 		return nil, err
 	}
-	type Coin struct {
-		ID     string `json:"id"`
-		Symbol string `json:"symbol"`
-		Name   string `json:"name"`
-	}
-	var coins []Coin
+	var coins []routes.Coin
 	if err := json.Unmarshal(data, &coins); err != nil {
 		return nil, err
 	}
-	supported := make(map[string]bool, len(coins))
-	for _, c := range coins {
-		supported[c.ID] = true
-	}
-	return supported, nil
+	return coins, nil
 }
@@ -7,7 +7,9 @@ require (
 	github.com/google/uuid v1.6.0
 	github.com/prometheus/client_golang v1.20.5
 	github.com/twilio/twilio-go v1.23.11
+	go.etcd.io/bbolt v1.3.11
 	golang.org/x/time v0.9.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
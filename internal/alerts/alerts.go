@@ -2,40 +2,276 @@ package alerts
 
 import (
 	"github.com/google/uuid"
+	"github.com/jasonmichels/Market-Sentry/internal/prices"
 	"github.com/jasonmichels/Market-Sentry/internal/storage"
 	"log"
+	"reflect"
 	"strconv"
+	"time"
 )
 
-func CreateAlert(store *storage.MemoryStore, phone, assetType, symbol, thresholdStr, direction string) {
-	store.Mu.Lock()
-	defer store.Mu.Unlock()
+// PercentWindows are the look-back windows offered for percent-change alerts.
+// "since" means since the alert was created.
+var PercentWindows = map[string]time.Duration{
+	"since": 0,
+	"15m":   15 * time.Minute,
+	"1h":    time.Hour,
+	"4h":    4 * time.Hour,
+	"24h":   24 * time.Hour,
+}
 
-	user := store.Users[phone]
-	if user == nil {
-		log.Printf("User %s not found\n", phone)
-		return
-	}
+// PercentMoves are the directions a percent-change alert can watch for.
+var PercentMoves = map[string]bool{
+	"up":   true,
+	"down": true,
+	"any":  true,
+}
+
+// Cooldowns are the minimum gaps offered between firings of a recurring alert.
+var Cooldowns = map[string]time.Duration{
+	"none": 0,
+	"5m":   5 * time.Minute,
+	"15m":  15 * time.Minute,
+	"1h":   time.Hour,
+	"4h":   4 * time.Hour,
+	"24h":  24 * time.Hour,
+}
+
+// MaxHysteresisPct bounds the reset band of a recurring alert.
+const MaxHysteresisPct = 50
+
+// Limits on the size of a compound alert's condition tree
+const (
+	MaxConditionLeaves = 10
+	MaxConditionDepth  = 4
+)
 
+// Recurrence makes a threshold alert recurring; the zero value fires once.
+type Recurrence struct {
+	Recurring     bool
+	Cooldown      time.Duration
+	HysteresisPct float64
+}
+
+// CreateAlert creates an alert that fires when the price crosses the threshold
+// in the given direction. If the price is already past it, the alert waits for
+// the next crossing unless fireImmediately is set.
+func CreateAlert(store storage.Store, fresh *prices.Freshness, phone, assetType, symbol, thresholdStr, direction, unit string, channels []string, recur Recurrence, fireImmediately bool) (storage.Alert, error) {
 	threshold, err := strconv.ParseFloat(thresholdStr, 64)
 	if err != nil {
 		log.Println("Error parsing threshold:", err)
-		return
+		return storage.Alert{}, err
 	}
 
 	above := direction == "above"
 
 	alert := storage.Alert{
 		ID:        generateAlertID(),
+		Kind:      storage.KindThreshold,
 		AssetType: assetType,
 		Symbol:    symbol,
+		CreatedAt: time.Now(),
 		Threshold: threshold,
 		Above:     above,
+		Unit:      unit,
+		Channels:  channels,
+
+		Recurring:     recur.Recurring,
+		Cooldown:      recur.Cooldown,
+		HysteresisPct: recur.HysteresisPct,
+
+		FireImmediately: fireImmediately,
 	}
+	alert.LastSide = currentSide(store, fresh, alert)
 
-	user.ActiveAlerts = append(user.ActiveAlerts, alert)
+	return saveAlert(store, phone, alert)
+}
+
+// CreatePercentAlert creates an alert that fires when the price moves changePct percent.
+// A zero window compares against the current price at creation; if there is no
+// fresh price yet, the first fresh price seen after creation becomes the base.
+func CreatePercentAlert(store storage.Store, fresh *prices.Freshness, phone, assetType, symbol string, changePct float64, move string, window time.Duration, unit string, channels []string) (storage.Alert, error) {
+	alert := storage.Alert{
+		ID:        generateAlertID(),
+		Kind:      storage.KindPercent,
+		AssetType: assetType,
+		Symbol:    symbol,
+		CreatedAt: time.Now(),
+		ChangePct: changePct,
+		Move:      move,
+		Window:    window,
+		Unit:      unit,
+		Channels:  channels,
+	}
+	if window == 0 {
+		alert.BasePrice = basePrice(store, fresh, alert)
+	}
+
+	return saveAlert(store, phone, alert)
+}
+
+// CreateCompoundAlert creates an alert that fires when cond becomes true. If it
+// already holds, the alert waits until it stops holding and holds again, unless
+// fireImmediately is set.
+func CreateCompoundAlert(store storage.Store, fresh *prices.Freshness, phone string, cond storage.Condition, channels []string, fireImmediately bool) (storage.Alert, error) {
+	alert := storage.Alert{
+		ID:              generateAlertID(),
+		Kind:            storage.KindCompound,
+		CreatedAt:       time.Now(),
+		Condition:       &cond,
+		Channels:        channels,
+		FireImmediately: fireImmediately,
+	}
+	alert.LastState = currentState(store, fresh, alert)
+
+	return saveAlert(store, phone, alert)
+}
+
+// CreateExpressionAlert creates an alert that fires when a rule (see package
+// rules) becomes true, like CreateCompoundAlert. bindings are the assets the
+// rule's names were resolved to when it was checked.
+func CreateExpressionAlert(store storage.Store, fresh *prices.Freshness, phone, expression string, bindings map[string]storage.AssetRef, channels []string, fireImmediately bool) (storage.Alert, error) {
+	alert := storage.Alert{
+		ID:              generateAlertID(),
+		Kind:            storage.KindExpression,
+		CreatedAt:       time.Now(),
+		Expression:      expression,
+		Bindings:        bindings,
+		Channels:        channels,
+		FireImmediately: fireImmediately,
+	}
+	alert.LastState = currentState(store, fresh, alert)
+
+	return saveAlert(store, phone, alert)
+}
 
-	user.CountActiveAlerts++
+// UpdateAlert replaces an active alert with an edited copy of it. A percent
+// alert measured since creation gets a new base price when what it watches changed,
+// and a recurring alert whose level moved is ready to fire again.
+func UpdateAlert(store storage.Store, fresh *prices.Freshness, phone string, prev, next storage.Alert) (storage.Alert, error) {
+	levelMoved := next.Threshold != prev.Threshold || next.Above != prev.Above ||
+		next.AssetType != prev.AssetType || next.Symbol != prev.Symbol || next.Unit != prev.Unit
+	if !next.Recurring || levelMoved {
+		next.AwaitingReset = false
+	}
+	if levelMoved || next.Kind != prev.Kind {
+		next.LastSide = currentSide(store, fresh, next)
+	}
+	if next.Kind != prev.Kind || !reflect.DeepEqual(next.Condition, prev.Condition) || next.Expression != prev.Expression {
+		next.LastState = currentState(store, fresh, next)
+	}
+	if next.Kind == storage.KindPercent && next.Window == 0 {
+		sameBase := prev.Kind == storage.KindPercent && prev.Window == 0 &&
+			prev.AssetType == next.AssetType && prev.Symbol == next.Symbol && prev.Unit == next.Unit
+		if !sameBase {
+			next.BasePrice = basePrice(store, fresh, next)
+		}
+	} else {
+		next.BasePrice = 0
+	}
+	if err := store.UpdateAlert(phone, next); err != nil {
+		log.Printf("Error updating alert %s for user %s: %v\n", next.ID, phone, err)
+		return storage.Alert{}, err
+	}
+	return findAlert(store, phone, next.ID)
+}
+
+// SetPaused pauses or resumes one of the user's active alerts. A paused alert
+// isn't evaluated until it is resumed.
+func SetPaused(store storage.Store, phone, alertID string, paused bool) (storage.Alert, error) {
+	if err := store.SetAlertPaused(phone, alertID, paused); err != nil {
+		log.Printf("Error pausing alert %s for user %s: %v\n", alertID, phone, err)
+		return storage.Alert{}, err
+	}
+	return findAlert(store, phone, alertID)
+}
+
+// RearmAlert puts a triggered alert back among the active ones, unpaused. A
+// percent alert measured since creation is measured again from the current fresh price.
+func RearmAlert(store storage.Store, fresh *prices.Freshness, phone, alertID string) (storage.Alert, error) {
+	user := store.GetUser(phone)
+	if user == nil {
+		return storage.Alert{}, storage.ErrUserNotFound
+	}
+	for _, a := range user.TriggeredAlerts {
+		if a.ID != alertID {
+			continue
+		}
+		if a.Kind == storage.KindPercent && a.Window == 0 {
+			a.BasePrice = basePrice(store, fresh, a)
+		}
+		a.LastSide = currentSide(store, fresh, a)
+		a.LastState = currentState(store, fresh, a)
+		if err := store.RearmAlert(phone, alertID, a.Revision, a.State()); err != nil {
+			log.Printf("Error re-arming alert %s for user %s: %v\n", alertID, phone, err)
+			return storage.Alert{}, err
+		}
+		return findAlert(store, phone, alertID)
+	}
+	return storage.Alert{}, storage.ErrAlertNotFound
+}
+
+// findAlert returns the current copy of one of the user's active alerts
+func findAlert(store storage.Store, phone, alertID string) (storage.Alert, error) {
+	user := store.GetUser(phone)
+	if user == nil {
+		return storage.Alert{}, storage.ErrUserNotFound
+	}
+	for _, a := range user.ActiveAlerts {
+		if a.ID == alertID {
+			return a, nil
+		}
+	}
+	return storage.Alert{}, storage.ErrAlertNotFound
+}
+
+// currentSide is which side of a threshold alert's level the latest price is
+// on. It is "" if there is no fresh price, since a stale one would make the
+// first fresh tick look like a crossing, for other kinds of alert, and for
+// alerts that fire immediately, so their first check can fire.
+func currentSide(store storage.Store, fresh *prices.Freshness, alert storage.Alert) string {
+	if alert.Kind == storage.KindPercent || alert.Kind == storage.KindCompound || alert.FireImmediately {
+		return ""
+	}
+	price, ok := prices.FreshPrice(store, fresh, time.Now())(alert.AssetType, alert.Symbol)
+	if !ok {
+		return ""
+	}
+	return prices.ThresholdSide(alert, prices.AlertPrice(alert, price))
+}
+
+// currentState is whether a compound or expression alert's condition holds at
+// the latest fresh prices. Like currentSide, it is "" when that can't be told
+// yet, for other kinds of alert, and for alerts that fire immediately.
+func currentState(store storage.Store, fresh *prices.Freshness, alert storage.Alert) string {
+	if (alert.Kind != storage.KindCompound && alert.Kind != storage.KindExpression) || alert.FireImmediately {
+		return ""
+	}
+	// Without the price history, rules over a window are left to the first check
+	now := time.Now()
+	met, ok := prices.EvalRule(alert, prices.FreshPrice(store, fresh, now), nil, now)
+	if !ok {
+		return ""
+	}
+	return prices.ConditionState(met)
+}
+
+// basePrice is the fresh price a percent alert measured since creation starts
+// from. It is 0 if there is no fresh price, and the first fresh check sets it.
+func basePrice(store storage.Store, fresh *prices.Freshness, alert storage.Alert) float64 {
+	price, ok := prices.FreshPrice(store, fresh, time.Now())(alert.AssetType, alert.Symbol)
+	if !ok {
+		return 0
+	}
+	return prices.AlertPrice(alert, price)
+}
+
+func saveAlert(store storage.Store, phone string, alert storage.Alert) (storage.Alert, error) {
+	if err := store.AddAlert(phone, alert); err != nil {
+		log.Printf("Error saving alert for user %s: %v\n", phone, err)
+		return storage.Alert{}, err
+	}
+	return alert, nil
 }
 
 // generateAlertID
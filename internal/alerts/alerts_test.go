@@ -0,0 +1,265 @@
+package alerts
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jasonmichels/Market-Sentry/internal/prices"
+	"github.com/jasonmichels/Market-Sentry/internal/storage"
+)
+
+const testPhone = "+15551234567"
+
+// newTestStore has a user and a checker that treats quotes over ten minutes old as stale
+func newTestStore(t *testing.T) (*storage.MemoryStore, *prices.Freshness) {
+	t.Helper()
+	store := storage.NewMemoryStore(nil)
+	if _, err := store.GetOrCreateUser(testPhone); err != nil {
+		t.Fatal(err)
+	}
+	return store, prices.NewFreshness(10 * time.Minute)
+}
+
+// setPrice stores a quote for symbol fetched age ago
+func setPrice(t *testing.T, store storage.Store, assetType, symbol string, price float64, age time.Duration) {
+	t.Helper()
+	q := storage.Quote{Price: price, FetchedAt: time.Now().Add(-age), Source: "test"}
+	if err := store.SaveQuotes(assetType, map[string]storage.Quote{symbol: q}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCreateAlertSidesOnlyFromFreshPrices(t *testing.T) {
+	store, fresh := newTestStore(t)
+
+	setPrice(t, store, "crypto", "bitcoin", 60000, time.Minute)
+	a, err := CreateAlert(store, fresh, testPhone, "crypto", "bitcoin", "50000", "above", "", nil, Recurrence{}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.LastSide != storage.SideAbove {
+		t.Errorf("side at a fresh price = %q, want above", a.LastSide)
+	}
+
+	// A stale price could be on the wrong side by now; the first fresh check decides
+	setPrice(t, store, "crypto", "bitcoin", 60000, time.Hour)
+	a, err = CreateAlert(store, fresh, testPhone, "crypto", "bitcoin", "50000", "above", "", nil, Recurrence{}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.LastSide != "" {
+		t.Errorf("side at a stale price = %q, want none", a.LastSide)
+	}
+}
+
+func TestCreateCompoundAlertStateOnlyFromFreshPrices(t *testing.T) {
+	store, fresh := newTestStore(t)
+	cond := storage.Condition{
+		Op: storage.OpAnd,
+		Children: []storage.Condition{
+			{Op: storage.OpPrice, AssetType: "crypto", Symbol: "bitcoin", Above: true, Threshold: 50000},
+			{Op: storage.OpPrice, AssetType: "crypto", Symbol: "ethereum", Above: true, Threshold: 3000},
+		},
+	}
+
+	setPrice(t, store, "crypto", "bitcoin", 60000, time.Minute)
+	setPrice(t, store, "crypto", "ethereum", 3500, time.Minute)
+	a, err := CreateCompoundAlert(store, fresh, testPhone, cond, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.LastState != storage.StateMet {
+		t.Errorf("state at fresh prices = %q, want met", a.LastState)
+	}
+
+	setPrice(t, store, "crypto", "ethereum", 3500, time.Hour)
+	a, err = CreateCompoundAlert(store, fresh, testPhone, cond, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.LastState != "" {
+		t.Errorf("state with a stale price = %q, want none", a.LastState)
+	}
+}
+
+func TestRearmAlertSidesOnlyFromFreshPrices(t *testing.T) {
+	store, fresh := newTestStore(t)
+	setPrice(t, store, "crypto", "bitcoin", 40000, time.Minute)
+	a, err := CreateAlert(store, fresh, testPhone, "crypto", "bitcoin", "50000", "above", "", nil, Recurrence{}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.TriggerAlert(testPhone, a.ID, a.Revision, storage.Notification{ID: "n1", AlertID: a.ID}); err != nil {
+		t.Fatal(err)
+	}
+
+	setPrice(t, store, "crypto", "bitcoin", 40000, time.Hour)
+	a, err = RearmAlert(store, fresh, testPhone, a.ID)
+	if err != nil {
+		t.Fatalf("RearmAlert: %v", err)
+	}
+	if a.LastSide != "" {
+		t.Errorf("side after re-arming at a stale price = %q, want none", a.LastSide)
+	}
+}
+
+func TestPercentAlertBaseOnlyFromFreshPrices(t *testing.T) {
+	store, fresh := newTestStore(t)
+
+	setPrice(t, store, "stock", "AAPL", 200, time.Minute)
+	a, err := CreatePercentAlert(store, fresh, testPhone, "stock", "AAPL", 5, "up", 0, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.BasePrice != 200 {
+		t.Errorf("base at a fresh price = %v, want 200", a.BasePrice)
+	}
+
+	// A stale base would measure the move from an outdated price
+	setPrice(t, store, "stock", "MSFT", 400, time.Hour)
+	b, err := CreatePercentAlert(store, fresh, testPhone, "stock", "MSFT", 5, "up", 0, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.BasePrice != 0 {
+		t.Errorf("base at a stale price = %v, want 0 for the first fresh check to set", b.BasePrice)
+	}
+
+	// Pointing the fresh alert at a stale symbol clears its base too
+	next := a
+	next.Symbol = "MSFT"
+	a, err = UpdateAlert(store, fresh, testPhone, a, next)
+	if err != nil {
+		t.Fatalf("UpdateAlert: %v", err)
+	}
+	if a.BasePrice != 0 {
+		t.Errorf("base after editing to a stale symbol = %v, want 0", a.BasePrice)
+	}
+
+	// Re-arming at a stale price leaves the base to the first fresh check
+	if err := store.TriggerAlert(testPhone, b.ID, b.Revision, storage.Notification{ID: "n1", AlertID: b.ID}); err != nil {
+		t.Fatal(err)
+	}
+	b, err = RearmAlert(store, fresh, testPhone, b.ID)
+	if err != nil {
+		t.Fatalf("RearmAlert: %v", err)
+	}
+	if b.BasePrice != 0 {
+		t.Errorf("base after re-arming at a stale price = %v, want 0", b.BasePrice)
+	}
+	setPrice(t, store, "stock", "MSFT", 410, 0)
+	if err := store.TriggerAlert(testPhone, b.ID, b.Revision, storage.Notification{ID: "n2", AlertID: b.ID}); err != nil {
+		t.Fatal(err)
+	}
+	if b, err = RearmAlert(store, fresh, testPhone, b.ID); err != nil || b.BasePrice != 410 {
+		t.Errorf("base after re-arming at a fresh price = %v, %v, want 410", b.BasePrice, err)
+	}
+}
+
+// counts checks the user's alert counters match their lists
+func counts(t *testing.T, store storage.Store, active, triggered int) {
+	t.Helper()
+	u := store.GetUser(testPhone)
+	if u.CountActiveAlerts != len(u.ActiveAlerts) || u.CountTriggeredAlerts != len(u.TriggeredAlerts) {
+		t.Errorf("counts %d active, %d triggered for lists of %d and %d",
+			u.CountActiveAlerts, u.CountTriggeredAlerts, len(u.ActiveAlerts), len(u.TriggeredAlerts))
+	}
+	if len(u.ActiveAlerts) != active || len(u.TriggeredAlerts) != triggered {
+		t.Errorf("%d active and %d triggered alerts, want %d and %d",
+			len(u.ActiveAlerts), len(u.TriggeredAlerts), active, triggered)
+	}
+}
+
+func TestChangesWinOverInFlightChecks(t *testing.T) {
+	edit := func(store storage.Store, fresh *prices.Freshness, a storage.Alert) error {
+		next := a
+		next.Threshold = 70000
+		_, err := UpdateAlert(store, fresh, testPhone, a, next)
+		return err
+	}
+	pause := func(store storage.Store, _ *prices.Freshness, a storage.Alert) error {
+		_, err := SetPaused(store, testPhone, a.ID, true)
+		return err
+	}
+	resume := func(store storage.Store, _ *prices.Freshness, a storage.Alert) error {
+		_, err := SetPaused(store, testPhone, a.ID, false)
+		return err
+	}
+	cases := []struct {
+		name   string
+		change func(storage.Store, *prices.Freshness, storage.Alert) error
+	}{
+		{"edit", edit},
+		{"pause", pause},
+		{"resume", resume},
+	}
+	for _, c := range cases {
+		store, fresh := newTestStore(t)
+		setPrice(t, store, "crypto", "bitcoin", 60000, time.Minute)
+		a, err := CreateAlert(store, fresh, testPhone, "crypto", "bitcoin", "50000", "above", "", nil, Recurrence{}, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// A check read the alert at a.Revision, then the user changed it
+		if err := c.change(store, fresh, a); err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if err := store.SetAlertState(testPhone, a.ID, a.Revision, storage.AlertState{LastSide: storage.SideBelow}); !errors.Is(err, storage.ErrAlertChanged) {
+			t.Errorf("%s: state saved over the change = %v, want ErrAlertChanged", c.name, err)
+		}
+		err = store.TriggerAlert(testPhone, a.ID, a.Revision, storage.Notification{ID: "n1", AlertID: a.ID})
+		if !errors.Is(err, storage.ErrAlertChanged) {
+			t.Errorf("%s: triggered on the old version = %v, want ErrAlertChanged", c.name, err)
+		}
+		counts(t, store, 1, 0)
+	}
+}
+
+func TestChangesToTriggeredAlerts(t *testing.T) {
+	store, fresh := newTestStore(t)
+	setPrice(t, store, "crypto", "bitcoin", 40000, time.Minute)
+	a, err := CreateAlert(store, fresh, testPhone, "crypto", "bitcoin", "50000", "above", "", nil, Recurrence{}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := RearmAlert(store, fresh, testPhone, a.ID); !errors.Is(err, storage.ErrAlertNotFound) {
+		t.Errorf("re-arming an active alert = %v, want ErrAlertNotFound", err)
+	}
+	if err := store.TriggerAlert(testPhone, a.ID, a.Revision, storage.Notification{ID: "n1", AlertID: a.ID}); err != nil {
+		t.Fatal(err)
+	}
+	counts(t, store, 0, 1)
+
+	// An edit or pause begun before the alert triggered finds it gone
+	next := a
+	next.Threshold = 70000
+	if _, err := UpdateAlert(store, fresh, testPhone, a, next); !errors.Is(err, storage.ErrAlertNotFound) {
+		t.Errorf("editing a triggered alert = %v, want ErrAlertNotFound", err)
+	}
+	if _, err := SetPaused(store, testPhone, a.ID, true); !errors.Is(err, storage.ErrAlertNotFound) {
+		t.Errorf("pausing a triggered alert = %v, want ErrAlertNotFound", err)
+	}
+	if err := store.RearmAlert(testPhone, a.ID, a.Revision-1, storage.AlertState{}); !errors.Is(err, storage.ErrAlertChanged) {
+		t.Errorf("re-arming an older version = %v, want ErrAlertChanged", err)
+	}
+	counts(t, store, 0, 1)
+
+	a, err = RearmAlert(store, fresh, testPhone, a.ID)
+	if err != nil {
+		t.Fatalf("RearmAlert: %v", err)
+	}
+	if _, err := RearmAlert(store, fresh, testPhone, a.ID); !errors.Is(err, storage.ErrAlertNotFound) {
+		t.Errorf("re-arming twice = %v, want ErrAlertNotFound", err)
+	}
+	counts(t, store, 1, 0)
+
+	if err := store.DeleteAlert(testPhone, a.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.DeleteAlert(testPhone, a.ID); !errors.Is(err, storage.ErrAlertNotFound) {
+		t.Errorf("deleting twice = %v, want ErrAlertNotFound", err)
+	}
+	counts(t, store, 0, 0)
+}
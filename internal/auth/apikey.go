@@ -0,0 +1,226 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jasonmichels/Market-Sentry/internal/storage"
+	"github.com/jasonmichels/Market-Sentry/internal/utils"
+)
+
+// API keys look like "ms_<16 hex id>_<secret>"; the id finds the stored key
+// and the secret is checked against its hash.
+const apiKeyPrefix = "ms_"
+
+// MaxAPIKeys is how many keys one user may hold.
+const MaxAPIKeys = 20
+
+// apiKeyTouchEvery limits how often a key's last-used time is written back
+const apiKeyTouchEvery = time.Minute
+
+var (
+	// ErrAPIKeyNotFound is returned when revoking a key the user doesn't have.
+	ErrAPIKeyNotFound = errors.New("API key not found")
+	// ErrTooManyAPIKeys is returned by CreateAPIKey once a user has MaxAPIKeys.
+	ErrTooManyAPIKeys = errors.New("too many API keys")
+)
+
+// APIKeyStore is the part of storage.Store API keys need.
+type APIKeyStore interface {
+	SaveAPIKey(key storage.APIKey) error
+	DeleteAPIKey(id string) error
+	TouchAPIKey(id string, at time.Time) error
+	GetAPIKey(id string) (storage.APIKey, bool)
+	ListAPIKeys(phone string) []storage.APIKey
+}
+
+var apiKeys APIKeyStore
+
+// ConfigureAPIKeys sets where API keys are kept.
+func ConfigureAPIKeys(store APIKeyStore) {
+	apiKeys = store
+}
+
+// CreateAPIKey issues a key for phone and returns the full key, which is
+// never stored and can't be shown again.
+func CreateAPIKey(phone, name, scope string) (string, storage.APIKey, error) {
+	if apiKeys == nil {
+		return "", storage.APIKey{}, errors.New("API keys not configured")
+	}
+	if scope != storage.ScopeRead && scope != storage.ScopeWrite {
+		return "", storage.APIKey{}, errors.New("scope must be read or write")
+	}
+	if len(apiKeys.ListAPIKeys(phone)) >= MaxAPIKeys {
+		return "", storage.APIKey{}, ErrTooManyAPIKeys
+	}
+
+	idBytes := make([]byte, 8)
+	if _, err := rand.Read(idBytes); err != nil {
+		return "", storage.APIKey{}, err
+	}
+	id := hex.EncodeToString(idBytes)
+	secret, err := randomToken(32)
+	if err != nil {
+		return "", storage.APIKey{}, err
+	}
+
+	key := storage.APIKey{
+		ID:        id,
+		Phone:     phone,
+		Name:      name,
+		Scope:     scope,
+		Hash:      hashToken(secret),
+		CreatedAt: time.Now(),
+	}
+	if err := apiKeys.SaveAPIKey(key); err != nil {
+		return "", storage.APIKey{}, err
+	}
+	return apiKeyPrefix + id + "_" + secret, key, nil
+}
+
+// RevokeAPIKey deletes one of phone's keys.
+func RevokeAPIKey(phone, id string) error {
+	if apiKeys == nil {
+		return errors.New("API keys not configured")
+	}
+	key, ok := apiKeys.GetAPIKey(id)
+	if !ok || key.Phone != phone {
+		return ErrAPIKeyNotFound
+	}
+	return apiKeys.DeleteAPIKey(id)
+}
+
+// UserAPIKeys lists phone's keys, oldest first.
+func UserAPIKeys(phone string) []storage.APIKey {
+	if apiKeys == nil {
+		return nil
+	}
+	return apiKeys.ListAPIKeys(phone)
+}
+
+// APIKeyDisplay is how a key is shown once its secret is gone: prefix and id.
+func APIKeyDisplay(key storage.APIKey) string {
+	return apiKeyPrefix + key.ID + "_…"
+}
+
+// checkAPIKey finds the stored key for a presented one
+func checkAPIKey(presented string) (storage.APIKey, bool) {
+	rest, ok := strings.CutPrefix(presented, apiKeyPrefix)
+	if !ok || apiKeys == nil {
+		return storage.APIKey{}, false
+	}
+	id, secret, ok := strings.Cut(rest, "_")
+	if !ok || secret == "" {
+		return storage.APIKey{}, false
+	}
+	key, ok := apiKeys.GetAPIKey(id)
+	if !ok || !tokensEqual(hashToken(secret), key.Hash) {
+		return storage.APIKey{}, false
+	}
+	return key, true
+}
+
+// touchAPIKey records that a key was just used, at most once a minute per key.
+// Only the last-used time is written, and not at all if the key was revoked
+// since it was checked.
+func touchAPIKey(key storage.APIKey) {
+	now := time.Now()
+	if now.Sub(key.LastUsedAt) < apiKeyTouchEvery {
+		return
+	}
+	err := apiKeys.TouchAPIKey(key.ID, now)
+	if err != nil && !errors.Is(err, storage.ErrAPIKeyNotFound) {
+		log.Printf("[Auth] Failed to record use of API key %s: %v", key.ID, err)
+	}
+}
+
+// APIMiddleware authenticates JSON API requests. It accepts an API key as
+// "Authorization: Bearer ms_…", or anything JWTMiddleware accepts. Read-only
+// keys may only make GET and HEAD requests, and every key is rate limited.
+// Failures are answered with a JSON error body.
+func APIMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		presented := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !strings.HasPrefix(presented, apiKeyPrefix) {
+			ctx, failure := authenticateSession(w, r)
+			if failure != "" {
+				WriteAPIError(w, http.StatusUnauthorized, "unauthorized", failure)
+				return
+			}
+			ctx = context.WithValue(ctx, scopeKey, storage.ScopeWrite)
+			next.ServeHTTP(w, r.WithContext(ctx))
+			return
+		}
+
+		key, ok := checkAPIKey(presented)
+		if !ok {
+			log.Printf("[Auth] Invalid API key presented")
+			WriteAPIError(w, http.StatusUnauthorized, "unauthorized", "Invalid API key")
+			return
+		}
+
+		limiter := utils.GetAPIKeyLimiter(key.ID)
+		if res := limiter.Reserve(); res.Delay() > 0 {
+			res.Cancel()
+			w.Header().Set("Retry-After", strconv.Itoa(int(res.Delay().Seconds())+1))
+			WriteAPIError(w, http.StatusTooManyRequests, "rate_limited", "Too many requests for this API key")
+			return
+		}
+
+		if key.Scope != storage.ScopeWrite && r.Method != http.MethodGet && r.Method != http.MethodHead {
+			WriteAPIError(w, http.StatusForbidden, "forbidden", "This API key is read-only")
+			return
+		}
+
+		touchAPIKey(key)
+		ctx := context.WithValue(r.Context(), phoneKey, key.Phone)
+		ctx = context.WithValue(ctx, scopeKey, key.Scope)
+		ctx = context.WithValue(ctx, apiKeyKey, key.ID)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// GetScope is the scope a request was authenticated with; signed-in users
+// have ScopeWrite.
+func GetScope(ctx context.Context) string {
+	if v, ok := ctx.Value(scopeKey).(string); ok {
+		return v
+	}
+	return storage.ScopeWrite
+}
+
+// GetAPIKeyID is the ID of the API key a request was made with, if any.
+func GetAPIKeyID(ctx context.Context) string {
+	if v, ok := ctx.Value(apiKeyKey).(string); ok {
+		return v
+	}
+	return ""
+}
+
+// APIError is the body of every JSON API error response.
+type APIError struct {
+	Error struct {
+		Code    string   `json:"code"`
+		Message string   `json:"message"`
+		Details []string `json:"details,omitempty"` // e.g. one entry per failed validation rule
+	} `json:"error"`
+}
+
+// WriteAPIError sends a JSON error with the given status, machine-readable code and message.
+func WriteAPIError(w http.ResponseWriter, status int, code, message string, details ...string) {
+	var body APIError
+	body.Error.Code = code
+	body.Error.Message = message
+	body.Error.Details = details
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
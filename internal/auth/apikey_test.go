@@ -0,0 +1,244 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/jasonmichels/Market-Sentry/internal/storage"
+	"github.com/jasonmichels/Market-Sentry/internal/utils"
+)
+
+const testPhone = "+15551234567"
+
+// newAPIKeyStore configures API keys on a fresh memory store
+func newAPIKeyStore(t *testing.T) *storage.MemoryStore {
+	t.Helper()
+	store := storage.NewMemoryStore(nil)
+	ConfigureAPIKeys(store)
+	t.Cleanup(func() { ConfigureAPIKeys(nil) })
+	return store
+}
+
+// apiRequest makes a request to /api/v1/alerts with the bearer token key
+func apiRequest(h http.Handler, method, key string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, "/api/v1/alerts", nil)
+	req.Header.Set("Authorization", "Bearer "+key)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+// errorCode is the code of a JSON API error response
+func errorCode(t *testing.T, rec *httptest.ResponseRecorder) string {
+	t.Helper()
+	var body APIError
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("error body %q: %v", rec.Body.String(), err)
+	}
+	return body.Error.Code
+}
+
+func TestCreateAndRevokeAPIKey(t *testing.T) {
+	newAPIKeyStore(t)
+	if _, _, err := CreateAPIKey(testPhone, "script", "admin"); err == nil {
+		t.Error("key with an unknown scope created")
+	}
+	full, key, err := CreateAPIKey(testPhone, "script", storage.ScopeWrite)
+	if err != nil {
+		t.Fatalf("CreateAPIKey: %v", err)
+	}
+	if !strings.HasPrefix(full, "ms_"+key.ID+"_") || strings.Contains(key.Hash, strings.TrimPrefix(full, "ms_"+key.ID+"_")) {
+		t.Errorf("key %q stored as %+v, want ms_<id>_<secret> with only a hash kept", full, key)
+	}
+	if got := APIKeyDisplay(key); got != "ms_"+key.ID+"_…" {
+		t.Errorf("display = %q", got)
+	}
+
+	for i := 1; i < MaxAPIKeys; i++ {
+		if _, _, err := CreateAPIKey(testPhone, "more", storage.ScopeRead); err != nil {
+			t.Fatalf("key %d: %v", i+1, err)
+		}
+	}
+	if _, _, err := CreateAPIKey(testPhone, "one too many", storage.ScopeRead); !errors.Is(err, ErrTooManyAPIKeys) {
+		t.Errorf("key past the limit = %v, want ErrTooManyAPIKeys", err)
+	}
+
+	if err := RevokeAPIKey("+15550000000", key.ID); !errors.Is(err, ErrAPIKeyNotFound) {
+		t.Errorf("revoking another user's key = %v, want ErrAPIKeyNotFound", err)
+	}
+	if err := RevokeAPIKey(testPhone, key.ID); err != nil {
+		t.Fatalf("RevokeAPIKey: %v", err)
+	}
+	if len(UserAPIKeys(testPhone)) != MaxAPIKeys-1 {
+		t.Errorf("keys after revoking one = %d, want %d", len(UserAPIKeys(testPhone)), MaxAPIKeys-1)
+	}
+}
+
+func TestAPIKeyScopes(t *testing.T) {
+	newAPIKeyStore(t)
+	readKey, read, err := CreateAPIKey(testPhone, "dashboard", storage.ScopeRead)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeKey, write, err := CreateAPIKey(testPhone, "script", storage.ScopeWrite)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got struct{ phone, scope, keyID string }
+	handler := APIMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got.phone, got.scope, got.keyID = GetUserPhone(r.Context()), GetScope(r.Context()), GetAPIKeyID(r.Context())
+	}))
+
+	cases := []struct {
+		name   string
+		key    string
+		method string
+		status int
+		code   string // JSON error code when refused
+		keyID  string
+	}{
+		{"read key GET", readKey, http.MethodGet, http.StatusOK, "", read.ID},
+		{"read key HEAD", readKey, http.MethodHead, http.StatusOK, "", read.ID},
+		{"read key POST", readKey, http.MethodPost, http.StatusForbidden, "forbidden", ""},
+		{"read key PATCH", readKey, http.MethodPatch, http.StatusForbidden, "forbidden", ""},
+		{"read key DELETE", readKey, http.MethodDelete, http.StatusForbidden, "forbidden", ""},
+		{"write key GET", writeKey, http.MethodGet, http.StatusOK, "", write.ID},
+		{"write key POST", writeKey, http.MethodPost, http.StatusOK, "", write.ID},
+		{"write key DELETE", writeKey, http.MethodDelete, http.StatusOK, "", write.ID},
+		{"wrong secret", "ms_" + read.ID + "_not-the-secret", http.MethodGet, http.StatusUnauthorized, "unauthorized", ""},
+		{"unknown id", "ms_0000000000000000_secret", http.MethodGet, http.StatusUnauthorized, "unauthorized", ""},
+		{"no secret", "ms_" + read.ID, http.MethodGet, http.StatusUnauthorized, "unauthorized", ""},
+	}
+	for _, c := range cases {
+		got.phone, got.scope, got.keyID = "", "", ""
+		rec := apiRequest(handler, c.method, c.key)
+		if rec.Code != c.status {
+			t.Errorf("%s: status %d, want %d", c.name, rec.Code, c.status)
+			continue
+		}
+		if c.code != "" {
+			if code := errorCode(t, rec); code != c.code {
+				t.Errorf("%s: error code %q, want %q", c.name, code, c.code)
+			}
+			if got.phone != "" {
+				t.Errorf("%s: refused request reached the handler", c.name)
+			}
+			continue
+		}
+		if got.phone != testPhone || got.keyID != c.keyID {
+			t.Errorf("%s: handler saw phone %q and key %q", c.name, got.phone, got.keyID)
+		}
+		if want := map[string]string{read.ID: storage.ScopeRead, write.ID: storage.ScopeWrite}[c.keyID]; got.scope != want {
+			t.Errorf("%s: scope %q, want %q", c.name, got.scope, want)
+		}
+	}
+}
+
+func TestAPIKeyRateLimit(t *testing.T) {
+	newAPIKeyStore(t)
+	utils.SetAPIKeyLimit(60, 3)
+	t.Cleanup(func() { utils.SetAPIKeyLimit(60, 20) })
+	busy, _, err := CreateAPIKey(testPhone, "busy", storage.ScopeRead)
+	if err != nil {
+		t.Fatal(err)
+	}
+	quiet, _, err := CreateAPIKey(testPhone, "quiet", storage.ScopeRead)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := APIMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for i := 0; i < 3; i++ {
+		if rec := apiRequest(handler, http.MethodGet, busy); rec.Code != http.StatusOK {
+			t.Fatalf("request %d within the burst = %d", i+1, rec.Code)
+		}
+	}
+	rec := apiRequest(handler, http.MethodGet, busy)
+	if rec.Code != http.StatusTooManyRequests || errorCode(t, rec) != "rate_limited" {
+		t.Fatalf("request past the burst = %d %s, want 429 rate_limited", rec.Code, rec.Body.String())
+	}
+	if retry, err := strconv.Atoi(rec.Header().Get("Retry-After")); err != nil || retry < 1 || retry > 2 {
+		t.Errorf("Retry-After = %q, want about a second at 60 a minute", rec.Header().Get("Retry-After"))
+	}
+
+	// Refused requests don't use up the allowance, and each key has its own
+	if rec := apiRequest(handler, http.MethodGet, quiet); rec.Code != http.StatusOK {
+		t.Errorf("another key of the same user = %d, want 200", rec.Code)
+	}
+	limiter := utils.GetAPIKeyLimiter(strings.Split(busy, "_")[1])
+	if tokens := limiter.Tokens(); tokens < -0.1 {
+		t.Errorf("limiter at %.2f tokens after refusals, want them not charged", tokens)
+	}
+}
+
+func TestRevokedKeyStaysRevokedAfterInFlightUse(t *testing.T) {
+	store := newAPIKeyStore(t)
+	full, key, err := CreateAPIKey(testPhone, "script", storage.ScopeRead)
+	if err != nil {
+		t.Fatalf("CreateAPIKey: %v", err)
+	}
+
+	// A request checks the key, the owner revokes it, then the request finishes
+	checked, ok := checkAPIKey(full)
+	if !ok {
+		t.Fatal("new key didn't check out")
+	}
+	if err := RevokeAPIKey(testPhone, key.ID); err != nil {
+		t.Fatalf("RevokeAPIKey: %v", err)
+	}
+	touchAPIKey(checked)
+
+	if _, ok := store.GetAPIKey(key.ID); ok {
+		t.Fatal("recording the in-flight use brought the revoked key back")
+	}
+	if _, ok := checkAPIKey(full); ok {
+		t.Error("revoked key still authenticates")
+	}
+}
+
+func TestRevokeWhileKeyInUse(t *testing.T) {
+	newAPIKeyStore(t)
+	utils.SetAPIKeyLimit(1_000_000, 1_000_000)
+	t.Cleanup(func() { utils.SetAPIKeyLimit(60, 20) })
+	full, key, err := CreateAPIKey(testPhone, "script", storage.ScopeRead)
+	if err != nil {
+		t.Fatalf("CreateAPIKey: %v", err)
+	}
+
+	handler := APIMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	request := func() int {
+		return apiRequest(handler, http.MethodGet, full).Code
+	}
+
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			for j := 0; j < 50; j++ {
+				request()
+			}
+		}()
+	}
+	close(start)
+	if err := RevokeAPIKey(testPhone, key.ID); err != nil {
+		t.Fatalf("RevokeAPIKey: %v", err)
+	}
+	wg.Wait()
+
+	if code := request(); code != http.StatusUnauthorized {
+		t.Errorf("request after revoke = %d, want 401", code)
+	}
+	if keys := UserAPIKeys(testPhone); len(keys) != 0 {
+		t.Errorf("keys after revoke = %+v, want none", keys)
+	}
+}
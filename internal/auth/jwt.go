@@ -7,37 +7,71 @@ import (
 	"github.com/golang-jwt/jwt/v4"
 )
 
-var secretKey = []byte("supersecretkey") // replace with your real secret, from config or env
+var (
+	keys          *KeySet
+	tokenLifetime = 8 * time.Hour
+)
+
+// Configure sets the keys tokens are signed and verified with and how long
+// issued tokens last. It must be called before any token is generated or parsed.
+func Configure(ks *KeySet, lifetime time.Duration) {
+	keys = ks
+	if lifetime > 0 {
+		tokenLifetime = lifetime
+	}
+}
 
-func GenerateJWT(phone string) (string, error) {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+// Keys is the configured key set, or nil before Configure.
+func Keys() *KeySet {
+	return keys
+}
+
+// TokenLifetime is how long an access token from GenerateJWT stays valid.
+func TokenLifetime() time.Duration {
+	return tokenLifetime
+}
+
+// GenerateJWT issues an access token for phone; its jti is the session it belongs to.
+func GenerateJWT(phone, sessionID string) (string, error) {
+	if keys == nil || keys.Signing() == nil {
+		return "", errors.New("JWT signing key not configured")
+	}
+	k := keys.Signing()
+	now := time.Now()
+	token := jwt.NewWithClaims(k.method(), jwt.MapClaims{
 		"phone": phone,
-		"exp":   time.Now().Add(8 * time.Hour).Unix(),
+		"jti":   sessionID,
+		"iat":   now.Unix(),
+		"exp":   now.Add(tokenLifetime).Unix(),
 	})
+	token.Header["kid"] = k.ID
 
-	return token.SignedString(secretKey)
+	return token.SignedString(k.sign)
 }
 
-func ParseJWT(tokenStr string) (string, error) {
-	token, err := jwt.Parse(tokenStr, func(t *jwt.Token) (interface{}, error) {
-		// Validate signing method
-		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, errors.New("unexpected signing method")
-		}
-		return secretKey, nil
-	})
+// ParseJWT validates an access token and returns its phone and session ID.
+func ParseJWT(tokenStr string) (phone, sessionID string, err error) {
+	if keys == nil {
+		return "", "", errors.New("JWT keys not configured")
+	}
+	// The key is chosen by kid and must match the token's alg
+	token, err := jwt.Parse(tokenStr, keys.keyFunc)
 
 	if err != nil {
-		return "", err
+		return "", "", err
 	}
 
 	// Extract claims
 	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
 		phone, ok := claims["phone"].(string)
 		if !ok {
-			return "", errors.New("phone not found in token claims")
+			return "", "", errors.New("phone not found in token claims")
+		}
+		sessionID, ok := claims["jti"].(string)
+		if !ok || sessionID == "" {
+			return "", "", errors.New("jti not found in token claims")
 		}
-		return phone, nil
+		return phone, sessionID, nil
 	}
-	return "", errors.New("invalid token claims")
+	return "", "", errors.New("invalid token claims")
 }
@@ -0,0 +1,321 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/golang-jwt/jwt/v4"
+	"gopkg.in/yaml.v3"
+)
+
+// Supported signing algorithms, as written in the "alg" header and key files.
+const (
+	AlgHS256 = "HS256"
+	AlgRS256 = "RS256"
+	AlgEdDSA = "EdDSA"
+)
+
+// Key is one JWT key. A key without a private part can only verify tokens,
+// which is how a retired asymmetric key is kept around until its tokens expire.
+type Key struct {
+	ID  string // "kid" header
+	Alg string
+
+	sign   any // []byte, *rsa.PrivateKey or ed25519.PrivateKey; nil for verify-only keys
+	verify any // []byte, *rsa.PublicKey or ed25519.PublicKey
+}
+
+// CanSign reports whether the key has its private part.
+func (k *Key) CanSign() bool {
+	return k.sign != nil
+}
+
+func (k *Key) method() jwt.SigningMethod {
+	switch k.Alg {
+	case AlgRS256:
+		return jwt.SigningMethodRS256
+	case AlgEdDSA:
+		return jwt.SigningMethodEdDSA
+	}
+	return jwt.SigningMethodHS256
+}
+
+// HMACKey builds an HS256 key. An empty kid is derived from the secret, so the
+// same secret always gets the same kid across restarts and replicas.
+func HMACKey(kid string, secret []byte) (*Key, error) {
+	if len(secret) < 32 {
+		return nil, errors.New("HMAC secret must be at least 32 bytes")
+	}
+	if kid == "" {
+		sum := sha256.Sum256(secret)
+		kid = "hs-" + hex.EncodeToString(sum[:4])
+	}
+	return &Key{ID: kid, Alg: AlgHS256, sign: secret, verify: secret}, nil
+}
+
+// KeySet holds the key new tokens are signed with and every key a token may
+// still be verified with. Rotating means adding a new signing key and keeping
+// the old one for verification until tokens signed with it have expired.
+type KeySet struct {
+	signing *Key
+	keys    map[string]*Key
+	order   []string // kids in the order they were added
+}
+
+// NewKeySet creates an empty key set.
+func NewKeySet() *KeySet {
+	return &KeySet{keys: make(map[string]*Key)}
+}
+
+// Add makes k available for verification; kids must be unique.
+func (s *KeySet) Add(k *Key) error {
+	if k.ID == "" {
+		return errors.New("key has no kid")
+	}
+	if _, ok := s.keys[k.ID]; ok {
+		return fmt.Errorf("duplicate key id %q", k.ID)
+	}
+	s.keys[k.ID] = k
+	s.order = append(s.order, k.ID)
+	return nil
+}
+
+// SetSigning selects the key new tokens are signed with.
+func (s *KeySet) SetSigning(kid string) error {
+	k, ok := s.keys[kid]
+	if !ok {
+		return fmt.Errorf("unknown signing key %q", kid)
+	}
+	if !k.CanSign() {
+		return fmt.Errorf("key %q has no private key and cannot sign", kid)
+	}
+	s.signing = k
+	return nil
+}
+
+// Signing is the key new tokens are signed with, or nil if none is set.
+func (s *KeySet) Signing() *Key {
+	return s.signing
+}
+
+// Keys lists every key in the order it was added.
+func (s *KeySet) Keys() []*Key {
+	out := make([]*Key, 0, len(s.order))
+	for _, kid := range s.order {
+		out = append(out, s.keys[kid])
+	}
+	return out
+}
+
+// keyFunc picks the verification key for a token by its kid. Tokens issued
+// before kids existed can only have been signed with the HMAC secret, which
+// is still the signing key right after upgrading.
+func (s *KeySet) keyFunc(t *jwt.Token) (interface{}, error) {
+	k := s.signing
+	if kid, _ := t.Header["kid"].(string); kid != "" {
+		var ok bool
+		if k, ok = s.keys[kid]; !ok {
+			return nil, fmt.Errorf("unknown key id %q", kid)
+		}
+	}
+	if k == nil || t.Method.Alg() != k.Alg {
+		return nil, errors.New("unexpected signing method")
+	}
+	return k.verify, nil
+}
+
+// keyFile is the on-disk key list loaded by LoadKeyFile.
+//
+//	signingKey: 2026-10
+//	keys:
+//	  - kid: 2026-10
+//	    alg: EdDSA
+//	    privateKeyFile: 2026-10.pem
+//	  - kid: 2026-04
+//	    alg: RS256
+//	    publicKeyFile: 2026-04.pub.pem
+//	  - kid: legacy
+//	    alg: HS256
+//	    secret: "at least 32 bytes of secret material"
+//
+// PEM keys may also be given inline with privateKey/publicKey. Relative file
+// paths are resolved against the key file's directory.
+type keyFile struct {
+	SigningKey string `yaml:"signingKey"`
+	Keys       []struct {
+		ID             string `yaml:"kid"`
+		Alg            string `yaml:"alg"`
+		Secret         string `yaml:"secret"`
+		PrivateKey     string `yaml:"privateKey"`
+		PrivateKeyFile string `yaml:"privateKeyFile"`
+		PublicKey      string `yaml:"publicKey"`
+		PublicKeyFile  string `yaml:"publicKeyFile"`
+	} `yaml:"keys"`
+}
+
+// LoadKeyFile adds the keys listed in the YAML file at path to s, and makes
+// the file's signingKey the signing key if it names one.
+func (s *KeySet) LoadKeyFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read key file: %w", err)
+	}
+	var f keyFile
+	if err := yaml.Unmarshal(data, &f); err != nil {
+		return fmt.Errorf("key file %s: %w", path, err)
+	}
+
+	dir := filepath.Dir(path)
+	pem := func(inline, file string) ([]byte, error) {
+		if inline != "" || file == "" {
+			return []byte(inline), nil
+		}
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(dir, file)
+		}
+		return os.ReadFile(file)
+	}
+
+	for i, kc := range f.Keys {
+		if kc.ID == "" {
+			return fmt.Errorf("key file %s: key %d has no kid", path, i+1)
+		}
+		priv, err := pem(kc.PrivateKey, kc.PrivateKeyFile)
+		if err != nil {
+			return fmt.Errorf("key %q: %w", kc.ID, err)
+		}
+		pub, err := pem(kc.PublicKey, kc.PublicKeyFile)
+		if err != nil {
+			return fmt.Errorf("key %q: %w", kc.ID, err)
+		}
+		k, err := parseKey(kc.ID, kc.Alg, []byte(kc.Secret), priv, pub)
+		if err != nil {
+			return fmt.Errorf("key %q: %w", kc.ID, err)
+		}
+		if err := s.Add(k); err != nil {
+			return err
+		}
+	}
+
+	if f.SigningKey != "" {
+		return s.SetSigning(f.SigningKey)
+	}
+	return nil
+}
+
+// parseKey builds a key from a secret (HS256) or PEM private/public key (RS256, EdDSA)
+func parseKey(kid, alg string, secret, priv, pub []byte) (*Key, error) {
+	k := &Key{ID: kid, Alg: alg}
+	switch alg {
+	case AlgHS256:
+		return HMACKey(kid, secret)
+
+	case AlgRS256:
+		if len(priv) > 0 {
+			key, err := jwt.ParseRSAPrivateKeyFromPEM(priv)
+			if err != nil {
+				return nil, err
+			}
+			k.sign, k.verify = key, &key.PublicKey
+			return k, nil
+		}
+		if len(pub) > 0 {
+			key, err := jwt.ParseRSAPublicKeyFromPEM(pub)
+			if err != nil {
+				return nil, err
+			}
+			k.verify = key
+			return k, nil
+		}
+
+	case AlgEdDSA:
+		if len(priv) > 0 {
+			key, err := jwt.ParseEdPrivateKeyFromPEM(priv)
+			if err != nil {
+				return nil, err
+			}
+			edKey, ok := key.(ed25519.PrivateKey)
+			if !ok {
+				return nil, errors.New("not an Ed25519 private key")
+			}
+			k.sign, k.verify = edKey, edKey.Public()
+			return k, nil
+		}
+		if len(pub) > 0 {
+			key, err := jwt.ParseEdPublicKeyFromPEM(pub)
+			if err != nil {
+				return nil, err
+			}
+			k.verify = key
+			return k, nil
+		}
+
+	default:
+		return nil, fmt.Errorf("unsupported alg %q (want %s, %s or %s)", alg, AlgHS256, AlgRS256, AlgEdDSA)
+	}
+	return nil, errors.New("no private or public key given")
+}
+
+// JWK is one public key in a JWKS document (RFC 7517).
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	Crv string `json:"crv,omitempty"` // OKP
+	X   string `json:"x,omitempty"`   // OKP
+	N   string `json:"n,omitempty"`   // RSA
+	E   string `json:"e,omitempty"`   // RSA
+}
+
+// JWKS is the public key set other services verify tokens with.
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS publishes the public half of every asymmetric key. HMAC keys are
+// shared secrets and are never included.
+func (s *KeySet) JWKS() JWKS {
+	b64 := base64.RawURLEncoding.EncodeToString
+	out := JWKS{Keys: []JWK{}}
+	for _, k := range s.Keys() {
+		switch pub := k.verify.(type) {
+		case *rsa.PublicKey:
+			out.Keys = append(out.Keys, JWK{
+				Kty: "RSA", Kid: k.ID, Alg: k.Alg, Use: "sig",
+				N: b64(pub.N.Bytes()),
+				E: b64(big.NewInt(int64(pub.E)).Bytes()),
+			})
+		case ed25519.PublicKey:
+			out.Keys = append(out.Keys, JWK{
+				Kty: "OKP", Kid: k.ID, Alg: k.Alg, Use: "sig",
+				Crv: "Ed25519",
+				X:   b64(pub),
+			})
+		}
+	}
+	return out
+}
+
+// JWKSHandler serves the key set's public keys, for /.well-known/jwks.json.
+func JWKSHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		set := JWKS{Keys: []JWK{}}
+		if keys != nil {
+			set = keys.JWKS()
+		}
+		_ = json.NewEncoder(w).Encode(set)
+	})
+}
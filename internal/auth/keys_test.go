@@ -0,0 +1,260 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+var testSecret = []byte("a test secret that is at least 32 bytes long")
+
+// pemFile writes a DER key as PEM into dir and returns its name
+func pemFile(t *testing.T, dir, name, blockType string, der []byte) string {
+	t.Helper()
+	data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	if err := os.WriteFile(filepath.Join(dir, name), data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+// writeKeyFile writes a key file with an Ed25519 signing key "new", a
+// verify-only RSA key "old" and an HMAC key "legacy", returning its path and
+// the public keys
+func writeKeyFile(t *testing.T) (string, ed25519.PublicKey, *rsa.PublicKey) {
+	t.Helper()
+	dir := t.TempDir()
+	edPub, edPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edDER, err := x509.MarshalPKCS8PrivateKey(edPriv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaDER, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	yaml := `signingKey: new
+keys:
+  - kid: new
+    alg: EdDSA
+    privateKeyFile: ` + pemFile(t, dir, "new.pem", "PRIVATE KEY", edDER) + `
+  - kid: old
+    alg: RS256
+    publicKeyFile: ` + pemFile(t, dir, "old.pub.pem", "PUBLIC KEY", rsaDER) + `
+  - kid: legacy
+    alg: HS256
+    secret: "` + string(testSecret) + `"
+`
+	path := filepath.Join(dir, "keys.yaml")
+	if err := os.WriteFile(path, []byte(yaml), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path, edPub, &rsaKey.PublicKey
+}
+
+// hmacKeySet has one HMAC key, signing
+func hmacKeySet(t *testing.T, kid string) *KeySet {
+	t.Helper()
+	ks := NewKeySet()
+	k, err := HMACKey(kid, testSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ks.Add(k); err != nil {
+		t.Fatal(err)
+	}
+	if err := ks.SetSigning(k.ID); err != nil {
+		t.Fatal(err)
+	}
+	return ks
+}
+
+// sign issues a token for testPhone with ks, checking its kid header
+func sign(t *testing.T, ks *KeySet) string {
+	t.Helper()
+	Configure(ks, time.Hour)
+	t.Cleanup(func() { Configure(nil, 0) })
+	token, err := GenerateJWT(testPhone, "s1")
+	if err != nil {
+		t.Fatalf("GenerateJWT: %v", err)
+	}
+	parsed, _, err := new(jwt.Parser).ParseUnverified(token, jwt.MapClaims{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kid := parsed.Header["kid"]; kid != ks.Signing().ID {
+		t.Errorf("kid = %v, want %q", kid, ks.Signing().ID)
+	}
+	return token
+}
+
+// verify checks token against ks
+func verify(ks *KeySet, token string) error {
+	Configure(ks, 0)
+	defer Configure(nil, 0)
+	_, _, err := ParseJWT(token)
+	return err
+}
+
+func TestHMACKeyIDFromSecret(t *testing.T) {
+	a, err := HMACKey("", testSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := HMACKey("", testSecret)
+	if a.ID == "" || a.ID != b.ID || !strings.HasPrefix(a.ID, "hs-") {
+		t.Errorf("derived kids = %q, %q, want the same hs- kid", a.ID, b.ID)
+	}
+	if _, err := HMACKey("short", []byte("too short")); err == nil {
+		t.Error("short HMAC secret accepted")
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	path, _, _ := writeKeyFile(t)
+	before := hmacKeySet(t, "legacy")
+	oldToken := sign(t, before)
+
+	// After rotating, new tokens are signed with the new key while tokens from
+	// the old one still verify until it is dropped
+	after := NewKeySet()
+	if err := after.LoadKeyFile(path); err != nil {
+		t.Fatalf("LoadKeyFile: %v", err)
+	}
+	if after.Signing() == nil || after.Signing().ID != "new" || after.Signing().Alg != AlgEdDSA {
+		t.Fatalf("signing key = %+v, want new", after.Signing())
+	}
+	newToken := sign(t, after)
+	if err := verify(after, newToken); err != nil {
+		t.Errorf("new token: %v", err)
+	}
+	if err := verify(after, oldToken); err != nil {
+		t.Errorf("token from the previous key: %v", err)
+	}
+	if err := verify(before, newToken); err == nil {
+		t.Error("a key set without the new key verified its token")
+	}
+
+	dropped := NewKeySet()
+	for _, k := range after.Keys() {
+		if k.ID != "legacy" {
+			if err := dropped.Add(k); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := dropped.SetSigning("new"); err != nil {
+		t.Fatal(err)
+	}
+	if err := verify(dropped, oldToken); err == nil || !strings.Contains(err.Error(), `unknown key id "legacy"`) {
+		t.Errorf("token from a dropped key = %v, want an unknown key id", err)
+	}
+}
+
+func TestVerifyChecksKeyAndAlg(t *testing.T) {
+	ks := hmacKeySet(t, "legacy")
+	claims := jwt.MapClaims{"phone": testPhone, "jti": "s1", "exp": time.Now().Add(time.Hour).Unix()}
+
+	// Tokens from before kids verify with the HMAC signing key
+	noKid, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(testSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := verify(ks, noKid); err != nil {
+		t.Errorf("token without a kid: %v", err)
+	}
+
+	path, _, rsaPub := writeKeyFile(t)
+	rotated := NewKeySet()
+	if err := rotated.LoadKeyFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := verify(rotated, noKid); err == nil {
+		t.Error("token without a kid verified against an EdDSA signing key")
+	}
+
+	// An HS256 token claiming the RSA key, signed with its public key as the secret
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	forged.Header["kid"] = "old"
+	pubDER, _ := x509.MarshalPKIXPublicKey(rsaPub)
+	forgedToken, err := forged.SignedString(pubDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := verify(rotated, forgedToken); err == nil {
+		t.Error("HS256 token verified with an RS256 key")
+	}
+
+	if err := rotated.SetSigning("old"); err == nil {
+		t.Error("verify-only key made the signing key")
+	}
+	if err := rotated.SetSigning("missing"); err == nil {
+		t.Error("unknown key made the signing key")
+	}
+	if k, _ := HMACKey("new", testSecret); rotated.Add(k) == nil {
+		t.Error("duplicate kid added")
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	path, edPub, rsaPub := writeKeyFile(t)
+	ks := NewKeySet()
+	if err := ks.LoadKeyFile(path); err != nil {
+		t.Fatal(err)
+	}
+	Configure(ks, 0)
+	t.Cleanup(func() { Configure(nil, 0) })
+
+	rec := httptest.NewRecorder()
+	JWKSHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil))
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	var set JWKS
+	if err := json.Unmarshal(rec.Body.Bytes(), &set); err != nil {
+		t.Fatalf("decode JWKS: %v", err)
+	}
+
+	// The HMAC secret is never published
+	if len(set.Keys) != 2 {
+		t.Fatalf("JWKS keys = %+v, want the EdDSA and RSA keys only", set.Keys)
+	}
+	b64 := base64.RawURLEncoding.EncodeToString
+	ed, rs := set.Keys[0], set.Keys[1]
+	if ed.Kid != "new" || ed.Kty != "OKP" || ed.Alg != AlgEdDSA || ed.Use != "sig" || ed.Crv != "Ed25519" || ed.X != b64(edPub) {
+		t.Errorf("EdDSA JWK = %+v", ed)
+	}
+	if rs.Kid != "old" || rs.Kty != "RSA" || rs.Alg != AlgRS256 || rs.Use != "sig" ||
+		rs.N != b64(rsaPub.N.Bytes()) || rs.E != b64(big.NewInt(int64(rsaPub.E)).Bytes()) {
+		t.Errorf("RSA JWK = %+v", rs)
+	}
+
+	Configure(nil, 0)
+	rec = httptest.NewRecorder()
+	JWKSHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil))
+	if body := strings.TrimSpace(rec.Body.String()); body != `{"keys":[]}` {
+		t.Errorf("JWKS without keys = %s, want an empty set", body)
+	}
+}
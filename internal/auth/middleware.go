@@ -10,48 +10,84 @@ import (
 // phoneKeyType is a custom type for storing the phone in context.
 type phoneKeyType string
 
-var phoneKey phoneKeyType = "phone"
+var (
+	phoneKey   phoneKeyType = "phone"
+	sessionKey phoneKeyType = "session"
+	scopeKey   phoneKeyType = "scope"
+	apiKeyKey  phoneKeyType = "apiKey"
+)
 
-// JWTMiddleware checks the cookie (or header) for a JWT, validates it, and sets user phone in context.
+// JWTMiddleware checks the cookie (or header) for a JWT, validates it and its
+// session, and sets user phone in context. A browser whose access token has
+// expired is signed back in with its refresh cookie, which is rotated.
 func JWTMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		var tokenStr string
-
-		// Prefer reading from Cookie
-		cookie, err := r.Cookie("marketsentry")
-		if err == nil {
-			log.Printf("[Auth] Found 'token' cookie")
-			tokenStr = cookie.Value
-		} else {
-			log.Printf("[Auth] No 'token' cookie found, checking 'Authorization' header")
-			// Alternatively, check Authorization header: "Bearer <token>"
-			authHeader := r.Header.Get("Authorization")
-			if strings.HasPrefix(authHeader, "Bearer ") {
-				tokenStr = strings.TrimPrefix(authHeader, "Bearer ")
-				log.Printf("[Auth] Found Bearer token in header")
-			}
+		ctx, failure := authenticateSession(w, r)
+		if failure != "" {
+			http.Error(w, failure, http.StatusUnauthorized)
+			return
 		}
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
 
-		if tokenStr == "" {
-			log.Printf("[Auth] No token found; user is unauthorized")
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
+// authenticateSession does JWTMiddleware's checks, returning the request
+// context with the user's phone and session, or why the request is refused.
+func authenticateSession(w http.ResponseWriter, r *http.Request) (context.Context, string) {
+	var tokenStr string
+
+	// Prefer reading from Cookie
+	cookie, err := r.Cookie(AccessCookie)
+	if err == nil {
+		log.Printf("[Auth] Found 'token' cookie")
+		tokenStr = cookie.Value
+	} else {
+		log.Printf("[Auth] No 'token' cookie found, checking 'Authorization' header")
+		// Alternatively, check Authorization header: "Bearer <token>"
+		authHeader := r.Header.Get("Authorization")
+		if strings.HasPrefix(authHeader, "Bearer ") {
+			tokenStr = strings.TrimPrefix(authHeader, "Bearer ")
+			log.Printf("[Auth] Found Bearer token in header")
 		}
+	}
 
+	var phone, sessionID string
+	if tokenStr != "" {
 		// Attempt to parse/validate JWT
-		phone, err := ParseJWT(tokenStr)
+		phone, sessionID, err = ParseJWT(tokenStr)
 		if err != nil {
 			log.Printf("[Auth] Invalid token: %v", err)
-			http.Error(w, "Invalid token", http.StatusUnauthorized)
-			return
+		} else if err = checkSession(sessionID, phone); err != nil {
+			// A revoked session can't be refreshed either
+			log.Printf("[Auth] Token for ended session %s rejected", sessionID)
+			ClearSessionCookies(w)
+			return nil, "Session ended"
 		}
+	}
 
-		log.Printf("[Auth] Token validated. Phone: %s", phone)
+	if tokenStr == "" || err != nil {
+		refresh, cerr := r.Cookie(RefreshCookie)
+		if cerr != nil {
+			log.Printf("[Auth] No valid token found; user is unauthorized")
+			return nil, "Unauthorized"
+		}
+		tokens, rerr := Refresh(refresh.Value)
+		if rerr != nil {
+			log.Printf("[Auth] Refresh failed: %v", rerr)
+			ClearSessionCookies(w)
+			return nil, "Unauthorized"
+		}
+		SetSessionCookies(w, tokens)
+		phone, sessionID = tokens.Phone, tokens.SessionID
+		log.Printf("[Auth] Session %s refreshed", sessionID)
+	}
 
-		// Store phone in context
-		ctx := context.WithValue(r.Context(), phoneKey, phone)
-		next.ServeHTTP(w, r.WithContext(ctx))
-	})
+	log.Printf("[Auth] Token validated. Phone: %s", phone)
+
+	// Store phone and session in context
+	ctx := context.WithValue(r.Context(), phoneKey, phone)
+	ctx = context.WithValue(ctx, sessionKey, sessionID)
+	return ctx, ""
 }
 
 // GetUserPhone extracts the phone from context
@@ -61,3 +97,11 @@ func GetUserPhone(ctx context.Context) string {
 	}
 	return ""
 }
+
+// GetSessionID extracts the ID of the session the request was made in
+func GetSessionID(ctx context.Context) string {
+	if v, ok := ctx.Value(sessionKey).(string); ok {
+		return v
+	}
+	return ""
+}
@@ -0,0 +1,256 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jasonmichels/Market-Sentry/internal/storage"
+	"github.com/jasonmichels/Market-Sentry/internal/utils"
+)
+
+// Cookie names: the short-lived access token and the refresh token that renews it.
+const (
+	AccessCookie  = "marketsentry"
+	RefreshCookie = "marketsentry_refresh"
+)
+
+// refreshGrace is how long a just-rotated refresh token is still honoured, so
+// parallel requests from one page don't look like a replayed token.
+const refreshGrace = 30 * time.Second
+
+var (
+	// ErrSessionNotFound is returned for a token whose session has ended or been revoked.
+	ErrSessionNotFound = errors.New("session not found")
+	// ErrRefreshReused is returned when a refresh token that was already rotated
+	// is presented again; the session is revoked since the token may be stolen.
+	ErrRefreshReused = errors.New("refresh token reused")
+)
+
+// SessionStore is the part of storage.Store sessions need.
+type SessionStore interface {
+	SaveSession(session storage.Session) error
+	UpdateSession(session storage.Session) error
+	DeleteSession(id string) error
+	GetSession(id string) (storage.Session, bool)
+	ListSessions(phone string) []storage.Session
+}
+
+var (
+	sessions        SessionStore
+	sessionLifetime = 30 * 24 * time.Hour
+
+	// refreshMu makes check-and-rotate of a refresh token atomic between
+	// refreshes; sign-outs don't take it, since the rotation only updates a
+	// session that still exists
+	refreshMu sync.Mutex
+)
+
+// ConfigureSessions sets where sessions are kept and how long one lasts
+// without being refreshed. It must be called before any session is started.
+func ConfigureSessions(store SessionStore, lifetime time.Duration) {
+	sessions = store
+	if lifetime > 0 {
+		sessionLifetime = lifetime
+	}
+}
+
+// Tokens is what a sign-in or refresh hands back to the client.
+type Tokens struct {
+	AccessToken  string    `json:"accessToken"`
+	RefreshToken string    `json:"refreshToken,omitempty"` // empty when a racing request already rotated it
+	ExpiresAt    time.Time `json:"expiresAt"`              // of the access token
+	SessionID    string    `json:"-"`
+	Phone        string    `json:"-"`
+}
+
+// StartSession records a new session for phone and issues its first tokens.
+func StartSession(phone string, r *http.Request) (Tokens, error) {
+	if sessions == nil {
+		return Tokens{}, errors.New("sessions not configured")
+	}
+	id, err := randomToken(16)
+	if err != nil {
+		return Tokens{}, err
+	}
+	secret, err := randomToken(32)
+	if err != nil {
+		return Tokens{}, err
+	}
+	now := time.Now()
+	s := storage.Session{
+		ID:          id,
+		Phone:       phone,
+		RefreshHash: hashToken(secret),
+		RotatedAt:   now,
+		CreatedAt:   now,
+		LastSeenAt:  now,
+		ExpiresAt:   now.Add(sessionLifetime),
+		UserAgent:   r.UserAgent(),
+		IP:          utils.ClientIP(r),
+	}
+	if err := sessions.SaveSession(s); err != nil {
+		return Tokens{}, err
+	}
+	access, err := GenerateJWT(phone, id)
+	if err != nil {
+		return Tokens{}, err
+	}
+	return Tokens{AccessToken: access, RefreshToken: id + "." + secret, ExpiresAt: now.Add(tokenLifetime), SessionID: id, Phone: s.Phone}, nil
+}
+
+// Refresh exchanges a refresh token for a new access token and a new refresh
+// token; the presented one stops working. The session's expiry slides forward.
+func Refresh(refreshToken string) (Tokens, error) {
+	if sessions == nil {
+		return Tokens{}, errors.New("sessions not configured")
+	}
+	id, secret, ok := strings.Cut(refreshToken, ".")
+	if !ok || id == "" || secret == "" {
+		return Tokens{}, errors.New("malformed refresh token")
+	}
+
+	refreshMu.Lock()
+	defer refreshMu.Unlock()
+
+	s, ok := sessions.GetSession(id)
+	now := time.Now()
+	if !ok || !now.Before(s.ExpiresAt) {
+		return Tokens{}, ErrSessionNotFound
+	}
+
+	hash := hashToken(secret)
+	switch {
+	case tokensEqual(hash, s.RefreshHash):
+		next, err := randomToken(32)
+		if err != nil {
+			return Tokens{}, err
+		}
+		s.PrevRefreshHash = s.RefreshHash
+		s.RefreshHash = hashToken(next)
+		s.RotatedAt = now
+		s.LastSeenAt = now
+		s.ExpiresAt = now.Add(sessionLifetime)
+		// A sign-out since GetSession wins; the rotation must not restore the session
+		if err := sessions.UpdateSession(s); err != nil {
+			if errors.Is(err, storage.ErrSessionNotFound) {
+				return Tokens{}, ErrSessionNotFound
+			}
+			return Tokens{}, err
+		}
+		access, err := GenerateJWT(s.Phone, s.ID)
+		if err != nil {
+			return Tokens{}, err
+		}
+		return Tokens{AccessToken: access, RefreshToken: id + "." + next, ExpiresAt: now.Add(tokenLifetime), SessionID: id, Phone: s.Phone}, nil
+
+	case tokensEqual(hash, s.PrevRefreshHash) && now.Sub(s.RotatedAt) < refreshGrace:
+		// Another request just rotated this token and carries the new one
+		access, err := GenerateJWT(s.Phone, s.ID)
+		if err != nil {
+			return Tokens{}, err
+		}
+		return Tokens{AccessToken: access, ExpiresAt: now.Add(tokenLifetime), SessionID: id, Phone: s.Phone}, nil
+	}
+
+	log.Printf("[Auth] Refresh token reuse on session %s for %s; revoking session", s.ID, s.Phone)
+	if err := sessions.DeleteSession(s.ID); err != nil {
+		log.Printf("[Auth] Failed to revoke session %s: %v", s.ID, err)
+	}
+	return Tokens{}, ErrRefreshReused
+}
+
+// EndSession revokes a session and every token issued for it.
+func EndSession(id string) error {
+	if sessions == nil {
+		return errors.New("sessions not configured")
+	}
+	return sessions.DeleteSession(id)
+}
+
+// UserSessions lists phone's live sessions, most recently seen first, and
+// clears out any that have expired.
+func UserSessions(phone string) []storage.Session {
+	if sessions == nil {
+		return nil
+	}
+	now := time.Now()
+	var live []storage.Session
+	for _, s := range sessions.ListSessions(phone) {
+		if now.Before(s.ExpiresAt) {
+			live = append(live, s)
+			continue
+		}
+		if err := sessions.DeleteSession(s.ID); err != nil {
+			log.Printf("[Auth] Failed to remove expired session %s: %v", s.ID, err)
+		}
+	}
+	return live
+}
+
+// checkSession confirms the session a token names is still live and belongs to phone.
+func checkSession(id, phone string) error {
+	if sessions == nil {
+		return errors.New("sessions not configured")
+	}
+	s, ok := sessions.GetSession(id)
+	if !ok || s.Phone != phone || !time.Now().Before(s.ExpiresAt) {
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+// SetSessionCookies stores the tokens in the browser. A missing refresh token
+// (see Tokens) leaves the refresh cookie as it is.
+func SetSessionCookies(w http.ResponseWriter, t Tokens) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     AccessCookie,
+		Value:    t.AccessToken,
+		Path:     "/",
+		Expires:  t.ExpiresAt,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	if t.RefreshToken != "" {
+		http.SetCookie(w, &http.Cookie{
+			Name:     RefreshCookie,
+			Value:    t.RefreshToken,
+			Path:     "/",
+			Expires:  time.Now().Add(sessionLifetime),
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		})
+	}
+}
+
+// ClearSessionCookies removes both session cookies.
+func ClearSessionCookies(w http.ResponseWriter) {
+	for _, name := range []string{AccessCookie, RefreshCookie} {
+		http.SetCookie(w, &http.Cookie{Name: name, Value: "", Path: "/", MaxAge: -1, HttpOnly: true})
+	}
+}
+
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func tokensEqual(a, b string) bool {
+	return b != "" && subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
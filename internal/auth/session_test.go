@@ -0,0 +1,162 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jasonmichels/Market-Sentry/internal/storage"
+)
+
+// configureTestKeys signs tokens with a throwaway HMAC key
+func configureTestKeys(t *testing.T) {
+	t.Helper()
+	ks := NewKeySet()
+	k, err := HMACKey("test", testSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ks.Add(k); err != nil {
+		t.Fatal(err)
+	}
+	if err := ks.SetSigning("test"); err != nil {
+		t.Fatal(err)
+	}
+	Configure(ks, time.Hour)
+	t.Cleanup(func() { Configure(nil, 0) })
+}
+
+// configureTestSessions keeps sessions in store and starts one for testPhone
+func configureTestSessions(t *testing.T, store SessionStore) Tokens {
+	t.Helper()
+	configureTestKeys(t)
+	ConfigureSessions(store, 0)
+	t.Cleanup(func() { ConfigureSessions(nil, 0) })
+	tokens, err := StartSession(testPhone, httptest.NewRequest(http.MethodPost, "/verify", nil))
+	if err != nil {
+		t.Fatalf("StartSession: %v", err)
+	}
+	return tokens
+}
+
+func TestRefreshRotatesToken(t *testing.T) {
+	store := storage.NewMemoryStore(nil)
+	first := configureTestSessions(t, store)
+
+	second, err := Refresh(first.RefreshToken)
+	if err != nil {
+		t.Fatalf("Refresh: %v", err)
+	}
+	if second.RefreshToken == "" || second.RefreshToken == first.RefreshToken {
+		t.Fatalf("refresh token = %q, want a new one", second.RefreshToken)
+	}
+	if phone, sessionID, err := ParseJWT(second.AccessToken); err != nil || phone != testPhone || sessionID != first.SessionID {
+		t.Errorf("access token = %q, %q, %v, want the same phone and session", phone, sessionID, err)
+	}
+
+	// A parallel request still carrying the old token within the grace period
+	// gets an access token but no new refresh token
+	racer, err := Refresh(first.RefreshToken)
+	if err != nil {
+		t.Fatalf("Refresh with the just-rotated token: %v", err)
+	}
+	if racer.AccessToken == "" || racer.RefreshToken != "" {
+		t.Errorf("tokens within grace = %+v, want an access token only", racer)
+	}
+	if _, err := Refresh(second.RefreshToken); err != nil {
+		t.Errorf("Refresh with the current token: %v", err)
+	}
+}
+
+func TestRefreshReuseAfterGraceRevokesSession(t *testing.T) {
+	store := storage.NewMemoryStore(nil)
+	first := configureTestSessions(t, store)
+	second, err := Refresh(first.RefreshToken)
+	if err != nil {
+		t.Fatalf("Refresh: %v", err)
+	}
+
+	s, _ := store.GetSession(first.SessionID)
+	s.RotatedAt = time.Now().Add(-refreshGrace - time.Second)
+	if err := store.UpdateSession(s); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Refresh(first.RefreshToken); !errors.Is(err, ErrRefreshReused) {
+		t.Fatalf("reusing a rotated token after the grace = %v, want ErrRefreshReused", err)
+	}
+	if _, ok := store.GetSession(first.SessionID); ok {
+		t.Error("session survived a reused refresh token")
+	}
+	if _, err := Refresh(second.RefreshToken); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("current token after the revoke = %v, want ErrSessionNotFound", err)
+	}
+	if err := checkSession(first.SessionID, testPhone); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("access tokens after the revoke = %v, want ErrSessionNotFound", err)
+	}
+}
+
+// signOutAfterRead is a SessionStore where a sign-out lands right after every
+// session read, the worst moment for a refresh racing a logout
+type signOutAfterRead struct {
+	*storage.MemoryStore
+}
+
+func (s signOutAfterRead) GetSession(id string) (storage.Session, bool) {
+	session, ok := s.MemoryStore.GetSession(id)
+	if err := EndSession(id); err != nil {
+		panic(err)
+	}
+	return session, ok
+}
+
+func TestRefreshLosesToSignOutAfterRead(t *testing.T) {
+	store := storage.NewMemoryStore(nil)
+	tokens := configureTestSessions(t, signOutAfterRead{store})
+
+	if _, err := Refresh(tokens.RefreshToken); !errors.Is(err, ErrSessionNotFound) {
+		t.Fatalf("refresh racing a sign-out = %v, want ErrSessionNotFound", err)
+	}
+	if _, ok := store.GetSession(tokens.SessionID); ok {
+		t.Error("refresh restored a signed-out session")
+	}
+}
+
+func TestSignOutRacingRefresh(t *testing.T) {
+	store := storage.NewMemoryStore(nil)
+	configureTestSessions(t, store)
+
+	for i := 0; i < 50; i++ {
+		tokens, err := StartSession(testPhone, httptest.NewRequest(http.MethodPost, "/verify", nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var wg sync.WaitGroup
+		var refreshed Tokens
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			refreshed, _ = Refresh(tokens.RefreshToken)
+		}()
+		go func() {
+			defer wg.Done()
+			if err := EndSession(tokens.SessionID); err != nil {
+				t.Error(err)
+			}
+		}()
+		wg.Wait()
+
+		if _, ok := store.GetSession(tokens.SessionID); ok {
+			t.Fatalf("run %d: session outlived its sign-out", i)
+		}
+		if refreshed.RefreshToken != "" {
+			if _, err := Refresh(refreshed.RefreshToken); err == nil {
+				t.Fatalf("run %d: refresh token from the race still works", i)
+			}
+		}
+	}
+}
@@ -0,0 +1,325 @@
+// Package config is Market Sentry's typed configuration. Values come from
+// built-in defaults, then an optional YAML file, then environment variables,
+// then command-line flags, each layer overriding the one before.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jasonmichels/Market-Sentry/internal/history"
+	"github.com/jasonmichels/Market-Sentry/internal/notify"
+	"github.com/jasonmichels/Market-Sentry/internal/prices"
+)
+
+// Every leaf field has a yaml key; its flag is the dotted yaml path (e.g.
+// -server.addr) and its environment variable is the env tag, if any.
+// Fields tagged secret:"true" are redacted by Redacted.
+
+// Config is the whole server configuration.
+type Config struct {
+	Server  ServerConfig  `yaml:"server"`
+	Auth    AuthConfig    `yaml:"auth"`
+	Admin   AdminConfig   `yaml:"admin"`
+	Data    DataConfig    `yaml:"data"`
+	Storage StorageConfig `yaml:"storage"`
+	Twilio  TwilioConfig  `yaml:"twilio"`
+	SMTP    SMTPConfig    `yaml:"smtp"`
+	Outbox  OutboxConfig  `yaml:"outbox"`
+	History HistoryConfig `yaml:"history"`
+	Prices  PricesConfig  `yaml:"prices"`
+}
+
+type ServerConfig struct {
+	Addr            string        `yaml:"addr" env:"ADDR"`
+	Environment     string        `yaml:"environment" env:"ENVIRONMENT"` // "local" relaxes login rate limits
+	ShutdownTimeout time.Duration `yaml:"shutdownTimeout" env:"SHUTDOWN_TIMEOUT"`
+}
+
+type AuthConfig struct {
+	JWTSecret       string        `yaml:"jwtSecret" env:"JWT_SECRET" secret:"true"`                 // HS256 signing secret
+	PreviousSecrets []string      `yaml:"previousSecrets" env:"JWT_PREVIOUS_SECRETS" secret:"true"` // retired secrets still accepted for verification
+	KeyFile         string        `yaml:"keyFile" env:"JWT_KEY_FILE"`                               // YAML list of HS256/RS256/EdDSA keys; its signingKey wins over jwtSecret
+	TokenLifetime   time.Duration `yaml:"tokenLifetime" env:"TOKEN_LIFETIME"`                       // access tokens; renewed with the refresh token
+	SessionLifetime time.Duration `yaml:"sessionLifetime" env:"SESSION_LIFETIME"`                   // a session ends if not refreshed within this
+	OTPLifetime     time.Duration `yaml:"otpLifetime" env:"OTP_LIFETIME"`
+	OTPSecret       string        `yaml:"otpSecret" env:"OTP_SECRET" secret:"true"` // HMAC key for stored login codes
+	OTPMaxAttempts  int           `yaml:"otpMaxAttempts" env:"OTP_MAX_ATTEMPTS"`    // wrong guesses before a code is invalidated
+
+	// Code verification lockout per IP address, doubling up to VerifyMaxLockout
+	VerifyFailuresPerIP int           `yaml:"verifyFailuresPerIp" env:"VERIFY_FAILURES_PER_IP"`
+	VerifyLockout       time.Duration `yaml:"verifyLockout" env:"VERIFY_LOCKOUT"`
+	VerifyMaxLockout    time.Duration `yaml:"verifyMaxLockout" env:"VERIFY_MAX_LOCKOUT"`
+
+	LoginsPerDay   int  `yaml:"loginsPerDay" env:"LOGINS_PER_DAY"`
+	LoginBurst     int  `yaml:"loginBurst" env:"LOGIN_BURST"`
+	RateLimitLocal bool `yaml:"rateLimitLocal" env:"RATE_LIMIT_LOCAL"` // apply login limits even when environment is "local"
+
+	// Requests allowed per API key
+	APIKeyPerMinute int `yaml:"apiKeyPerMinute" env:"API_KEY_PER_MINUTE"`
+	APIKeyBurst     int `yaml:"apiKeyBurst" env:"API_KEY_BURST"`
+}
+
+type AdminConfig struct {
+	Phones []string `yaml:"phones" env:"ADMIN_PHONES"`
+}
+
+type DataConfig struct {
+	CoinsPath string `yaml:"coinsPath" env:"COINS_PATH"`
+}
+
+type StorageConfig struct {
+	Backend          string        `yaml:"backend" env:"STORAGE_BACKEND"` // memory, bolt or journal
+	Path             string        `yaml:"path" env:"STORAGE_PATH"`
+	SnapshotInterval time.Duration `yaml:"snapshotInterval" env:"SNAPSHOT_INTERVAL"`
+}
+
+type TwilioConfig struct {
+	Enabled    bool   `yaml:"enabled" env:"TWILIO_ENABLED"`
+	AccountSID string `yaml:"accountSid" env:"TWILIO_ACCOUNT_SID"`
+	AuthToken  string `yaml:"authToken" env:"TWILIO_AUTH_TOKEN" secret:"true"`
+	FromNumber string `yaml:"fromNumber" env:"TWILIO_FROM_NUMBER"`
+}
+
+type SMTPConfig struct {
+	Host     string `yaml:"host" env:"SMTP_HOST"` // email is off when empty
+	Port     string `yaml:"port" env:"SMTP_PORT"`
+	Username string `yaml:"username" env:"SMTP_USERNAME"`
+	Password string `yaml:"password" env:"SMTP_PASSWORD" secret:"true"`
+	From     string `yaml:"from" env:"SMTP_FROM"`
+}
+
+type OutboxConfig struct {
+	Workers     int `yaml:"workers" env:"OUTBOX_WORKERS"`
+	MaxAttempts int `yaml:"maxAttempts" env:"OUTBOX_MAX_ATTEMPTS"`
+}
+
+type HistoryConfig struct {
+	RawRetention    time.Duration `yaml:"rawRetention" env:"HISTORY_RAW_RETENTION"`
+	MinuteRetention time.Duration `yaml:"minuteRetention" env:"HISTORY_1M_RETENTION"`
+	HourRetention   time.Duration `yaml:"hourRetention" env:"HISTORY_1H_RETENTION"`
+	DayRetention    time.Duration `yaml:"dayRetention" env:"HISTORY_1D_RETENTION"`
+	MaxRawPoints    int           `yaml:"maxRawPoints" env:"HISTORY_MAX_RAW_POINTS"`
+}
+
+type PricesConfig struct {
+	MaxAge    time.Duration  `yaml:"maxAge" env:"PRICE_MAX_AGE"`
+	Interval  time.Duration  `yaml:"interval" env:"PRICE_INTERVAL"`
+	Intervals AssetDurations `yaml:"intervals"`
+	Jitter    float64        `yaml:"jitter" env:"PRICE_JITTER"` // negative disables jitter
+
+	Breaker BreakerConfig `yaml:"breaker"`
+	Chains  AssetChains   `yaml:"chains"`
+
+	CoinGecko ProviderConfig `yaml:"coingecko" env:"COINGECKO"`
+	CoinCap   ProviderConfig `yaml:"coincap" env:"COINCAP"`
+	MetalsDev ProviderConfig `yaml:"metalsdev" env:"METALSDEV"`
+	FMP       ProviderConfig `yaml:"fmp" env:"FMP"`
+}
+
+// AssetDurations overrides the refresh interval per asset type; zero means prices.interval.
+type AssetDurations struct {
+	Crypto time.Duration `yaml:"crypto" env:"PRICE_INTERVAL_CRYPTO"`
+	Metal  time.Duration `yaml:"metal" env:"PRICE_INTERVAL_METAL"`
+	Stock  time.Duration `yaml:"stock" env:"PRICE_INTERVAL_STOCK"`
+}
+
+// AssetChains is the provider failover order per asset type; empty means registration order.
+type AssetChains struct {
+	Crypto []string `yaml:"crypto" env:"PRICE_PROVIDER_CRYPTO"`
+	Metal  []string `yaml:"metal" env:"PRICE_PROVIDER_METAL"`
+	Stock  []string `yaml:"stock" env:"PRICE_PROVIDER_STOCK"`
+}
+
+type BreakerConfig struct {
+	Failures int           `yaml:"failures" env:"BREAKER_FAILURES"`
+	OpenFor  time.Duration `yaml:"openFor" env:"BREAKER_OPEN_FOR"`
+}
+
+// ProviderConfig is one price provider's endpoint; the env tag of the field
+// holding it is the variable prefix, e.g. COINGECKO_BASE_URL.
+type ProviderConfig struct {
+	BaseURL string        `yaml:"baseUrl" env:"BASE_URL"`
+	APIKey  string        `yaml:"apiKey" env:"API_KEY" secret:"true"`
+	Timeout time.Duration `yaml:"timeout" env:"TIMEOUT"`
+}
+
+// Default is the configuration used when nothing overrides it.
+func Default() Config {
+	retention := history.DefaultRetention
+	outbox := notify.DefaultOutboxConfig
+	schedule := prices.DefaultScheduleConfig
+	breaker := prices.DefaultBreakerConfig
+	return Config{
+		Server: ServerConfig{
+			Addr:            ":8080",
+			Environment:     "production",
+			ShutdownTimeout: 20 * time.Second,
+		},
+		Auth: AuthConfig{
+			TokenLifetime:   15 * time.Minute,
+			SessionLifetime: 30 * 24 * time.Hour,
+			OTPLifetime:     5 * time.Minute,
+			OTPMaxAttempts:  5,
+
+			VerifyFailuresPerIP: 10,
+			VerifyLockout:       time.Minute,
+			VerifyMaxLockout:    time.Hour,
+
+			LoginsPerDay: 20,
+			LoginBurst:   20,
+
+			APIKeyPerMinute: 60,
+			APIKeyBurst:     20,
+		},
+		Data:    DataConfig{CoinsPath: "web/data/coins.json"},
+		Storage: StorageConfig{Backend: "memory", SnapshotInterval: 10 * time.Minute},
+		SMTP:    SMTPConfig{Port: "587"},
+		Outbox:  OutboxConfig{Workers: outbox.Workers, MaxAttempts: outbox.MaxAttempts},
+		History: HistoryConfig{
+			RawRetention:    retention.Raw,
+			MinuteRetention: retention.Minute,
+			HourRetention:   retention.Hour,
+			DayRetention:    retention.Day,
+			MaxRawPoints:    retention.MaxRawPoints,
+		},
+		Prices: PricesConfig{
+			MaxAge:   prices.DefaultMaxPriceAge,
+			Interval: schedule.Interval,
+			Jitter:   schedule.Jitter,
+			Breaker:  BreakerConfig{Failures: breaker.FailureThreshold, OpenFor: breaker.OpenFor},
+		},
+	}
+}
+
+// IsLocal reports whether the server runs in the "local" development environment.
+func (c Config) IsLocal() bool {
+	return c.Server.Environment == "local"
+}
+
+// Validate checks the configuration, returning every problem found at once.
+func (c Config) Validate() error {
+	var errs []error
+	add := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if c.Server.Addr == "" {
+		add("server.addr must be set")
+	}
+	if c.Server.ShutdownTimeout <= 0 {
+		add("server.shutdownTimeout must be positive")
+	}
+
+	if c.Auth.JWTSecret == "" && c.Auth.KeyFile == "" && !c.IsLocal() {
+		add("auth.jwtSecret (JWT_SECRET) or auth.keyFile (JWT_KEY_FILE) must be set outside the local environment")
+	} else if c.Auth.JWTSecret != "" && len(c.Auth.JWTSecret) < 32 {
+		add("auth.jwtSecret must be at least 32 characters")
+	}
+	if c.Auth.OTPSecret == "" && !c.IsLocal() {
+		add("auth.otpSecret (OTP_SECRET) must be set outside the local environment")
+	} else if c.Auth.OTPSecret != "" && len(c.Auth.OTPSecret) < 32 {
+		add("auth.otpSecret must be at least 32 characters")
+	}
+	for i, secret := range c.Auth.PreviousSecrets {
+		if len(secret) < 32 {
+			add("auth.previousSecrets[%d] must be at least 32 characters", i)
+		}
+	}
+	if c.Auth.TokenLifetime <= 0 {
+		add("auth.tokenLifetime must be positive")
+	}
+	if c.Auth.SessionLifetime <= c.Auth.TokenLifetime {
+		add("auth.sessionLifetime must be longer than auth.tokenLifetime")
+	}
+	if c.Auth.OTPLifetime <= 0 || c.Auth.OTPLifetime > time.Hour {
+		add("auth.otpLifetime must be between 0 and 1h")
+	}
+	if c.Auth.OTPMaxAttempts <= 0 || c.Auth.VerifyFailuresPerIP <= 0 {
+		add("auth.otpMaxAttempts and auth.verifyFailuresPerIp must be positive")
+	}
+	if c.Auth.VerifyLockout <= 0 || c.Auth.VerifyMaxLockout < c.Auth.VerifyLockout {
+		add("auth.verifyLockout must be positive and no longer than auth.verifyMaxLockout")
+	}
+	if c.Auth.LoginsPerDay <= 0 || c.Auth.LoginBurst <= 0 {
+		add("auth.loginsPerDay and auth.loginBurst must be positive")
+	}
+	if c.Auth.APIKeyPerMinute <= 0 || c.Auth.APIKeyBurst <= 0 {
+		add("auth.apiKeyPerMinute and auth.apiKeyBurst must be positive")
+	}
+
+	for _, phone := range c.Admin.Phones {
+		if !strings.HasPrefix(phone, "+") {
+			add("admin.phones: %q must be in E.164 form, e.g. +15555550100", phone)
+		}
+	}
+
+	if c.Data.CoinsPath == "" {
+		add("data.coinsPath must be set")
+	}
+
+	switch c.Storage.Backend {
+	case "memory", "bolt", "journal":
+	default:
+		add("storage.backend must be memory, bolt or journal, not %q", c.Storage.Backend)
+	}
+	if c.Storage.Backend == "journal" && c.Storage.SnapshotInterval <= 0 {
+		add("storage.snapshotInterval must be positive")
+	}
+
+	if c.Twilio.Enabled && (c.Twilio.AccountSID == "" || c.Twilio.AuthToken == "" || c.Twilio.FromNumber == "") {
+		add("twilio.accountSid, twilio.authToken and twilio.fromNumber are required when twilio.enabled is true")
+	}
+	if c.SMTP.Host != "" && c.SMTP.From == "" {
+		add("smtp.from is required when smtp.host is set")
+	}
+
+	if c.Outbox.Workers <= 0 || c.Outbox.MaxAttempts <= 0 {
+		add("outbox.workers and outbox.maxAttempts must be positive")
+	}
+	if c.History.RawRetention <= 0 || c.History.MinuteRetention <= 0 || c.History.HourRetention <= 0 || c.History.DayRetention <= 0 {
+		add("history retentions must be positive")
+	}
+	if c.History.MaxRawPoints <= 0 {
+		add("history.maxRawPoints must be positive")
+	}
+
+	if c.Prices.MaxAge <= 0 {
+		add("prices.maxAge must be positive")
+	}
+	if c.Prices.Interval <= 0 {
+		add("prices.interval must be positive")
+	}
+	if c.Prices.Intervals.Crypto < 0 || c.Prices.Intervals.Metal < 0 || c.Prices.Intervals.Stock < 0 {
+		add("prices.intervals must not be negative")
+	}
+	if c.Prices.MaxAge > 0 {
+		// Prices older than maxAge are stale, so every refresh must come sooner
+		for _, asset := range []struct {
+			name     string
+			interval time.Duration
+		}{
+			{"crypto", c.Prices.Intervals.Crypto},
+			{"metal", c.Prices.Intervals.Metal},
+			{"stock", c.Prices.Intervals.Stock},
+		} {
+			interval := asset.interval
+			if interval == 0 {
+				interval = c.Prices.Interval
+			}
+			if c.Prices.MaxAge <= interval {
+				add("prices.maxAge (%s) must be longer than the %s refresh interval (%s)", c.Prices.MaxAge, asset.name, interval)
+			}
+		}
+	}
+	if c.Prices.Jitter >= 1 {
+		add("prices.jitter must be below 1")
+	}
+	if c.Prices.Breaker.Failures <= 0 || c.Prices.Breaker.OpenFor <= 0 {
+		add("prices.breaker.failures and prices.breaker.openFor must be positive")
+	}
+
+	return errors.Join(errs...)
+}
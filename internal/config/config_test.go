@@ -0,0 +1,60 @@
+package config
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDefaultIsValidLocally(t *testing.T) {
+	cfg := Default()
+	cfg.Server.Environment = "local"
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("local defaults: %v", err)
+	}
+
+	// Outside local, the secrets have to be set
+	err := Default().Validate()
+	for _, want := range []string{"auth.jwtSecret", "auth.otpSecret"} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("production defaults = %v, want %s required", err, want)
+		}
+	}
+}
+
+func TestValidatePriceMaxAge(t *testing.T) {
+	cases := []struct {
+		name   string
+		modify func(*PricesConfig)
+		want   string // "" if valid
+	}{
+		{"longer than every interval", func(p *PricesConfig) {
+			p.MaxAge, p.Interval = 10*time.Minute, time.Minute
+		}, ""},
+		{"zero", func(p *PricesConfig) { p.MaxAge = 0 }, "prices.maxAge must be positive"},
+		{"negative", func(p *PricesConfig) { p.MaxAge = -time.Minute }, "prices.maxAge must be positive"},
+		{"equal to the interval", func(p *PricesConfig) {
+			p.MaxAge, p.Interval = time.Minute, time.Minute
+		}, "prices.maxAge (1m0s) must be longer than the crypto refresh interval (1m0s)"},
+		{"shorter than an asset's own interval", func(p *PricesConfig) {
+			p.MaxAge, p.Interval, p.Intervals.Stock = 10*time.Minute, time.Minute, 15*time.Minute
+		}, "prices.maxAge (10m0s) must be longer than the stock refresh interval (15m0s)"},
+		{"asset interval under maxAge though the default isn't", func(p *PricesConfig) {
+			p.MaxAge, p.Interval = 10*time.Minute, 20*time.Minute
+			p.Intervals = AssetDurations{Crypto: time.Minute, Metal: time.Minute, Stock: time.Minute}
+		}, ""},
+		{"negative asset interval", func(p *PricesConfig) { p.Intervals.Metal = -time.Minute }, "prices.intervals must not be negative"},
+	}
+	for _, c := range cases {
+		cfg := Default()
+		cfg.Server.Environment = "local"
+		c.modify(&cfg.Prices)
+		err := cfg.Validate()
+		switch {
+		case c.want == "" && err != nil:
+			t.Errorf("%s: %v", c.name, err)
+		case c.want != "" && (err == nil || !strings.Contains(err.Error(), c.want)):
+			t.Errorf("%s: Validate = %v, want %q", c.name, err, c.want)
+		}
+	}
+}
@@ -0,0 +1,229 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Options are the command-line switches that aren't configuration values.
+type Options struct {
+	ConfigPath  string // YAML file to load, from -config or MARKETSENTRY_CONFIG
+	PrintConfig bool   // print the effective configuration and exit
+}
+
+// Load builds the configuration from defaults, the config file, the
+// environment and args (without the program name), in that order of
+// precedence, and validates the result.
+func Load(args []string, getenv func(string) string) (Config, Options, error) {
+	var opts Options
+	cfg := Default()
+
+	fs := flag.NewFlagSet("marketsentry", flag.ContinueOnError)
+	fs.StringVar(&opts.ConfigPath, "config", getenv("MARKETSENTRY_CONFIG"), "path to a YAML config file")
+	fs.BoolVar(&opts.PrintConfig, "print-config", false, "print the effective configuration, secrets redacted, and exit")
+
+	// Every setting is also a flag named after its yaml path; values are
+	// applied after the file and environment so flags win.
+	flagValues := make(map[string]string)
+	for _, f := range fields(&cfg) {
+		name := f.path
+		fs.Func(name, "sets "+name, func(v string) error {
+			flagValues[name] = v
+			return nil
+		})
+	}
+	if err := fs.Parse(args); err != nil {
+		return cfg, opts, err
+	}
+	if fs.NArg() > 0 {
+		return cfg, opts, fmt.Errorf("unexpected arguments: %v", fs.Args())
+	}
+
+	if opts.ConfigPath != "" {
+		if err := loadFile(&cfg, opts.ConfigPath); err != nil {
+			return cfg, opts, err
+		}
+	}
+
+	for _, f := range fields(&cfg) {
+		if f.env == "" {
+			continue
+		}
+		if v := getenv(f.env); v != "" {
+			if err := f.set(v); err != nil {
+				return cfg, opts, fmt.Errorf("environment variable %s: %w", f.env, err)
+			}
+		}
+	}
+
+	for _, f := range fields(&cfg) {
+		if v, ok := flagValues[f.path]; ok {
+			if err := f.set(v); err != nil {
+				return cfg, opts, fmt.Errorf("flag -%s: %w", f.path, err)
+			}
+		}
+	}
+
+	return cfg, opts, cfg.Validate()
+}
+
+// loadFile overlays a YAML file on cfg; unknown keys are an error so typos don't go unnoticed
+func loadFile(cfg *Config, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read config file: %w", err)
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// field is one settable leaf of Config
+type field struct {
+	path   string // dotted yaml path, also the flag name
+	env    string // environment variable, if any
+	secret bool
+	value  reflect.Value
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// fields lists the leaves of cfg in declaration order
+func fields(cfg *Config) []field {
+	var out []field
+	walk(reflect.ValueOf(cfg).Elem(), "", "", &out)
+	return out
+}
+
+func walk(v reflect.Value, path, envPrefix string, out *[]field) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		key := sf.Tag.Get("yaml")
+		if path != "" {
+			key = path + "." + key
+		}
+		env := sf.Tag.Get("env")
+		if env != "" && envPrefix != "" {
+			env = envPrefix + "_" + env
+		}
+
+		fv := v.Field(i)
+		if fv.Kind() == reflect.Struct {
+			// A tagged struct gives its children an env prefix
+			walk(fv, key, env, out)
+			continue
+		}
+		*out = append(*out, field{path: key, env: env, secret: sf.Tag.Get("secret") == "true", value: fv})
+	}
+}
+
+// set parses s into the field according to its type
+func (f field) set(s string) error {
+	v := f.value
+	switch {
+	case v.Type() == durationType:
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+	case v.Kind() == reflect.String:
+		v.SetString(s)
+	case v.Kind() == reflect.Int:
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(n))
+	case v.Kind() == reflect.Float64:
+		x, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(x)
+	case v.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.String:
+		// Comma-separated, e.g. ADMIN_PHONES=+15555550100,+15555550101
+		var items []string
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported setting type %s", v.Type())
+	}
+	return nil
+}
+
+// Redacted renders the configuration as YAML with every secret that is set
+// replaced by "REDACTED", for -print-config and startup logs.
+func (c Config) Redacted() string {
+	root := &yaml.Node{Kind: yaml.MappingNode}
+	nodes := map[string]*yaml.Node{"": root}
+
+	for _, f := range fields(&c) {
+		// Make sure every parent mapping exists
+		parent := ""
+		parts := strings.Split(f.path, ".")
+		for i, part := range parts[:len(parts)-1] {
+			p := strings.Join(parts[:i+1], ".")
+			if _, ok := nodes[p]; !ok {
+				m := &yaml.Node{Kind: yaml.MappingNode}
+				nodes[parent].Content = append(nodes[parent].Content, scalar(part), m)
+				nodes[p] = m
+			}
+			parent = p
+		}
+		nodes[parent].Content = append(nodes[parent].Content, scalar(parts[len(parts)-1]), valueNode(f))
+	}
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	_ = enc.Encode(root)
+	return buf.String()
+}
+
+func scalar(s string) *yaml.Node {
+	return &yaml.Node{Kind: yaml.ScalarNode, Value: s}
+}
+
+func valueNode(f field) *yaml.Node {
+	v := f.value
+	switch {
+	case f.secret && !v.IsZero():
+		return scalar("REDACTED")
+	case v.Type() == durationType:
+		return scalar(time.Duration(v.Int()).String())
+	case v.Kind() == reflect.Slice:
+		seq := &yaml.Node{Kind: yaml.SequenceNode, Style: yaml.FlowStyle}
+		for i := 0; i < v.Len(); i++ {
+			seq.Content = append(seq.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: v.Index(i).String(), Style: yaml.DoubleQuotedStyle})
+		}
+		return seq
+	case v.Kind() == reflect.String:
+		return &yaml.Node{Kind: yaml.ScalarNode, Value: v.String(), Style: yaml.DoubleQuotedStyle}
+	}
+	return scalar(fmt.Sprint(v.Interface()))
+}
@@ -0,0 +1,130 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// env is a fake environment
+type env map[string]string
+
+func (e env) get(key string) string { return e[key] }
+
+// writeConfig writes a config file into a temporary directory
+func writeConfig(t *testing.T, yaml string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(yaml), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	path := writeConfig(t, `
+server:
+  environment: local
+  addr: ":9000"
+  shutdownTimeout: 30s
+auth:
+  loginsPerDay: 5
+  loginBurst: 3
+prices:
+  interval: 2m
+`)
+	e := env{
+		"MARKETSENTRY_CONFIG": path,
+		"ADDR":                ":9100",
+		"LOGIN_BURST":         "4",
+		"ADMIN_PHONES":        "+15555550100, +15555550101,",
+		"COINGECKO_TIMEOUT":   "7s",
+	}
+	cfg, opts, err := Load([]string{"-server.addr", ":9200", "-prices.jitter=0.5"}, e.get)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if opts.ConfigPath != path {
+		t.Errorf("config path = %q, want it from MARKETSENTRY_CONFIG", opts.ConfigPath)
+	}
+
+	cases := []struct {
+		name      string
+		got, want any
+	}{
+		{"default", cfg.Auth.OTPLifetime, 5 * time.Minute},
+		{"file over default", cfg.Server.ShutdownTimeout, 30 * time.Second},
+		{"file over default", cfg.Auth.LoginsPerDay, 5},
+		{"file over default", cfg.Prices.Interval, 2 * time.Minute},
+		{"env over file", cfg.Auth.LoginBurst, 4},
+		{"flag over env and file", cfg.Server.Addr, ":9200"},
+		{"flag over default", cfg.Prices.Jitter, 0.5},
+		{"env list", strings.Join(cfg.Admin.Phones, " "), "+15555550100 +15555550101"},
+		{"prefixed env", cfg.Prices.CoinGecko.Timeout, 7 * time.Second},
+	}
+	for _, c := range cases {
+		if c.got != c.want {
+			t.Errorf("%s: got %v, want %v", c.name, c.got, c.want)
+		}
+	}
+}
+
+func TestLoadConfigFlagOverridesEnv(t *testing.T) {
+	fromEnv := writeConfig(t, "server:\n  environment: local\n  addr: \":1\"\n")
+	fromFlag := writeConfig(t, "server:\n  environment: local\n  addr: \":2\"\n")
+	cfg, opts, err := Load([]string{"-config", fromFlag}, env{"MARKETSENTRY_CONFIG": fromEnv}.get)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if opts.ConfigPath != fromFlag || cfg.Server.Addr != ":2" {
+		t.Errorf("loaded %q with addr %q, want the -config file", opts.ConfigPath, cfg.Server.Addr)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	local := env{"ENVIRONMENT": "local"}
+	cases := []struct {
+		name string
+		args []string
+		env  env
+		file string
+		want string
+	}{
+		{"unknown file key", nil, local, "server:\n  adr: \":1\"\n", "field adr not found"},
+		{"bad env value", nil, env{"ENVIRONMENT": "local", "OTP_LIFETIME": "soon"}, "", "environment variable OTP_LIFETIME"},
+		{"bad flag value", []string{"-auth.loginBurst", "many"}, local, "", "flag -auth.loginBurst"},
+		{"unknown flag", []string{"-no.such.setting", "1"}, local, "", "no.such.setting"},
+		{"stray argument", []string{"serve"}, local, "", "unexpected arguments"},
+		{"invalid result", []string{"-storage.backend", "sqlite"}, local, "", `storage.backend must be memory, bolt or journal, not "sqlite"`},
+	}
+	for _, c := range cases {
+		args := c.args
+		if c.file != "" {
+			args = append([]string{"-config", writeConfig(t, c.file)}, args...)
+		}
+		_, _, err := Load(args, c.env.get)
+		if err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("%s: Load = %v, want an error mentioning %q", c.name, err, c.want)
+		}
+	}
+}
+
+func TestRedacted(t *testing.T) {
+	cfg := Default()
+	cfg.Auth.JWTSecret = "a secret that is at least 32 characters"
+	cfg.Prices.FMP.APIKey = "fmp-key"
+	out := cfg.Redacted()
+
+	for _, secret := range []string{cfg.Auth.JWTSecret, cfg.Prices.FMP.APIKey} {
+		if strings.Contains(out, secret) {
+			t.Errorf("redacted config shows %q", secret)
+		}
+	}
+	for _, want := range []string{"jwtSecret: REDACTED", `otpSecret: ""`, `addr: ":8080"`, "maxAge: " + cfg.Prices.MaxAge.String()} {
+		if !strings.Contains(out, want) {
+			t.Errorf("redacted config lacks %q:\n%s", want, out)
+		}
+	}
+}
@@ -0,0 +1,331 @@
+package history
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Point is one recorded price.
+type Point struct {
+	Time  time.Time
+	Price float64
+}
+
+// Candle is an OHLC summary of the points in [Start, Start+resolution).
+type Candle struct {
+	Start time.Time
+	Open  float64
+	High  float64
+	Low   float64
+	Close float64
+	Count int // number of raw points folded in
+}
+
+// Resolution is the bucket width of a rollup series.
+type Resolution time.Duration
+
+const (
+	Minute Resolution = Resolution(time.Minute)
+	Hour   Resolution = Resolution(time.Hour)
+	Day    Resolution = Resolution(24 * time.Hour)
+)
+
+// resolutions lists the rollups from finest to coarsest
+var resolutions = []Resolution{Minute, Hour, Day}
+
+func (r Resolution) String() string {
+	switch r {
+	case Minute:
+		return "1m"
+	case Hour:
+		return "1h"
+	case Day:
+		return "1d"
+	}
+	return time.Duration(r).String()
+}
+
+// ParseResolution accepts "1m", "1h" or "1d".
+func ParseResolution(s string) (Resolution, bool) {
+	for _, r := range resolutions {
+		if r.String() == s {
+			return r, true
+		}
+	}
+	return 0, false
+}
+
+// RetentionPolicy is how long each series is kept. Raw points are also capped
+// at MaxRawPoints per symbol so a fast fetch interval can't grow memory unbounded.
+type RetentionPolicy struct {
+	Raw          time.Duration
+	Minute       time.Duration
+	Hour         time.Duration
+	Day          time.Duration
+	MaxRawPoints int
+}
+
+// DefaultRetention keeps a day of raw prices and progressively coarser history after that.
+var DefaultRetention = RetentionPolicy{
+	Raw:          25 * time.Hour,
+	Minute:       7 * 24 * time.Hour,
+	Hour:         90 * 24 * time.Hour,
+	Day:          5 * 365 * 24 * time.Hour,
+	MaxRawPoints: 10000,
+}
+
+func (p RetentionPolicy) forResolution(r Resolution) time.Duration {
+	switch r {
+	case Minute:
+		return p.Minute
+	case Hour:
+		return p.Hour
+	case Day:
+		return p.Day
+	}
+	return 0
+}
+
+// series is the history of one symbol
+type series struct {
+	raw     []Point                 // oldest first
+	candles map[Resolution][]Candle // oldest first
+}
+
+// Store is a bounded, in-memory time series of fetched prices. Every point is
+// kept raw for a while and rolled up into 1m, 1h and 1d OHLC candles, each
+// trimmed by the retention policy.
+type Store struct {
+	mu        sync.RWMutex
+	retention RetentionPolicy
+	series    map[string]*series // key: assetType + "/" + symbol
+}
+
+// New creates a history with the given retention; zero fields use DefaultRetention.
+func New(retention RetentionPolicy) *Store {
+	d := DefaultRetention
+	if retention.Raw <= 0 {
+		retention.Raw = d.Raw
+	}
+	if retention.Minute <= 0 {
+		retention.Minute = d.Minute
+	}
+	if retention.Hour <= 0 {
+		retention.Hour = d.Hour
+	}
+	if retention.Day <= 0 {
+		retention.Day = d.Day
+	}
+	if retention.MaxRawPoints <= 0 {
+		retention.MaxRawPoints = d.MaxRawPoints
+	}
+	return &Store{
+		retention: retention,
+		series:    make(map[string]*series),
+	}
+}
+
+// Retention returns the policy in effect.
+func (s *Store) Retention() RetentionPolicy {
+	return s.retention
+}
+
+func key(assetType, symbol string) string {
+	return assetType + "/" + symbol
+}
+
+// Record appends one fetched price per symbol, folds it into the rollups and
+// trims expired data. Points must arrive in time order; older ones are dropped.
+func (s *Store) Record(assetType string, prices map[string]float64, at time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for symbol, price := range prices {
+		k := key(assetType, symbol)
+		ser, ok := s.series[k]
+		if !ok {
+			ser = &series{candles: make(map[Resolution][]Candle)}
+			s.series[k] = ser
+		}
+		if n := len(ser.raw); n > 0 && at.Before(ser.raw[n-1].Time) {
+			continue
+		}
+
+		ser.raw = append(ser.raw, Point{Time: at, Price: price})
+		ser.raw = trimPoints(ser.raw, at.Add(-s.retention.Raw), s.retention.MaxRawPoints)
+
+		for _, res := range resolutions {
+			candles := addToCandles(ser.candles[res], res, at, price)
+			ser.candles[res] = trimCandles(candles, at.Add(-s.retention.forResolution(res)))
+		}
+	}
+}
+
+// addToCandles folds a point into the last candle, or starts a new one
+func addToCandles(candles []Candle, res Resolution, at time.Time, price float64) []Candle {
+	start := at.Truncate(time.Duration(res))
+	if n := len(candles); n > 0 && candles[n-1].Start.Equal(start) {
+		c := &candles[n-1]
+		if price > c.High {
+			c.High = price
+		}
+		if price < c.Low {
+			c.Low = price
+		}
+		c.Close = price
+		c.Count++
+		return candles
+	}
+	return append(candles, Candle{Start: start, Open: price, High: price, Low: price, Close: price, Count: 1})
+}
+
+func trimPoints(points []Point, cutoff time.Time, max int) []Point {
+	i := sort.Search(len(points), func(i int) bool { return !points[i].Time.Before(cutoff) })
+	if len(points)-i > max {
+		i = len(points) - max
+	}
+	if i == 0 {
+		return points
+	}
+	// Copy so the dropped prefix can be garbage collected
+	return append([]Point(nil), points[i:]...)
+}
+
+func trimCandles(candles []Candle, cutoff time.Time) []Candle {
+	i := sort.Search(len(candles), func(i int) bool { return !candles[i].Start.Before(cutoff) })
+	if i == 0 {
+		return candles
+	}
+	return append([]Candle(nil), candles[i:]...)
+}
+
+// Symbols lists the symbols with recorded history for an asset type.
+func (s *Store) Symbols(assetType string) []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	prefix := assetType + "/"
+	var symbols []string
+	for k := range s.series {
+		if len(k) > len(prefix) && k[:len(prefix)] == prefix {
+			symbols = append(symbols, k[len(prefix):])
+		}
+	}
+	sort.Strings(symbols)
+	return symbols
+}
+
+// Latest returns the most recent raw point for a symbol.
+func (s *Store) Latest(assetType, symbol string) (Point, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	ser, ok := s.series[key(assetType, symbol)]
+	if !ok || len(ser.raw) == 0 {
+		return Point{}, false
+	}
+	return ser.raw[len(ser.raw)-1], true
+}
+
+// Range returns the raw points for a symbol with from <= Time <= to, oldest first.
+func (s *Store) Range(assetType, symbol string, from, to time.Time) []Point {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ser, ok := s.series[key(assetType, symbol)]
+	if !ok {
+		return nil
+	}
+	points := ser.raw
+	start := sort.Search(len(points), func(i int) bool { return !points[i].Time.Before(from) })
+	end := sort.Search(len(points), func(i int) bool { return points[i].Time.After(to) })
+	if start >= end {
+		return nil
+	}
+	return append([]Point(nil), points[start:end]...)
+}
+
+// Candles returns the rollup candles at res whose Start is in [from, to], oldest first.
+func (s *Store) Candles(assetType, symbol string, res Resolution, from, to time.Time) []Candle {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ser, ok := s.series[key(assetType, symbol)]
+	if !ok {
+		return nil
+	}
+	return candlesBetween(ser.candles[res], from, to)
+}
+
+func candlesBetween(candles []Candle, from, to time.Time) []Candle {
+	start := sort.Search(len(candles), func(i int) bool { return !candles[i].Start.Before(from) })
+	end := sort.Search(len(candles), func(i int) bool { return candles[i].Start.After(to) })
+	if start >= end {
+		return nil
+	}
+	return append([]Candle(nil), candles[start:end]...)
+}
+
+// Span returns the finest data available for [from, to] as candles, oldest first:
+// raw points (as single-point candles) where they are still kept, then 1m, 1h
+// and 1d rollups for the parts of the range that only the coarser series cover.
+func (s *Store) Span(assetType, symbol string, from, to time.Time) []Candle {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ser, ok := s.series[key(assetType, symbol)]
+	if !ok {
+		return nil
+	}
+
+	// Walk from finest to coarsest, each series filling in what lies before
+	// the start of the finer data already collected.
+	var out []Candle
+	covered := to.Add(time.Nanosecond) // everything at or after this is already in out
+
+	var raw []Candle
+	for _, p := range ser.raw {
+		if p.Time.Before(from) || p.Time.After(to) {
+			continue
+		}
+		raw = append(raw, Candle{Start: p.Time, Open: p.Price, High: p.Price, Low: p.Price, Close: p.Price, Count: 1})
+	}
+	if len(raw) > 0 {
+		out = raw
+		covered = raw[0].Start
+	}
+
+	for _, res := range resolutions {
+		if !covered.After(from) {
+			break
+		}
+		var earlier []Candle
+		for _, c := range candlesBetween(ser.candles[res], from, covered) {
+			if !c.Start.Add(time.Duration(res)).After(covered) {
+				earlier = append(earlier, c)
+			}
+		}
+		if len(earlier) > 0 {
+			out = append(earlier, out...)
+			covered = earlier[0].Start
+		}
+	}
+	return out
+}
+
+// MinMax returns the lowest and highest price recorded for a symbol since the given time.
+func (s *Store) MinMax(assetType, symbol string, since time.Time) (min, max float64, ok bool) {
+	candles := s.Span(assetType, symbol, since, time.Now())
+	if len(candles) == 0 {
+		return 0, 0, false
+	}
+	min, max = candles[0].Low, candles[0].High
+	for _, c := range candles[1:] {
+		if c.Low < min {
+			min = c.Low
+		}
+		if c.High > max {
+			max = c.High
+		}
+	}
+	return min, max, true
+}
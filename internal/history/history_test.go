@@ -0,0 +1,174 @@
+package history
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+var base = time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+
+func record(s *Store, offset time.Duration, price float64) {
+	s.Record("crypto", map[string]float64{"bitcoin": price}, base.Add(offset))
+}
+
+func TestRollsUpIntoCandles(t *testing.T) {
+	s := New(RetentionPolicy{})
+	record(s, 0, 100)
+	record(s, 10*time.Second, 120)
+	record(s, 20*time.Second, 90)
+	record(s, 50*time.Second, 110)
+	record(s, time.Minute+5*time.Second, 105)
+	record(s, time.Hour+30*time.Second, 130)
+
+	minutes := s.Candles("crypto", "bitcoin", Minute, base, base.Add(2*time.Hour))
+	want := []Candle{
+		{Start: base, Open: 100, High: 120, Low: 90, Close: 110, Count: 4},
+		{Start: base.Add(time.Minute), Open: 105, High: 105, Low: 105, Close: 105, Count: 1},
+		{Start: base.Add(time.Hour), Open: 130, High: 130, Low: 130, Close: 130, Count: 1},
+	}
+	if !reflect.DeepEqual(minutes, want) {
+		t.Errorf("1m candles = %+v, want %+v", minutes, want)
+	}
+
+	hours := s.Candles("crypto", "bitcoin", Hour, base, base.Add(2*time.Hour))
+	want = []Candle{
+		{Start: base, Open: 100, High: 120, Low: 90, Close: 105, Count: 5},
+		{Start: base.Add(time.Hour), Open: 130, High: 130, Low: 130, Close: 130, Count: 1},
+	}
+	if !reflect.DeepEqual(hours, want) {
+		t.Errorf("1h candles = %+v, want %+v", hours, want)
+	}
+
+	days := s.Candles("crypto", "bitcoin", Day, base.Truncate(24*time.Hour), base.Add(time.Hour))
+	if len(days) != 1 || days[0].Open != 100 || days[0].High != 130 || days[0].Low != 90 || days[0].Close != 130 || days[0].Count != 6 {
+		t.Errorf("1d candles = %+v, want one candle over all six points", days)
+	}
+}
+
+func TestRecordDropsOutOfOrderPoints(t *testing.T) {
+	s := New(RetentionPolicy{})
+	record(s, time.Minute, 100)
+	record(s, 0, 50)
+
+	points := s.Range("crypto", "bitcoin", base, base.Add(time.Hour))
+	if len(points) != 1 || points[0].Price != 100 {
+		t.Errorf("points = %+v, want only the in-order point", points)
+	}
+	if c := s.Candles("crypto", "bitcoin", Minute, base, base.Add(time.Hour)); len(c) != 1 || c[0].Low != 100 {
+		t.Errorf("1m candles = %+v, want the late point left out", c)
+	}
+}
+
+func TestRetentionTrimsEachSeries(t *testing.T) {
+	s := New(RetentionPolicy{Raw: 10 * time.Minute, Minute: time.Hour, Hour: 24 * time.Hour, Day: 30 * 24 * time.Hour})
+	for i := 0; i <= 120; i++ {
+		record(s, time.Duration(i)*time.Minute, float64(i))
+	}
+	end := base.Add(120 * time.Minute)
+
+	points := s.Range("crypto", "bitcoin", base, end)
+	if len(points) != 11 || !points[0].Time.Equal(end.Add(-10*time.Minute)) {
+		t.Errorf("raw points = %d from %v, want the last 10 minutes", len(points), points[0].Time)
+	}
+	minutes := s.Candles("crypto", "bitcoin", Minute, base, end)
+	if len(minutes) != 61 || !minutes[0].Start.Equal(end.Add(-time.Hour)) {
+		t.Errorf("1m candles = %d from %v, want the last hour", len(minutes), minutes[0].Start)
+	}
+	if hours := s.Candles("crypto", "bitcoin", Hour, base, end); len(hours) != 3 {
+		t.Errorf("1h candles = %d, want all 3", len(hours))
+	}
+
+	// A week later only the day candle is still within retention
+	record(s, 7*24*time.Hour, 500)
+	if hours := s.Candles("crypto", "bitcoin", Hour, base, end); len(hours) != 0 {
+		t.Errorf("1h candles a week later = %+v, want none", hours)
+	}
+	if days := s.Candles("crypto", "bitcoin", Day, base.Truncate(24*time.Hour), end); len(days) != 1 {
+		t.Errorf("1d candles a week later = %+v, want the first day kept", days)
+	}
+}
+
+func TestRetentionCapsRawPoints(t *testing.T) {
+	s := New(RetentionPolicy{MaxRawPoints: 5})
+	for i := 0; i < 20; i++ {
+		record(s, time.Duration(i)*time.Second, float64(i))
+	}
+	points := s.Range("crypto", "bitcoin", base, base.Add(time.Hour))
+	if len(points) != 5 || points[0].Price != 15 || points[4].Price != 19 {
+		t.Errorf("raw points = %+v, want the newest 5", points)
+	}
+	// Rollups still saw every point
+	if c := s.Candles("crypto", "bitcoin", Minute, base, base); len(c) != 1 || c[0].Count != 20 || c[0].Open != 0 {
+		t.Errorf("1m candle = %+v, want all 20 points", c)
+	}
+}
+
+func TestNewFillsDefaults(t *testing.T) {
+	got := New(RetentionPolicy{Raw: time.Hour}).Retention()
+	want := DefaultRetention
+	want.Raw = time.Hour
+	if got != want {
+		t.Errorf("retention = %+v, want %+v", got, want)
+	}
+}
+
+func TestRangeAndLatest(t *testing.T) {
+	s := New(RetentionPolicy{})
+	for i := 0; i < 5; i++ {
+		record(s, time.Duration(i)*time.Minute, float64(100+i))
+	}
+	s.Record("stock", map[string]float64{"AAPL": 190, "MSFT": 400}, base)
+
+	points := s.Range("crypto", "bitcoin", base.Add(time.Minute), base.Add(3*time.Minute))
+	if len(points) != 3 || points[0].Price != 101 || points[2].Price != 103 {
+		t.Errorf("range = %+v, want the points at 1m to 3m inclusive", points)
+	}
+	if points := s.Range("crypto", "bitcoin", base.Add(time.Hour), base.Add(2*time.Hour)); points != nil {
+		t.Errorf("empty range = %+v, want nil", points)
+	}
+	if latest, ok := s.Latest("crypto", "bitcoin"); !ok || latest.Price != 104 {
+		t.Errorf("latest = %+v, %v, want 104", latest, ok)
+	}
+	if _, ok := s.Latest("crypto", "ethereum"); ok {
+		t.Error("latest found a price for an unrecorded symbol")
+	}
+	if got := s.Symbols("stock"); !reflect.DeepEqual(got, []string{"AAPL", "MSFT"}) {
+		t.Errorf("stock symbols = %v", got)
+	}
+}
+
+func TestSpanStitchesRawOntoRollups(t *testing.T) {
+	s := New(RetentionPolicy{Raw: 5 * time.Minute})
+	for i := 0; i <= 90; i++ {
+		record(s, time.Duration(i)*time.Minute, float64(i))
+	}
+	end := base.Add(90 * time.Minute)
+
+	span := s.Span("crypto", "bitcoin", base, end)
+	// 1m candles for 0..84, then the 6 raw points kept for 85..90
+	if len(span) != 91 {
+		t.Fatalf("span has %d candles, want 91", len(span))
+	}
+	for i, c := range span {
+		if !c.Start.Equal(base.Add(time.Duration(i)*time.Minute)) || c.Close != float64(i) {
+			t.Fatalf("span[%d] = %+v, want the minute %d price", i, c, i)
+		}
+	}
+
+	min, max, ok := s.MinMax("crypto", "bitcoin", base)
+	if !ok || min != 0 || max != 90 {
+		t.Errorf("MinMax = %v, %v, %v, want 0, 90", min, max, ok)
+	}
+}
+
+func TestParseResolution(t *testing.T) {
+	for _, r := range []Resolution{Minute, Hour, Day} {
+		if got, ok := ParseResolution(r.String()); !ok || got != r {
+			t.Errorf("ParseResolution(%q) = %v, %v", r.String(), got, ok)
+		}
+	}
+	if _, ok := ParseResolution("5m"); ok {
+		t.Error("ParseResolution accepted 5m")
+	}
+}
@@ -12,8 +12,40 @@ var (
 	)
 
 	// You could add more metrics: histograms for request latency, gauge for # of alerts, etc.
+
+	// Price provider health, labelled by provider name
+	PriceProviderRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "marketsentry_price_provider_requests_total",
+			Help: "Price provider fetches by outcome (success or failure)",
+		},
+		[]string{"provider", "outcome"},
+	)
+	PriceProviderLatency = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "marketsentry_price_provider_latency_seconds",
+			Help:    "Time taken by price provider fetches",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"provider"},
+	)
+	PriceProviderBreakerState = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "marketsentry_price_provider_breaker_state",
+			Help: "Circuit breaker state per price provider: 0 closed, 1 half-open, 2 open",
+		},
+		[]string{"provider"},
+	)
+	PriceFailovers = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "marketsentry_price_failovers_total",
+			Help: "Fetches served by a provider other than the first in the asset type's chain",
+		},
+		[]string{"asset_type"},
+	)
 )
 
 func init() {
 	prometheus.MustRegister(TotalRequests)
+	prometheus.MustRegister(PriceProviderRequests, PriceProviderLatency, PriceProviderBreakerState, PriceFailovers)
 }
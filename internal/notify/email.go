@@ -0,0 +1,66 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strings"
+
+	"github.com/jasonmichels/Market-Sentry/internal/storage"
+)
+
+// SMTPConfig is the outgoing mail server used by EmailNotifier.
+type SMTPConfig struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+// EmailNotifier emails the notification to the user's saved address.
+type EmailNotifier struct {
+	cfg SMTPConfig
+}
+
+func NewEmailNotifier(cfg SMTPConfig) *EmailNotifier {
+	if cfg.Port == "" {
+		cfg.Port = "587"
+	}
+	return &EmailNotifier{cfg: cfg}
+}
+
+func (n *EmailNotifier) Name() string { return "email" }
+
+func (n *EmailNotifier) Notify(ctx context.Context, user *storage.User, note storage.Notification) error {
+	if user.Email == "" {
+		return errors.New("no email address on file")
+	}
+	// net/smtp has no context support, so only a cancellation before sending counts
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if n.cfg.Username != "" {
+		auth = smtp.PlainAuth("", n.cfg.Username, n.cfg.Password, n.cfg.Host)
+	}
+
+	msg := strings.Join([]string{
+		"From: " + n.cfg.From,
+		"To: " + user.Email,
+		"Subject: Market Sentry alert",
+		"Content-Type: text/plain; charset=UTF-8",
+		"",
+		note.Message,
+		"",
+	}, "\r\n")
+
+	addr := net.JoinHostPort(n.cfg.Host, n.cfg.Port)
+	if err := smtp.SendMail(addr, auth, n.cfg.From, []string{user.Email}, []byte(msg)); err != nil {
+		return fmt.Errorf("failed to send email: %w", err)
+	}
+	return nil
+}
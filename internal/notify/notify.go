@@ -0,0 +1,118 @@
+package notify
+
+import (
+	"context"
+	"log"
+	"sort"
+	"sync"
+
+	"github.com/jasonmichels/Market-Sentry/internal/storage"
+)
+
+// DefaultChannels is used for alerts that don't name any channels,
+// including alerts created before channels were selectable.
+var DefaultChannels = []string{"sse", "sms"}
+
+// Notifier delivers a notification to a user over one channel.
+type Notifier interface {
+	// Name is the channel name users select, e.g. "sms"
+	Name() string
+	// Notify sends the notification; user is a current snapshot with contact details.
+	Notify(ctx context.Context, user *storage.User, note storage.Notification) error
+}
+
+// LiveNotifier is implemented by channels that can push transient updates to
+// whatever the user has open, outside of the notification outbox.
+type LiveNotifier interface {
+	Push(phone string, event any) error
+}
+
+// Registry holds the channels configured at startup.
+type Registry struct {
+	mu        sync.RWMutex
+	notifiers map[string]Notifier
+}
+
+// NewRegistry creates an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{notifiers: make(map[string]Notifier)}
+}
+
+// Register adds a channel, replacing any existing one with the same name.
+func (r *Registry) Register(n Notifier) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.notifiers[n.Name()] = n
+}
+
+// Get returns the channel with the given name.
+func (r *Registry) Get(name string) (Notifier, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	n, ok := r.notifiers[name]
+	return n, ok
+}
+
+// Names lists the registered channel names in sorted order.
+func (r *Registry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, 0, len(r.notifiers))
+	for name := range r.notifiers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Dispatcher fans a notification out to the channels an alert asked for.
+// Deliveries go through the outbox, so they survive failures and restarts.
+type Dispatcher struct {
+	registry *Registry
+	outbox   *Outbox
+}
+
+// NewDispatcher creates a dispatcher that queues deliveries on outbox.
+func NewDispatcher(registry *Registry, outbox *Outbox) *Dispatcher {
+	return &Dispatcher{registry: registry, outbox: outbox}
+}
+
+// Registry returns the channels the dispatcher delivers through.
+func (d *Dispatcher) Registry() *Registry {
+	return d.registry
+}
+
+// defaultChannels returns the DefaultChannels that are actually configured,
+// so alerts that never chose channels don't dead-letter deliveries for missing ones.
+func (d *Dispatcher) defaultChannels() []string {
+	var channels []string
+	for _, name := range DefaultChannels {
+		if _, ok := d.registry.Get(name); ok {
+			channels = append(channels, name)
+		}
+	}
+	return channels
+}
+
+// Push sends a transient status event, such as a price going stale, straight
+// to every channel that supports live updates. Nothing is queued or retried.
+func (d *Dispatcher) Push(phone string, event any) {
+	for _, name := range d.registry.Names() {
+		n, _ := d.registry.Get(name)
+		live, ok := n.(LiveNotifier)
+		if !ok {
+			continue
+		}
+		if err := live.Push(phone, event); err != nil {
+			log.Printf("[Notify] Failed to push %s event to %s: %v", name, phone, err)
+		}
+	}
+}
+
+// Dispatch queues note for delivery to the user over each channel.
+func (d *Dispatcher) Dispatch(phone string, channels []string, note storage.Notification) {
+	if len(channels) == 0 {
+		channels = d.defaultChannels()
+	}
+	d.outbox.Enqueue(phone, channels, note)
+}
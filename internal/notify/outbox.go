@@ -0,0 +1,336 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jasonmichels/Market-Sentry/internal/storage"
+)
+
+// OutboxConfig tunes delivery retries.
+type OutboxConfig struct {
+	Workers      int           // concurrent deliveries
+	MaxAttempts  int           // attempts before an item is dead-lettered
+	BaseBackoff  time.Duration // delay after the first failure; doubles each attempt
+	MaxBackoff   time.Duration // cap on the delay between attempts
+	PollInterval time.Duration // how often to look for due items
+	Lease        time.Duration // how long a claimed item is hidden from other polls
+}
+
+// DefaultOutboxConfig is used for any zero field in the config passed to NewOutbox.
+var DefaultOutboxConfig = OutboxConfig{
+	Workers:      4,
+	MaxAttempts:  8,
+	BaseBackoff:  30 * time.Second,
+	MaxBackoff:   30 * time.Minute,
+	PollInterval: 5 * time.Second,
+	Lease:        2 * time.Minute,
+}
+
+// ErrDeliveryInFlight is returned by Requeue while a worker is attempting the item.
+var ErrDeliveryInFlight = errors.New("delivery is being attempted")
+
+// Outbox delivers notifications from a persistent queue. Every channel delivery
+// is stored before it is attempted, retried with exponential backoff and jitter
+// on failure, and dead-lettered after MaxAttempts until an admin requeues it.
+type Outbox struct {
+	store    storage.Store
+	registry *Registry
+	cfg      OutboxConfig
+
+	// leaseMu keeps Requeue from resetting an item while poll leases it
+	leaseMu sync.Mutex
+
+	wake chan struct{}
+	stop chan struct{}
+	wg   sync.WaitGroup
+
+	// ctx is passed to every delivery; Stop cancels it if its deadline passes
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// NewOutbox creates an outbox; call Start to begin delivering.
+func NewOutbox(store storage.Store, registry *Registry, cfg OutboxConfig) *Outbox {
+	d := DefaultOutboxConfig
+	if cfg.Workers <= 0 {
+		cfg.Workers = d.Workers
+	}
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = d.MaxAttempts
+	}
+	if cfg.BaseBackoff <= 0 {
+		cfg.BaseBackoff = d.BaseBackoff
+	}
+	if cfg.MaxBackoff <= 0 {
+		cfg.MaxBackoff = d.MaxBackoff
+	}
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = d.PollInterval
+	}
+	if cfg.Lease <= 0 {
+		cfg.Lease = d.Lease
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Outbox{
+		store:    store,
+		registry: registry,
+		cfg:      cfg,
+		wake:     make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+}
+
+// Start launches the poller and worker pool.
+func (o *Outbox) Start() {
+	jobs := make(chan storage.OutboxItem)
+
+	o.wg.Add(1)
+	go func() {
+		defer o.wg.Done()
+		defer close(jobs)
+		o.poll(jobs)
+	}()
+
+	for i := 0; i < o.cfg.Workers; i++ {
+		o.wg.Add(1)
+		go func() {
+			defer o.wg.Done()
+			for item := range jobs {
+				o.attempt(item)
+			}
+		}()
+	}
+	log.Printf("[Outbox] Started %d workers (max %d attempts)", o.cfg.Workers, o.cfg.MaxAttempts)
+}
+
+// Stop stops polling, lets in-flight deliveries finish and then makes one last
+// attempt at every item that is already due, so notifications raised just
+// before shutdown go out. When ctx expires, deliveries still running are
+// cancelled. Anything left undelivered stays in the store for the next start.
+func (o *Outbox) Stop(ctx context.Context) {
+	defer o.cancel()
+	stopDelivering := context.AfterFunc(ctx, o.cancel)
+	defer stopDelivering()
+
+	close(o.stop)
+	o.wg.Wait()
+
+	flushed := 0
+	for _, item := range o.store.DueOutboxItems(time.Now(), 0) {
+		if o.ctx.Err() != nil {
+			break
+		}
+		o.attempt(item)
+		flushed++
+	}
+	if flushed > 0 {
+		log.Printf("[Outbox] Flushed %d due deliveries on shutdown", flushed)
+	}
+}
+
+// Enqueue stores one delivery per channel and wakes the workers.
+func (o *Outbox) Enqueue(phone string, channels []string, note storage.Notification) {
+	now := time.Now()
+	for _, channel := range channels {
+		item := storage.OutboxItem{
+			ID:             uuid.New().String(),
+			Phone:          phone,
+			NotificationID: note.ID,
+			Channel:        channel,
+			Status:         storage.DeliveryPending,
+			NextAttempt:    now,
+			CreatedAt:      now,
+		}
+		if _, ok := o.registry.Get(channel); !ok {
+			// No amount of retrying will make a missing channel appear
+			item.Status = storage.DeliveryDead
+			item.LastError = "channel not configured"
+		}
+
+		if err := o.store.SaveOutboxItem(item); err != nil {
+			log.Printf("[Outbox] Failed to enqueue %s delivery for notification %s: %v", channel, note.ID, err)
+			continue
+		}
+		o.recordStatus(item, now)
+	}
+	o.signal()
+}
+
+// Requeue resets a dead or retrying item so it is attempted again right away.
+// It returns ErrDeliveryInFlight while a worker holds the item's lease, since
+// the next poll would then deliver it a second time.
+func (o *Outbox) Requeue(id string) error {
+	o.leaseMu.Lock()
+	defer o.leaseMu.Unlock()
+	item, ok := o.store.GetOutboxItem(id)
+	if !ok {
+		return fmt.Errorf("outbox item %s not found", id)
+	}
+	now := time.Now()
+	if item.LeasedUntil.After(now) {
+		return ErrDeliveryInFlight
+	}
+	item.Status = storage.DeliveryPending
+	item.Attempts = 0
+	item.NextAttempt = now
+	if err := o.store.SaveOutboxItem(item); err != nil {
+		return err
+	}
+	o.recordStatus(item, now)
+	o.signal()
+	return nil
+}
+
+// Stuck lists dead-lettered items and items that have failed at least once.
+func (o *Outbox) Stuck() []storage.OutboxItem {
+	var stuck []storage.OutboxItem
+	for _, item := range o.store.ListOutbox() {
+		if item.Status == storage.DeliveryDead || item.Attempts > 0 {
+			stuck = append(stuck, item)
+		}
+	}
+	return stuck
+}
+
+func (o *Outbox) signal() {
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+}
+
+// poll hands due items to the workers, leasing each one first so the
+// next poll doesn't pick it up again while it is in flight.
+func (o *Outbox) poll(jobs chan<- storage.OutboxItem) {
+	ticker := time.NewTicker(o.cfg.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		now := time.Now()
+		for _, item := range o.store.DueOutboxItems(now, o.cfg.Workers*2) {
+			leased, ok := o.lease(item.ID, now)
+			if !ok {
+				continue
+			}
+			select {
+			case jobs <- leased:
+			case <-o.stop:
+				return
+			}
+		}
+
+		select {
+		case <-ticker.C:
+		case <-o.wake:
+		case <-o.stop:
+			return
+		}
+	}
+}
+
+// lease hides a due item from later polls and from Requeue until the attempt
+// is over or the lease runs out. ok is false if the item is no longer due.
+func (o *Outbox) lease(id string, now time.Time) (storage.OutboxItem, bool) {
+	o.leaseMu.Lock()
+	defer o.leaseMu.Unlock()
+	item, ok := o.store.GetOutboxItem(id)
+	if !ok || item.Status == storage.DeliveryDead || item.NextAttempt.After(now) {
+		return item, false
+	}
+	item.NextAttempt = now.Add(o.cfg.Lease)
+	item.LeasedUntil = item.NextAttempt
+	if err := o.store.SaveOutboxItem(item); err != nil {
+		log.Printf("[Outbox] Failed to lease item %s: %v", id, err)
+		return item, false
+	}
+	return item, true
+}
+
+// attempt makes one delivery attempt and stores the outcome.
+func (o *Outbox) attempt(item storage.OutboxItem) {
+	err := o.deliver(item)
+	now := time.Now()
+	item.Attempts++
+	item.LeasedUntil = time.Time{}
+
+	if err == nil {
+		if err := o.store.DeleteOutboxItem(item.ID); err != nil {
+			log.Printf("[Outbox] Failed to remove delivered item %s: %v", item.ID, err)
+		}
+		item.Status = storage.DeliverySent
+		item.LastError = ""
+		o.recordStatus(item, now)
+		return
+	}
+
+	item.LastError = err.Error()
+	if item.Attempts >= o.cfg.MaxAttempts {
+		item.Status = storage.DeliveryDead
+		log.Printf("[Outbox] Dead-lettered %s delivery %s after %d attempts: %v", item.Channel, item.ID, item.Attempts, err)
+	} else {
+		item.Status = storage.DeliveryRetrying
+		item.NextAttempt = now.Add(o.backoff(item.Attempts))
+		log.Printf("[Outbox] %s delivery %s failed (attempt %d), retrying at %s: %v",
+			item.Channel, item.ID, item.Attempts, item.NextAttempt.Format(time.RFC3339), err)
+	}
+
+	if err := o.store.SaveOutboxItem(item); err != nil {
+		log.Printf("[Outbox] Failed to save item %s: %v", item.ID, err)
+	}
+	o.recordStatus(item, now)
+}
+
+// deliver sends the item's notification over its channel
+func (o *Outbox) deliver(item storage.OutboxItem) error {
+	n, ok := o.registry.Get(item.Channel)
+	if !ok {
+		return errors.New("channel not configured")
+	}
+	user := o.store.GetUser(item.Phone)
+	if user == nil {
+		return storage.ErrUserNotFound
+	}
+	for _, note := range user.Notifications {
+		if note.ID == item.NotificationID {
+			return n.Notify(o.ctx, user, note)
+		}
+	}
+	return fmt.Errorf("notification %s not found", item.NotificationID)
+}
+
+// backoff doubles BaseBackoff per attempt up to MaxBackoff, then picks a random
+// delay in [d/2, d) so failed deliveries don't retry in lockstep.
+func (o *Outbox) backoff(attempts int) time.Duration {
+	d := o.cfg.BaseBackoff
+	for i := 1; i < attempts && d < o.cfg.MaxBackoff; i++ {
+		d *= 2
+	}
+	if d > o.cfg.MaxBackoff {
+		d = o.cfg.MaxBackoff
+	}
+	half := d / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+// recordStatus mirrors the item's state onto its notification
+func (o *Outbox) recordStatus(item storage.OutboxItem, at time.Time) {
+	d := storage.Delivery{
+		Channel:  item.Channel,
+		Status:   item.Status,
+		Attempts: item.Attempts,
+		Error:    item.LastError,
+		At:       at,
+	}
+	if err := o.store.RecordDelivery(item.Phone, item.NotificationID, d); err != nil {
+		log.Printf("[Outbox] Failed to record %s status for notification %s: %v", item.Channel, item.NotificationID, err)
+	}
+}
@@ -0,0 +1,126 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jasonmichels/Market-Sentry/internal/storage"
+)
+
+const testPhone = "+15551234567"
+
+// fakeNotifier counts deliveries and fails them with err
+type fakeNotifier struct {
+	err  error
+	sent int
+}
+
+func (f *fakeNotifier) Name() string { return "sms" }
+
+func (f *fakeNotifier) Notify(ctx context.Context, user *storage.User, note storage.Notification) error {
+	f.sent++
+	return f.err
+}
+
+// newTestOutbox has one sms delivery of a triggered alert's notification queued
+func newTestOutbox(t *testing.T, n *fakeNotifier) (*Outbox, *storage.MemoryStore, string) {
+	t.Helper()
+	store := storage.NewMemoryStore(nil)
+	if _, err := store.GetOrCreateUser(testPhone); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.AddAlert(testPhone, storage.Alert{ID: "a1"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.TriggerAlert(testPhone, "a1", 0, storage.Notification{ID: "n1", AlertID: "a1"}); err != nil {
+		t.Fatal(err)
+	}
+	registry := NewRegistry()
+	registry.Register(n)
+	o := NewOutbox(store, registry, OutboxConfig{MaxAttempts: 2})
+	o.Enqueue(testPhone, []string{"sms"}, storage.Notification{ID: "n1"})
+	items := store.ListOutbox()
+	if len(items) != 1 {
+		t.Fatalf("%d outbox items, want 1", len(items))
+	}
+	return o, store, items[0].ID
+}
+
+func TestRequeueWaitsForLease(t *testing.T) {
+	n := &fakeNotifier{err: errors.New("carrier down")}
+	o, store, id := newTestOutbox(t, n)
+
+	leased, ok := o.lease(id, time.Now())
+	if !ok {
+		t.Fatal("due item not leased")
+	}
+	if _, ok := o.lease(id, time.Now()); ok {
+		t.Error("leased item leased again")
+	}
+	if err := o.Requeue(id); !errors.Is(err, ErrDeliveryInFlight) {
+		t.Fatalf("requeue during the attempt = %v, want ErrDeliveryInFlight", err)
+	}
+
+	// Once the attempt fails the item can be requeued ahead of its backoff
+	o.attempt(leased)
+	item, _ := store.GetOutboxItem(id)
+	if item.Status != storage.DeliveryRetrying || !item.LeasedUntil.IsZero() {
+		t.Fatalf("after a failed attempt: %+v", item)
+	}
+	if err := o.Requeue(id); err != nil {
+		t.Fatalf("Requeue: %v", err)
+	}
+	item, _ = store.GetOutboxItem(id)
+	if item.Status != storage.DeliveryPending || item.Attempts != 0 || item.NextAttempt.After(time.Now()) {
+		t.Errorf("requeued item = %+v, want pending and due", item)
+	}
+
+	// A lease that ran out no longer blocks requeueing
+	leased, ok = o.lease(id, time.Now())
+	if !ok {
+		t.Fatal("requeued item not leased")
+	}
+	leased.LeasedUntil = time.Now().Add(-time.Second)
+	if err := store.SaveOutboxItem(leased); err != nil {
+		t.Fatal(err)
+	}
+	if err := o.Requeue(id); err != nil {
+		t.Errorf("requeue after the lease ran out: %v", err)
+	}
+}
+
+func TestRequeueDeadItem(t *testing.T) {
+	n := &fakeNotifier{err: errors.New("carrier down")}
+	o, store, id := newTestOutbox(t, n)
+	for i := 0; i < 2; i++ {
+		leased, ok := o.lease(id, time.Now().Add(time.Hour))
+		if !ok {
+			t.Fatalf("attempt %d: item not leased", i+1)
+		}
+		o.attempt(leased)
+	}
+	if item, _ := store.GetOutboxItem(id); item.Status != storage.DeliveryDead {
+		t.Fatalf("after the last attempt: %+v, want dead", item)
+	}
+	if _, ok := o.lease(id, time.Now().Add(time.Hour)); ok {
+		t.Error("dead item leased")
+	}
+
+	n.err = nil
+	if err := o.Requeue(id); err != nil {
+		t.Fatalf("Requeue: %v", err)
+	}
+	leased, ok := o.lease(id, time.Now())
+	if !ok {
+		t.Fatal("requeued item not leased")
+	}
+	o.attempt(leased)
+	if _, ok := store.GetOutboxItem(id); ok || n.sent != 3 {
+		t.Errorf("after delivering: item kept = %v, %d sends, want removed after 3", ok, n.sent)
+	}
+	if err := o.Requeue(id); err == nil {
+		t.Error("delivered item requeued")
+	}
+}
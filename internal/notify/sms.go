@@ -0,0 +1,21 @@
+package notify
+
+import (
+	"context"
+
+	"github.com/jasonmichels/Market-Sentry/internal/storage"
+	"github.com/jasonmichels/Market-Sentry/internal/twilio"
+)
+
+// SMSNotifier texts the notification to the user's phone via Twilio.
+type SMSNotifier struct{}
+
+func NewSMSNotifier() *SMSNotifier {
+	return &SMSNotifier{}
+}
+
+func (n *SMSNotifier) Name() string { return "sms" }
+
+func (n *SMSNotifier) Notify(ctx context.Context, user *storage.User, note storage.Notification) error {
+	return twilio.SendSMS(ctx, user.PhoneNumber, "Market Sentry: "+note.Message)
+}
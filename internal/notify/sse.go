@@ -0,0 +1,42 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/jasonmichels/Market-Sentry/internal/sse"
+	"github.com/jasonmichels/Market-Sentry/internal/storage"
+)
+
+// SSENotifier pushes the notification to the user's open browser tabs.
+type SSENotifier struct {
+	hub *sse.SSEHub
+}
+
+func NewSSENotifier(hub *sse.SSEHub) *SSENotifier {
+	return &SSENotifier{hub: hub}
+}
+
+func (n *SSENotifier) Name() string { return "sse" }
+
+func (n *SSENotifier) Notify(_ context.Context, user *storage.User, note storage.Notification) error {
+	msg, err := json.Marshal(map[string]string{
+		"type":    "alertsUpdated",
+		"message": note.Message,
+	})
+	if err != nil {
+		return err
+	}
+	n.hub.BroadcastToUser(user.PhoneNumber, string(msg))
+	return nil
+}
+
+// Push sends event, marshalled to JSON, to the user's open browser tabs.
+func (n *SSENotifier) Push(phone string, event any) error {
+	msg, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	n.hub.BroadcastToUser(phone, string(msg))
+	return nil
+}
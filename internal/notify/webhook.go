@@ -0,0 +1,144 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"time"
+
+	"github.com/jasonmichels/Market-Sentry/internal/storage"
+)
+
+// webhookPayload is the JSON body POSTed to a user's webhook URL
+type webhookPayload struct {
+	NotificationID string    `json:"notificationId"`
+	AlertID        string    `json:"alertId"`
+	Phone          string    `json:"phone"`
+	Message        string    `json:"message"`
+	Timestamp      time.Time `json:"timestamp"`
+}
+
+// ErrPrivateAddress is returned for webhook URLs that point at loopback,
+// private, link-local or otherwise non-public addresses.
+var ErrPrivateAddress = errors.New("webhook address is not a public internet address")
+
+// WebhookNotifier POSTs the notification as JSON to the user's webhook URL.
+// It only connects to public addresses, checked on every dial so a host that
+// re-resolves to an internal address (DNS rebinding) or a redirect there fails.
+type WebhookNotifier struct {
+	client *http.Client
+}
+
+func NewWebhookNotifier() *WebhookNotifier {
+	dialer := &net.Dialer{
+		Timeout: 5 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if !publicIP(net.ParseIP(host)) {
+				return ErrPrivateAddress
+			}
+			return nil
+		},
+	}
+	transport := &http.Transport{
+		DialContext:         dialer.DialContext,
+		TLSHandshakeTimeout: 5 * time.Second,
+	}
+	return &WebhookNotifier{client: &http.Client{Timeout: 5 * time.Second, Transport: transport}}
+}
+
+// CheckWebhookURL reports whether raw is an http(s) URL whose host resolves
+// only to public addresses. Notify checks again when it connects.
+func CheckWebhookURL(ctx context.Context, raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return errors.New("webhook URL must be a full http:// or https:// URL")
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, u.Hostname())
+	if err != nil {
+		return fmt.Errorf("look up webhook host: %w", err)
+	}
+	for _, addr := range addrs {
+		if !publicIP(addr.IP) {
+			return ErrPrivateAddress
+		}
+	}
+	return nil
+}
+
+// nonPublicNets are ranges the net.IP predicates don't cover: "this network",
+// carrier-grade NAT, and the NAT64 prefix, which reaches any IPv4 address
+// through the gateway, private ones included.
+var nonPublicNets = []*net.IPNet{
+	mustCIDR("0.0.0.0/8"),
+	mustCIDR("100.64.0.0/10"),
+	mustCIDR("64:ff9b::/96"),
+}
+
+func mustCIDR(s string) *net.IPNet {
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		panic(err)
+	}
+	return n
+}
+
+// publicIP reports whether ip is a routable internet address
+func publicIP(ip net.IP) bool {
+	if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return false
+	}
+	for _, n := range nonPublicNets {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+func (n *WebhookNotifier) Name() string { return "webhook" }
+
+func (n *WebhookNotifier) Notify(ctx context.Context, user *storage.User, note storage.Notification) error {
+	if user.WebhookURL == "" {
+		return errors.New("no webhook URL on file")
+	}
+
+	body, err := json.Marshal(webhookPayload{
+		NotificationID: note.ID,
+		AlertID:        note.AlertID,
+		Phone:          user.PhoneNumber,
+		Message:        note.Message,
+		Timestamp:      note.Timestamp,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, user.WebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "MarketSentry-Webhook")
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("webhook request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook returned status %s", resp.Status)
+	}
+	return nil
+}
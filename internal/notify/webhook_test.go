@@ -0,0 +1,48 @@
+package notify
+
+import (
+	"net"
+	"testing"
+)
+
+func TestPublicIP(t *testing.T) {
+	cases := []struct {
+		ip   string
+		want bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:2800:220:1:248:1893:25c8:1946", true},
+		{"100.63.255.255", true},
+		{"100.128.0.0", true},
+		{"1.0.0.0", true},
+		{"64:ff9b:1::a00:1", true}, // the local-use NAT64 prefix isn't the well-known one
+
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"fd00::1", false},
+		{"169.254.169.254", false},
+		{"fe80::1", false},
+		{"0.0.0.0", false},
+		{"::", false},
+		{"0.1.2.3", false},
+		{"100.64.0.1", false},
+		{"100.127.255.254", false},
+		{"::ffff:100.100.100.100", false},
+		{"::ffff:10.0.0.1", false},
+		{"64:ff9b::a00:1", false},     // NAT64 for 10.0.0.1
+		{"64:ff9b::5db8:d822", false}, // NAT64 for a public address is still refused
+		{"224.0.0.1", false},
+		{"ff02::1", false},
+	}
+	for _, c := range cases {
+		if got := publicIP(net.ParseIP(c.ip)); got != c.want {
+			t.Errorf("publicIP(%s) = %v, want %v", c.ip, got, c.want)
+		}
+	}
+	if publicIP(nil) {
+		t.Error("publicIP(nil) = true")
+	}
+}
@@ -0,0 +1,215 @@
+package prices
+
+import (
+	"fmt"
+	"log"
+	"maps"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jasonmichels/Market-Sentry/internal/history"
+	"github.com/jasonmichels/Market-Sentry/internal/rules"
+	"github.com/jasonmichels/Market-Sentry/internal/storage"
+)
+
+// PriceFunc looks up the stored price of an asset; ok is false if there isn't
+// a usable one.
+type PriceFunc func(assetType, symbol string) (price float64, ok bool)
+
+// EvalCondition reports whether a compound alert's condition holds. Every leaf
+// is evaluated, and ok is false if any of their prices is unavailable, so a
+// missing price never decides the outcome.
+func EvalCondition(c storage.Condition, price PriceFunc) (met, ok bool) {
+	switch c.Op {
+	case storage.OpPrice:
+		stored, ok := price(c.AssetType, c.Symbol)
+		if !ok {
+			return false, false
+		}
+		return ConditionHolds(leafAlert(c), ConvertLeafPrice(c, stored)), true
+	case storage.OpNot:
+		if len(c.Children) != 1 {
+			return false, false
+		}
+		met, ok := EvalCondition(c.Children[0], price)
+		return !met && ok, ok
+	case storage.OpAnd, storage.OpOr:
+		if len(c.Children) == 0 {
+			return false, false
+		}
+		met = c.Op == storage.OpAnd
+		for _, child := range c.Children {
+			childMet, childOK := EvalCondition(child, price)
+			if !childOK {
+				return false, false
+			}
+			if c.Op == storage.OpAnd {
+				met = met && childMet
+			} else {
+				met = met || childMet
+			}
+		}
+		return met, true
+	}
+	return false, false
+}
+
+// EvalRule reports whether a compound alert's condition or an expression
+// alert's rule holds, like EvalCondition. Without hist, rules that look at
+// price history can't be evaluated.
+func EvalRule(alert storage.Alert, price PriceFunc, hist *history.Store, now time.Time) (met, ok bool) {
+	switch alert.Kind {
+	case storage.KindCompound:
+		if alert.Condition == nil {
+			return false, false
+		}
+		return EvalCondition(*alert.Condition, price)
+	case storage.KindExpression:
+		program := compiledRule(alert)
+		if program == nil {
+			return false, false
+		}
+		return program.Eval(rules.Env{Now: now, Price: price, Hist: hist})
+	}
+	return false, false
+}
+
+// ruleCacheSize bounds the compiled rule cache; it is emptied when full, which
+// only costs recompiling the rules still in use
+const ruleCacheSize = 10000
+
+// compiledRules caches expression alerts' compiled rules by alert ID, so each
+// rule is compiled once rather than on every check
+var (
+	rulesMu       sync.Mutex
+	compiledRules = make(map[string]compiledRuleEntry)
+)
+
+type compiledRuleEntry struct {
+	source   string
+	bindings map[string]storage.AssetRef
+	program  *rules.Program // nil if the rule doesn't compile
+}
+
+// compiledRule is an expression alert's compiled rule, or nil if it doesn't
+// compile. The cached program is reused until the alert's rule changes.
+func compiledRule(alert storage.Alert) *rules.Program {
+	rulesMu.Lock()
+	defer rulesMu.Unlock()
+	if e, ok := compiledRules[alert.ID]; ok && e.source == alert.Expression && maps.Equal(e.bindings, alert.Bindings) {
+		return e.program
+	}
+
+	program, err := rules.Compile(alert.Expression, rules.Bound(alert.Bindings))
+	if err != nil {
+		log.Printf("[Alert Trigger] Rule of alert %s doesn't compile: %v", alert.ID, err)
+		program = nil
+	}
+	if len(compiledRules) >= ruleCacheSize {
+		clear(compiledRules)
+	}
+	compiledRules[alert.ID] = compiledRuleEntry{source: alert.Expression, bindings: maps.Clone(alert.Bindings), program: program}
+	return program
+}
+
+// ConditionState is the storage.State* value for whether a condition holds
+func ConditionState(met bool) string {
+	if met {
+		return storage.StateMet
+	}
+	return storage.StateUnmet
+}
+
+// becameTrue reports whether a compound alert's condition has just started to
+// hold. The first check only counts if the alert fires immediately.
+func becameTrue(alert storage.Alert, state string) bool {
+	if state != storage.StateMet {
+		return false
+	}
+	if alert.LastState == "" {
+		return alert.FireImmediately
+	}
+	return alert.LastState != state
+}
+
+// ConvertLeafPrice converts a stored price into the unit a condition leaf is expressed in.
+func ConvertLeafPrice(c storage.Condition, stored float64) float64 {
+	return AlertPrice(leafAlert(c), stored)
+}
+
+// leafAlert is a condition leaf as a threshold alert, so leaves share the
+// threshold alert helpers
+func leafAlert(c storage.Condition) storage.Alert {
+	return storage.Alert{
+		Kind:      storage.KindThreshold,
+		AssetType: c.AssetType,
+		Symbol:    c.Symbol,
+		Unit:      c.Unit,
+		Above:     c.Above,
+		Threshold: c.Threshold,
+	}
+}
+
+// DescribeCondition renders a condition tree for people, e.g.
+// "bitcoin below $50000.00 AND (gold above $80.00 per gram OR NOT silver above $35.00)".
+func DescribeCondition(c storage.Condition) string {
+	switch c.Op {
+	case storage.OpPrice:
+		direction := "below"
+		if c.Above {
+			direction = "above"
+		}
+		return fmt.Sprintf("%s %s %s%s", c.Symbol, direction, FormatUSD(c.Threshold), UnitSuffix(leafAlert(c)))
+	case storage.OpNot:
+		if len(c.Children) != 1 {
+			return "NOT ?"
+		}
+		return "NOT " + describeOperand(c.Children[0])
+	default:
+		parts := make([]string, len(c.Children))
+		for i, child := range c.Children {
+			parts[i] = describeOperand(child)
+		}
+		return strings.Join(parts, " "+strings.ToUpper(c.Op)+" ")
+	}
+}
+
+// describeOperand describes a condition nested in another, bracketing groups
+// of more than one condition
+func describeOperand(c storage.Condition) string {
+	if (c.Op == storage.OpAnd || c.Op == storage.OpOr) && len(c.Children) > 1 {
+		return "(" + DescribeCondition(c) + ")"
+	}
+	return DescribeCondition(c)
+}
+
+// checkRuleAlert returns the notification message for a compound or
+// expression alert whose condition has just become true, listing the prices
+// that decided it.
+func checkRuleAlert(alert storage.Alert, price PriceFunc) string {
+	var met string
+	var leaves []storage.Condition
+	if alert.Kind == storage.KindCompound {
+		met = "Condition met: " + DescribeCondition(*alert.Condition)
+		leaves = alert.Condition.Leaves()
+	} else {
+		met = "Rule met: " + alert.Expression
+		for _, asset := range alert.Assets() {
+			leaves = append(leaves, storage.Condition{AssetType: asset.AssetType, Symbol: asset.Symbol})
+		}
+	}
+
+	var current []string
+	seen := make(map[string]bool)
+	for _, leaf := range leaves {
+		key := leaf.AssetType + "/" + leaf.Symbol + "/" + leaf.Unit
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		stored, _ := price(leaf.AssetType, leaf.Symbol)
+		current = append(current, fmt.Sprintf("%s %s%s", leaf.Symbol, FormatUSD(ConvertLeafPrice(leaf, stored)), UnitSuffix(leafAlert(leaf))))
+	}
+	return fmt.Sprintf("%s (current prices: %s)", met, strings.Join(current, ", "))
+}
@@ -0,0 +1,168 @@
+package prices
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jasonmichels/Market-Sentry/internal/storage"
+)
+
+// resetRuleCache empties the compiled rule cache before and after a test
+func resetRuleCache(t *testing.T) {
+	t.Helper()
+	clearRules := func() {
+		rulesMu.Lock()
+		clear(compiledRules)
+		rulesMu.Unlock()
+	}
+	clearRules()
+	t.Cleanup(clearRules)
+}
+
+func expressionAlert(id, rule string) storage.Alert {
+	return storage.Alert{
+		ID:         id,
+		Kind:       storage.KindExpression,
+		Expression: rule,
+		Bindings: map[string]storage.AssetRef{
+			"bitcoin": {AssetType: "crypto", Symbol: "bitcoin"},
+			"gold":    {AssetType: "metal", Symbol: "XAU"},
+		},
+	}
+}
+
+func TestCompiledRuleCache(t *testing.T) {
+	resetRuleCache(t)
+	alert := expressionAlert("a1", "price(bitcoin) > 50000")
+
+	first := compiledRule(alert)
+	if first == nil {
+		t.Fatal("rule didn't compile")
+	}
+	if again := compiledRule(alert); again != first {
+		t.Error("unchanged rule was compiled again")
+	}
+	if other := compiledRule(expressionAlert("a2", alert.Expression)); other == first {
+		t.Error("another alert with the same rule shares its cache entry")
+	}
+
+	// Editing the rule or what its names stand for replaces the cached program
+	edited := alert
+	edited.Expression = "price(gold) > 2400"
+	if p := compiledRule(edited); p == nil || p == first || p.Source != edited.Expression {
+		t.Errorf("program after editing the rule = %+v, want the new rule", p)
+	}
+	rebound := expressionAlert("a1", "price(bitcoin) > 50000")
+	rebound.Bindings["bitcoin"] = storage.AssetRef{AssetType: "stock", Symbol: "BITCOIN"}
+	p := compiledRule(rebound)
+	if p == nil || p == first || p.Bindings["bitcoin"].AssetType != "stock" {
+		t.Errorf("program after rebinding = %+v, want the new binding", p)
+	}
+
+	// The cache keeps its own copy of the bindings
+	rebound.Bindings["bitcoin"] = storage.AssetRef{AssetType: "crypto", Symbol: "bitcoin"}
+	if again := compiledRule(rebound); again == p {
+		t.Error("changing the alert's bindings in place changed the cache entry")
+	}
+}
+
+func TestCompiledRuleCachesFailures(t *testing.T) {
+	resetRuleCache(t)
+	broken := expressionAlert("a1", "price(silver) > 30")
+	if p := compiledRule(broken); p != nil {
+		t.Fatalf("rule with an unbound name compiled: %+v", p)
+	}
+	if e, ok := compiledRules[broken.ID]; !ok || e.program != nil {
+		t.Errorf("cache entry = %+v, %v, want the failure remembered", e, ok)
+	}
+	if met, ok := EvalRule(broken, func(string, string) (float64, bool) { return 1, true }, nil, time.Now()); ok {
+		t.Errorf("broken rule evaluated to %v", met)
+	}
+}
+
+func TestCompiledRuleCacheBounded(t *testing.T) {
+	resetRuleCache(t)
+	for i := 0; i < ruleCacheSize; i++ {
+		compiledRule(expressionAlert(fmt.Sprint("a", i), "price(bitcoin) > 1"))
+	}
+	if len(compiledRules) != ruleCacheSize {
+		t.Fatalf("cache holds %d rules, want %d", len(compiledRules), ruleCacheSize)
+	}
+	if p := compiledRule(expressionAlert("one-more", "price(bitcoin) > 1")); p == nil {
+		t.Fatal("rule didn't compile")
+	}
+	if len(compiledRules) != 1 {
+		t.Errorf("cache holds %d rules after overflowing, want it emptied and refilled with 1", len(compiledRules))
+	}
+}
+
+// leaf holds when symbol's price is above (or below) threshold
+func leaf(assetType, symbol string, above bool, threshold float64) storage.Condition {
+	return storage.Condition{Op: storage.OpPrice, AssetType: assetType, Symbol: symbol, Above: above, Threshold: threshold}
+}
+
+func node(op string, children ...storage.Condition) storage.Condition {
+	return storage.Condition{Op: op, Children: children}
+}
+
+func TestEvalCondition(t *testing.T) {
+	stored := map[string]float64{"bitcoin": 60000, "ethereum": 2500, "XAU": 3110.34768}
+	price := func(assetType, symbol string) (float64, bool) {
+		p, ok := stored[symbol]
+		return p, ok
+	}
+	btcAbove := leaf("crypto", "bitcoin", true, 50000) // holds
+	ethAbove := leaf("crypto", "ethereum", true, 3000) // doesn't hold
+	missing := leaf("crypto", "solana", true, 100)
+	goldPerGram := leaf("metal", "XAU", false, 101)
+	goldPerGram.Unit = "g"
+
+	cases := []struct {
+		name    string
+		c       storage.Condition
+		met, ok bool
+	}{
+		{"leaf", btcAbove, true, true},
+		{"leaf in another unit", goldPerGram, true, true},
+		{"and, all hold", node(storage.OpAnd, btcAbove, goldPerGram), true, true},
+		{"and, one fails", node(storage.OpAnd, btcAbove, ethAbove), false, true},
+		{"or, one holds", node(storage.OpOr, ethAbove, btcAbove), true, true},
+		{"or, none hold", node(storage.OpOr, ethAbove, node(storage.OpNot, btcAbove)), false, true},
+		{"not", node(storage.OpNot, ethAbove), true, true},
+		{"nested", node(storage.OpAnd, btcAbove, node(storage.OpOr, ethAbove, node(storage.OpNot, ethAbove))), true, true},
+
+		// A missing price never decides the outcome, even where it couldn't change it
+		{"missing leaf", missing, false, false},
+		{"and with a missing price", node(storage.OpAnd, ethAbove, missing), false, false},
+		{"or with a missing price", node(storage.OpOr, btcAbove, missing), false, false},
+		{"not of a missing price", node(storage.OpNot, missing), false, false},
+		{"missing price deep down", node(storage.OpOr, btcAbove, node(storage.OpAnd, btcAbove, node(storage.OpNot, missing))), false, false},
+
+		{"empty and", node(storage.OpAnd), false, false},
+		{"empty or", node(storage.OpOr), false, false},
+		{"not without a child", node(storage.OpNot), false, false},
+		{"not with two children", node(storage.OpNot, btcAbove, ethAbove), false, false},
+		{"unknown op", node("xor", btcAbove, ethAbove), false, false},
+	}
+	for _, c := range cases {
+		met, ok := EvalCondition(c.c, price)
+		if met != c.met || ok != c.ok {
+			t.Errorf("%s: EvalCondition = %v, %v, want %v, %v", c.name, met, ok, c.met, c.ok)
+		}
+	}
+}
+
+func TestEvalRuleCompound(t *testing.T) {
+	price := func(string, string) (float64, bool) { return 60000, true }
+	cond := node(storage.OpNot, leaf("crypto", "bitcoin", true, 50000))
+	alert := storage.Alert{ID: "a1", Kind: storage.KindCompound, Condition: &cond}
+	if met, ok := EvalRule(alert, price, nil, time.Now()); met || !ok {
+		t.Errorf("compound alert = %v, %v, want false, true", met, ok)
+	}
+
+	alert.Condition = nil
+	if _, ok := EvalRule(alert, price, nil, time.Now()); ok {
+		t.Error("compound alert without a condition evaluated")
+	}
+}
@@ -1,12 +1,14 @@
 package prices
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
-	"time"
 )
 
 // coingeckoResponse is the example response shape for multiple coins
@@ -14,34 +16,59 @@ type coingeckoResponse map[string]struct {
 	USD float64 `json:"usd"`
 }
 
-// FetchCrypto calls CoinGecko with a list of symbols (e.g. "bitcoin", "ethereum")
-func FetchCrypto(symbols []string) (map[string]float64, error) {
+// CoinGecko prices crypto by CoinGecko coin ID (e.g. "bitcoin", "ethereum").
+type CoinGecko struct {
+	cfg    ProviderConfig
+	client *http.Client
+}
+
+// NewCoinGecko creates the provider; cfg.APIKey is sent as a demo API key if set.
+func NewCoinGecko(cfg ProviderConfig) *CoinGecko {
+	cfg = cfg.withDefaults("https://api.coingecko.com/api/v3")
+	return &CoinGecko{cfg: cfg, client: &http.Client{Timeout: cfg.Timeout}}
+}
+
+func (c *CoinGecko) Name() string { return "coingecko" }
+
+func (c *CoinGecko) AssetTypes() []string { return []string{"crypto"} }
+
+// Fetch calls CoinGecko with a list of symbols (e.g. "bitcoin", "ethereum")
+func (c *CoinGecko) Fetch(ctx context.Context, symbols []string) (map[string]float64, error) {
 	if len(symbols) == 0 {
 		return nil, nil
 	}
 
-	client := &http.Client{Timeout: 5 * time.Second}
-
 	// Example: "bitcoin,ethereum,dogecoin"
 	joined := strings.Join(symbols, ",")
-	url := fmt.Sprintf("https://api.coingecko.com/api/v3/simple/price?ids=%s&vs_currencies=usd", joined)
+	reqURL := fmt.Sprintf("%s/simple/price?ids=%s&vs_currencies=usd", strings.TrimRight(c.cfg.BaseURL, "/"), url.QueryEscape(joined))
 
-	log.Printf("[Crypto Fetch] Sending request to CoinGecko: %s\n", url)
+	log.Printf("[Crypto Fetch] Sending request to CoinGecko: %s\n", reqURL)
 
-	resp, err := client.Get(url)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, reqURL, nil)
 	if err != nil {
-		log.Printf("[Error] CoinGecko request failed: %v\n", err)
 		return nil, err
 	}
+	if c.cfg.APIKey != "" {
+		req.Header.Set("x-cg-demo-api-key", c.cfg.APIKey)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		log.Printf("[Error] CoinGecko request failed: %v\n", err)
+		return nil, transportError(err)
+	}
 	defer resp.Body.Close()
 
 	log.Printf("[Crypto Fetch] Received response with status: %s\n", resp.Status)
+	if err := statusError(resp.StatusCode, resp.Status); err != nil {
+		return nil, err
+	}
 
 	// Decode response
 	var cgResp coingeckoResponse
 	if err := json.NewDecoder(resp.Body).Decode(&cgResp); err != nil {
 		log.Printf("[Error] Failed to decode CoinGecko response: %v\n", err)
-		return nil, err
+		return nil, fmt.Errorf("%w: decoding response: %v", ErrUpstream, err)
 	}
 
 	// Build output
@@ -53,3 +80,74 @@ func FetchCrypto(symbols []string) (map[string]float64, error) {
 	log.Printf("[Crypto Fetch] Processed data: %v\n", out)
 	return out, nil
 }
+
+// coincapResponse is the /assets response; CoinCap sends prices as strings
+type coincapResponse struct {
+	Data []struct {
+		ID       string `json:"id"`
+		PriceUSD string `json:"priceUsd"`
+	} `json:"data"`
+}
+
+// CoinCap prices crypto by CoinCap asset ID, which matches CoinGecko's IDs for
+// the common coins, so it can stand in when CoinGecko is down.
+type CoinCap struct {
+	cfg    ProviderConfig
+	client *http.Client
+}
+
+// NewCoinCap creates the provider; cfg.APIKey is sent as a bearer token if set.
+func NewCoinCap(cfg ProviderConfig) *CoinCap {
+	cfg = cfg.withDefaults("https://rest.coincap.io/v3")
+	return &CoinCap{cfg: cfg, client: &http.Client{Timeout: cfg.Timeout}}
+}
+
+func (c *CoinCap) Name() string { return "coincap" }
+
+func (c *CoinCap) AssetTypes() []string { return []string{"crypto"} }
+
+// Fetch calls CoinCap's /assets endpoint for all symbols at once
+func (c *CoinCap) Fetch(ctx context.Context, symbols []string) (map[string]float64, error) {
+	if len(symbols) == 0 {
+		return nil, nil
+	}
+
+	reqURL := fmt.Sprintf("%s/assets?ids=%s", strings.TrimRight(c.cfg.BaseURL, "/"), url.QueryEscape(strings.Join(symbols, ",")))
+	log.Printf("[Crypto Fetch] Sending request to CoinCap: %s\n", reqURL)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, reqURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if c.cfg.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.cfg.APIKey)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, transportError(err)
+	}
+	defer resp.Body.Close()
+
+	if err := statusError(resp.StatusCode, resp.Status); err != nil {
+		return nil, err
+	}
+
+	var ccResp coincapResponse
+	if err := json.NewDecoder(resp.Body).Decode(&ccResp); err != nil {
+		return nil, fmt.Errorf("%w: decoding response: %v", ErrUpstream, err)
+	}
+
+	out := make(map[string]float64)
+	for _, asset := range ccResp.Data {
+		price, err := strconv.ParseFloat(asset.PriceUSD, 64)
+		if err != nil {
+			log.Printf("[Crypto Fetch] Ignoring unparseable CoinCap price for %s: %q\n", asset.ID, asset.PriceUSD)
+			continue
+		}
+		out[asset.ID] = price
+	}
+
+	log.Printf("[Crypto Fetch] Processed data: %v\n", out)
+	return out, nil
+}
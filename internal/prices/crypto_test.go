@@ -0,0 +1,144 @@
+package prices
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// recordingServer serves body with status and hands each request to the test
+func recordingServer(t *testing.T, status int, body string) (*httptest.Server, <-chan *http.Request) {
+	t.Helper()
+	reqs := make(chan *http.Request, 100)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reqs <- r
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(srv.Close)
+	return srv, reqs
+}
+
+// statusCases are the HTTP failures every provider has to map the same way
+var statusCases = []struct {
+	status int
+	want   error
+}{
+	{http.StatusUnauthorized, ErrUnauthorized},
+	{http.StatusForbidden, ErrUnauthorized},
+	{http.StatusTooManyRequests, ErrRateLimited},
+	{http.StatusInternalServerError, ErrUpstream},
+	{http.StatusBadGateway, ErrUpstream},
+	{http.StatusNotFound, ErrUpstream},
+}
+
+func TestCoinGeckoFetch(t *testing.T) {
+	srv, reqs := recordingServer(t, http.StatusOK, `{"bitcoin":{"usd":50000.5},"ethereum":{"usd":3000}}`)
+	p := NewCoinGecko(ProviderConfig{BaseURL: srv.URL + "/", APIKey: "demo-key"})
+
+	got, err := p.Fetch(context.Background(), []string{"bitcoin", "ethereum", "notacoin"})
+	if err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+	if len(got) != 2 || got["bitcoin"] != 50000.5 || got["ethereum"] != 3000 {
+		t.Errorf("Fetch = %v, want bitcoin 50000.5 and ethereum 3000", got)
+	}
+
+	r := <-reqs
+	if r.URL.Path != "/simple/price" {
+		t.Errorf("path = %q, want /simple/price", r.URL.Path)
+	}
+	if ids := r.URL.Query().Get("ids"); ids != "bitcoin,ethereum,notacoin" {
+		t.Errorf("ids = %q, want all three symbols", ids)
+	}
+	if vs := r.URL.Query().Get("vs_currencies"); vs != "usd" {
+		t.Errorf("vs_currencies = %q, want usd", vs)
+	}
+	if key := r.Header.Get("x-cg-demo-api-key"); key != "demo-key" {
+		t.Errorf("api key header = %q, want demo-key", key)
+	}
+}
+
+func TestCoinGeckoFetchWithoutSymbols(t *testing.T) {
+	srv, reqs := recordingServer(t, http.StatusOK, `{}`)
+	p := NewCoinGecko(ProviderConfig{BaseURL: srv.URL})
+
+	got, err := p.Fetch(context.Background(), nil)
+	if err != nil || got != nil {
+		t.Errorf("Fetch(nil) = %v, %v, want nothing", got, err)
+	}
+	if len(reqs) != 0 {
+		t.Error("Fetch(nil) sent a request")
+	}
+}
+
+func TestCoinGeckoErrors(t *testing.T) {
+	for _, tc := range statusCases {
+		srv, _ := recordingServer(t, tc.status, `{"error":"nope"}`)
+		p := NewCoinGecko(ProviderConfig{BaseURL: srv.URL})
+		if _, err := p.Fetch(context.Background(), []string{"bitcoin"}); !errors.Is(err, tc.want) {
+			t.Errorf("status %d: err = %v, want %v", tc.status, err, tc.want)
+		}
+	}
+
+	srv, _ := recordingServer(t, http.StatusOK, `<html>maintenance</html>`)
+	p := NewCoinGecko(ProviderConfig{BaseURL: srv.URL})
+	if _, err := p.Fetch(context.Background(), []string{"bitcoin"}); !errors.Is(err, ErrUpstream) {
+		t.Errorf("undecodable body: err = %v, want ErrUpstream", err)
+	}
+}
+
+func TestCoinCapFetch(t *testing.T) {
+	srv, reqs := recordingServer(t, http.StatusOK, `{"data":[
+		{"id":"bitcoin","priceUsd":"50000.1234"},
+		{"id":"ethereum","priceUsd":"3000"},
+		{"id":"dogecoin","priceUsd":""}
+	]}`)
+	p := NewCoinCap(ProviderConfig{BaseURL: srv.URL, APIKey: "cc-key"})
+
+	got, err := p.Fetch(context.Background(), []string{"bitcoin", "ethereum", "dogecoin"})
+	if err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+	// The unparseable dogecoin price is left out rather than failing the batch
+	if len(got) != 2 || got["bitcoin"] != 50000.1234 || got["ethereum"] != 3000 {
+		t.Errorf("Fetch = %v, want bitcoin and ethereum only", got)
+	}
+
+	r := <-reqs
+	if r.URL.Path != "/assets" {
+		t.Errorf("path = %q, want /assets", r.URL.Path)
+	}
+	if ids := r.URL.Query().Get("ids"); ids != "bitcoin,ethereum,dogecoin" {
+		t.Errorf("ids = %q, want all three symbols", ids)
+	}
+	if auth := r.Header.Get("Authorization"); auth != "Bearer cc-key" {
+		t.Errorf("Authorization = %q, want the bearer key", auth)
+	}
+}
+
+func TestCoinCapErrors(t *testing.T) {
+	for _, tc := range statusCases {
+		srv, _ := recordingServer(t, tc.status, `{"error":"nope"}`)
+		p := NewCoinCap(ProviderConfig{BaseURL: srv.URL})
+		if _, err := p.Fetch(context.Background(), []string{"bitcoin"}); !errors.Is(err, tc.want) {
+			t.Errorf("status %d: err = %v, want %v", tc.status, err, tc.want)
+		}
+	}
+}
+
+func TestCryptoProvidersTransportError(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	srv.Close()
+
+	for _, p := range []PriceProvider{
+		NewCoinGecko(ProviderConfig{BaseURL: srv.URL}),
+		NewCoinCap(ProviderConfig{BaseURL: srv.URL}),
+	} {
+		if _, err := p.Fetch(context.Background(), []string{"bitcoin"}); !errors.Is(err, ErrUpstream) {
+			t.Errorf("%s: err = %v, want ErrUpstream", p.Name(), err)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package prices
+
+import (
+	"sync"
+	"time"
+
+	"github.com/jasonmichels/Market-Sentry/internal/storage"
+)
+
+// DefaultMaxPriceAge is how old a price may be before alerts stop evaluating it.
+const DefaultMaxPriceAge = 10 * time.Minute
+
+// Freshness decides whether a stored quote is recent enough to act on, and
+// remembers which symbols were stale on the last check so a change of state
+// is reported once rather than on every update.
+type Freshness struct {
+	MaxAge time.Duration
+
+	mu    sync.Mutex
+	stale map[string]bool // key: assetType + "/" + symbol
+}
+
+// NewFreshness creates a checker; a zero maxAge uses DefaultMaxPriceAge.
+func NewFreshness(maxAge time.Duration) *Freshness {
+	if maxAge <= 0 {
+		maxAge = DefaultMaxPriceAge
+	}
+	return &Freshness{MaxAge: maxAge, stale: make(map[string]bool)}
+}
+
+// StaleSince reports whether q is too old to use at now, and the moment it became so.
+// A quote with no fetch time (stored before timestamps existed) is stale since forever.
+func (f *Freshness) StaleSince(q storage.Quote, now time.Time) (time.Time, bool) {
+	if q.FetchedAt.IsZero() {
+		return time.Time{}, true
+	}
+	since := q.FetchedAt.Add(f.MaxAge)
+	return since, !now.Before(since)
+}
+
+// changed records the latest staleness of a symbol and reports whether it differs
+// from the previous check. A symbol seen for the first time counts as fresh before.
+func (f *Freshness) changed(assetType, symbol string, stale bool) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	k := assetType + "/" + symbol
+	if f.stale[k] == stale {
+		return false
+	}
+	if stale {
+		f.stale[k] = true
+	} else {
+		delete(f.stale, k)
+	}
+	return true
+}
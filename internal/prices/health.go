@@ -0,0 +1,191 @@
+package prices
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/jasonmichels/Market-Sentry/internal/metrics"
+)
+
+// ErrCircuitOpen is returned for a provider whose breaker is refusing requests.
+var ErrCircuitOpen = errors.New("price provider circuit open")
+
+// Breaker states, as shown on the admin page.
+const (
+	BreakerClosed   = "closed"    // requests flow normally
+	BreakerOpen     = "open"      // requests are refused until OpenFor has passed
+	BreakerHalfOpen = "half-open" // one probe request decides whether to close again
+)
+
+// BreakerConfig tunes the circuit breaker in front of every provider.
+type BreakerConfig struct {
+	FailureThreshold int           // consecutive failures that open the breaker
+	OpenFor          time.Duration // how long to refuse requests before probing
+	Clock            Clock         // nil means RealClock
+}
+
+// DefaultBreakerConfig is used for any zero field in the config passed to NewRegistry.
+var DefaultBreakerConfig = BreakerConfig{
+	FailureThreshold: 3,
+	OpenFor:          5 * time.Minute,
+}
+
+func (c BreakerConfig) withDefaults() BreakerConfig {
+	if c.FailureThreshold <= 0 {
+		c.FailureThreshold = DefaultBreakerConfig.FailureThreshold
+	}
+	if c.OpenFor <= 0 {
+		c.OpenFor = DefaultBreakerConfig.OpenFor
+	}
+	if c.Clock == nil {
+		c.Clock = RealClock{}
+	}
+	return c
+}
+
+// ProviderHealth is a snapshot of one provider's breaker and recent results.
+type ProviderHealth struct {
+	Name                string
+	AssetTypes          []string
+	State               string
+	ConsecutiveFailures int
+	Requests            int
+	Failures            int
+	LastLatency         time.Duration
+	LastSuccess         time.Time
+	LastFailure         time.Time
+	LastError           string
+	OpenUntil           time.Time // when an open breaker will allow a probe
+}
+
+// monitor wraps a provider with a circuit breaker and health bookkeeping.
+type monitor struct {
+	provider PriceProvider
+	cfg      BreakerConfig
+	now      func() time.Time
+
+	mu      sync.Mutex
+	health  ProviderHealth
+	probing bool // a half-open probe is in flight
+}
+
+func newMonitor(p PriceProvider, cfg BreakerConfig) *monitor {
+	m := &monitor{
+		provider: p,
+		cfg:      cfg,
+		now:      cfg.Clock.Now,
+		health: ProviderHealth{
+			Name:       p.Name(),
+			AssetTypes: p.AssetTypes(),
+			State:      BreakerClosed,
+		},
+	}
+	m.publishState()
+	return m
+}
+
+// fetch calls the provider if the breaker allows it and records the outcome.
+func (m *monitor) fetch(ctx context.Context, symbols []string) (map[string]float64, error) {
+	if !m.allow() {
+		return nil, ErrCircuitOpen
+	}
+
+	start := m.now()
+	data, err := m.provider.Fetch(ctx, symbols)
+	latency := m.now().Sub(start)
+
+	// A cancelled caller says nothing about the provider's health
+	if err != nil && ctx.Err() != nil {
+		m.mu.Lock()
+		m.probing = false
+		m.mu.Unlock()
+		return nil, err
+	}
+
+	m.record(latency, err)
+	return data, err
+}
+
+// allow reports whether a request may go through, moving an open breaker to
+// half-open once OpenFor has passed and letting exactly one probe through.
+func (m *monitor) allow() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	switch m.health.State {
+	case BreakerOpen:
+		if m.now().Before(m.health.OpenUntil) {
+			return false
+		}
+		m.health.State = BreakerHalfOpen
+		m.probing = true
+		m.publishState()
+		return true
+	case BreakerHalfOpen:
+		if m.probing {
+			return false
+		}
+		m.probing = true
+		return true
+	}
+	return true
+}
+
+func (m *monitor) record(latency time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	h := &m.health
+	h.Requests++
+	h.LastLatency = latency
+	m.probing = false
+
+	outcome := "success"
+	if err == nil {
+		h.ConsecutiveFailures = 0
+		h.LastSuccess = now
+		h.State = BreakerClosed
+		h.OpenUntil = time.Time{}
+	} else {
+		outcome = "failure"
+		h.Failures++
+		h.ConsecutiveFailures++
+		h.LastFailure = now
+		h.LastError = err.Error()
+		// A failed probe reopens at once; otherwise wait for the threshold
+		if h.State == BreakerHalfOpen || h.ConsecutiveFailures >= m.cfg.FailureThreshold {
+			h.State = BreakerOpen
+			h.OpenUntil = now.Add(m.cfg.OpenFor)
+		}
+	}
+
+	metrics.PriceProviderRequests.WithLabelValues(h.Name, outcome).Inc()
+	metrics.PriceProviderLatency.WithLabelValues(h.Name).Observe(latency.Seconds())
+	m.publishState()
+}
+
+// publishState mirrors the breaker state into the gauge; callers hold m.mu or own m.
+func (m *monitor) publishState() {
+	var v float64
+	switch m.health.State {
+	case BreakerHalfOpen:
+		v = 1
+	case BreakerOpen:
+		v = 2
+	}
+	metrics.PriceProviderBreakerState.WithLabelValues(m.health.Name).Set(v)
+}
+
+func (m *monitor) snapshot() ProviderHealth {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	h := m.health
+	// Report an expired open breaker as ready to probe
+	if h.State == BreakerOpen && !m.now().Before(h.OpenUntil) {
+		h.State = BreakerHalfOpen
+	}
+	return h
+}
@@ -0,0 +1,161 @@
+package prices
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// breakerRegistry puts a single CoinGecko provider pointed at upstream behind
+// a breaker that opens after two failures and stays open for a minute.
+func breakerRegistry(upstream *fakeUpstream, clock Clock) *Registry {
+	r := NewRegistry(BreakerConfig{FailureThreshold: 2, OpenFor: time.Minute, Clock: clock})
+	r.Register(NewCoinGecko(ProviderConfig{BaseURL: upstream.URL}))
+	return r
+}
+
+func fetchBitcoin(r *Registry) error {
+	_, _, err := r.Fetch(context.Background(), "crypto", []string{"bitcoin"})
+	return err
+}
+
+// openBreaker fails requests until the breaker opens
+func openBreaker(t *testing.T, r *Registry, upstream *fakeUpstream) {
+	t.Helper()
+	upstream.respond(http.StatusInternalServerError, `{}`)
+	for i := 0; i < 2; i++ {
+		if err := fetchBitcoin(r); !errors.Is(err, ErrUpstream) {
+			t.Fatalf("failing fetch %d = %v, want ErrUpstream", i+1, err)
+		}
+	}
+	if h := r.Health()[0]; h.State != BreakerOpen {
+		t.Fatalf("state after 2 failures = %s, want open", h.State)
+	}
+}
+
+func TestBreakerOpensAfterThreshold(t *testing.T) {
+	clock := newFakeClock()
+	upstream := newFakeUpstream(t, http.StatusInternalServerError, `{}`)
+	r := breakerRegistry(upstream, clock)
+
+	if err := fetchBitcoin(r); !errors.Is(err, ErrUpstream) {
+		t.Fatalf("first fetch = %v, want ErrUpstream", err)
+	}
+	if h := r.Health()[0]; h.State != BreakerClosed || h.ConsecutiveFailures != 1 {
+		t.Fatalf("health after 1 failure = %+v, want closed with 1 failure", h)
+	}
+
+	if err := fetchBitcoin(r); !errors.Is(err, ErrUpstream) {
+		t.Fatalf("second fetch = %v, want ErrUpstream", err)
+	}
+	h := r.Health()[0]
+	if h.State != BreakerOpen {
+		t.Fatalf("state after 2 failures = %s, want open", h.State)
+	}
+	if want := clock.Now().Add(time.Minute); !h.OpenUntil.Equal(want) {
+		t.Errorf("open until %v, want %v", h.OpenUntil, want)
+	}
+
+	// An open breaker refuses without calling the provider
+	upstream.respond(http.StatusOK, coingeckoBitcoin)
+	if err := fetchBitcoin(r); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("fetch while open = %v, want ErrCircuitOpen", err)
+	}
+	if upstream.requests() != 2 {
+		t.Errorf("upstream got %d requests, want 2", upstream.requests())
+	}
+	clock.Advance(59 * time.Second)
+	if err := fetchBitcoin(r); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("fetch before OpenFor passed = %v, want ErrCircuitOpen", err)
+	}
+}
+
+func TestBreakerClosesAfterSuccessfulProbe(t *testing.T) {
+	clock := newFakeClock()
+	upstream := newFakeUpstream(t, http.StatusOK, coingeckoBitcoin)
+	r := breakerRegistry(upstream, clock)
+	openBreaker(t, r, upstream)
+
+	clock.Advance(time.Minute)
+	if h := r.Health()[0]; h.State != BreakerHalfOpen {
+		t.Fatalf("state after OpenFor = %s, want half-open", h.State)
+	}
+
+	upstream.respond(http.StatusOK, coingeckoBitcoin)
+	if err := fetchBitcoin(r); err != nil {
+		t.Fatalf("probe: %v", err)
+	}
+	h := r.Health()[0]
+	if h.State != BreakerClosed || h.ConsecutiveFailures != 0 || !h.OpenUntil.IsZero() {
+		t.Errorf("health after probe = %+v, want closed with no failures", h)
+	}
+	if !h.LastSuccess.Equal(clock.Now()) {
+		t.Errorf("last success = %v, want %v", h.LastSuccess, clock.Now())
+	}
+	if err := fetchBitcoin(r); err != nil {
+		t.Errorf("fetch after closing: %v", err)
+	}
+}
+
+func TestBreakerReopensAfterFailedProbe(t *testing.T) {
+	clock := newFakeClock()
+	upstream := newFakeUpstream(t, http.StatusOK, coingeckoBitcoin)
+	r := breakerRegistry(upstream, clock)
+	openBreaker(t, r, upstream)
+
+	clock.Advance(time.Minute)
+	upstream.respond(http.StatusUnauthorized, `{}`)
+	if err := fetchBitcoin(r); !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("probe = %v, want ErrUnauthorized", err)
+	}
+
+	// One failed probe is enough to reopen, for another full OpenFor
+	h := r.Health()[0]
+	if h.State != BreakerOpen {
+		t.Fatalf("state after failed probe = %s, want open", h.State)
+	}
+	if want := clock.Now().Add(time.Minute); !h.OpenUntil.Equal(want) {
+		t.Errorf("open until %v, want %v", h.OpenUntil, want)
+	}
+	if h.Requests != 3 || h.Failures != 3 {
+		t.Errorf("requests/failures = %d/%d, want 3/3", h.Requests, h.Failures)
+	}
+	if err := fetchBitcoin(r); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("fetch after failed probe = %v, want ErrCircuitOpen", err)
+	}
+}
+
+func TestBreakerLetsOneProbeThrough(t *testing.T) {
+	clock := newFakeClock()
+	upstream := newFakeUpstream(t, http.StatusOK, coingeckoBitcoin)
+	r := breakerRegistry(upstream, clock)
+	openBreaker(t, r, upstream)
+	clock.Advance(time.Minute)
+
+	m := r.monitors["coingecko"]
+	if !m.allow() {
+		t.Fatal("half-open breaker refused the probe")
+	}
+	if m.allow() {
+		t.Error("half-open breaker allowed a second request while probing")
+	}
+}
+
+func TestBreakerIgnoresCancelledRequests(t *testing.T) {
+	clock := newFakeClock()
+	upstream := newFakeUpstream(t, http.StatusInternalServerError, `{}`)
+	r := breakerRegistry(upstream, clock)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for i := 0; i < 3; i++ {
+		if _, _, err := r.Fetch(ctx, "crypto", []string{"bitcoin"}); !errors.Is(err, context.Canceled) {
+			t.Fatalf("cancelled fetch = %v, want context.Canceled", err)
+		}
+	}
+	if h := r.Health()[0]; h.State != BreakerClosed || h.Failures != 0 {
+		t.Errorf("health after cancelled fetches = %+v, want closed with no failures", h)
+	}
+}
@@ -1,136 +1,135 @@
 package prices
 
 import (
-	"github.com/jasonmichels/Market-Sentry/internal/sse"
+	"context"
+	"errors"
+	"fmt"
 	"log"
+	"sort"
 	"sync"
+	"time"
 
+	"github.com/jasonmichels/Market-Sentry/internal/history"
+	"github.com/jasonmichels/Market-Sentry/internal/notify"
 	"github.com/jasonmichels/Market-Sentry/internal/storage"
 )
 
 // UpdatePriceStore updates the in-memory store with fresh data:
 //  1. Gather unique symbols from active alerts
-//  2. Fetch them from relevant APIs
-//  3. Store them
+//  2. Fetch them through each asset type's provider chain
+//  3. Store them and record them in the price history
 //  4. Trigger any alerts that meet conditions
-func UpdatePriceStore(store *storage.MemoryStore, hub *sse.SSEHub) {
-	log.Println("[Price Fetch] Starting update...")
+//
+// If assetTypes are given only those are fetched; alerts of every type are still checked.
+// Cancelling ctx abandons in-flight fetches; nothing from a cancelled run is saved.
+// The error reports the asset types whose providers all failed.
+func UpdatePriceStore(ctx context.Context, store storage.Store, hist *history.Store, providers *Registry, dispatcher *notify.Dispatcher, fresh *Freshness, assetTypes ...string) error {
+	log.Printf("[Price Fetch] Starting update %v...", assetTypes)
 
 	// 1) Gather needed symbols from the store
-	cryptoSymbols, metalSymbols, stockSymbols := gatherSymbols(store)
+	symbolsByType := gatherSymbols(store)
+	if len(assetTypes) > 0 {
+		wanted := make(map[string][]string)
+		for _, assetType := range assetTypes {
+			if symbols, ok := symbolsByType[assetType]; ok {
+				wanted[assetType] = symbols
+			}
+		}
+		symbolsByType = wanted
+	}
 
 	// If everything is empty, log and bail out early
-	if len(cryptoSymbols) == 0 && len(metalSymbols) == 0 && len(stockSymbols) == 0 {
+	if len(symbolsByType) == 0 {
 		log.Println("[Price Fetch] No symbols to fetch. Skipping API calls.")
 		// We can still do an alert check, but there's nothing new
-		return
+		return nil
 	}
 
-	// 2) Fetch them from relevant APIs concurrently
-	var wg sync.WaitGroup
-	var cryptoErr, metalErr, stockErr error
-	var cryptoData, metalData, stockData map[string]float64
-
-	wg.Add(3)
-
-	// (A) Crypto
-	go func() {
-		defer wg.Done()
-		if len(cryptoSymbols) == 0 {
-			log.Println("[Crypto Fetch] No crypto symbols to fetch.")
-			return
-		}
-		cryptoData, cryptoErr = FetchCrypto(cryptoSymbols)
-		if cryptoErr != nil {
-			log.Printf("[Error] Failed to fetch crypto data: %v\n", cryptoErr)
-		} else {
-			log.Printf("[Crypto Fetch] Fetched data: %v\n", cryptoData)
-		}
-	}()
-
-	// (B) Metals
-	go func() {
-		defer wg.Done()
-		if len(metalSymbols) == 0 {
-			log.Println("[Metals Fetch] No metal symbols to fetch.")
-			return
-		}
-		metalData, metalErr = FetchMetals(metalSymbols)
-		if metalErr != nil {
-			log.Printf("[Error] Failed to fetch metals data: %v\n", metalErr)
-		} else {
-			log.Printf("[Metals Fetch] Fetched data: %v\n", metalData)
-		}
-	}()
-
-	// (C) Stocks
-	go func() {
-		defer wg.Done()
-		if len(stockSymbols) == 0 {
-			log.Println("[Stocks Fetch] No stock symbols to fetch.")
-			return
-		}
-		stockData, stockErr = FetchStocks(stockSymbols)
-		if stockErr != nil {
-			log.Printf("[Error] Failed to fetch stocks data: %v\n", stockErr)
-		} else {
-			log.Printf("[Stocks Fetch] Fetched data: %v\n", stockData)
-		}
-	}()
-
+	// 2) Fetch them from relevant providers concurrently
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		results = make(map[string]map[string]storage.Quote)
+		errs    []error
+	)
+	for assetType, symbols := range symbolsByType {
+		wg.Add(1)
+		go func(assetType string, symbols []string) {
+			defer wg.Done()
+			data, source, err := providers.Fetch(ctx, assetType, symbols)
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Printf("[Error] All %s providers failed, prices not updated: %v\n", assetType, err)
+				}
+				mu.Lock()
+				errs = append(errs, fmt.Errorf("%s: %w", assetType, err))
+				mu.Unlock()
+				return
+			}
+			log.Printf("[Price Fetch] Fetched %s data from %s: %v\n", assetType, source, data)
+			fetchedAt := time.Now()
+			quotes := make(map[string]storage.Quote, len(data))
+			for symbol, price := range data {
+				quotes[symbol] = storage.Quote{Price: price, FetchedAt: fetchedAt, Source: source}
+			}
+			mu.Lock()
+			results[assetType] = quotes
+			mu.Unlock()
+		}(assetType, symbols)
+	}
 	wg.Wait()
 
-	// 3) Lock store and update
-	store.Mu.Lock()
-	if cryptoErr == nil && cryptoData != nil {
-		store.Crypto = cryptoData
-	}
-	if metalErr == nil && metalData != nil {
-		store.Metals = metalData
+	if ctx.Err() != nil {
+		log.Println("[Price Fetch] Update cancelled.")
+		return ctx.Err()
 	}
-	if stockErr == nil && stockData != nil {
-		store.Stocks = stockData
+
+	// 3) Save the fresh prices
+	now := time.Now()
+	for assetType, quotes := range results {
+		saveQuotes(store, assetType, quotes)
+		data := make(map[string]float64, len(quotes))
+		for symbol, q := range quotes {
+			data[symbol] = q.Price
+		}
+		hist.Record(assetType, data, now)
 	}
-	store.Mu.Unlock()
 
 	log.Println("[Price Fetch] Update complete. Checking alerts...")
 
 	// 4) Trigger any alerts if necessary
-	TriggerAlerts(store, hub)
+	TriggerAlerts(ctx, store, hist, dispatcher, fresh)
+	return errors.Join(errs...)
 }
 
-// gatherSymbols scans all users’ ActiveAlerts to find needed symbols for each asset type
-func gatherSymbols(store *storage.MemoryStore) (cryptoSymbols, metalSymbols, stockSymbols []string) {
-	cryptoSet := make(map[string]bool)
-	metalSet := make(map[string]bool)
-	stockSet := make(map[string]bool)
-
-	store.Mu.RLock()
-	defer store.Mu.RUnlock()
+// saveQuotes stores one asset type's prices, logging rather than aborting on failure
+func saveQuotes(store storage.Store, assetType string, quotes map[string]storage.Quote) {
+	if err := store.SaveQuotes(assetType, quotes); err != nil {
+		log.Printf("[Error] Failed to save %s prices: %v\n", assetType, err)
+	}
+}
 
-	for _, user := range store.Users {
+// gatherSymbols scans all users' ActiveAlerts to find needed symbols for each asset type
+func gatherSymbols(store storage.Store) map[string][]string {
+	sets := make(map[string]map[string]bool)
+	for _, user := range store.ListUsers() {
 		for _, alert := range user.ActiveAlerts {
-			switch alert.AssetType {
-			case "crypto":
-				cryptoSet[alert.Symbol] = true
-			case "metal":
-				metalSet[alert.Symbol] = true
-			case "stock":
-				stockSet[alert.Symbol] = true
+			for _, asset := range alert.Assets() {
+				if sets[asset.AssetType] == nil {
+					sets[asset.AssetType] = make(map[string]bool)
+				}
+				sets[asset.AssetType][asset.Symbol] = true
 			}
 		}
 	}
 
-	// Convert sets to slices
-	for sym := range cryptoSet {
-		cryptoSymbols = append(cryptoSymbols, sym)
-	}
-	for sym := range metalSet {
-		metalSymbols = append(metalSymbols, sym)
-	}
-	for sym := range stockSet {
-		stockSymbols = append(stockSymbols, sym)
+	// Convert sets to sorted slices
+	out := make(map[string][]string, len(sets))
+	for assetType, set := range sets {
+		for sym := range set {
+			out[assetType] = append(out[assetType], sym)
+		}
+		sort.Strings(out[assetType])
 	}
-
-	return
+	return out
 }
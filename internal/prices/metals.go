@@ -1,29 +1,156 @@
 package prices
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
 	"log"
+	"net/http"
+	"net/url"
+	"strings"
 )
 
-// FetchMetals calls a metals API with a list of symbols
-func FetchMetals(symbols []string) (map[string]float64, error) {
+// Metal prices are stored in USD per troy ounce; alerts convert to their own unit.
+const MetalBaseUnit = "toz"
+
+// gramsPerTroyOunce is the exact definition of a troy ounce
+const gramsPerTroyOunce = 31.1034768
+
+// SupportedMetals are the metal symbols alerts can watch.
+var SupportedMetals = map[string]bool{
+	"gold":      true,
+	"silver":    true,
+	"platinum":  true,
+	"palladium": true,
+}
+
+// MetalUnits maps each weight unit to how many of it make one troy ounce.
+var MetalUnits = map[string]float64{
+	"toz": 1,
+	"g":   gramsPerTroyOunce,
+	"kg":  gramsPerTroyOunce / 1000,
+}
+
+// MetalUnitLabels are the display names for MetalUnits.
+var MetalUnitLabels = map[string]string{
+	"toz": "troy oz",
+	"g":   "gram",
+	"kg":  "kg",
+}
+
+// ConvertMetalPrice turns a price per troy ounce into a price per unit.
+// An empty or unknown unit is treated as troy ounces.
+func ConvertMetalPrice(pricePerTroyOunce float64, unit string) float64 {
+	perOunce, ok := MetalUnits[unit]
+	if !ok {
+		return pricePerTroyOunce
+	}
+	return pricePerTroyOunce / perOunce
+}
+
+// toTroyOunce turns a price per unit into a price per troy ounce
+func toTroyOunce(price float64, unit string) (float64, error) {
+	if unit == "" {
+		unit = MetalBaseUnit
+	}
+	perOunce, ok := MetalUnits[unit]
+	if !ok {
+		return 0, fmt.Errorf("unsupported unit %q", unit)
+	}
+	return price * perOunce, nil
+}
+
+// metalsDevResponse is the shape of the metals.dev /latest response
+type metalsDevResponse struct {
+	Status       string             `json:"status"`
+	Unit         string             `json:"unit"`
+	Metals       map[string]float64 `json:"metals"`
+	ErrorCode    int                `json:"error_code"`
+	ErrorMessage string             `json:"error_message"`
+}
+
+// MetalsDev prices precious metals with the metals.dev latest-rates API.
+type MetalsDev struct {
+	cfg    ProviderConfig
+	client *http.Client
+}
+
+// NewMetalsDev creates the provider; cfg.APIKey is required by the public API.
+func NewMetalsDev(cfg ProviderConfig) *MetalsDev {
+	cfg = cfg.withDefaults("https://api.metals.dev/v1")
+	return &MetalsDev{cfg: cfg, client: &http.Client{Timeout: cfg.Timeout}}
+}
+
+func (p *MetalsDev) Name() string { return "metalsdev" }
+
+func (p *MetalsDev) AssetTypes() []string { return []string{"metal"} }
+
+// Fetch gets all metal rates in one call and returns the requested symbols per troy ounce
+func (p *MetalsDev) Fetch(ctx context.Context, symbols []string) (map[string]float64, error) {
 	if len(symbols) == 0 {
 		return nil, nil
 	}
 	log.Printf("[Metals Fetch] Requested symbols: %v\n", symbols)
 
-	// TODO: call actual free metals API, pass your symbols
-	// For now, return dummy data matching each symbol
-	data := make(map[string]float64)
+	reqURL := fmt.Sprintf("%s/latest?api_key=%s&currency=USD&unit=%s",
+		strings.TrimRight(p.cfg.BaseURL, "/"), url.QueryEscape(p.cfg.APIKey), MetalBaseUnit)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, reqURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, transportError(err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("%w: reading metals response: %v", ErrUpstream, err)
+	}
+
+	var mdResp metalsDevResponse
+	if err := json.Unmarshal(body, &mdResp); err != nil {
+		if serr := statusError(resp.StatusCode, resp.Status); serr != nil {
+			return nil, serr
+		}
+		return nil, fmt.Errorf("%w: decoding metals response: %v", ErrUpstream, err)
+	}
+	if mdResp.Status != "success" {
+		return nil, metalsDevError(resp.StatusCode, resp.Status, mdResp)
+	}
+
+	// The API answers in the unit we asked for, but normalise whatever it says it used
+	out := make(map[string]float64)
 	for _, sym := range symbols {
-		switch sym {
-		case "gold":
-			data[sym] = 1940.15
-		case "silver":
-			data[sym] = 25.30
-		default:
-			// Just a placeholder
-			data[sym] = 99.99
+		price, ok := mdResp.Metals[sym]
+		if !ok || price <= 0 {
+			continue
 		}
+		perOunce, err := toTroyOunce(price, mdResp.Unit)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrUpstream, err)
+		}
+		out[sym] = perOunce
+	}
+
+	log.Printf("[Metals Fetch] Processed data: %v\n", out)
+	return out, nil
+}
+
+// metalsDevError maps a failure response to one of the provider errors
+func metalsDevError(code int, status string, r metalsDevResponse) error {
+	msg := strings.ToLower(r.ErrorMessage)
+	switch {
+	case code == 429 || strings.Contains(msg, "limit") || strings.Contains(msg, "quota"):
+		return fmt.Errorf("%w: %s", ErrRateLimited, r.ErrorMessage)
+	case code == 401 || code == 403 || strings.Contains(msg, "key"):
+		return fmt.Errorf("%w: %s", ErrUnauthorized, r.ErrorMessage)
+	}
+	if err := statusError(code, status); err != nil {
+		return fmt.Errorf("%w (error %d: %s)", err, r.ErrorCode, r.ErrorMessage)
 	}
-	return data, nil
+	return fmt.Errorf("%w: error %d: %s", ErrUpstream, r.ErrorCode, r.ErrorMessage)
 }
@@ -0,0 +1,199 @@
+package prices
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/jasonmichels/Market-Sentry/internal/metrics"
+)
+
+// Errors providers wrap so callers can tell failures apart.
+var (
+	ErrUnauthorized = errors.New("price provider rejected credentials")
+	ErrRateLimited  = errors.New("price provider rate limit exceeded")
+	ErrUpstream     = errors.New("price provider unavailable")
+)
+
+// statusError maps a non-2xx HTTP status to one of the provider errors
+func statusError(code int, status string) error {
+	switch {
+	case code >= 200 && code <= 299:
+		return nil
+	case code == 401 || code == 403:
+		return fmt.Errorf("%w: %s", ErrUnauthorized, status)
+	case code == 429:
+		return fmt.Errorf("%w: %s", ErrRateLimited, status)
+	default:
+		return fmt.Errorf("%w: %s", ErrUpstream, status)
+	}
+}
+
+// transportError wraps a failed request as ErrUpstream without the request URL,
+// which can carry an API key in its query string.
+func transportError(err error) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		err = urlErr.Err
+	}
+	return fmt.Errorf("%w: %v", ErrUpstream, err)
+}
+
+// CheckSymbol asks the provider for assetType whether it can price symbol.
+// It returns (false, nil) for an unknown symbol and an error if the provider
+// couldn't be asked, so callers can decide whether to accept the symbol anyway.
+func CheckSymbol(ctx context.Context, registry *Registry, assetType, symbol string) (bool, error) {
+	data, _, err := registry.Fetch(ctx, assetType, []string{symbol})
+	if err != nil {
+		return false, err
+	}
+	_, found := data[symbol]
+	return found, nil
+}
+
+// PriceProvider fetches current USD prices for one or more asset types.
+type PriceProvider interface {
+	// Name identifies the provider in logs and config, e.g. "coingecko"
+	Name() string
+	// AssetTypes lists the asset types it can price ("crypto", "metal", "stock")
+	AssetTypes() []string
+	// Fetch returns prices keyed by symbol; symbols it can't price are left out.
+	Fetch(ctx context.Context, symbols []string) (map[string]float64, error)
+}
+
+// ProviderConfig is the connection settings shared by HTTP-backed providers.
+// An empty BaseURL means the vendor's public endpoint.
+type ProviderConfig struct {
+	BaseURL string
+	APIKey  string
+	Timeout time.Duration
+}
+
+func (c ProviderConfig) withDefaults(baseURL string) ProviderConfig {
+	if c.BaseURL == "" {
+		c.BaseURL = baseURL
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = 5 * time.Second
+	}
+	return c
+}
+
+// Registry keeps an ordered failover chain of providers for each asset type.
+// Every provider sits behind its own circuit breaker, so one that keeps
+// failing is skipped until a probe shows it has recovered.
+type Registry struct {
+	mu       sync.RWMutex
+	breaker  BreakerConfig
+	monitors map[string]*monitor // by provider name
+	order    []string            // provider names in registration order
+	chains   map[string][]string // by asset type, provider names in failover order
+}
+
+// NewRegistry creates an empty provider registry; zero breaker fields use DefaultBreakerConfig.
+func NewRegistry(breaker BreakerConfig) *Registry {
+	return &Registry{
+		breaker:  breaker.withDefaults(),
+		monitors: make(map[string]*monitor),
+		chains:   make(map[string][]string),
+	}
+}
+
+// Register adds a provider to the end of the chain for each asset type it supports.
+func (r *Registry) Register(p PriceProvider) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.monitors[p.Name()] = newMonitor(p, r.breaker)
+	r.order = append(r.order, p.Name())
+	for _, assetType := range p.AssetTypes() {
+		r.chains[assetType] = append(r.chains[assetType], p.Name())
+	}
+	log.Printf("[Prices] Registered provider %s for %v", p.Name(), p.AssetTypes())
+}
+
+// SetChain replaces the failover order for assetType with the named providers.
+func (r *Registry) SetChain(assetType string, names []string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(names) == 0 {
+		return fmt.Errorf("empty price provider chain for %s", assetType)
+	}
+	for _, name := range names {
+		m, ok := r.monitors[name]
+		if !ok {
+			return fmt.Errorf("unknown price provider %q", name)
+		}
+		if !supports(m.provider, assetType) {
+			return fmt.Errorf("price provider %q does not support %s", name, assetType)
+		}
+	}
+	r.chains[assetType] = append([]string(nil), names...)
+	return nil
+}
+
+func supports(p PriceProvider, assetType string) bool {
+	for _, t := range p.AssetTypes() {
+		if t == assetType {
+			return true
+		}
+	}
+	return false
+}
+
+// Chain returns the provider names serving assetType, in failover order.
+func (r *Registry) Chain(assetType string) []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return append([]string(nil), r.chains[assetType]...)
+}
+
+// Fetch tries each provider in assetType's chain until one succeeds, skipping
+// providers whose breaker is open. It returns the prices and the name of the
+// provider that served them, or the last error if every provider failed.
+func (r *Registry) Fetch(ctx context.Context, assetType string, symbols []string) (map[string]float64, string, error) {
+	r.mu.RLock()
+	chain := make([]*monitor, 0, len(r.chains[assetType]))
+	for _, name := range r.chains[assetType] {
+		chain = append(chain, r.monitors[name])
+	}
+	r.mu.RUnlock()
+
+	if len(chain) == 0 {
+		return nil, "", fmt.Errorf("no price provider for %s", assetType)
+	}
+
+	var lastErr error
+	for i, m := range chain {
+		data, err := m.fetch(ctx, symbols)
+		if err == nil {
+			if i > 0 {
+				metrics.PriceFailovers.WithLabelValues(assetType).Inc()
+				log.Printf("[Prices] %s served by fallback provider %s", assetType, m.provider.Name())
+			}
+			return data, m.provider.Name(), nil
+		}
+		if ctx.Err() != nil {
+			return nil, "", ctx.Err()
+		}
+		if !errors.Is(err, ErrCircuitOpen) {
+			log.Printf("[Prices] Provider %s failed for %s: %v", m.provider.Name(), assetType, err)
+		}
+		lastErr = fmt.Errorf("%s: %w", m.provider.Name(), err)
+	}
+	return nil, "", lastErr
+}
+
+// Health returns a snapshot of every provider's breaker, in registration order.
+func (r *Registry) Health() []ProviderHealth {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	out := make([]ProviderHealth, 0, len(r.order))
+	for _, name := range r.order {
+		out = append(out, r.monitors[name].snapshot())
+	}
+	return out
+}
@@ -0,0 +1,182 @@
+package prices
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeUpstream is an httptest server standing in for a vendor API. It answers
+// every request with the current status and body and counts the requests.
+type fakeUpstream struct {
+	*httptest.Server
+
+	mu     sync.Mutex
+	status int
+	body   string
+	hits   int
+}
+
+func newFakeUpstream(t *testing.T, status int, body string) *fakeUpstream {
+	t.Helper()
+	f := &fakeUpstream{status: status, body: body}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		f.hits++
+		status, body := f.status, f.body
+		f.mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(f.Close)
+	return f
+}
+
+// respond changes what the server sends from the next request on
+func (f *fakeUpstream) respond(status int, body string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.status, f.body = status, body
+}
+
+func (f *fakeUpstream) requests() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.hits
+}
+
+const (
+	coingeckoBitcoin = `{"bitcoin":{"usd":50000}}`
+	coincapBitcoin   = `{"data":[{"id":"bitcoin","priceUsd":"49990.5"}]}`
+)
+
+// cryptoRegistry chains CoinGecko in front of CoinCap, both pointed at fakes
+func cryptoRegistry(primary, fallback *fakeUpstream, breaker BreakerConfig) *Registry {
+	r := NewRegistry(breaker)
+	r.Register(NewCoinGecko(ProviderConfig{BaseURL: primary.URL}))
+	r.Register(NewCoinCap(ProviderConfig{BaseURL: fallback.URL}))
+	return r
+}
+
+func TestRegistryFailsOverToNextProvider(t *testing.T) {
+	primary := newFakeUpstream(t, http.StatusServiceUnavailable, `{}`)
+	fallback := newFakeUpstream(t, http.StatusOK, coincapBitcoin)
+	r := cryptoRegistry(primary, fallback, BreakerConfig{})
+
+	data, served, err := r.Fetch(context.Background(), "crypto", []string{"bitcoin"})
+	if err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+	if served != "coincap" {
+		t.Errorf("served by %q, want coincap", served)
+	}
+	if data["bitcoin"] != 49990.5 {
+		t.Errorf("bitcoin = %v, want 49990.5", data["bitcoin"])
+	}
+	if primary.requests() != 1 || fallback.requests() != 1 {
+		t.Errorf("requests = %d primary, %d fallback, want 1 each", primary.requests(), fallback.requests())
+	}
+
+	health := r.Health()
+	if health[0].ConsecutiveFailures != 1 || health[0].State != BreakerClosed {
+		t.Errorf("coingecko health = %+v, want one failure and a closed breaker", health[0])
+	}
+	if health[1].Requests != 1 || health[1].Failures != 0 {
+		t.Errorf("coincap health = %+v, want one successful request", health[1])
+	}
+}
+
+func TestRegistryUsesPrimaryWhenHealthy(t *testing.T) {
+	primary := newFakeUpstream(t, http.StatusOK, coingeckoBitcoin)
+	fallback := newFakeUpstream(t, http.StatusOK, coincapBitcoin)
+	r := cryptoRegistry(primary, fallback, BreakerConfig{})
+
+	data, served, err := r.Fetch(context.Background(), "crypto", []string{"bitcoin"})
+	if err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+	if served != "coingecko" || data["bitcoin"] != 50000 {
+		t.Errorf("Fetch = %v from %q, want bitcoin 50000 from coingecko", data, served)
+	}
+	if fallback.requests() != 0 {
+		t.Errorf("fallback got %d requests, want none", fallback.requests())
+	}
+}
+
+func TestRegistryReturnsLastErrorWhenEveryProviderFails(t *testing.T) {
+	primary := newFakeUpstream(t, http.StatusInternalServerError, `{}`)
+	fallback := newFakeUpstream(t, http.StatusTooManyRequests, `{}`)
+	r := cryptoRegistry(primary, fallback, BreakerConfig{})
+
+	_, served, err := r.Fetch(context.Background(), "crypto", []string{"bitcoin"})
+	if !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("err = %v, want the fallback's ErrRateLimited", err)
+	}
+	if !strings.HasPrefix(err.Error(), "coincap: ") {
+		t.Errorf("err = %q, want it prefixed with the provider name", err)
+	}
+	if served != "" {
+		t.Errorf("served by %q, want none", served)
+	}
+}
+
+func TestRegistrySkipsProviderWithOpenBreaker(t *testing.T) {
+	clock := newFakeClock()
+	primary := newFakeUpstream(t, http.StatusBadGateway, `{}`)
+	fallback := newFakeUpstream(t, http.StatusOK, coincapBitcoin)
+	r := cryptoRegistry(primary, fallback, BreakerConfig{FailureThreshold: 2, Clock: clock})
+
+	for i := 0; i < 2; i++ {
+		if _, _, err := r.Fetch(context.Background(), "crypto", []string{"bitcoin"}); err != nil {
+			t.Fatalf("Fetch %d: %v", i+1, err)
+		}
+	}
+	if primary.requests() != 2 {
+		t.Fatalf("primary got %d requests before opening, want 2", primary.requests())
+	}
+
+	// With the primary's breaker open, requests go straight to the fallback
+	_, served, err := r.Fetch(context.Background(), "crypto", []string{"bitcoin"})
+	if err != nil || served != "coincap" {
+		t.Fatalf("Fetch = %q, %v, want coincap", served, err)
+	}
+	if primary.requests() != 2 {
+		t.Errorf("primary got %d requests while open, want still 2", primary.requests())
+	}
+}
+
+func TestRegistryFetchWithoutProvider(t *testing.T) {
+	r := NewRegistry(BreakerConfig{})
+	if _, _, err := r.Fetch(context.Background(), "metal", []string{"XAU"}); err == nil {
+		t.Fatal("Fetch with an empty chain succeeded")
+	}
+}
+
+func TestRegistrySetChain(t *testing.T) {
+	primary := newFakeUpstream(t, http.StatusOK, coingeckoBitcoin)
+	fallback := newFakeUpstream(t, http.StatusOK, coincapBitcoin)
+	r := cryptoRegistry(primary, fallback, BreakerConfig{})
+
+	if err := r.SetChain("crypto", []string{"coincap", "coingecko"}); err != nil {
+		t.Fatalf("SetChain: %v", err)
+	}
+	if _, served, _ := r.Fetch(context.Background(), "crypto", []string{"bitcoin"}); served != "coincap" {
+		t.Errorf("served by %q after reordering, want coincap", served)
+	}
+
+	if err := r.SetChain("crypto", []string{"fmp"}); err == nil {
+		t.Error("SetChain accepted an unknown provider")
+	}
+	r.Register(NewFMPStocks(ProviderConfig{BaseURL: primary.URL}))
+	if err := r.SetChain("crypto", []string{"fmp"}); err == nil {
+		t.Error("SetChain accepted a provider for the wrong asset type")
+	}
+	if err := r.SetChain("crypto", nil); err == nil {
+		t.Error("SetChain accepted an empty chain")
+	}
+}
@@ -0,0 +1,240 @@
+package prices
+
+import (
+	"context"
+	"log"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock is the time source the scheduler waits on, so tests can drive it by hand.
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+// RealClock is the wall clock.
+type RealClock struct{}
+
+func (RealClock) Now() time.Time                         { return time.Now() }
+func (RealClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// ScheduleConfig sets how often each asset type is refreshed.
+type ScheduleConfig struct {
+	AssetTypes []string                 // asset types to schedule
+	Interval   time.Duration            // default time between runs
+	Intervals  map[string]time.Duration // per asset type overrides
+	Jitter     float64                  // each wait is randomised by ±Jitter of the interval; negative disables it
+	MaxBackoff time.Duration            // cap on the wait after failed runs, which doubles with each one
+	Clock      Clock                    // nil means RealClock
+}
+
+// DefaultScheduleConfig is used for any zero field in the config passed to NewScheduler.
+var DefaultScheduleConfig = ScheduleConfig{
+	AssetTypes: []string{"crypto", "metal", "stock"},
+	Interval:   time.Minute,
+	Jitter:     0.1,
+	MaxBackoff: 15 * time.Minute,
+}
+
+// ScheduleStatus is one asset type's schedule, as shown on the admin page.
+type ScheduleStatus struct {
+	AssetType    string
+	Interval     time.Duration
+	Running      bool
+	LastRun      time.Time
+	LastDuration time.Duration
+	NextRun      time.Time
+	Failures     int // consecutive failed runs; the next run is backed off
+}
+
+// Scheduler refreshes each asset type on its own interval. Every asset type has
+// a single loop, so a run that takes longer than the interval delays the next
+// one instead of overlapping it, and a manual refresh is folded into the same
+// loop for the same reason. After a failed run the wait doubles, up to
+// MaxBackoff, until a run succeeds.
+type Scheduler struct {
+	cfg ScheduleConfig
+	run func(ctx context.Context, assetType string) error
+
+	mu      sync.Mutex
+	status  map[string]*ScheduleStatus
+	refresh map[string]chan struct{}
+
+	ctx    context.Context // cancelled by Stop, which aborts in-flight runs
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewScheduler creates a scheduler that calls run for an asset type each time
+// it is due; call Start to begin. The context passed to run is cancelled on Stop,
+// and an error from run backs off the next run.
+func NewScheduler(cfg ScheduleConfig, run func(ctx context.Context, assetType string) error) *Scheduler {
+	d := DefaultScheduleConfig
+	if len(cfg.AssetTypes) == 0 {
+		cfg.AssetTypes = d.AssetTypes
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = d.Interval
+	}
+	switch {
+	case cfg.Jitter == 0 || cfg.Jitter >= 1:
+		cfg.Jitter = d.Jitter
+	case cfg.Jitter < 0:
+		cfg.Jitter = 0
+	}
+	if cfg.MaxBackoff <= 0 {
+		cfg.MaxBackoff = d.MaxBackoff
+	}
+	if cfg.Clock == nil {
+		cfg.Clock = RealClock{}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Scheduler{
+		cfg:     cfg,
+		run:     run,
+		status:  make(map[string]*ScheduleStatus),
+		refresh: make(map[string]chan struct{}),
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+	for _, assetType := range cfg.AssetTypes {
+		s.status[assetType] = &ScheduleStatus{AssetType: assetType, Interval: s.interval(assetType)}
+		s.refresh[assetType] = make(chan struct{}, 1)
+	}
+	return s
+}
+
+func (s *Scheduler) interval(assetType string) time.Duration {
+	if d := s.cfg.Intervals[assetType]; d > 0 {
+		return d
+	}
+	return s.cfg.Interval
+}
+
+// Start launches one loop per asset type; each runs once right away.
+func (s *Scheduler) Start() {
+	for _, assetType := range s.cfg.AssetTypes {
+		s.wg.Add(1)
+		go func(assetType string) {
+			defer s.wg.Done()
+			s.loop(assetType)
+		}(assetType)
+		log.Printf("[Scheduler] Refreshing %s prices every %s (±%.0f%%)", assetType, s.interval(assetType), s.cfg.Jitter*100)
+	}
+}
+
+// Stop cancels in-flight runs, ends the loops and waits for them to return.
+func (s *Scheduler) Stop() {
+	s.cancel()
+	s.wg.Wait()
+}
+
+// RefreshNow asks for an immediate run of assetType, or of every asset type if
+// it is empty. A request made while a run is in progress starts another run
+// as soon as that one finishes; repeated requests collapse into one.
+func (s *Scheduler) RefreshNow(assetType string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if assetType != "" {
+		ch, ok := s.refresh[assetType]
+		if !ok {
+			return false
+		}
+		signal(ch)
+		return true
+	}
+	for _, ch := range s.refresh {
+		signal(ch)
+	}
+	return true
+}
+
+func signal(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// Status lists each asset type's schedule, sorted by asset type.
+func (s *Scheduler) Status() []ScheduleStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]ScheduleStatus, 0, len(s.status))
+	for _, st := range s.status {
+		out = append(out, *st)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].AssetType < out[j].AssetType })
+	return out
+}
+
+func (s *Scheduler) loop(assetType string) {
+	for {
+		if s.ctx.Err() != nil {
+			return
+		}
+		failures := s.runOnce(assetType)
+
+		wait := s.jittered(s.backoff(s.interval(assetType), failures))
+		s.mu.Lock()
+		s.status[assetType].NextRun = s.cfg.Clock.Now().Add(wait)
+		s.mu.Unlock()
+
+		select {
+		case <-s.cfg.Clock.After(wait):
+		case <-s.refresh[assetType]:
+			log.Printf("[Scheduler] Manual refresh of %s prices", assetType)
+		case <-s.ctx.Done():
+			return
+		}
+	}
+}
+
+// runOnce runs assetType and returns its count of consecutive failed runs
+func (s *Scheduler) runOnce(assetType string) int {
+	start := s.cfg.Clock.Now()
+	s.mu.Lock()
+	st := s.status[assetType]
+	st.Running = true
+	st.LastRun = start
+	st.NextRun = time.Time{}
+	s.mu.Unlock()
+
+	err := s.run(s.ctx, assetType)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st.Running = false
+	st.LastDuration = s.cfg.Clock.Now().Sub(start)
+	switch {
+	case err == nil:
+		st.Failures = 0
+	case s.ctx.Err() == nil:
+		st.Failures++
+		log.Printf("[Scheduler] %s refresh failed %d time(s) in a row: %v", assetType, st.Failures, err)
+	}
+	return st.Failures
+}
+
+// backoff is the wait after a run: the interval, doubled for each consecutive
+// failure up to MaxBackoff, but never less than the interval
+func (s *Scheduler) backoff(interval time.Duration, failures int) time.Duration {
+	wait := interval
+	for i := 0; i < failures && wait < s.cfg.MaxBackoff; i++ {
+		wait *= 2
+	}
+	return max(interval, min(wait, s.cfg.MaxBackoff))
+}
+
+// jittered spreads runs over [d-j, d+j] so providers aren't hit in lockstep
+func (s *Scheduler) jittered(d time.Duration) time.Duration {
+	if s.cfg.Jitter == 0 {
+		return d
+	}
+	spread := float64(d) * s.cfg.Jitter
+	return d + time.Duration((rand.Float64()*2-1)*spread)
+}
@@ -0,0 +1,271 @@
+package prices
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock is a Clock that only moves when the test advances it. Every
+// After call is reported on waits so tests can tell when a loop is idle.
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []fakeTimer
+	waits  chan time.Duration
+}
+
+type fakeTimer struct {
+	at time.Time
+	ch chan time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{
+		now:   time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+		waits: make(chan time.Duration, 100),
+	}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	ch := make(chan time.Time, 1)
+	c.timers = append(c.timers, fakeTimer{at: c.now.Add(d), ch: ch})
+	c.mu.Unlock()
+	c.waits <- d
+	return ch
+}
+
+// Advance moves the clock on by d and fires the timers that are due
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	pending := c.timers[:0]
+	for _, t := range c.timers {
+		if t.at.After(c.now) {
+			pending = append(pending, t)
+			continue
+		}
+		t.ch <- c.now
+	}
+	c.timers = pending
+}
+
+// nextWait returns the duration of the next After call
+func (c *fakeClock) nextWait(t *testing.T) time.Duration {
+	t.Helper()
+	select {
+	case d := <-c.waits:
+		return d
+	case <-time.After(2 * time.Second):
+		t.Fatal("scheduler never started waiting")
+		return 0
+	}
+}
+
+// nextRun returns the asset type of the next run
+func nextRun(t *testing.T, runs <-chan string) string {
+	t.Helper()
+	select {
+	case assetType := <-runs:
+		return assetType
+	case <-time.After(2 * time.Second):
+		t.Fatal("scheduler never ran")
+		return ""
+	}
+}
+
+func noRun(t *testing.T, runs <-chan string) {
+	t.Helper()
+	select {
+	case assetType := <-runs:
+		t.Fatalf("unexpected %s run", assetType)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestSchedulerPerAssetIntervals(t *testing.T) {
+	clock := newFakeClock()
+	runs := make(chan string, 10)
+	s := NewScheduler(ScheduleConfig{
+		AssetTypes: []string{"crypto", "stock"},
+		Interval:   time.Minute,
+		Intervals:  map[string]time.Duration{"stock": 5 * time.Minute},
+		Jitter:     -1,
+		Clock:      clock,
+	}, func(ctx context.Context, assetType string) error {
+		runs <- assetType
+		return nil
+	})
+	s.Start()
+	defer s.Stop()
+
+	// Both run right away, then wait for their own interval
+	first := map[string]bool{nextRun(t, runs): true, nextRun(t, runs): true}
+	if !first["crypto"] || !first["stock"] {
+		t.Fatalf("first runs = %v, want crypto and stock", first)
+	}
+	clock.nextWait(t)
+	clock.nextWait(t)
+	start := clock.Now()
+	for _, st := range s.Status() {
+		want := start.Add(time.Minute)
+		if st.AssetType == "stock" {
+			want = start.Add(5 * time.Minute)
+		}
+		if !st.NextRun.Equal(want) {
+			t.Errorf("%s next run = %v, want %v", st.AssetType, st.NextRun, want)
+		}
+	}
+
+	for i := 1; i < 5; i++ {
+		clock.Advance(time.Minute)
+		if got := nextRun(t, runs); got != "crypto" {
+			t.Fatalf("run after %dm = %s, want crypto", i, got)
+		}
+		clock.nextWait(t)
+		noRun(t, runs)
+	}
+
+	clock.Advance(time.Minute)
+	got := map[string]bool{nextRun(t, runs): true, nextRun(t, runs): true}
+	if !got["crypto"] || !got["stock"] {
+		t.Fatalf("runs after 5m = %v, want crypto and stock", got)
+	}
+}
+
+func TestSchedulerBacksOffAfterFailures(t *testing.T) {
+	clock := newFakeClock()
+	runs := make(chan string, 10)
+	var mu sync.Mutex
+	fail := 4
+	s := NewScheduler(ScheduleConfig{
+		AssetTypes: []string{"crypto"},
+		Interval:   time.Minute,
+		Jitter:     -1,
+		MaxBackoff: 5 * time.Minute,
+		Clock:      clock,
+	}, func(ctx context.Context, assetType string) error {
+		runs <- assetType
+		mu.Lock()
+		defer mu.Unlock()
+		if fail > 0 {
+			fail--
+			return errors.New("all providers failed")
+		}
+		return nil
+	})
+	s.Start()
+	defer s.Stop()
+
+	// The wait doubles with each failure up to MaxBackoff, then resets on success
+	for i, want := range []time.Duration{2 * time.Minute, 4 * time.Minute, 5 * time.Minute, 5 * time.Minute, time.Minute} {
+		nextRun(t, runs)
+		if got := clock.nextWait(t); got != want {
+			t.Fatalf("wait after run %d = %s, want %s", i+1, got, want)
+		}
+		if i == 2 {
+			if st := s.Status()[0]; st.Failures != 3 {
+				t.Errorf("failures after run 3 = %d, want 3", st.Failures)
+			}
+		}
+		clock.Advance(want)
+	}
+	if st := s.Status()[0]; st.Failures != 0 {
+		t.Errorf("failures after a success = %d, want 0", st.Failures)
+	}
+}
+
+func TestSchedulerRefreshNow(t *testing.T) {
+	clock := newFakeClock()
+	runs := make(chan string, 10)
+	s := NewScheduler(ScheduleConfig{
+		AssetTypes: []string{"metal"},
+		Interval:   time.Hour,
+		Jitter:     -1,
+		Clock:      clock,
+	}, func(ctx context.Context, assetType string) error {
+		runs <- assetType
+		return nil
+	})
+	s.Start()
+	defer s.Stop()
+
+	nextRun(t, runs)
+	clock.nextWait(t)
+	if s.RefreshNow("stock") {
+		t.Error("RefreshNow accepted an unscheduled asset type")
+	}
+	if !s.RefreshNow("metal") {
+		t.Fatal("RefreshNow refused metal")
+	}
+	if got := nextRun(t, runs); got != "metal" {
+		t.Fatalf("refresh ran %s, want metal", got)
+	}
+}
+
+func TestSchedulerStopCancelsRuns(t *testing.T) {
+	clock := newFakeClock()
+	started := make(chan struct{})
+	cancelled := make(chan error, 1)
+	s := NewScheduler(ScheduleConfig{
+		AssetTypes: []string{"crypto"},
+		Interval:   time.Minute,
+		Jitter:     -1,
+		Clock:      clock,
+	}, func(ctx context.Context, assetType string) error {
+		close(started)
+		<-ctx.Done()
+		cancelled <- ctx.Err()
+		return ctx.Err()
+	})
+	s.Start()
+	<-started
+
+	stopped := make(chan struct{})
+	go func() {
+		s.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Stop didn't return while a run was in flight")
+	}
+	if err := <-cancelled; !errors.Is(err, context.Canceled) {
+		t.Errorf("run context error = %v, want context.Canceled", err)
+	}
+	if st := s.Status()[0]; st.Running || st.Failures != 0 {
+		t.Errorf("status after Stop = %+v, want idle with no failures", st)
+	}
+}
+
+func TestSchedulerStopWhileWaiting(t *testing.T) {
+	clock := newFakeClock()
+	runs := make(chan string, 10)
+	s := NewScheduler(ScheduleConfig{
+		AssetTypes: []string{"crypto"},
+		Interval:   time.Minute,
+		Jitter:     -1,
+		Clock:      clock,
+	}, func(ctx context.Context, assetType string) error {
+		runs <- assetType
+		return nil
+	})
+	s.Start()
+	nextRun(t, runs)
+	clock.nextWait(t)
+
+	s.Stop()
+	clock.Advance(time.Hour)
+	noRun(t, runs)
+}
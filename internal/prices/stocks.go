@@ -1,25 +1,120 @@
 package prices
 
-import "log"
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
 
-// FetchStocks calls a stocks API with a list of symbols
-func FetchStocks(symbols []string) (map[string]float64, error) {
+// stockSymbolRegex matches exchange tickers like "AAPL", "BRK.B" or "RDS-A"
+var stockSymbolRegex = regexp.MustCompile(`^[A-Z][A-Z0-9]{0,5}([.\-][A-Z0-9]{1,2})?$`)
+
+// stockBatchSize is how many tickers go into one quote request
+const stockBatchSize = 50
+
+// NormalizeStockSymbol upper-cases a ticker and reports whether it is well formed.
+func NormalizeStockSymbol(symbol string) (string, bool) {
+	symbol = strings.ToUpper(strings.TrimSpace(symbol))
+	return symbol, stockSymbolRegex.MatchString(symbol)
+}
+
+// fmpQuote is one entry of the FMP /quote response
+type fmpQuote struct {
+	Symbol string   `json:"symbol"`
+	Price  *float64 `json:"price"`
+}
+
+// fmpError is the body FMP sends instead of quotes when a request is rejected
+type fmpError struct {
+	Message string `json:"Error Message"`
+}
+
+// FMPStocks prices stocks with the Financial Modeling Prep batch quote API.
+type FMPStocks struct {
+	cfg    ProviderConfig
+	client *http.Client
+}
+
+// NewFMPStocks creates the provider; cfg.APIKey is required by the public API.
+func NewFMPStocks(cfg ProviderConfig) *FMPStocks {
+	cfg = cfg.withDefaults("https://financialmodelingprep.com/api/v3")
+	return &FMPStocks{cfg: cfg, client: &http.Client{Timeout: cfg.Timeout}}
+}
+
+func (p *FMPStocks) Name() string { return "fmp" }
+
+func (p *FMPStocks) AssetTypes() []string { return []string{"stock"} }
+
+// Fetch requests quotes in batches of stockBatchSize tickers
+func (p *FMPStocks) Fetch(ctx context.Context, symbols []string) (map[string]float64, error) {
 	if len(symbols) == 0 {
 		return nil, nil
 	}
 	log.Printf("[Stocks Fetch] Requested symbols: %v\n", symbols)
 
-	// TODO: call a real stocks API
-	data := make(map[string]float64)
-	for _, sym := range symbols {
-		switch sym {
-		case "AAPL":
-			data[sym] = 150.25
-		case "TSLA":
-			data[sym] = 730.10
-		default:
-			data[sym] = 100.00 // placeholder
+	out := make(map[string]float64)
+	for start := 0; start < len(symbols); start += stockBatchSize {
+		end := start + stockBatchSize
+		if end > len(symbols) {
+			end = len(symbols)
+		}
+		if err := p.fetchBatch(ctx, symbols[start:end], out); err != nil {
+			return nil, err
+		}
+	}
+
+	log.Printf("[Stocks Fetch] Processed data: %v\n", out)
+	return out, nil
+}
+
+func (p *FMPStocks) fetchBatch(ctx context.Context, symbols []string, out map[string]float64) error {
+	reqURL := fmt.Sprintf("%s/quote/%s?apikey=%s",
+		strings.TrimRight(p.cfg.BaseURL, "/"),
+		url.PathEscape(strings.Join(symbols, ",")),
+		url.QueryEscape(p.cfg.APIKey))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, reqURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return transportError(err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 4<<20))
+	if err != nil {
+		return fmt.Errorf("%w: reading quote response: %v", ErrUpstream, err)
+	}
+	if err := statusError(resp.StatusCode, resp.Status); err != nil {
+		return err
+	}
+
+	var quotes []fmpQuote
+	if err := json.Unmarshal(body, &quotes); err != nil {
+		// FMP reports bad keys and plan limits as a JSON object, sometimes with a 200
+		var apiErr fmpError
+		if json.Unmarshal(body, &apiErr) == nil && apiErr.Message != "" {
+			if strings.Contains(strings.ToLower(apiErr.Message), "limit") {
+				return fmt.Errorf("%w: %s", ErrRateLimited, apiErr.Message)
+			}
+			return fmt.Errorf("%w: %s", ErrUnauthorized, apiErr.Message)
+		}
+		return fmt.Errorf("%w: decoding quote response: %v", ErrUpstream, err)
+	}
+
+	for _, q := range quotes {
+		if q.Price == nil || *q.Price <= 0 {
+			continue
 		}
+		out[strings.ToUpper(q.Symbol)] = *q.Price
 	}
-	return data, nil
+	return nil
 }
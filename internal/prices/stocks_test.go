@@ -0,0 +1,204 @@
+package prices
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeFMP answers /quote/{symbols} with a quote of 10 per symbol, or with the
+// fixed status and body if one is set, and records each requested batch.
+type fakeFMP struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	batches [][]string
+	keys    []string
+	status  int
+	body    string
+}
+
+func newFakeFMP(t *testing.T) *fakeFMP {
+	t.Helper()
+	f := &fakeFMP{}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		symbols := strings.Split(strings.TrimPrefix(r.URL.Path, "/quote/"), ",")
+		f.mu.Lock()
+		f.batches = append(f.batches, symbols)
+		f.keys = append(f.keys, r.URL.Query().Get("apikey"))
+		status, body := f.status, f.body
+		f.mu.Unlock()
+
+		if status != 0 {
+			w.WriteHeader(status)
+			w.Write([]byte(body))
+			return
+		}
+		quotes := make([]map[string]any, 0, len(symbols))
+		for _, s := range symbols {
+			quotes = append(quotes, map[string]any{"symbol": s, "price": 10.0})
+		}
+		json.NewEncoder(w).Encode(quotes)
+	}))
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *fakeFMP) respond(status int, body string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.status, f.body = status, body
+}
+
+func TestFMPStocksFetch(t *testing.T) {
+	srv, reqs := recordingServer(t, http.StatusOK, `[
+		{"symbol":"AAPL","price":189.5},
+		{"symbol":"brk.b","price":412.25},
+		{"symbol":"DELIST","price":null},
+		{"symbol":"ZERO","price":0}
+	]`)
+	p := NewFMPStocks(ProviderConfig{BaseURL: srv.URL, APIKey: "fmp-key"})
+
+	got, err := p.Fetch(context.Background(), []string{"AAPL", "BRK.B", "DELIST", "ZERO"})
+	if err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+	// Missing and zero prices are left out; symbols come back upper-cased
+	if len(got) != 2 || got["AAPL"] != 189.5 || got["BRK.B"] != 412.25 {
+		t.Errorf("Fetch = %v, want AAPL and BRK.B only", got)
+	}
+
+	r := <-reqs
+	if r.URL.Path != "/quote/AAPL,BRK.B,DELIST,ZERO" {
+		t.Errorf("path = %q, want every symbol in one quote request", r.URL.Path)
+	}
+	if key := r.URL.Query().Get("apikey"); key != "fmp-key" {
+		t.Errorf("apikey = %q, want fmp-key", key)
+	}
+}
+
+func TestFMPStocksBatchesRequests(t *testing.T) {
+	fmp := newFakeFMP(t)
+	p := NewFMPStocks(ProviderConfig{BaseURL: fmp.URL, APIKey: "fmp-key"})
+
+	symbols := make([]string, 120)
+	for i := range symbols {
+		symbols[i] = fmt.Sprintf("S%d", i)
+	}
+	got, err := p.Fetch(context.Background(), symbols)
+	if err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+	if len(got) != len(symbols) {
+		t.Errorf("got %d prices, want %d", len(got), len(symbols))
+	}
+
+	fmp.mu.Lock()
+	defer fmp.mu.Unlock()
+	sizes := make([]int, len(fmp.batches))
+	for i, b := range fmp.batches {
+		sizes[i] = len(b)
+	}
+	if fmt.Sprint(sizes) != "[50 50 20]" {
+		t.Errorf("batch sizes = %v, want [50 50 20]", sizes)
+	}
+	if fmp.batches[1][0] != "S50" || fmp.batches[2][19] != "S119" {
+		t.Errorf("batches out of order: %v", fmp.batches)
+	}
+	for i, key := range fmp.keys {
+		if key != "fmp-key" {
+			t.Errorf("batch %d apikey = %q, want fmp-key", i+1, key)
+		}
+	}
+}
+
+func TestFMPStocksErrors(t *testing.T) {
+	for _, tc := range statusCases {
+		srv, _ := recordingServer(t, tc.status, `{}`)
+		p := NewFMPStocks(ProviderConfig{BaseURL: srv.URL})
+		if _, err := p.Fetch(context.Background(), []string{"AAPL"}); !errors.Is(err, tc.want) {
+			t.Errorf("status %d: err = %v, want %v", tc.status, err, tc.want)
+		}
+	}
+
+	// FMP reports key and plan problems as an error object, even with a 200
+	bodies := []struct {
+		body string
+		want error
+	}{
+		{`{"Error Message":"Invalid API KEY. Please retry or visit our documentation."}`, ErrUnauthorized},
+		{`{"Error Message":"Limit Reach . Please upgrade your plan."}`, ErrRateLimited},
+		{`not json`, ErrUpstream},
+	}
+	for _, tc := range bodies {
+		srv, _ := recordingServer(t, http.StatusOK, tc.body)
+		p := NewFMPStocks(ProviderConfig{BaseURL: srv.URL})
+		if _, err := p.Fetch(context.Background(), []string{"AAPL"}); !errors.Is(err, tc.want) {
+			t.Errorf("body %s: err = %v, want %v", tc.body, err, tc.want)
+		}
+	}
+}
+
+func TestFMPStocksFailedBatchFailsFetch(t *testing.T) {
+	fmp := newFakeFMP(t)
+	p := NewFMPStocks(ProviderConfig{BaseURL: fmp.URL})
+	fmp.respond(http.StatusTooManyRequests, `{}`)
+
+	symbols := make([]string, 60)
+	for i := range symbols {
+		symbols[i] = fmt.Sprintf("S%d", i)
+	}
+	got, err := p.Fetch(context.Background(), symbols)
+	if !errors.Is(err, ErrRateLimited) || got != nil {
+		t.Errorf("Fetch = %v, %v, want no prices and ErrRateLimited", got, err)
+	}
+	fmp.mu.Lock()
+	defer fmp.mu.Unlock()
+	if len(fmp.batches) != 1 {
+		t.Errorf("sent %d batches, want to stop after the first failure", len(fmp.batches))
+	}
+}
+
+func TestFMPStocksTransportErrorHidesKey(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	srv.Close()
+	p := NewFMPStocks(ProviderConfig{BaseURL: srv.URL, APIKey: "secret-key"})
+
+	_, err := p.Fetch(context.Background(), []string{"AAPL"})
+	if !errors.Is(err, ErrUpstream) {
+		t.Fatalf("err = %v, want ErrUpstream", err)
+	}
+	if strings.Contains(err.Error(), "secret-key") {
+		t.Errorf("err = %q leaks the API key", err)
+	}
+}
+
+func TestNormalizeStockSymbol(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+		ok   bool
+	}{
+		{"aapl", "AAPL", true},
+		{" brk.b ", "BRK.B", true},
+		{"RDS-A", "RDS-A", true},
+		{"GOOGL", "GOOGL", true},
+		{"", "", false},
+		{"1ABC", "1ABC", false},
+		{"TOOLONGX", "TOOLONGX", false},
+		{"AB.CDE", "AB.CDE", false},
+		{"A,B", "A,B", false},
+	}
+	for _, tc := range tests {
+		got, ok := NormalizeStockSymbol(tc.in)
+		if got != tc.want || ok != tc.ok {
+			t.Errorf("NormalizeStockSymbol(%q) = %q, %v, want %q, %v", tc.in, got, ok, tc.want, tc.ok)
+		}
+	}
+}
@@ -1,128 +1,406 @@
 package prices
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"strings"
+	"sync"
 	"time"
 
-	"github.com/jasonmichels/Market-Sentry/internal/sse"
+	"github.com/google/uuid"
+	"github.com/jasonmichels/Market-Sentry/internal/history"
+	"github.com/jasonmichels/Market-Sentry/internal/notify"
 	"github.com/jasonmichels/Market-Sentry/internal/storage"
 )
 
+// triggerMu serialises TriggerAlerts runs
+var triggerMu sync.Mutex
+
 // TriggerAlerts checks each user's ActiveAlerts against the current prices
-// and moves triggered alerts + builds notifications.
-func TriggerAlerts(store *storage.MemoryStore, hub *sse.SSEHub) {
+// and moves triggered alerts + builds notifications. Threshold alerts fire when
+// the price crosses their level, not merely for being past it, and compound
+// and expression alerts when their condition becomes true; recurring alerts
+// stay active and wait out their cooldown and band. Alerts whose price is
+// missing or older than fresh.MaxAge are left alone until a fresh price arrives.
+// If ctx is cancelled part way, the alerts already triggered are still dispatched.
+func TriggerAlerts(ctx context.Context, store storage.Store, hist *history.Store, dispatcher *notify.Dispatcher, fresh *Freshness) {
+	// Asset types refresh on their own schedules; check alerts one pass at a time
+	triggerMu.Lock()
+	defer triggerMu.Unlock()
+
 	// We'll need the prices to check the conditions
-	store.Mu.RLock()
-	cryptoPrices := store.Crypto
-	metalPrices := store.Metals
-	stockPrices := store.Stocks
-	usersMap := store.Users
-	store.Mu.RUnlock()
-
-	// triggeredMap[phone] = slice of triggered alert IDs
-	triggeredMap := make(map[string][]string)
-	// notificationsMap[phone] = slice of notifications
-	notificationsMap := make(map[string][]storage.Notification)
-
-	for phone, user := range usersMap {
+	quotes := map[string]map[string]storage.Quote{
+		"crypto": store.Quotes("crypto"),
+		"metal":  store.Quotes("metal"),
+		"stock":  store.Quotes("stock"),
+	}
+	now := time.Now()
+	changes := staleChanges(quotes, fresh, now)
+
+	// Notifications to queue once every store update is done
+	var pending []pendingNotification
+
+	// freshPrice is the stored price of an asset, if it has a fresh one
+	freshPrice := func(assetType, symbol string) (float64, bool) {
+		return freshQuote(quotes[assetType][symbol], fresh, now)
+	}
+
+	for _, user := range store.ListUsers() {
+		if ctx.Err() != nil {
+			log.Println("[Alert Trigger] Cancelled, remaining users are checked on the next run")
+			break
+		}
+		phone := user.PhoneNumber
+		pushed := make(map[string]bool)
+
+		// fire records that alert fired with message and queues its notification
+		fire := func(alert storage.Alert, message string) {
+			log.Printf("[Alert Trigger] Phone=%s Alert=%s Kind=%s Message=%q", phone, alert.ID, alert.Kind, message)
+
+			note := storage.Notification{
+				ID:        uuid.New().String(),
+				AlertID:   alert.ID,
+				Timestamp: time.Now(),
+				Message:   message,
+			}
+
+			// Move the alert from ActiveAlerts to TriggeredAlerts and add the notification;
+			// recurring alerts stay active until the price moves back past their band
+			var err error
+			if alert.Recurring {
+				state := alert.State()
+				state.LastFiredAt = note.Timestamp
+				state.AwaitingReset = true
+				err = store.FireAlert(phone, alert.ID, alert.Revision, state, note)
+			} else {
+				err = store.TriggerAlert(phone, alert.ID, alert.Revision, note)
+			}
+			if errors.Is(err, storage.ErrAlertChanged) {
+				log.Printf("[Alert Trigger] Alert %s changed while being checked; it is checked again next run", alert.ID)
+				return
+			}
+			if err != nil {
+				log.Printf("[Error] Failed to record trigger for alert %s: %v", alert.ID, err)
+				return
+			}
+			pending = append(pending, pendingNotification{phone: phone, channels: alert.Channels, note: note})
+		}
+
 		for _, alert := range user.ActiveAlerts {
-			price := getPriceForAlert(alert, cryptoPrices, metalPrices, stockPrices)
-			if price == 0 {
-				// If we don't have a price, skip
+			for _, asset := range alert.Assets() {
+				key := asset.AssetType + "/" + asset.Symbol
+				if status, ok := changes[key]; ok && !pushed[key] {
+					// Let open pages show or clear the "stale since" state
+					dispatcher.Push(phone, status)
+					pushed[key] = true
+				}
+			}
+
+			if alert.Paused {
+				continue
+			}
+
+			if alert.Kind == storage.KindCompound || alert.Kind == storage.KindExpression {
+				met, ok := EvalRule(alert, freshPrice, hist, now)
+				if !ok {
+					// Some price or history is unavailable or stale; wait until it is there
+					continue
+				}
+				state := ConditionState(met)
+				if becameTrue(alert, state) {
+					fire(alert, checkRuleAlert(alert, freshPrice))
+				} else if state != alert.LastState {
+					alert.LastState = state
+					saveState(store, phone, alert)
+				}
+				continue
+			}
+
+			stored, ok := freshPrice(alert.AssetType, alert.Symbol)
+			if !ok {
+				// Price unavailable or stale, nothing to compare against
+				continue
+			}
+			price := AlertPrice(alert, stored)
+
+			// A recurring alert in its cooldown doesn't look at the price at all, so a
+			// crossing that happens meanwhile still fires once the cooldown is over
+			if alert.Recurring && !alert.AwaitingReset && now.Sub(alert.LastFiredAt) < alert.Cooldown {
 				continue
 			}
+
 			// Check condition
-			triggered := false
-			if alert.Above && price > alert.Threshold {
-				triggered = true
-			} else if !alert.Above && price < alert.Threshold {
-				triggered = true
+			var message string
+			changed := false
+			switch alert.Kind {
+			case storage.KindPercent:
+				message = checkPercentAlert(store, hist, phone, alert, price)
+			default:
+				side := ThresholdSide(alert, price)
+				if crossed(alert, side) {
+					message = checkThresholdAlert(alert, price)
+				}
+				if side != alert.LastSide {
+					alert.LastSide = side
+					changed = true
+				}
 			}
 
-			if triggered {
-				log.Printf("[Alert Trigger] Phone=%s Symbol=%s Price=%.4f Threshold=%.4f Above=%t",
-					phone, alert.Symbol, price, alert.Threshold, alert.Above)
-
-				triggeredMap[phone] = append(triggeredMap[phone], alert.ID)
-
-				// Format the threshold and current price
-				formattedThreshold := formatUSD(alert.Threshold)
-				formattedPrice := formatUSD(price)
-
-				// Build a notification message that includes the current price
-				if alert.Above {
-					notificationsMap[phone] = append(notificationsMap[phone], storage.Notification{
-						AlertID:   alert.ID,
-						Timestamp: time.Now(),
-						Message:   fmt.Sprintf("%s went above %s (current price: %s)", alert.Symbol, formattedThreshold, formattedPrice),
-					})
-				} else {
-					notificationsMap[phone] = append(notificationsMap[phone], storage.Notification{
-						AlertID:   alert.ID,
-						Timestamp: time.Now(),
-						Message:   fmt.Sprintf("%s went below %s (current price: %s)", alert.Symbol, formattedThreshold, formattedPrice),
-					})
+			if alert.Recurring && alert.AwaitingReset {
+				if movedBack(alert, price) {
+					// Ready to fire on the next crossing
+					alert.AwaitingReset = false
+					changed = true
 				}
+				message = ""
 			}
+
+			if message == "" {
+				if changed {
+					saveState(store, phone, alert)
+				}
+				continue
+			}
+
+			fire(alert, message)
 		}
 	}
 
-	// Now apply the triggered changes under a write lock
-	store.Mu.Lock()
-	defer store.Mu.Unlock()
+	// Queue deliveries once every store update is done
+	for _, p := range pending {
+		dispatcher.Dispatch(p.phone, p.channels, p.note)
+	}
+}
+
+// FreshPrice looks up the latest stored price of an asset, unless it is
+// missing or older than fresh.MaxAge.
+func FreshPrice(store storage.Store, fresh *Freshness, now time.Time) PriceFunc {
+	return func(assetType, symbol string) (float64, bool) {
+		return freshQuote(store.Quotes(assetType)[symbol], fresh, now)
+	}
+}
+
+// freshQuote is the quote's price, unless it has none or it is stale
+func freshQuote(quote storage.Quote, fresh *Freshness, now time.Time) (float64, bool) {
+	if quote.Price == 0 {
+		return 0, false
+	}
+	if _, stale := fresh.StaleSince(quote, now); stale {
+		return 0, false
+	}
+	return quote.Price, true
+}
 
-	for phone, alertIDs := range triggeredMap {
-		user := store.Users[phone]
-		if user == nil {
-			continue
+// saveState records the check state of an alert, unless the user changed the
+// alert since it was read; the next run checks the new revision instead
+func saveState(store storage.Store, phone string, alert storage.Alert) {
+	err := store.SetAlertState(phone, alert.ID, alert.Revision, alert.State())
+	if err != nil && !errors.Is(err, storage.ErrAlertChanged) && !errors.Is(err, storage.ErrAlertNotFound) {
+		log.Printf("[Error] Failed to record check state for alert %s: %v", alert.ID, err)
+	}
+}
+
+// checkThresholdAlert returns the notification message if price is past the alert's threshold.
+// TriggerAlerts only asks once the price has crossed to that side.
+func checkThresholdAlert(alert storage.Alert, price float64) string {
+	triggered := false
+	if alert.Above && price > alert.Threshold {
+		triggered = true
+	} else if !alert.Above && price < alert.Threshold {
+		triggered = true
+	}
+	if !triggered {
+		return ""
+	}
+
+	// Format the threshold and current price
+	formattedThreshold := FormatUSD(alert.Threshold)
+	formattedPrice := FormatUSD(price)
+
+	// Build a notification message that includes the current price
+	direction := "below"
+	if alert.Above {
+		direction = "above"
+	}
+	return fmt.Sprintf("%s went %s %s%s (current price: %s)", alert.Symbol, direction, formattedThreshold, UnitSuffix(alert), formattedPrice)
+}
+
+// movedBack reports whether a recurring alert's price has moved back past its
+// threshold by the alert's hysteresis band, so it may fire again.
+func movedBack(alert storage.Alert, price float64) bool {
+	band := alert.Threshold * alert.HysteresisPct / 100
+	if alert.Above {
+		return price <= alert.Threshold-band
+	}
+	return price >= alert.Threshold+band
+}
+
+// checkPercentAlert returns the notification message if the price has moved enough.
+// Windowed alerts compare against the low/high recorded in the window; the others
+// compare against the alert's BasePrice, which is filled in here if it is still unknown.
+func checkPercentAlert(store storage.Store, hist *history.Store, phone string, alert storage.Alert, price float64) string {
+	if alert.Window > 0 {
+		low, high, ok := hist.MinMax(alert.AssetType, alert.Symbol, time.Now().Add(-alert.Window))
+		if !ok {
+			return ""
+		}
+		low, high = AlertPrice(alert, low), AlertPrice(alert, high)
+		if low <= 0 || high <= 0 {
+			// No meaningful percentage to compare against
+			return ""
 		}
+		rise := (price - low) / low * 100
+		drop := (high - price) / high * 100
+		within := "within " + FormatWindow(alert.Window)
 
-		// Move triggered alerts from ActiveAlerts to TriggeredAlerts
-		var remaining []storage.Alert
-		for _, a := range user.ActiveAlerts {
-			triggered := false
-			for _, trigID := range alertIDs {
-				if a.ID == trigID {
-					user.TriggeredAlerts = append(user.TriggeredAlerts, a)
-					user.CountTriggeredAlerts++
-					triggered = true
-					break
-				}
+		if alert.Move != "down" && rise >= alert.ChangePct {
+			return fmt.Sprintf("%s rose %.2f%% %s, from %s to %s%s", alert.Symbol, rise, within, FormatUSD(low), FormatUSD(price), UnitSuffix(alert))
+		}
+		if alert.Move != "up" && drop >= alert.ChangePct {
+			return fmt.Sprintf("%s dropped %.2f%% %s, from %s to %s%s", alert.Symbol, drop, within, FormatUSD(high), FormatUSD(price), UnitSuffix(alert))
+		}
+		return ""
+	}
+
+	if alert.BasePrice == 0 {
+		// First price seen since the alert was created becomes the base
+		alert.BasePrice = price
+		saveState(store, phone, alert)
+		return ""
+	}
+
+	change := (price - alert.BasePrice) / alert.BasePrice * 100
+	since := "since the alert was created"
+	if alert.Move != "down" && change >= alert.ChangePct {
+		return fmt.Sprintf("%s rose %.2f%% %s, from %s to %s%s", alert.Symbol, change, since, FormatUSD(alert.BasePrice), FormatUSD(price), UnitSuffix(alert))
+	}
+	if alert.Move != "up" && -change >= alert.ChangePct {
+		return fmt.Sprintf("%s dropped %.2f%% %s, from %s to %s%s", alert.Symbol, -change, since, FormatUSD(alert.BasePrice), FormatUSD(price), UnitSuffix(alert))
+	}
+	return ""
+}
+
+// FormatWindow renders a look-back window like "15m" or "4h".
+func FormatWindow(d time.Duration) string {
+	if d%time.Hour == 0 {
+		return fmt.Sprintf("%dh", int(d.Hours()))
+	}
+	return fmt.Sprintf("%dm", int(d.Minutes()))
+}
+
+// pendingNotification is a triggered notification waiting to be fanned out
+type pendingNotification struct {
+	phone    string
+	channels []string
+	note     storage.Notification
+}
+
+// priceStatus is the SSE event sent when a watched price goes stale or recovers
+type priceStatus struct {
+	Type       string    `json:"type"` // always "priceStatus"
+	AssetType  string    `json:"assetType"`
+	Symbol     string    `json:"symbol"`
+	Stale      bool      `json:"stale"`
+	StaleSince time.Time `json:"staleSince"`
+	FetchedAt  time.Time `json:"fetchedAt"`
+	Source     string    `json:"source"`
+}
+
+// staleChanges returns, keyed by assetType/symbol, the quotes whose staleness
+// changed since the previous check
+func staleChanges(quotes map[string]map[string]storage.Quote, fresh *Freshness, now time.Time) map[string]priceStatus {
+	changes := make(map[string]priceStatus)
+	for assetType, bySymbol := range quotes {
+		for symbol, q := range bySymbol {
+			since, stale := fresh.StaleSince(q, now)
+			if !fresh.changed(assetType, symbol, stale) {
+				continue
 			}
-			if !triggered {
-				remaining = append(remaining, a)
+			if stale {
+				log.Printf("[Alert Trigger] %s %s price is stale (fetched %s from %s); its alerts are paused",
+					assetType, symbol, q.FetchedAt.Format(time.RFC3339), q.Source)
 			} else {
-				user.CountActiveAlerts--
+				since = time.Time{}
+			}
+			changes[assetType+"/"+symbol] = priceStatus{
+				Type:       "priceStatus",
+				AssetType:  assetType,
+				Symbol:     symbol,
+				Stale:      stale,
+				StaleSince: since,
+				FetchedAt:  q.FetchedAt,
+				Source:     q.Source,
 			}
 		}
-		user.ActiveAlerts = remaining
+	}
+	return changes
+}
+
+// ThresholdSide is which side of a threshold alert's level price is on. A price
+// exactly at the level counts as not yet past it.
+func ThresholdSide(alert storage.Alert, price float64) string {
+	if alert.Above {
+		if price > alert.Threshold {
+			return storage.SideAbove
+		}
+		return storage.SideBelow
+	}
+	if price < alert.Threshold {
+		return storage.SideBelow
+	}
+	return storage.SideAbove
+}
+
+// alertingSide is the side of the level a threshold alert fires on
+func alertingSide(alert storage.Alert) string {
+	if alert.Above {
+		return storage.SideAbove
+	}
+	return storage.SideBelow
+}
 
-		// Add new notifications
-		notes := notificationsMap[phone]
-		user.Notifications = append(user.Notifications, notes...)
-		user.CountNotifications += len(notes)
+// ConditionHolds reports whether price is already past a threshold alert's level.
+func ConditionHolds(alert storage.Alert, price float64) bool {
+	return ThresholdSide(alert, price) == alertingSide(alert)
+}
+
+// crossed reports whether a threshold alert's price has just moved to the
+// alerting side. The first price seen only counts if the alert fires immediately.
+func crossed(alert storage.Alert, side string) bool {
+	if side != alertingSide(alert) {
+		return false
+	}
+	if alert.LastSide == "" {
+		return alert.FireImmediately
+	}
+	return alert.LastSide != side
+}
 
-		// Notify only that user
-		hub.BroadcastToUser(phone, `{"type":"alertsUpdated","message":"Alerts updated"}`)
+// AlertPrice converts a stored price into the unit the alert is expressed in.
+// Only metals have units; other prices pass through unchanged.
+func AlertPrice(alert storage.Alert, stored float64) float64 {
+	if alert.AssetType != "metal" {
+		return stored
 	}
+	return ConvertMetalPrice(stored, alert.Unit)
 }
 
-// getPriceForAlert returns the relevant price for the alert from the store
-func getPriceForAlert(alert storage.Alert, crypto map[string]float64, metals map[string]float64, stocks map[string]float64) float64 {
-	switch alert.AssetType {
-	case "crypto":
-		return crypto[alert.Symbol]
-	case "metal":
-		return metals[alert.Symbol]
-	case "stock":
-		return stocks[alert.Symbol]
+// UnitSuffix renders " per gram" etc. for metal alerts, or "" for everything else.
+func UnitSuffix(alert storage.Alert) string {
+	if alert.AssetType != "metal" {
+		return ""
+	}
+	unit := alert.Unit
+	if unit == "" {
+		unit = MetalBaseUnit
 	}
-	return 0
+	return " per " + MetalUnitLabels[unit]
 }
 
-func formatUSD(amount float64) string {
+// FormatUSD renders a price in dollars, with more decimals for tiny amounts.
+func FormatUSD(amount float64) string {
 	switch {
 	case amount >= 1:
 		// For amounts 1 or more, use two decimals.
@@ -0,0 +1,180 @@
+package prices
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jasonmichels/Market-Sentry/internal/history"
+	"github.com/jasonmichels/Market-Sentry/internal/notify"
+	"github.com/jasonmichels/Market-Sentry/internal/storage"
+)
+
+const testPhone = "+15551234567"
+
+// triggerEnv runs TriggerAlerts for one user's bitcoin alerts. Nothing is
+// delivered; fired alerts show up in the user's notifications.
+type triggerEnv struct {
+	store      *storage.MemoryStore
+	fresh      *Freshness
+	hist       *history.Store
+	dispatcher *notify.Dispatcher
+}
+
+// newTriggerEnv has a user with alert as their only active alert
+func newTriggerEnv(t *testing.T, alert storage.Alert) *triggerEnv {
+	t.Helper()
+	store := storage.NewMemoryStore(nil)
+	if _, err := store.GetOrCreateUser(testPhone); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.AddAlert(testPhone, alert); err != nil {
+		t.Fatal(err)
+	}
+	registry := notify.NewRegistry()
+	return &triggerEnv{
+		store:      store,
+		fresh:      NewFreshness(10 * time.Minute),
+		hist:       history.New(history.RetentionPolicy{}),
+		dispatcher: notify.NewDispatcher(registry, notify.NewOutbox(store, registry, notify.OutboxConfig{})),
+	}
+}
+
+// check stores a fresh bitcoin price and runs one trigger pass, returning
+// whether it fired the alert
+func (e *triggerEnv) check(t *testing.T, price float64) bool {
+	t.Helper()
+	before := len(e.store.GetUser(testPhone).Notifications)
+	q := storage.Quote{Price: price, FetchedAt: time.Now(), Source: "test"}
+	if err := e.store.SaveQuotes("crypto", map[string]storage.Quote{"bitcoin": q}); err != nil {
+		t.Fatal(err)
+	}
+	TriggerAlerts(context.Background(), e.store, e.hist, e.dispatcher, e.fresh)
+	return len(e.store.GetUser(testPhone).Notifications) > before
+}
+
+// alert is the current copy of the user's alert
+func (e *triggerEnv) alert(t *testing.T) storage.Alert {
+	t.Helper()
+	active := e.store.GetUser(testPhone).ActiveAlerts
+	if len(active) != 1 {
+		t.Fatalf("%d active alerts, want the recurring one", len(active))
+	}
+	return active[0]
+}
+
+// recurringAlert fires when bitcoin crosses above 100
+func recurringAlert(cooldown time.Duration, hysteresisPct float64) storage.Alert {
+	return storage.Alert{
+		ID:            "a1",
+		AssetType:     "crypto",
+		Symbol:        "bitcoin",
+		Threshold:     100,
+		Above:         true,
+		Recurring:     true,
+		Cooldown:      cooldown,
+		HysteresisPct: hysteresisPct,
+	}
+}
+
+func TestRecurringAlertCooldown(t *testing.T) {
+	e := newTriggerEnv(t, recurringAlert(time.Hour, 0))
+	steps := []struct {
+		price float64
+		fires bool
+	}{
+		{90, false},
+		{110, true},
+		{90, false}, // back under the level, ready once the cooldown is over
+		{110, false},
+		{90, false},
+		{110, false},
+	}
+	for i, s := range steps {
+		if fired := e.check(t, s.price); fired != s.fires {
+			t.Fatalf("step %d at %v: fired = %v, want %v", i, s.price, fired, s.fires)
+		}
+	}
+
+	// The crossing during the cooldown wasn't recorded, so the alert fires as
+	// soon as the cooldown is over while the price is still past the level
+	a := e.alert(t)
+	if a.LastSide != storage.SideBelow || a.AwaitingReset {
+		t.Fatalf("state in the cooldown = %+v", a.State())
+	}
+	state := a.State()
+	state.LastFiredAt = time.Now().Add(-2 * time.Hour)
+	if err := e.store.SetAlertState(testPhone, a.ID, a.Revision, state); err != nil {
+		t.Fatal(err)
+	}
+	if !e.check(t, 110) {
+		t.Error("crossing after the cooldown didn't fire")
+	}
+	if a := e.alert(t); !a.AwaitingReset || time.Since(a.LastFiredAt) > time.Minute {
+		t.Errorf("state after firing again = %+v", a.State())
+	}
+}
+
+func TestRecurringAlertHysteresisBand(t *testing.T) {
+	e := newTriggerEnv(t, recurringAlert(0, 5))
+	steps := []struct {
+		price float64
+		fires bool
+		reset bool // AwaitingReset after the check
+	}{
+		{90, false, false},
+		{110, true, true},
+		{97, false, true},  // under the level but inside the 5% band
+		{110, false, true}, // crossing again without leaving the band doesn't fire
+		{95.01, false, true},
+		{95, false, false}, // the band's edge counts as moved back
+		{100, false, false},
+		{101, true, true},
+	}
+	for i, s := range steps {
+		if fired := e.check(t, s.price); fired != s.fires {
+			t.Fatalf("step %d at %v: fired = %v, want %v", i, s.price, fired, s.fires)
+		}
+		if reset := e.alert(t).AwaitingReset; reset != s.reset {
+			t.Fatalf("step %d at %v: awaiting reset = %v, want %v", i, s.price, reset, s.reset)
+		}
+	}
+}
+
+func TestMovedBack(t *testing.T) {
+	cases := []struct {
+		above bool
+		band  float64
+		price float64
+		want  bool
+	}{
+		{true, 5, 96, false},
+		{true, 5, 95, true},
+		{true, 0, 100, true},
+		{true, 0, 100.5, false},
+		{false, 5, 104, false},
+		{false, 5, 105, true},
+		{false, 0, 100, true},
+		{false, 0, 99.5, false},
+	}
+	for _, c := range cases {
+		alert := storage.Alert{Threshold: 100, Above: c.above, HysteresisPct: c.band}
+		if got := movedBack(alert, c.price); got != c.want {
+			t.Errorf("movedBack(above=%v, band=%v%%, %v) = %v, want %v", c.above, c.band, c.price, got, c.want)
+		}
+	}
+}
+
+func TestFormatWindow(t *testing.T) {
+	cases := map[time.Duration]string{
+		15 * time.Minute: "15m",
+		90 * time.Minute: "90m",
+		time.Hour:        "1h",
+		24 * time.Hour:   "24h",
+	}
+	for d, want := range cases {
+		if got := FormatWindow(d); got != want {
+			t.Errorf("FormatWindow(%v) = %q, want %q", d, got, want)
+		}
+	}
+}
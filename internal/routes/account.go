@@ -0,0 +1,126 @@
+package routes
+
+import (
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jasonmichels/Market-Sentry/internal/auth"
+	"github.com/jasonmichels/Market-Sentry/internal/storage"
+)
+
+// RegisterAccountRoutes registers the account page, where users manage API keys.
+// Keys can only be managed from a signed-in browser, never with another key.
+func RegisterAccountRoutes(mux *http.ServeMux) {
+	mux.Handle("/account", auth.JWTMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		renderAccount(w, r, "", nil)
+	})))
+	mux.Handle("/account/keys", auth.JWTMiddleware(http.HandlerFunc(handleCreateAPIKey)))
+	mux.Handle("/account/keys/revoke", auth.JWTMiddleware(http.HandlerFunc(handleRevokeAPIKey)))
+}
+
+// renderAccount shows the account page; newKey is a key just created, shown this once
+func renderAccount(w http.ResponseWriter, r *http.Request, newKey string, errs []string) {
+	phone := auth.GetUserPhone(r.Context())
+
+	type keyRow struct {
+		ID         string
+		Name       string
+		Display    string
+		ReadOnly   bool
+		CreatedAt  time.Time
+		LastUsedAt time.Time
+	}
+	var keys []keyRow
+	for _, k := range auth.UserAPIKeys(phone) {
+		keys = append(keys, keyRow{
+			ID:         k.ID,
+			Name:       k.Name,
+			Display:    auth.APIKeyDisplay(k),
+			ReadOnly:   k.Scope == storage.ScopeRead,
+			CreatedAt:  k.CreatedAt,
+			LastUsedAt: k.LastUsedAt,
+		})
+	}
+
+	data := struct {
+		Phone  string
+		Keys   []keyRow
+		NewKey string
+		Errors []string
+	}{phone, keys, newKey, errs}
+
+	funcs := template.FuncMap{
+		"formatTime": func(t time.Time) string {
+			if t.IsZero() {
+				return ""
+			}
+			return t.Format("Jan 2 2006 3:04 PM")
+		},
+	}
+	tmpl := template.Must(template.New("account.html").Funcs(funcs).ParseFiles("web/templates/account.html"))
+	if err := tmpl.Execute(w, data); err != nil {
+		log.Printf("Error executing account template: %v", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+	}
+}
+
+func handleCreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	phone := auth.GetUserPhone(r.Context())
+	name := strings.TrimSpace(r.FormValue("name"))
+	scope := r.FormValue("scope")
+
+	var errs []string
+	if name == "" || len(name) > 64 {
+		errs = append(errs, "Name the key (up to 64 characters) so you can tell it apart later.")
+	}
+	if scope != storage.ScopeRead && scope != storage.ScopeWrite {
+		errs = append(errs, "Choose read-only or read-write access.")
+	}
+	if len(errs) > 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		renderAccount(w, r, "", errs)
+		return
+	}
+
+	key, _, err := auth.CreateAPIKey(phone, name, scope)
+	if errors.Is(err, auth.ErrTooManyAPIKeys) {
+		w.WriteHeader(http.StatusBadRequest)
+		renderAccount(w, r, "", []string{"You already have the maximum number of API keys; revoke one first."})
+		return
+	}
+	if err != nil {
+		log.Printf("Error creating API key for %s: %v", phone, err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("[Auth] %s created %s API key %q", phone, scope, name)
+	renderAccount(w, r, key, nil)
+}
+
+func handleRevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	phone := auth.GetUserPhone(r.Context())
+	id := r.FormValue("id")
+	if err := auth.RevokeAPIKey(phone, id); err != nil {
+		if errors.Is(err, auth.ErrAPIKeyNotFound) {
+			http.Error(w, "API key not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("Error revoking API key %s: %v", id, err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("[Auth] %s revoked API key %s", phone, id)
+	http.Redirect(w, r, "/account", http.StatusSeeOther)
+}
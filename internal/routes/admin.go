@@ -1,23 +1,34 @@
 package routes
 
 import (
+	"errors"
 	"html/template"
 	"log"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/jasonmichels/Market-Sentry/internal/auth"
+	"github.com/jasonmichels/Market-Sentry/internal/notify"
+	"github.com/jasonmichels/Market-Sentry/internal/prices"
 	"github.com/jasonmichels/Market-Sentry/internal/storage"
 )
 
 // RegisterAdminRoutes registers admin routes.
-func RegisterAdminRoutes(mux *http.ServeMux, store *storage.MemoryStore, adminPhones map[string]bool) {
+func RegisterAdminRoutes(mux *http.ServeMux, store storage.Store, adminPhones map[string]bool, outbox *notify.Outbox, providers *prices.Registry, scheduler *prices.Scheduler) {
 	mux.Handle("/admin", auth.JWTMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		handleAdmin(store, w, r, adminPhones)
+		handleAdmin(store, outbox, providers, scheduler, w, r, adminPhones)
+	})))
+	mux.Handle("/admin/prices/refresh", auth.JWTMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handleRefreshPrices(scheduler, w, r, adminPhones)
+	})))
+	mux.Handle("/admin/outbox/requeue", auth.JWTMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handleRequeue(outbox, w, r, adminPhones)
 	})))
 }
 
-func handleAdmin(store *storage.MemoryStore, w http.ResponseWriter, r *http.Request, adminPhones map[string]bool) {
+func handleAdmin(store storage.Store, outbox *notify.Outbox, providers *prices.Registry, scheduler *prices.Scheduler, w http.ResponseWriter, r *http.Request, adminPhones map[string]bool) {
 	phone := auth.GetUserPhone(r.Context())
 	if !adminPhones[phone] {
 		http.Error(w, "Forbidden", http.StatusForbidden)
@@ -31,13 +42,8 @@ func handleAdmin(store *storage.MemoryStore, w http.ResponseWriter, r *http.Requ
 	}
 	const pageSize = 100
 
-	store.Mu.RLock()
-	userList := make([]*storage.User, 0, len(store.Users))
-	for _, u := range store.Users {
-		userList = append(userList, u)
-	}
+	userList := store.ListUsers()
 	totalUsers := len(userList)
-	store.Mu.RUnlock()
 
 	start := (pageNum - 1) * pageSize
 	if start > totalUsers {
@@ -54,17 +60,45 @@ func handleAdmin(store *storage.MemoryStore, w http.ResponseWriter, r *http.Requ
 		PageSize    int
 		TotalUsers  int
 		Users       []*storage.User
+		Stuck       []storage.OutboxItem
+		Providers   []prices.ProviderHealth
+		Chains      map[string][]string
+		Schedule    []prices.ScheduleStatus
 	}{
 		CurrentPage: pageNum,
 		PageSize:    pageSize,
 		TotalUsers:  totalUsers,
 		Users:       pageUsers,
+		Stuck:       outbox.Stuck(),
+		Providers:   providers.Health(),
+		Chains: map[string][]string{
+			"crypto": providers.Chain("crypto"),
+			"metal":  providers.Chain("metal"),
+			"stock":  providers.Chain("stock"),
+		},
+		Schedule: scheduler.Status(),
 	}
 
 	funcs := template.FuncMap{
 		"sub": func(a, b int) int { return a - b },
 		"add": func(a, b int) int { return a + b },
 		"mul": func(a, b int) int { return a * b },
+		"formatTime": func(t time.Time) string {
+			if t.IsZero() {
+				return ""
+			}
+			return t.Format("Jan 2 2006 3:04 PM")
+		},
+		"join": strings.Join,
+		"formatLatency": func(d time.Duration) string {
+			if d == 0 {
+				return ""
+			}
+			if d < time.Millisecond {
+				return d.Round(time.Microsecond).String()
+			}
+			return d.Round(time.Millisecond).String()
+		},
 	}
 	tmpl := template.Must(template.New("admin.html").Funcs(funcs).ParseFiles("web/templates/admin.html"))
 	if err := tmpl.Execute(w, data); err != nil {
@@ -72,3 +106,51 @@ func handleAdmin(store *storage.MemoryStore, w http.ResponseWriter, r *http.Requ
 		http.Error(w, "Server error", http.StatusInternalServerError)
 	}
 }
+
+// handleRequeue puts a stuck outbox delivery back in the queue.
+func handleRequeue(outbox *notify.Outbox, w http.ResponseWriter, r *http.Request, adminPhones map[string]bool) {
+	phone := auth.GetUserPhone(r.Context())
+	if !adminPhones[phone] {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id := r.FormValue("id")
+	err := outbox.Requeue(id)
+	if errors.Is(err, notify.ErrDeliveryInFlight) {
+		http.Error(w, "Delivery is being attempted; try again once it finishes", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("Error requeueing outbox item %s: %v", id, err)
+		http.Error(w, "Delivery not found", http.StatusNotFound)
+		return
+	}
+	log.Printf("[Admin] %s requeued outbox item %s", phone, id)
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
+
+// handleRefreshPrices fetches prices now instead of waiting for the next scheduled run.
+func handleRefreshPrices(scheduler *prices.Scheduler, w http.ResponseWriter, r *http.Request, adminPhones map[string]bool) {
+	phone := auth.GetUserPhone(r.Context())
+	if !adminPhones[phone] {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	assetType := r.FormValue("assetType") // empty refreshes everything
+	if !scheduler.RefreshNow(assetType) {
+		http.Error(w, "Unknown asset type", http.StatusBadRequest)
+		return
+	}
+	log.Printf("[Admin] %s requested a price refresh (%q)", phone, assetType)
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
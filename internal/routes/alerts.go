@@ -1,15 +1,23 @@
 package routes
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/jasonmichels/Market-Sentry/internal/alerts"
 	"html/template"
 	"log"
 	"net/http"
+	"net/mail"
+	"net/url"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/jasonmichels/Market-Sentry/internal/auth"
+	"github.com/jasonmichels/Market-Sentry/internal/notify"
+	"github.com/jasonmichels/Market-Sentry/internal/prices"
+	"github.com/jasonmichels/Market-Sentry/internal/rules"
 	"github.com/jasonmichels/Market-Sentry/internal/sse"
 	"github.com/jasonmichels/Market-Sentry/internal/storage"
 )
@@ -22,24 +30,73 @@ var tmplFuncs = template.FuncMap{
 		}
 		return t.Format("Jan 2 2006 3:04 PM")
 	},
+	"join": strings.Join,
+	"unitLabel": func(unit string) string {
+		if unit == "" {
+			unit = prices.MetalBaseUnit
+		}
+		return prices.MetalUnitLabels[unit]
+	},
+	"describeCondition": func(c *storage.Condition) string {
+		if c == nil {
+			return ""
+		}
+		return prices.DescribeCondition(*c)
+	},
+	"formatWindow": prices.FormatWindow,
+}
+
+// alertsPageData is what the alertsPage template renders: the user's alerts
+// plus the create form, re-filled with what they typed if validation failed.
+type alertsPageData struct {
+	User          *storage.User
+	Prices        priceBoard
+	Errors        []string
+	Channels      []string // channels the user can pick from
+	FormKind      string
+	FormAssetType string
+	FormSymbol    string
+	FormThreshold string
+	FormDirection string
+	FormChangePct string
+	FormMove      string
+	FormWindow    string
+	FormUnit      string
+	FormChannels  map[string]bool
+	FormRecurring bool
+	FormCooldown  string
+	FormBand      string
+	FormFireNow   bool
+	FormCondition string // JSON for the condition builder
+	FormRule      string
+
+	// Warnings ask the user to confirm the form; Confirmed is set on the resubmission
+	Warnings  []string
+	Confirmed bool
 }
 
 // RegisterAlertsRoutes registers alerts-related routes.
-func RegisterAlertsRoutes(mux *http.ServeMux, store *storage.MemoryStore, hub *sse.SSEHub) {
+func RegisterAlertsRoutes(mux *http.ServeMux, store storage.Store, hub *sse.SSEHub, channels *notify.Registry, providers *prices.Registry, fresh *prices.Freshness) {
 	mux.Handle("/alerts", auth.JWTMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		handleAlerts(store, w, r)
+		handleAlerts(store, channels, providers, fresh, w, r)
+	})))
+	mux.Handle("/alerts/contact", auth.JWTMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handleContact(store, channels, fresh, w, r)
 	})))
 	mux.Handle("/alerts/partial", auth.JWTMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		handleAlertsPartial(store, w, r)
+		handleAlertsPartial(store, fresh, w, r)
 	})))
 	mux.Handle("/alerts/stream", auth.JWTMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		hub.ServeHTTP(w, r)
 	})))
+	mux.Handle("/alerts/{id}/{action}", auth.JWTMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handleAlertAction(store, channels, providers, fresh, w, r)
+	})))
 }
 
-func handleAlerts(store *storage.MemoryStore, w http.ResponseWriter, r *http.Request) {
+func handleAlerts(store storage.Store, channels *notify.Registry, providers *prices.Registry, fresh *prices.Freshness, w http.ResponseWriter, r *http.Request) {
 	phone := auth.GetUserPhone(r.Context()) // from JWT middleware
-	user := store.Users[phone]
+	user := store.GetUser(phone)
 	if user == nil {
 		http.Redirect(w, r, "/", http.StatusSeeOther)
 		return
@@ -47,105 +104,637 @@ func handleAlerts(store *storage.MemoryStore, w http.ResponseWriter, r *http.Req
 
 	// If POST, we are creating a new alert
 	if r.Method == http.MethodPost {
-		assetType := r.FormValue("assetType")    // "crypto", "metal", "stock"
-		symbol := r.FormValue("symbol")          // e.g. "bitcoin"
-		thresholdStr := r.FormValue("threshold") // e.g. "20000"
-		direction := r.FormValue("direction")    // "above" or "below"
+		_ = r.ParseForm()
+		in := alertInput{
+			Kind:      r.FormValue("alertKind"), // "threshold", "percent", "compound" or "expression"
+			AssetType: r.FormValue("assetType"), // "crypto", "metal", "stock"
+			Symbol:    r.FormValue("symbol"),    // e.g. "bitcoin"
+			Threshold: r.FormValue("threshold"), // e.g. "20000"
+			Direction: r.FormValue("direction"), // "above" or "below"
+			ChangePct: r.FormValue("changePct"), // e.g. "5"
+			Move:      r.FormValue("move"),      // "up", "down" or "any"
+			Window:    r.FormValue("window"),    // key of alerts.PercentWindows
+			Unit:      r.FormValue("unit"),      // "toz", "g" or "kg" for metals
+			Channels:  r.Form["channels"],       // e.g. ["sse", "sms"]
 
-		var validationErrors []string
+			Recurring:  r.FormValue("recurring") != "", // checkbox
+			Cooldown:   r.FormValue("cooldown"),        // key of alerts.Cooldowns
+			Hysteresis: r.FormValue("hysteresis"),      // reset band, percent
+
+			Expression: r.FormValue("expression"), // e.g. "pct_change(bitcoin, 1h) < -5"
+
+			FireImmediately: r.FormValue("fireImmediately") != "", // checkbox
+		}
+		confirmed := r.FormValue("confirmed") != "" // the user has seen the warnings
 
-		// 1) Validate assetType
-		validAssetTypes := map[string]bool{
-			"crypto": true,
-			"metal":  true,
-			"stock":  true,
+		// If any validation errors, re-render alertsPage with error messages;
+		// warnings re-render it once so the user can change their mind
+		var validationErrors []string
+		rawCondition := r.FormValue("condition") // JSON from the condition builder
+		if in.Kind == storage.KindCompound && rawCondition != "" {
+			cond, err := parseCondition(rawCondition)
+			if err != nil {
+				validationErrors = append(validationErrors, "The conditions could not be read; please build them again.")
+			}
+			in.Condition = cond
+		}
+		if len(validationErrors) == 0 {
+			validationErrors = in.validate(r.Context(), store, channels, providers, user)
 		}
-		if !validAssetTypes[assetType] {
-			validationErrors = append(validationErrors, "Invalid asset type.")
+		var warnings []string
+		if len(validationErrors) == 0 && !confirmed {
+			if warning := in.alreadyTrueWarning(store, fresh); warning != "" {
+				warnings = append(warnings, warning)
+			}
+		}
+		if len(validationErrors) > 0 || len(warnings) > 0 {
+			// Inject the errors plus the form fields so the user doesn't lose what they typed.
+			data := alertsPageData{
+				User:          user,
+				Prices:        newPriceBoard(store, fresh),
+				Errors:        validationErrors,
+				Warnings:      warnings,
+				Confirmed:     len(warnings) > 0,
+				Channels:      channels.Names(),
+				FormKind:      in.Kind,
+				FormAssetType: in.AssetType,
+				FormSymbol:    in.Symbol,
+				FormThreshold: in.Threshold,
+				FormDirection: in.Direction,
+				FormChangePct: in.ChangePct,
+				FormMove:      in.Move,
+				FormWindow:    in.Window,
+				FormUnit:      in.Unit,
+				FormChannels:  channelSet(in.Channels),
+				FormRecurring: in.Recurring,
+				FormCooldown:  in.Cooldown,
+				FormBand:      in.Hysteresis,
+				FormFireNow:   in.FireImmediately,
+				FormCondition: rawCondition,
+				FormRule:      in.Expression,
+			}
+			renderAlertsPage(w, data)
+			return
+		}
+
+		// If we get here, everything is valid -> create alert
+		if _, err := in.create(store, fresh, phone); err != nil {
+			http.Error(w, "Failed to create alert", http.StatusInternalServerError)
+			return
 		}
+		http.Redirect(w, r, "/alerts", http.StatusSeeOther)
+		return
+	}
+
+	// If GET, show the alerts page (no errors)
+	renderAlertsPage(w, newAlertsPageData(store, channels, fresh, user, nil))
+}
 
-		if assetType == "crypto" {
-			store.Mu.RLock()
-			_, found := store.SupportedCoins[symbol]
-			store.Mu.RUnlock()
+// newAlertsPageData is the alerts page with an empty create form
+func newAlertsPageData(store storage.Store, channels *notify.Registry, fresh *prices.Freshness, user *storage.User, errs []string) alertsPageData {
+	return alertsPageData{
+		User:          user,
+		Prices:        newPriceBoard(store, fresh),
+		Errors:        errs,
+		Channels:      channels.Names(),
+		FormKind:      storage.KindThreshold, // default
+		FormAssetType: "crypto",              // default
+		FormDirection: "above",
+		FormMove:      "any",
+		FormWindow:    "1h",
+		FormUnit:      prices.MetalBaseUnit,
+		FormChannels:  channelSet(notify.DefaultChannels),
+		FormCooldown:  "1h",
+		FormBand:      "1",
+	}
+}
+
+// handleAlertAction changes one existing alert from the buttons on the alerts
+// page: edit, pause, resume, rearm or delete. Edit takes the same fields as the
+// create form (a compound alert's whole condition); any that are left out keep
+// their value.
+func handleAlertAction(store storage.Store, channels *notify.Registry, providers *prices.Registry, fresh *prices.Freshness, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/alerts", http.StatusSeeOther)
+		return
+	}
+	phone := auth.GetUserPhone(r.Context())
+	user := store.GetUser(phone)
+	if user == nil {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
 
-			if !found {
-				validationErrors = append(validationErrors, fmt.Sprintf("Invalid crypto coin: %s", symbol))
+	id := r.PathValue("id")
+	var err error
+	switch r.PathValue("action") {
+	case "edit":
+		prev, status, ok := findAlert(user, id)
+		if !ok || status != alertActive {
+			err = storage.ErrAlertNotFound
+			break
+		}
+		in := inputFromAlert(prev)
+		for _, f := range []struct {
+			name string
+			dst  *string
+		}{
+			{"threshold", &in.Threshold},
+			{"direction", &in.Direction},
+			{"changePct", &in.ChangePct},
+			{"move", &in.Move},
+			{"window", &in.Window},
+			{"expression", &in.Expression},
+		} {
+			if v := r.PostFormValue(f.name); v != "" {
+				*f.dst = v
+			}
+		}
+		if raw := r.PostFormValue("condition"); raw != "" && in.Kind == storage.KindCompound {
+			if in.Condition, err = parseCondition(raw); err != nil {
+				renderAlertsPage(w, newAlertsPageData(store, channels, fresh, user, []string{"The conditions could not be read; please build them again."}))
+				return
 			}
 		}
+		if validationErrors := in.validate(r.Context(), store, channels, providers, user); len(validationErrors) > 0 {
+			renderAlertsPage(w, newAlertsPageData(store, channels, fresh, user, validationErrors))
+			return
+		}
+		_, err = alerts.UpdateAlert(store, fresh, phone, prev, in.applyTo(prev))
+	case "pause":
+		_, err = alerts.SetPaused(store, phone, id, true)
+	case "resume":
+		_, err = alerts.SetPaused(store, phone, id, false)
+	case "rearm":
+		_, err = alerts.RearmAlert(store, fresh, phone, id)
+	case "delete":
+		err = store.DeleteAlert(phone, id)
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	if errors.Is(err, storage.ErrAlertNotFound) {
+		// Usually a stale page: the alert triggered or was deleted meanwhile
+		renderAlertsPage(w, newAlertsPageData(store, channels, fresh, store.GetUser(phone), []string{"That alert has changed since the page loaded; please try again."}))
+		return
+	}
+	if err != nil {
+		log.Printf("Error changing alert %s for %s: %v", id, phone, err)
+		http.Error(w, "Failed to update alert", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/alerts", http.StatusSeeOther)
+}
+
+// alertInput is an alert as submitted by the create form or the JSON API,
+// before it has been validated.
+type alertInput struct {
+	Kind      string
+	AssetType string
+	Symbol    string
+	Threshold string
+	Direction string
+	ChangePct string
+	Move      string
+	Window    string
+	Unit      string
+	Channels  []string
+
+	// Recurring threshold alerts; Cooldown is a key of alerts.Cooldowns
+	Recurring  bool
+	Cooldown   string
+	Hysteresis string // percent
+
+	// Compound alerts
+	Condition *conditionInput
+
+	// Expression alerts; validate fills in Bindings
+	Expression string
+	Bindings   map[string]storage.AssetRef
+
+	// Fire on the first check if the threshold is already crossed, or the
+	// compound condition already holds
+	FireImmediately bool
+}
 
+// validate checks the input against the rules every alert must meet and
+// returns one message per problem. It normalizes the symbol and unit in place.
+func (in *alertInput) validate(ctx context.Context, store storage.Store, channels *notify.Registry, providers *prices.Registry, user *storage.User) []string {
+	if in.Kind == "" {
+		in.Kind = storage.KindThreshold
+	}
+
+	var validationErrors []string
+
+	// 1) Validate the asset; compound and expression alerts name theirs in the condition
+	if in.Kind != storage.KindCompound && in.Kind != storage.KindExpression {
+		validationErrors = append(validationErrors, validateAsset(ctx, store, providers, in.AssetType, &in.Symbol, &in.Unit)...)
+	}
+
+	switch in.Kind {
+	case storage.KindThreshold:
 		// 3) Validate threshold is a numeric > 0
-		thresholdVal, err := strconv.ParseFloat(thresholdStr, 64)
+		thresholdVal, err := strconv.ParseFloat(in.Threshold, 64)
 		if err != nil || thresholdVal <= 0 {
 			validationErrors = append(validationErrors, "Threshold must be a valid number greater than 0.")
 		}
 
 		// 4) Validate direction
-		if direction != "above" && direction != "below" {
+		if in.Direction != "above" && in.Direction != "below" {
 			validationErrors = append(validationErrors, "Invalid direction, must be 'above' or 'below'.")
 		}
 
-		// If any validation errors, re-render alertsPage with error messages
-		if len(validationErrors) > 0 {
-			// Build the same template we normally show, but inject an Errors field
-			// plus the form fields so the user doesn't lose what they typed.
-			data := struct {
-				User          *storage.User
-				Crypto        map[string]float64
-				Errors        []string
-				FormAssetType string
-				FormSymbol    string
-				FormThreshold string
-				FormDirection string
-			}{
-				User:          user,
-				Crypto:        store.Crypto,
-				Errors:        validationErrors,
-				FormAssetType: assetType,
-				FormSymbol:    symbol,
-				FormThreshold: thresholdStr,
-				FormDirection: direction,
+		// 5) Validate cooldown and reset band of recurring alerts
+		if in.Recurring {
+			if in.Cooldown == "" {
+				in.Cooldown = "none"
 			}
-
-			// parse + render
-			tmpl := template.Must(template.New("alerts.html").
-				Funcs(tmplFuncs).
-				ParseFiles(
-					"web/templates/alerts.html",
-					"web/templates/alerts_partial.html",
-				))
-			if err := tmpl.ExecuteTemplate(w, "alertsPage", data); err != nil {
-				log.Printf("Error executing template with validation errors: %v", err)
-				http.Error(w, "Server error", http.StatusInternalServerError)
+			if _, ok := alerts.Cooldowns[in.Cooldown]; !ok {
+				validationErrors = append(validationErrors, "Invalid cooldown.")
+			}
+			if in.Hysteresis == "" {
+				in.Hysteresis = "0"
+			}
+			hysteresis, err := strconv.ParseFloat(in.Hysteresis, 64)
+			if err != nil || hysteresis < 0 || hysteresis > alerts.MaxHysteresisPct {
+				validationErrors = append(validationErrors, fmt.Sprintf("Reset band must be a percentage from 0 to %d.", alerts.MaxHysteresisPct))
 			}
-			return
 		}
 
-		// If we get here, everything is valid -> create alert
-		alerts.CreateAlert(store, phone, assetType, symbol, thresholdStr, direction)
+	case storage.KindPercent:
+		// 3) Validate percentage is a numeric in (0, 1000]
+		changePct, err := strconv.ParseFloat(in.ChangePct, 64)
+		if err != nil || changePct <= 0 || changePct > 1000 {
+			validationErrors = append(validationErrors, "Percent change must be a number greater than 0 and at most 1000.")
+		}
+
+		// 4) Validate move direction and window
+		if !alerts.PercentMoves[in.Move] {
+			validationErrors = append(validationErrors, "Invalid move, must be 'up', 'down' or 'any'.")
+		}
+		if _, ok := alerts.PercentWindows[in.Window]; !ok {
+			validationErrors = append(validationErrors, "Invalid time window.")
+		}
+		if in.Recurring {
+			validationErrors = append(validationErrors, "Only price level alerts can repeat.")
+		}
+
+	case storage.KindCompound:
+		// 3) Validate the condition tree
+		in.AssetType, in.Symbol, in.Unit = "", "", ""
+		if in.Condition == nil {
+			validationErrors = append(validationErrors, "Add at least one condition.")
+		} else {
+			validationErrors = append(validationErrors, in.Condition.validate(ctx, store, providers)...)
+		}
+		if in.Recurring {
+			validationErrors = append(validationErrors, "Only price level alerts can repeat.")
+		}
+
+	case storage.KindExpression:
+		// 3) Parse and type-check the rule
+		in.AssetType, in.Symbol, in.Unit = "", "", ""
+		in.Expression = strings.TrimSpace(in.Expression)
+		program, err := rules.Compile(in.Expression, assetResolver(ctx, store, providers))
+		var ruleErr *rules.Error
+		switch {
+		case errors.As(err, &ruleErr):
+			validationErrors = append(validationErrors, fmt.Sprintf("Rule error at column %d: %s.", ruleErr.Column, ruleErr.Msg))
+		case err != nil:
+			validationErrors = append(validationErrors, fmt.Sprintf("Rule error: %v.", err))
+		default:
+			in.Bindings = program.Bindings
+		}
+		if in.Recurring {
+			validationErrors = append(validationErrors, "Only price level alerts can repeat.")
+		}
+
+	default:
+		validationErrors = append(validationErrors, "Invalid alert type.")
+	}
+
+	// 5) Validate notification channels
+	validationErrors = append(validationErrors, validateChannels(channels, user, in.Channels)...)
+
+	return validationErrors
+}
+
+// create saves a validated input as a new active alert for phone.
+func (in alertInput) create(store storage.Store, fresh *prices.Freshness, phone string) (storage.Alert, error) {
+	if in.Kind == storage.KindCompound {
+		return alerts.CreateCompoundAlert(store, fresh, phone, in.Condition.toCondition(), in.Channels, in.FireImmediately)
+	}
+	if in.Kind == storage.KindExpression {
+		return alerts.CreateExpressionAlert(store, fresh, phone, in.Expression, in.Bindings, in.Channels, in.FireImmediately)
+	}
+	if in.Kind == storage.KindPercent {
+		changePct, _ := strconv.ParseFloat(in.ChangePct, 64)
+		return alerts.CreatePercentAlert(store, fresh, phone, in.AssetType, in.Symbol, changePct, in.Move, alerts.PercentWindows[in.Window], in.Unit, in.Channels)
+	}
+	return alerts.CreateAlert(store, fresh, phone, in.AssetType, in.Symbol, in.Threshold, in.Direction, in.Unit, in.Channels, in.recurrence(), in.FireImmediately)
+}
+
+// alreadyTrueWarning explains that a validated threshold, compound or
+// expression alert's condition already holds at the latest prices, so it will
+// wait for the next crossing. It is empty if the condition doesn't hold, a price
+// (or, for rules, the history) isn't known yet or is stale, or the alert fires
+// immediately anyway.
+func (in alertInput) alreadyTrueWarning(store storage.Store, fresh *prices.Freshness) string {
+	price := prices.FreshPrice(store, fresh, time.Now())
+	if (in.Kind == storage.KindCompound || in.Kind == storage.KindExpression) && !in.FireImmediately {
+		met, ok := prices.EvalRule(in.applyTo(storage.Alert{}), price, nil, time.Now())
+		if !ok || !met {
+			return ""
+		}
+		return "The condition already holds at the latest prices. The alert will only fire after it stops holding and then holds again, unless you choose to fire immediately."
+	}
+	if in.Kind != storage.KindThreshold || in.FireImmediately {
+		return ""
+	}
+	alert := in.applyTo(storage.Alert{})
+	stored, ok := price(alert.AssetType, alert.Symbol)
+	if !ok {
+		return ""
+	}
+	current := prices.AlertPrice(alert, stored)
+	if !prices.ConditionHolds(alert, current) {
+		return ""
+	}
+	threshold := prices.FormatUSD(alert.Threshold) + prices.UnitSuffix(alert)
+	return fmt.Sprintf("%s is already %s %s (last price %s). The alert will only fire after the price crosses back and then past %s again, unless you choose to fire immediately.",
+		alert.Symbol, in.Direction, threshold, prices.FormatUSD(current), threshold)
+}
+
+// recurrence is the validated input's repeat settings
+func (in alertInput) recurrence() alerts.Recurrence {
+	if !in.Recurring {
+		return alerts.Recurrence{}
+	}
+	hysteresis, _ := strconv.ParseFloat(in.Hysteresis, 64)
+	return alerts.Recurrence{Recurring: true, Cooldown: alerts.Cooldowns[in.Cooldown], HysteresisPct: hysteresis}
+}
+
+// inputFromAlert is the form input that would recreate a saved alert; edits
+// from the page and the API lay the submitted fields over it
+func inputFromAlert(a storage.Alert) alertInput {
+	in := alertInput{
+		Kind:      a.Kind,
+		AssetType: a.AssetType,
+		Symbol:    a.Symbol,
+		Unit:      a.Unit,
+		Channels:  a.Channels,
+	}
+	if a.Kind == storage.KindPercent {
+		in.ChangePct = strconv.FormatFloat(a.ChangePct, 'f', -1, 64)
+		in.Move = a.Move
+		in.Window = windowKey(a.Window)
+		return in
+	}
+	if a.Kind == storage.KindCompound {
+		if a.Condition != nil {
+			in.Condition = newConditionInput(*a.Condition)
+		}
+		in.FireImmediately = a.FireImmediately
+		return in
+	}
+	if a.Kind == storage.KindExpression {
+		in.Expression = a.Expression
+		in.FireImmediately = a.FireImmediately
+		return in
+	}
+	in.Kind = storage.KindThreshold
+	in.Threshold = strconv.FormatFloat(a.Threshold, 'f', -1, 64)
+	in.Direction = "below"
+	if a.Above {
+		in.Direction = "above"
+	}
+	if a.Recurring {
+		in.Recurring = true
+		in.Cooldown = cooldownKey(a.Cooldown)
+		in.Hysteresis = strconv.FormatFloat(a.HysteresisPct, 'f', -1, 64)
+	}
+	in.FireImmediately = a.FireImmediately
+	return in
+}
+
+// cooldownKey is the alerts.Cooldowns key for a cooldown
+func cooldownKey(d time.Duration) string {
+	for k, v := range alerts.Cooldowns {
+		if v == d {
+			return k
+		}
+	}
+	return d.String()
+}
+
+// applyTo returns a with the validated input's settings, keeping its identity
+func (in alertInput) applyTo(a storage.Alert) storage.Alert {
+	a.Kind = in.Kind
+	a.AssetType = in.AssetType
+	a.Symbol = in.Symbol
+	a.Unit = in.Unit
+	a.Channels = in.Channels
+	a.Threshold, a.Above = 0, false
+	a.ChangePct, a.Move, a.Window = 0, "", 0
+	a.Recurring, a.Cooldown, a.HysteresisPct = false, 0, 0
+	a.Condition = nil
+	a.Expression, a.Bindings = "", nil
+	a.FireImmediately = false
+	if in.Kind == storage.KindCompound {
+		cond := in.Condition.toCondition()
+		a.Condition = &cond
+		a.FireImmediately = in.FireImmediately
+		return a
+	}
+	if in.Kind == storage.KindExpression {
+		a.Expression, a.Bindings = in.Expression, in.Bindings
+		a.FireImmediately = in.FireImmediately
+		return a
+	}
+	if in.Kind == storage.KindPercent {
+		a.ChangePct, _ = strconv.ParseFloat(in.ChangePct, 64)
+		a.Move = in.Move
+		a.Window = alerts.PercentWindows[in.Window]
+		return a
+	}
+	a.Threshold, _ = strconv.ParseFloat(in.Threshold, 64)
+	a.Above = in.Direction == "above"
+	recur := in.recurrence()
+	a.Recurring, a.Cooldown, a.HysteresisPct = recur.Recurring, recur.Cooldown, recur.HysteresisPct
+	a.FireImmediately = in.FireImmediately
+	return a
+}
+
+// handleContact saves where the email and webhook channels deliver to.
+func handleContact(store storage.Store, channels *notify.Registry, fresh *prices.Freshness, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
 		http.Redirect(w, r, "/alerts", http.StatusSeeOther)
 		return
 	}
 
-	// If GET, show the alerts page (no errors)
-	data := struct {
-		User          *storage.User
-		Crypto        map[string]float64
-		Errors        []string
-		FormAssetType string
-		FormSymbol    string
-		FormThreshold string
-		FormDirection string
-	}{
-		User:          user,
-		Crypto:        store.Crypto,
-		Errors:        nil,
-		FormAssetType: "crypto", // default
-		FormSymbol:    "",
-		FormThreshold: "",
-		FormDirection: "above",
+	phone := auth.GetUserPhone(r.Context())
+	user := store.GetUser(phone)
+	if user == nil {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	email := strings.TrimSpace(r.FormValue("email"))
+	webhookURL := strings.TrimSpace(r.FormValue("webhookURL"))
+
+	var validationErrors []string
+	if email != "" {
+		if addr, err := mail.ParseAddress(email); err != nil || addr.Address != email {
+			validationErrors = append(validationErrors, "Invalid email address.")
+		}
+	}
+	if webhookURL != "" {
+		u, err := url.Parse(webhookURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			validationErrors = append(validationErrors, "Webhook URL must be a full http:// or https:// URL.")
+		} else if err := notify.CheckWebhookURL(r.Context(), webhookURL); errors.Is(err, notify.ErrPrivateAddress) {
+			validationErrors = append(validationErrors, "Webhook URL must point to a public internet address.")
+		} else if err != nil {
+			validationErrors = append(validationErrors, "Webhook URL's host could not be found.")
+		}
+	}
+
+	if len(validationErrors) > 0 {
+		user.Email = email
+		user.WebhookURL = webhookURL
+		renderAlertsPage(w, newAlertsPageData(store, channels, fresh, user, validationErrors))
+		return
+	}
+
+	if err := store.SetContact(phone, email, webhookURL); err != nil {
+		log.Printf("Error saving contact details for %s: %v", phone, err)
+		http.Error(w, "Failed to save contact details", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/alerts", http.StatusSeeOther)
+}
+
+// validateChannels checks that each selected channel exists and that the user
+// has the contact details it needs.
+func validateChannels(channels *notify.Registry, user *storage.User, selected []string) []string {
+	var errs []string
+	for _, name := range selected {
+		if _, ok := channels.Get(name); !ok {
+			errs = append(errs, fmt.Sprintf("Notification channel %q is not available.", name))
+			continue
+		}
+		if name == "email" && user.Email == "" {
+			errs = append(errs, "Add an email address before choosing email notifications.")
+		}
+		if name == "webhook" && user.WebhookURL == "" {
+			errs = append(errs, "Add a webhook URL before choosing webhook notifications.")
+		}
+	}
+	return errs
+}
+
+// validateAsset checks that an asset exists and can be priced, normalizing the
+// symbol and, for metals, the unit in place. Other assets have no unit.
+func validateAsset(ctx context.Context, store storage.Store, providers *prices.Registry, assetType string, symbol, unit *string) []string {
+	var errs []string
+
+	// 1) Validate assetType
+	validAssetTypes := map[string]bool{
+		"crypto": true,
+		"metal":  true,
+		"stock":  true,
+	}
+	if !validAssetTypes[assetType] {
+		errs = append(errs, "Invalid asset type.")
+	}
+
+	if assetType == "crypto" {
+		if !store.IsSupportedCoin(*symbol) {
+			errs = append(errs, fmt.Sprintf("Invalid crypto coin: %s", *symbol))
+		}
+	}
+
+	if assetType == "stock" {
+		errs = append(errs, validateStockSymbol(ctx, providers, symbol)...)
+	}
+
+	if assetType == "metal" {
+		*symbol = strings.ToLower(strings.TrimSpace(*symbol))
+		if !prices.SupportedMetals[*symbol] {
+			errs = append(errs, fmt.Sprintf("Invalid metal: %s. Choose gold, silver, platinum or palladium.", *symbol))
+		}
+		if *unit == "" {
+			*unit = prices.MetalBaseUnit
+		}
+		if _, ok := prices.MetalUnits[*unit]; !ok {
+			errs = append(errs, "Invalid unit, must be 'toz', 'g' or 'kg'.")
+		}
+	} else {
+		*unit = ""
 	}
+	return errs
+}
 
+// assetResolver resolves the asset names in a rule. Names in capitals are
+// stock tickers; others are metals, then coin ids. A "crypto:", "metal:" or
+// "stock:" prefix picks the asset type. Metal prices are per troy ounce.
+func assetResolver(ctx context.Context, store storage.Store, providers *prices.Registry) rules.Resolver {
+	return func(name string) (storage.AssetRef, error) {
+		assetType, symbol, typed := strings.Cut(name, ":")
+		if !typed {
+			symbol = name
+			switch lower := strings.ToLower(name); {
+			case name == strings.ToUpper(name):
+				assetType = "stock"
+			case prices.SupportedMetals[lower]:
+				assetType = "metal"
+			case store.IsSupportedCoin(lower):
+				assetType, symbol = "crypto", lower
+			default:
+				return storage.AssetRef{}, fmt.Errorf("unknown asset %q; use a coin id like bitcoin, a metal like gold or a stock ticker like AAPL", name)
+			}
+		}
+		unit := ""
+		if errs := validateAsset(ctx, store, providers, assetType, &symbol, &unit); len(errs) > 0 {
+			return storage.AssetRef{}, errors.New(strings.TrimSuffix(errs[0], "."))
+		}
+		return storage.AssetRef{AssetType: assetType, Symbol: symbol}, nil
+	}
+}
+
+// validateStockSymbol normalizes the ticker in place and checks that the quote
+// provider knows it. If the provider can't be reached the symbol is accepted,
+// so a vendor outage doesn't block creating alerts.
+func validateStockSymbol(ctx context.Context, providers *prices.Registry, symbol *string) []string {
+	normalized, ok := prices.NormalizeStockSymbol(*symbol)
+	if !ok {
+		return []string{fmt.Sprintf("Invalid stock symbol: %s", *symbol)}
+	}
+	*symbol = normalized
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	found, err := prices.CheckSymbol(ctx, providers, "stock", normalized)
+	if err != nil {
+		log.Printf("Could not verify stock symbol %s, accepting it: %v", normalized, err)
+		return nil
+	}
+	if !found {
+		return []string{fmt.Sprintf("Unknown stock symbol: %s", normalized)}
+	}
+	return nil
+}
+
+func channelSet(names []string) map[string]bool {
+	set := make(map[string]bool, len(names))
+	for _, n := range names {
+		set[n] = true
+	}
+	return set
+}
+
+func renderAlertsPage(w http.ResponseWriter, data alertsPageData) {
 	tmpl := template.Must(template.New("alerts.html").
 		Funcs(tmplFuncs).
 		ParseFiles(
@@ -158,14 +747,14 @@ func handleAlerts(store *storage.MemoryStore, w http.ResponseWriter, r *http.Req
 	}
 }
 
-func handleAlertsPartial(store *storage.MemoryStore, w http.ResponseWriter, r *http.Request) {
+func handleAlertsPartial(store storage.Store, fresh *prices.Freshness, w http.ResponseWriter, r *http.Request) {
 	phone := auth.GetUserPhone(r.Context())
 	if phone == "" {
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
 
-	user := store.Users[phone]
+	user := store.GetUser(phone)
 	if user == nil {
 		http.Error(w, "User not found", http.StatusNotFound)
 		return
@@ -190,10 +779,10 @@ func handleAlertsPartial(store *storage.MemoryStore, w http.ResponseWriter, r *h
 	// Build the same data struct you used in handleAlerts
 	data := struct {
 		User   *storage.User
-		Crypto map[string]float64
+		Prices priceBoard
 	}{
 		User:   user,
-		Crypto: store.Crypto, // store.Crypto is the map of prices
+		Prices: newPriceBoard(store, fresh), // latest prices with their age
 	}
 
 	// Now render only the "alertsPartial" template block
@@ -203,3 +792,80 @@ func handleAlertsPartial(store *storage.MemoryStore, w http.ResponseWriter, r *h
 		return
 	}
 }
+
+// priceBoard is the latest quote for every asset, as the alerts templates see it.
+type priceBoard struct {
+	quotes map[string]map[string]storage.Quote
+	fresh  *prices.Freshness
+	now    time.Time
+}
+
+// alertPrice is an alert's current price in its own unit, and whether it can be trusted
+type alertPrice struct {
+	Available  bool
+	Price      float64
+	FetchedAt  time.Time
+	Source     string
+	Stale      bool
+	StaleSince time.Time
+}
+
+func newPriceBoard(store storage.Store, fresh *prices.Freshness) priceBoard {
+	return priceBoard{
+		quotes: map[string]map[string]storage.Quote{
+			"crypto": store.Quotes("crypto"),
+			"metal":  store.Quotes("metal"),
+			"stock":  store.Quotes("stock"),
+		},
+		fresh: fresh,
+		now:   time.Now(),
+	}
+}
+
+// conditionPrice is the price of one asset a compound or expression alert watches
+type conditionPrice struct {
+	Symbol string
+	alertPrice
+}
+
+// ForAssets looks up the prices a compound or expression alert is evaluated
+// against, once per asset and unit.
+func (b priceBoard) ForAssets(alert storage.Alert) []conditionPrice {
+	var leaves []storage.Condition
+	if alert.Condition != nil {
+		leaves = alert.Condition.Leaves()
+	} else {
+		for _, asset := range alert.Assets() {
+			leaves = append(leaves, storage.Condition{AssetType: asset.AssetType, Symbol: asset.Symbol})
+		}
+	}
+	var out []conditionPrice
+	seen := make(map[string]bool)
+	for _, leaf := range leaves {
+		key := leaf.AssetType + "/" + leaf.Symbol + "/" + leaf.Unit
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		p := b.For(storage.Alert{AssetType: leaf.AssetType, Symbol: leaf.Symbol, Unit: leaf.Unit})
+		out = append(out, conditionPrice{Symbol: leaf.Symbol, alertPrice: p})
+	}
+	return out
+}
+
+// For looks up the price an alert is evaluated against.
+func (b priceBoard) For(alert storage.Alert) alertPrice {
+	q, ok := b.quotes[alert.AssetType][alert.Symbol]
+	if !ok || q.Price == 0 {
+		return alertPrice{}
+	}
+	since, stale := b.fresh.StaleSince(q, b.now)
+	return alertPrice{
+		Available:  true,
+		Price:      prices.AlertPrice(alert, q.Price),
+		FetchedAt:  q.FetchedAt,
+		Source:     q.Source,
+		Stale:      stale,
+		StaleSince: since,
+	}
+}
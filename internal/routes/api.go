@@ -0,0 +1,789 @@
+package routes
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jasonmichels/Market-Sentry/internal/alerts"
+	"github.com/jasonmichels/Market-Sentry/internal/auth"
+	"github.com/jasonmichels/Market-Sentry/internal/notify"
+	"github.com/jasonmichels/Market-Sentry/internal/prices"
+	"github.com/jasonmichels/Market-Sentry/internal/storage"
+)
+
+// Pagination defaults for list endpoints.
+const (
+	defaultPerPage = 50
+	maxPerPage     = 200
+)
+
+// maxAPIBody caps the size of a JSON request body.
+const maxAPIBody = 64 << 10
+
+// openAPIPath is the OpenAPI document describing /api/v1.
+const openAPIPath = "web/api/openapi.json"
+
+// Coin is one entry of the supported coins list (web/data/coins.json).
+type Coin struct {
+	ID            string `json:"id"`
+	Symbol        string `json:"symbol"`
+	Name          string `json:"name"`
+	MarketCapRank *int   `json:"market_cap_rank"`
+}
+
+// APIDeps is what the JSON API needs besides the store.
+type APIDeps struct {
+	Channels  *notify.Registry
+	Providers *prices.Registry
+	Fresh     *prices.Freshness
+	Coins     []Coin
+}
+
+// RegisterAPIRoutes registers the JSON API. Every route accepts an API key or a
+// signed-in session through auth.APIMiddleware, except the OpenAPI document.
+func RegisterAPIRoutes(mux *http.ServeMux, store storage.Store, deps APIDeps) {
+	api := func(pattern string, h func(w http.ResponseWriter, r *http.Request)) {
+		mux.Handle(pattern, auth.APIMiddleware(http.HandlerFunc(h)))
+	}
+
+	api("/api/v1/account", func(w http.ResponseWriter, r *http.Request) {
+		handleAPIAccount(store, w, r)
+	})
+	api("/api/v1/alerts", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+			handleAPIListAlerts(store, w, r)
+		case http.MethodPost:
+			handleAPICreateAlert(store, deps, w, r)
+		default:
+			methodNotAllowed(w, "GET, POST")
+		}
+	})
+	api("/api/v1/alerts/{id}", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+			handleAPIGetAlert(store, w, r)
+		case http.MethodPatch:
+			handleAPIUpdateAlert(store, deps, w, r)
+		case http.MethodDelete:
+			handleAPIDeleteAlert(store, w, r)
+		default:
+			methodNotAllowed(w, "GET, PATCH, DELETE")
+		}
+	})
+	api("/api/v1/alerts/{id}/{action}", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			methodNotAllowed(w, "POST")
+			return
+		}
+		handleAPIAlertAction(store, deps.Fresh, w, r)
+	})
+	api("/api/v1/notifications", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			methodNotAllowed(w, "GET")
+			return
+		}
+		handleAPIListNotifications(store, w, r)
+	})
+	api("/api/v1/notifications/{id}/ack", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			methodNotAllowed(w, "POST")
+			return
+		}
+		handleAPIAckNotification(store, w, r)
+	})
+	priceHandler := func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			methodNotAllowed(w, "GET")
+			return
+		}
+		handleAPIPrices(store, deps.Fresh, w, r)
+	}
+	api("/api/v1/prices", priceHandler)
+	api("/api/v1/prices/{assetType}", priceHandler)
+	api("/api/v1/prices/{assetType}/{symbol}", priceHandler)
+	api("/api/v1/coins", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			methodNotAllowed(w, "GET")
+			return
+		}
+		handleAPICoins(deps.Coins, w, r)
+	})
+
+	mux.HandleFunc("/api/v1/openapi.json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		http.ServeFile(w, r, openAPIPath)
+	})
+	mux.HandleFunc("/api/", func(w http.ResponseWriter, r *http.Request) {
+		auth.WriteAPIError(w, http.StatusNotFound, "not_found", "No such API endpoint")
+	})
+}
+
+// handleAPIAccount describes the caller, which is handy for checking a key works.
+func handleAPIAccount(store storage.Store, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		methodNotAllowed(w, "GET")
+		return
+	}
+	phone := auth.GetUserPhone(r.Context())
+	user := store.GetUser(phone)
+	if user == nil {
+		auth.WriteAPIError(w, http.StatusNotFound, "not_found", "User not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"phone":           user.PhoneNumber,
+		"scope":           auth.GetScope(r.Context()),
+		"activeAlerts":    user.CountActiveAlerts,
+		"triggeredAlerts": user.CountTriggeredAlerts,
+		"notifications":   user.CountNotifications,
+	})
+}
+
+// apiAlert is how an alert looks in the JSON API.
+type apiAlert struct {
+	ID        string    `json:"id"`
+	Status    string    `json:"status"` // "active" or "triggered"
+	Paused    bool      `json:"paused"`
+	Kind      string    `json:"kind"`
+	AssetType string    `json:"assetType"`
+	Symbol    string    `json:"symbol"`
+	Unit      string    `json:"unit,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+	Threshold float64   `json:"threshold,omitempty"`
+	Direction string    `json:"direction,omitempty"`
+	ChangePct float64   `json:"changePct,omitempty"`
+	Move      string    `json:"move,omitempty"`
+	Window    string    `json:"window,omitempty"`
+	BasePrice float64   `json:"basePrice,omitempty"`
+	Channels  []string  `json:"channels"`
+
+	Recurring     bool       `json:"recurring"`
+	Cooldown      string     `json:"cooldown,omitempty"`
+	HysteresisPct float64    `json:"hysteresisPct,omitempty"`
+	LastFiredAt   *time.Time `json:"lastFiredAt,omitempty"`
+	AwaitingReset bool       `json:"awaitingReset,omitempty"`
+
+	FireImmediately bool   `json:"fireImmediately,omitempty"`
+	LastSide        string `json:"lastSide,omitempty"` // side of the threshold the price was last seen on
+
+	Condition  *conditionInput `json:"condition,omitempty"`
+	Summary    string          `json:"summary,omitempty"` // the condition, readably
+	Expression string          `json:"expression,omitempty"`
+	LastState  string          `json:"lastState,omitempty"` // whether the condition or rule held at the last check
+
+	Warnings []string `json:"warnings,omitempty"` // only on create and update responses
+}
+
+// Alert statuses as reported by the API
+const (
+	alertActive    = "active"
+	alertTriggered = "triggered"
+)
+
+func newAPIAlert(a storage.Alert, status string) apiAlert {
+	out := apiAlert{
+		ID:        a.ID,
+		Status:    status,
+		Paused:    a.Paused,
+		Kind:      a.Kind,
+		AssetType: a.AssetType,
+		Symbol:    a.Symbol,
+		Unit:      a.Unit,
+		CreatedAt: a.CreatedAt,
+		Channels:  a.Channels,
+	}
+	if out.Channels == nil {
+		out.Channels = []string{}
+	}
+	if a.Kind == storage.KindPercent {
+		out.ChangePct = a.ChangePct
+		out.Move = a.Move
+		out.Window = windowKey(a.Window)
+		out.BasePrice = a.BasePrice
+		return out
+	}
+	if a.Kind == storage.KindCompound || a.Kind == storage.KindExpression {
+		if a.Condition != nil {
+			out.Condition = newConditionInput(*a.Condition)
+			out.Summary = prices.DescribeCondition(*a.Condition)
+		}
+		out.Expression = a.Expression
+		out.FireImmediately = a.FireImmediately
+		out.LastState = a.LastState
+		return out
+	}
+	out.Kind = storage.KindThreshold
+	out.Threshold = a.Threshold
+	out.Direction = "below"
+	if a.Above {
+		out.Direction = "above"
+	}
+	out.FireImmediately = a.FireImmediately
+	out.LastSide = a.LastSide
+	if a.Recurring {
+		out.Recurring = true
+		out.Cooldown = cooldownKey(a.Cooldown)
+		out.HysteresisPct = a.HysteresisPct
+		out.AwaitingReset = a.AwaitingReset
+		if !a.LastFiredAt.IsZero() {
+			at := a.LastFiredAt
+			out.LastFiredAt = &at
+		}
+	}
+	return out
+}
+
+// windowKey is the alerts.PercentWindows key for a window
+func windowKey(d time.Duration) string {
+	for k, v := range alerts.PercentWindows {
+		if v == d {
+			return k
+		}
+	}
+	return d.String()
+}
+
+// findAlert looks up one of the user's alerts and whether it has triggered
+func findAlert(user *storage.User, id string) (storage.Alert, string, bool) {
+	for _, a := range user.ActiveAlerts {
+		if a.ID == id {
+			return a, alertActive, true
+		}
+	}
+	for _, a := range user.TriggeredAlerts {
+		if a.ID == id {
+			return a, alertTriggered, true
+		}
+	}
+	return storage.Alert{}, "", false
+}
+
+// apiAlertRequest is the body of POST and PATCH /api/v1/alerts. Fields mean
+// the same as in the create form; on PATCH omitted fields keep their value.
+// Numbers may be sent as JSON numbers or numeric strings.
+type apiAlertRequest struct {
+	Kind      *string      `json:"kind"`
+	AssetType *string      `json:"assetType"`
+	Symbol    *string      `json:"symbol"`
+	Threshold *json.Number `json:"threshold"`
+	Direction *string      `json:"direction"`
+	ChangePct *json.Number `json:"changePct"`
+	Move      *string      `json:"move"`
+	Window    *string      `json:"window"`
+	Unit      *string      `json:"unit"`
+	Channels  *[]string    `json:"channels"`
+
+	Recurring     *bool        `json:"recurring"`
+	Cooldown      *string      `json:"cooldown"`
+	HysteresisPct *json.Number `json:"hysteresisPct"`
+
+	FireImmediately *bool `json:"fireImmediately"`
+
+	Condition  *conditionInput `json:"condition"` // compound alerts; replaces the whole tree
+	Expression *string         `json:"expression"`
+}
+
+// overlay copies the fields that were sent onto in
+func (req apiAlertRequest) overlay(in *alertInput) {
+	set := func(dst *string, src *string) {
+		if src != nil {
+			*dst = *src
+		}
+	}
+	set(&in.Kind, req.Kind)
+	set(&in.AssetType, req.AssetType)
+	set(&in.Symbol, req.Symbol)
+	set(&in.Direction, req.Direction)
+	set(&in.Move, req.Move)
+	set(&in.Window, req.Window)
+	set(&in.Unit, req.Unit)
+	if req.Threshold != nil {
+		in.Threshold = req.Threshold.String()
+	}
+	if req.ChangePct != nil {
+		in.ChangePct = req.ChangePct.String()
+	}
+	if req.Channels != nil {
+		in.Channels = *req.Channels
+	}
+	if req.Recurring != nil {
+		in.Recurring = *req.Recurring
+	}
+	set(&in.Cooldown, req.Cooldown)
+	if req.HysteresisPct != nil {
+		in.Hysteresis = req.HysteresisPct.String()
+	}
+	if req.FireImmediately != nil {
+		in.FireImmediately = *req.FireImmediately
+	}
+	if req.Condition != nil {
+		in.Condition = req.Condition
+	}
+	set(&in.Expression, req.Expression)
+}
+
+func handleAPIListAlerts(store storage.Store, w http.ResponseWriter, r *http.Request) {
+	user, ok := apiUser(store, w, r)
+	if !ok {
+		return
+	}
+	status := r.URL.Query().Get("status")
+	if status != "" && status != alertActive && status != alertTriggered {
+		auth.WriteAPIError(w, http.StatusBadRequest, "invalid_parameter", "status must be 'active' or 'triggered'")
+		return
+	}
+
+	var list []apiAlert
+	if status != alertTriggered {
+		for _, a := range user.ActiveAlerts {
+			list = append(list, newAPIAlert(a, alertActive))
+		}
+	}
+	if status != alertActive {
+		for _, a := range user.TriggeredAlerts {
+			list = append(list, newAPIAlert(a, alertTriggered))
+		}
+	}
+	writePage(w, r, list)
+}
+
+func handleAPICreateAlert(store storage.Store, deps APIDeps, w http.ResponseWriter, r *http.Request) {
+	user, ok := apiUser(store, w, r)
+	if !ok {
+		return
+	}
+	var req apiAlertRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	var in alertInput
+	req.overlay(&in)
+	if errs := in.validate(r.Context(), store, deps.Channels, deps.Providers, user); len(errs) > 0 {
+		auth.WriteAPIError(w, http.StatusUnprocessableEntity, "validation_failed", "The alert is not valid", errs...)
+		return
+	}
+
+	alert, err := in.create(store, deps.Fresh, user.PhoneNumber)
+	if err != nil {
+		auth.WriteAPIError(w, http.StatusInternalServerError, "internal_error", "Failed to create alert")
+		return
+	}
+	out := newAPIAlert(alert, alertActive)
+	if warning := in.alreadyTrueWarning(store, deps.Fresh); warning != "" {
+		out.Warnings = []string{warning}
+	}
+	w.Header().Set("Location", "/api/v1/alerts/"+alert.ID)
+	writeJSON(w, http.StatusCreated, out)
+}
+
+func handleAPIGetAlert(store storage.Store, w http.ResponseWriter, r *http.Request) {
+	user, ok := apiUser(store, w, r)
+	if !ok {
+		return
+	}
+	alert, status, ok := findAlert(user, r.PathValue("id"))
+	if !ok {
+		auth.WriteAPIError(w, http.StatusNotFound, "not_found", "Alert not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, newAPIAlert(alert, status))
+}
+
+func handleAPIUpdateAlert(store storage.Store, deps APIDeps, w http.ResponseWriter, r *http.Request) {
+	user, ok := apiUser(store, w, r)
+	if !ok {
+		return
+	}
+	prev, status, ok := findAlert(user, r.PathValue("id"))
+	if !ok {
+		auth.WriteAPIError(w, http.StatusNotFound, "not_found", "Alert not found")
+		return
+	}
+	if status != alertActive {
+		auth.WriteAPIError(w, http.StatusConflict, "alert_triggered", "Re-arm a triggered alert before changing it")
+		return
+	}
+	var req apiAlertRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	in := inputFromAlert(prev)
+	req.overlay(&in)
+	if errs := in.validate(r.Context(), store, deps.Channels, deps.Providers, user); len(errs) > 0 {
+		auth.WriteAPIError(w, http.StatusUnprocessableEntity, "validation_failed", "The alert is not valid", errs...)
+		return
+	}
+
+	alert, err := alerts.UpdateAlert(store, deps.Fresh, user.PhoneNumber, prev, in.applyTo(prev))
+	if errors.Is(err, storage.ErrAlertNotFound) {
+		// Triggered between the lookup and the update
+		auth.WriteAPIError(w, http.StatusConflict, "alert_triggered", "Re-arm a triggered alert before changing it")
+		return
+	}
+	if err != nil {
+		auth.WriteAPIError(w, http.StatusInternalServerError, "internal_error", "Failed to update alert")
+		return
+	}
+	out := newAPIAlert(alert, alertActive)
+	if alert.Threshold != prev.Threshold || alert.Above != prev.Above || alert.Symbol != prev.Symbol ||
+		!reflect.DeepEqual(alert.Condition, prev.Condition) || alert.Expression != prev.Expression {
+		if warning := in.alreadyTrueWarning(store, deps.Fresh); warning != "" {
+			out.Warnings = []string{warning}
+		}
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+func handleAPIDeleteAlert(store storage.Store, w http.ResponseWriter, r *http.Request) {
+	phone := auth.GetUserPhone(r.Context())
+	err := store.DeleteAlert(phone, r.PathValue("id"))
+	if errors.Is(err, storage.ErrAlertNotFound) || errors.Is(err, storage.ErrUserNotFound) {
+		auth.WriteAPIError(w, http.StatusNotFound, "not_found", "Alert not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Error deleting alert %s for %s: %v", r.PathValue("id"), phone, err)
+		auth.WriteAPIError(w, http.StatusInternalServerError, "internal_error", "Failed to delete alert")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleAPIAlertAction pauses, resumes or re-arms an alert. Pause and resume
+// apply to active alerts, rearm to triggered ones; anything else is a 409.
+func handleAPIAlertAction(store storage.Store, fresh *prices.Freshness, w http.ResponseWriter, r *http.Request) {
+	user, ok := apiUser(store, w, r)
+	if !ok {
+		return
+	}
+	id := r.PathValue("id")
+	_, status, ok := findAlert(user, id)
+	if !ok {
+		auth.WriteAPIError(w, http.StatusNotFound, "not_found", "Alert not found")
+		return
+	}
+
+	var alert storage.Alert
+	var err error
+	switch action := r.PathValue("action"); action {
+	case "pause", "resume":
+		if status != alertActive {
+			auth.WriteAPIError(w, http.StatusConflict, "alert_triggered", "Re-arm a triggered alert before pausing or resuming it")
+			return
+		}
+		alert, err = alerts.SetPaused(store, user.PhoneNumber, id, action == "pause")
+	case "rearm":
+		if status != alertTriggered {
+			auth.WriteAPIError(w, http.StatusConflict, "alert_active", "Only triggered alerts can be re-armed")
+			return
+		}
+		alert, err = alerts.RearmAlert(store, fresh, user.PhoneNumber, id)
+	default:
+		auth.WriteAPIError(w, http.StatusNotFound, "not_found", "No such API endpoint")
+		return
+	}
+	if errors.Is(err, storage.ErrAlertNotFound) {
+		auth.WriteAPIError(w, http.StatusConflict, "conflict", "The alert changed meanwhile; try again")
+		return
+	}
+	if err != nil {
+		auth.WriteAPIError(w, http.StatusInternalServerError, "internal_error", "Failed to update alert")
+		return
+	}
+	writeJSON(w, http.StatusOK, newAPIAlert(alert, alertActive))
+}
+
+// apiNotification is how a notification looks in the JSON API.
+type apiNotification struct {
+	ID             string        `json:"id"`
+	AlertID        string        `json:"alertId"`
+	Timestamp      time.Time     `json:"timestamp"`
+	Message        string        `json:"message"`
+	Acknowledged   bool          `json:"acknowledged"`
+	AcknowledgedAt *time.Time    `json:"acknowledgedAt,omitempty"`
+	Deliveries     []apiDelivery `json:"deliveries"`
+}
+
+type apiDelivery struct {
+	Channel  string    `json:"channel"`
+	Status   string    `json:"status"`
+	Attempts int       `json:"attempts"`
+	Error    string    `json:"error,omitempty"`
+	At       time.Time `json:"at"`
+}
+
+func newAPINotification(n storage.Notification) apiNotification {
+	out := apiNotification{
+		ID:         n.ID,
+		AlertID:    n.AlertID,
+		Timestamp:  n.Timestamp,
+		Message:    n.Message,
+		Deliveries: []apiDelivery{},
+	}
+	if !n.AcknowledgedAt.IsZero() {
+		at := n.AcknowledgedAt
+		out.Acknowledged = true
+		out.AcknowledgedAt = &at
+	}
+	for _, d := range n.Deliveries {
+		out.Deliveries = append(out.Deliveries, apiDelivery(d))
+	}
+	return out
+}
+
+// handleAPIListNotifications lists notifications newest first; ?unacknowledged=true
+// leaves out the ones already acknowledged.
+func handleAPIListNotifications(store storage.Store, w http.ResponseWriter, r *http.Request) {
+	user, ok := apiUser(store, w, r)
+	if !ok {
+		return
+	}
+	onlyUnacked := false
+	if v := r.URL.Query().Get("unacknowledged"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			auth.WriteAPIError(w, http.StatusBadRequest, "invalid_parameter", "unacknowledged must be true or false")
+			return
+		}
+		onlyUnacked = b
+	}
+
+	var list []apiNotification
+	for i := len(user.Notifications) - 1; i >= 0; i-- {
+		n := user.Notifications[i]
+		if onlyUnacked && !n.AcknowledgedAt.IsZero() {
+			continue
+		}
+		list = append(list, newAPINotification(n))
+	}
+	writePage(w, r, list)
+}
+
+// handleAPIAckNotification marks a notification as seen. Acknowledging it
+// again keeps the first acknowledgement time.
+func handleAPIAckNotification(store storage.Store, w http.ResponseWriter, r *http.Request) {
+	user, ok := apiUser(store, w, r)
+	if !ok {
+		return
+	}
+	id := r.PathValue("id")
+	for _, n := range user.Notifications {
+		if n.ID != id {
+			continue
+		}
+		if n.AcknowledgedAt.IsZero() {
+			n.AcknowledgedAt = time.Now()
+			if err := store.AcknowledgeNotification(user.PhoneNumber, id, n.AcknowledgedAt); err != nil {
+				log.Printf("Error acknowledging notification %s for %s: %v", id, user.PhoneNumber, err)
+				auth.WriteAPIError(w, http.StatusInternalServerError, "internal_error", "Failed to acknowledge notification")
+				return
+			}
+		}
+		writeJSON(w, http.StatusOK, newAPINotification(n))
+		return
+	}
+	auth.WriteAPIError(w, http.StatusNotFound, "not_found", "Notification not found")
+}
+
+// apiPrice is the latest quote for one asset.
+type apiPrice struct {
+	AssetType  string     `json:"assetType"`
+	Symbol     string     `json:"symbol"`
+	Price      float64    `json:"price"`
+	Unit       string     `json:"unit,omitempty"` // metals only
+	FetchedAt  *time.Time `json:"fetchedAt,omitempty"`
+	Source     string     `json:"source,omitempty"`
+	Stale      bool       `json:"stale"`
+	StaleSince *time.Time `json:"staleSince,omitempty"`
+}
+
+var apiAssetTypes = []string{"crypto", "metal", "stock"}
+
+// handleAPIPrices serves the latest quotes for every asset, one asset type or
+// one symbol. Metal prices are per troy ounce unless ?unit= asks otherwise.
+func handleAPIPrices(store storage.Store, fresh *prices.Freshness, w http.ResponseWriter, r *http.Request) {
+	assetType, symbol := r.PathValue("assetType"), r.PathValue("symbol")
+	types := apiAssetTypes
+	if assetType != "" {
+		types = []string{assetType}
+		if !validAssetType(assetType) {
+			auth.WriteAPIError(w, http.StatusNotFound, "not_found", "Unknown asset type")
+			return
+		}
+	}
+	unit := r.URL.Query().Get("unit")
+	if unit == "" {
+		unit = prices.MetalBaseUnit
+	}
+	if _, ok := prices.MetalUnits[unit]; !ok {
+		auth.WriteAPIError(w, http.StatusBadRequest, "invalid_parameter", "unit must be 'toz', 'g' or 'kg'")
+		return
+	}
+
+	now := time.Now()
+	quote := func(t, sym string, q storage.Quote) apiPrice {
+		p := apiPrice{AssetType: t, Symbol: sym, Price: q.Price, Source: q.Source}
+		if t == "metal" {
+			p.Price = prices.ConvertMetalPrice(q.Price, unit)
+			p.Unit = unit
+		}
+		if !q.FetchedAt.IsZero() {
+			at := q.FetchedAt
+			p.FetchedAt = &at
+		}
+		since, stale := fresh.StaleSince(q, now)
+		p.Stale = stale
+		if stale && !since.IsZero() {
+			p.StaleSince = &since
+		}
+		return p
+	}
+
+	if symbol != "" {
+		if assetType == "stock" {
+			symbol, _ = prices.NormalizeStockSymbol(symbol)
+		} else {
+			symbol = strings.ToLower(symbol)
+		}
+		q, ok := store.Quotes(assetType)[symbol]
+		if !ok || q.Price == 0 {
+			auth.WriteAPIError(w, http.StatusNotFound, "not_found", "No price for that symbol yet")
+			return
+		}
+		writeJSON(w, http.StatusOK, quote(assetType, symbol, q))
+		return
+	}
+
+	var list []apiPrice
+	for _, t := range types {
+		quotes := store.Quotes(t)
+		symbols := make([]string, 0, len(quotes))
+		for sym, q := range quotes {
+			if q.Price != 0 {
+				symbols = append(symbols, sym)
+			}
+		}
+		sort.Strings(symbols)
+		for _, sym := range symbols {
+			list = append(list, quote(t, sym, quotes[sym]))
+		}
+	}
+	writePage(w, r, list)
+}
+
+func validAssetType(t string) bool {
+	for _, v := range apiAssetTypes {
+		if v == t {
+			return true
+		}
+	}
+	return false
+}
+
+// handleAPICoins lists the supported crypto coins. ?q= filters by id, symbol or name.
+func handleAPICoins(coins []Coin, w http.ResponseWriter, r *http.Request) {
+	q := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("q")))
+	list := coins
+	if q != "" {
+		list = nil
+		for _, c := range coins {
+			if strings.Contains(c.ID, q) || strings.Contains(strings.ToLower(c.Symbol), q) || strings.Contains(strings.ToLower(c.Name), q) {
+				list = append(list, c)
+			}
+		}
+	}
+	writePage(w, r, list)
+}
+
+// Pagination is included with every list response.
+type Pagination struct {
+	Page       int `json:"page"`
+	PerPage    int `json:"perPage"`
+	Total      int `json:"total"`
+	TotalPages int `json:"totalPages"`
+}
+
+// writePage sends the page of items asked for by ?page= (from 1) and ?perPage=
+// as {"data": [...], "pagination": {...}}.
+func writePage[T any](w http.ResponseWriter, r *http.Request, items []T) {
+	page, perPage := 1, defaultPerPage
+	query := r.URL.Query()
+	if v := query.Get("page"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			auth.WriteAPIError(w, http.StatusBadRequest, "invalid_parameter", "page must be a whole number of at least 1")
+			return
+		}
+		page = n
+	}
+	if v := query.Get("perPage"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxPerPage {
+			auth.WriteAPIError(w, http.StatusBadRequest, "invalid_parameter", "perPage must be a whole number from 1 to "+strconv.Itoa(maxPerPage))
+			return
+		}
+		perPage = n
+	}
+
+	total := len(items)
+	start := min((page-1)*perPage, total)
+	end := min(start+perPage, total)
+	data := items[start:end]
+	if data == nil {
+		data = []T{}
+	}
+	writeJSON(w, http.StatusOK, struct {
+		Data       []T        `json:"data"`
+		Pagination Pagination `json:"pagination"`
+	}{
+		Data: data,
+		Pagination: Pagination{
+			Page:       page,
+			PerPage:    perPage,
+			Total:      total,
+			TotalPages: (total + perPage - 1) / perPage,
+		},
+	})
+}
+
+// apiUser loads the caller, answering 404 if their account is gone
+func apiUser(store storage.Store, w http.ResponseWriter, r *http.Request) (*storage.User, bool) {
+	user := store.GetUser(auth.GetUserPhone(r.Context()))
+	if user == nil {
+		auth.WriteAPIError(w, http.StatusNotFound, "not_found", "User not found")
+		return nil, false
+	}
+	return user, true
+}
+
+// decodeJSON reads a JSON request body into v, answering 400 if it can't
+func decodeJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAPIBody))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		auth.WriteAPIError(w, http.StatusBadRequest, "invalid_json", "Request body is not valid JSON: "+err.Error())
+		return false
+	}
+	return true
+}
+
+func methodNotAllowed(w http.ResponseWriter, allow string) {
+	w.Header().Set("Allow", allow)
+	auth.WriteAPIError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Use "+allow)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,353 @@
+package routes
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jasonmichels/Market-Sentry/internal/auth"
+	"github.com/jasonmichels/Market-Sentry/internal/notify"
+	"github.com/jasonmichels/Market-Sentry/internal/prices"
+	"github.com/jasonmichels/Market-Sentry/internal/storage"
+	"github.com/jasonmichels/Market-Sentry/internal/utils"
+)
+
+// nopNotifier is a channel that delivers nothing
+type nopNotifier string
+
+func (n nopNotifier) Name() string { return string(n) }
+
+func (n nopNotifier) Notify(context.Context, *storage.User, storage.Notification) error { return nil }
+
+// apiServer is the JSON API over a memory store, with a write key and a
+// read-only key for testPhone
+type apiServer struct {
+	mux      *http.ServeMux
+	store    *storage.MemoryStore
+	writeKey string
+	readKey  string
+}
+
+func newAPIServer(t *testing.T) *apiServer {
+	t.Helper()
+	store := storage.NewMemoryStore(map[string]bool{"bitcoin": true, "ethereum": true})
+	if _, err := store.GetOrCreateUser(testPhone); err != nil {
+		t.Fatal(err)
+	}
+	auth.ConfigureAPIKeys(store)
+	utils.SetAPIKeyLimit(1_000_000, 1_000_000)
+	t.Cleanup(func() {
+		auth.ConfigureAPIKeys(nil)
+		utils.SetAPIKeyLimit(60, 20)
+	})
+	writeKey, _, err := auth.CreateAPIKey(testPhone, "script", storage.ScopeWrite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	readKey, _, err := auth.CreateAPIKey(testPhone, "dashboard", storage.ScopeRead)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	channels := notify.NewRegistry()
+	channels.Register(nopNotifier("sms"))
+	mux := http.NewServeMux()
+	RegisterAPIRoutes(mux, store, APIDeps{
+		Channels: channels,
+		Fresh:    prices.NewFreshness(10 * time.Minute),
+		Coins: []Coin{
+			{ID: "bitcoin", Symbol: "btc", Name: "Bitcoin"},
+			{ID: "ethereum", Symbol: "eth", Name: "Ethereum"},
+			{ID: "tether", Symbol: "usdt", Name: "Tether"},
+		},
+	})
+	return &apiServer{mux: mux, store: store, writeKey: writeKey, readKey: readKey}
+}
+
+// do makes an API request with key, sending body as JSON unless it is empty
+func (s *apiServer) do(method, path, key, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if key != "" {
+		req.Header.Set("Authorization", "Bearer "+key)
+	}
+	rec := httptest.NewRecorder()
+	s.mux.ServeHTTP(rec, req)
+	return rec
+}
+
+// decode unmarshals a response body, failing the test on a status other than want
+func decode(t *testing.T, rec *httptest.ResponseRecorder, want int, v any) {
+	t.Helper()
+	if rec.Code != want {
+		t.Fatalf("status %d, want %d: %s", rec.Code, want, rec.Body.String())
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+		t.Fatalf("decode %s: %v", rec.Body.String(), err)
+	}
+}
+
+// page is a list response
+type page[T any] struct {
+	Data       []T        `json:"data"`
+	Pagination Pagination `json:"pagination"`
+}
+
+func TestAPIAlertLifecycle(t *testing.T) {
+	s := newAPIServer(t)
+
+	var created apiAlert
+	rec := s.do(http.MethodPost, "/api/v1/alerts", s.writeKey,
+		`{"assetType": "crypto", "symbol": "bitcoin", "threshold": 50000, "direction": "above", "channels": ["sms"]}`)
+	decode(t, rec, http.StatusCreated, &created)
+	if created.ID == "" || created.Status != alertActive || created.Threshold != 50000 || created.Direction != "above" {
+		t.Fatalf("created alert = %+v", created)
+	}
+	if loc := rec.Header().Get("Location"); loc != "/api/v1/alerts/"+created.ID {
+		t.Errorf("Location = %q", loc)
+	}
+
+	var list page[apiAlert]
+	decode(t, s.do(http.MethodGet, "/api/v1/alerts", s.readKey, ""), http.StatusOK, &list)
+	if len(list.Data) != 1 || list.Data[0].ID != created.ID || list.Pagination.Total != 1 {
+		t.Errorf("alerts = %+v", list)
+	}
+
+	// Omitted fields keep their values; a threshold may be a numeric string
+	var updated apiAlert
+	decode(t, s.do(http.MethodPatch, "/api/v1/alerts/"+created.ID, s.writeKey, `{"threshold": "55000"}`), http.StatusOK, &updated)
+	if updated.Threshold != 55000 || updated.Direction != "above" || len(updated.Channels) != 1 {
+		t.Errorf("updated alert = %+v, want only the threshold changed", updated)
+	}
+
+	var paused apiAlert
+	decode(t, s.do(http.MethodPost, "/api/v1/alerts/"+created.ID+"/pause", s.writeKey, ""), http.StatusOK, &paused)
+	if !paused.Paused {
+		t.Errorf("paused alert = %+v", paused)
+	}
+	if rec := s.do(http.MethodPost, "/api/v1/alerts/"+created.ID+"/rearm", s.writeKey, ""); rec.Code != http.StatusConflict {
+		t.Errorf("re-arming an active alert = %d, want 409", rec.Code)
+	}
+
+	if rec := s.do(http.MethodDelete, "/api/v1/alerts/"+created.ID, s.readKey, ""); rec.Code != http.StatusForbidden {
+		t.Errorf("delete with a read-only key = %d, want 403", rec.Code)
+	}
+	if rec := s.do(http.MethodDelete, "/api/v1/alerts/"+created.ID, s.writeKey, ""); rec.Code != http.StatusNoContent {
+		t.Fatalf("delete = %d, want 204", rec.Code)
+	}
+	if rec := s.do(http.MethodGet, "/api/v1/alerts/"+created.ID, s.readKey, ""); rec.Code != http.StatusNotFound {
+		t.Errorf("deleted alert = %d, want 404", rec.Code)
+	}
+}
+
+func TestAPITriggeredAlertAndNotifications(t *testing.T) {
+	s := newAPIServer(t)
+	var a apiAlert
+	decode(t, s.do(http.MethodPost, "/api/v1/alerts", s.writeKey,
+		`{"assetType": "metal", "symbol": "Gold", "unit": "g", "threshold": 80, "direction": "above"}`), http.StatusCreated, &a)
+	if a.Symbol != "gold" || a.Unit != "g" {
+		t.Errorf("metal alert = %+v, want the symbol normalized and the unit kept", a)
+	}
+	if err := s.store.TriggerAlert(testPhone, a.ID, 0, storage.Notification{ID: "n1", AlertID: a.ID, Timestamp: time.Now(), Message: "gold above $80.00"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var list page[apiAlert]
+	decode(t, s.do(http.MethodGet, "/api/v1/alerts?status=triggered", s.readKey, ""), http.StatusOK, &list)
+	if len(list.Data) != 1 || list.Data[0].Status != alertTriggered {
+		t.Errorf("triggered alerts = %+v", list.Data)
+	}
+	if rec := s.do(http.MethodPatch, "/api/v1/alerts/"+a.ID, s.writeKey, `{"threshold": 90}`); rec.Code != http.StatusConflict {
+		t.Errorf("editing a triggered alert = %d, want 409", rec.Code)
+	}
+
+	var notes page[apiNotification]
+	decode(t, s.do(http.MethodGet, "/api/v1/notifications?unacknowledged=true", s.readKey, ""), http.StatusOK, &notes)
+	if len(notes.Data) != 1 || notes.Data[0].ID != "n1" || notes.Data[0].Acknowledged {
+		t.Fatalf("unacknowledged notifications = %+v", notes.Data)
+	}
+	var acked apiNotification
+	decode(t, s.do(http.MethodPost, "/api/v1/notifications/n1/ack", s.writeKey, ""), http.StatusOK, &acked)
+	if !acked.Acknowledged || acked.AcknowledgedAt == nil {
+		t.Fatalf("acknowledged notification = %+v", acked)
+	}
+	var again apiNotification
+	decode(t, s.do(http.MethodPost, "/api/v1/notifications/n1/ack", s.writeKey, ""), http.StatusOK, &again)
+	if !again.AcknowledgedAt.Equal(*acked.AcknowledgedAt) {
+		t.Errorf("acknowledging again moved the time from %v to %v", acked.AcknowledgedAt, again.AcknowledgedAt)
+	}
+	decode(t, s.do(http.MethodGet, "/api/v1/notifications?unacknowledged=true", s.readKey, ""), http.StatusOK, &notes)
+	if len(notes.Data) != 0 {
+		t.Errorf("unacknowledged notifications after ack = %+v", notes.Data)
+	}
+
+	var rearmed apiAlert
+	decode(t, s.do(http.MethodPost, "/api/v1/alerts/"+a.ID+"/rearm", s.writeKey, ""), http.StatusOK, &rearmed)
+	if rearmed.Status != alertActive {
+		t.Errorf("re-armed alert = %+v", rearmed)
+	}
+}
+
+func TestAPIPrices(t *testing.T) {
+	s := newAPIServer(t)
+	now := time.Now()
+	if err := s.store.SaveQuotes("crypto", map[string]storage.Quote{
+		"bitcoin":  {Price: 60000, FetchedAt: now, Source: "coingecko"},
+		"ethereum": {Price: 3000, FetchedAt: now.Add(-time.Hour), Source: "coingecko"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.store.SaveQuotes("metal", map[string]storage.Quote{"gold": {Price: 3110.35, FetchedAt: now}}); err != nil {
+		t.Fatal(err)
+	}
+
+	var list page[apiPrice]
+	decode(t, s.do(http.MethodGet, "/api/v1/prices/crypto", s.readKey, ""), http.StatusOK, &list)
+	if len(list.Data) != 2 || list.Data[0].Symbol != "bitcoin" || list.Data[0].Stale || !list.Data[1].Stale {
+		t.Errorf("crypto prices = %+v, want bitcoin fresh then ethereum stale", list.Data)
+	}
+
+	var gold apiPrice
+	decode(t, s.do(http.MethodGet, "/api/v1/prices/metal/GOLD?unit=g", s.readKey, ""), http.StatusOK, &gold)
+	if gold.Unit != "g" || gold.Price < 99.9 || gold.Price > 100.1 {
+		t.Errorf("gold per gram = %+v, want about 100", gold)
+	}
+
+	decode(t, s.do(http.MethodGet, "/api/v1/prices", s.readKey, ""), http.StatusOK, &list)
+	if list.Pagination.Total != 3 {
+		t.Errorf("all prices = %+v, want 3", list)
+	}
+
+	cases := []struct {
+		path   string
+		status int
+	}{
+		{"/api/v1/prices/bonds", http.StatusNotFound},
+		{"/api/v1/prices/crypto/dogecoin", http.StatusNotFound},
+		{"/api/v1/prices/metal?unit=lb", http.StatusBadRequest},
+	}
+	for _, c := range cases {
+		if rec := s.do(http.MethodGet, c.path, s.readKey, ""); rec.Code != c.status {
+			t.Errorf("GET %s = %d, want %d", c.path, rec.Code, c.status)
+		}
+	}
+}
+
+func TestAPIPagination(t *testing.T) {
+	s := newAPIServer(t)
+	var coins page[Coin]
+	decode(t, s.do(http.MethodGet, "/api/v1/coins?perPage=2&page=2", s.readKey, ""), http.StatusOK, &coins)
+	if len(coins.Data) != 1 || coins.Data[0].ID != "tether" {
+		t.Errorf("second page = %+v, want tether", coins.Data)
+	}
+	if p := coins.Pagination; p.Page != 2 || p.PerPage != 2 || p.Total != 3 || p.TotalPages != 2 {
+		t.Errorf("pagination = %+v", p)
+	}
+
+	decode(t, s.do(http.MethodGet, "/api/v1/coins?page=5", s.readKey, ""), http.StatusOK, &coins)
+	if coins.Data == nil || len(coins.Data) != 0 {
+		t.Errorf("page past the end = %+v, want an empty list", coins.Data)
+	}
+	decode(t, s.do(http.MethodGet, "/api/v1/coins?q=BTC", s.readKey, ""), http.StatusOK, &coins)
+	if len(coins.Data) != 1 || coins.Data[0].ID != "bitcoin" {
+		t.Errorf("coins matching BTC = %+v", coins.Data)
+	}
+
+	for _, query := range []string{"page=0", "page=x", "perPage=0", "perPage=201"} {
+		if rec := s.do(http.MethodGet, "/api/v1/coins?"+query, s.readKey, ""); rec.Code != http.StatusBadRequest {
+			t.Errorf("?%s = %d, want 400", query, rec.Code)
+		}
+	}
+}
+
+func TestAPIErrors(t *testing.T) {
+	s := newAPIServer(t)
+	cases := []struct {
+		name, method, path, key, body string
+		status                        int
+		code                          string
+	}{
+		{"no credentials", http.MethodGet, "/api/v1/alerts", "", "", http.StatusUnauthorized, "unauthorized"},
+		{"unknown endpoint", http.MethodGet, "/api/v1/nothing", s.readKey, "", http.StatusNotFound, "not_found"},
+		{"wrong method", http.MethodPut, "/api/v1/alerts", s.writeKey, "", http.StatusMethodNotAllowed, "method_not_allowed"},
+		{"bad JSON", http.MethodPost, "/api/v1/alerts", s.writeKey, `{"threshold": }`, http.StatusBadRequest, "invalid_json"},
+		{"unknown field", http.MethodPost, "/api/v1/alerts", s.writeKey, `{"treshold": 1}`, http.StatusBadRequest, "invalid_json"},
+		{"invalid alert", http.MethodPost, "/api/v1/alerts", s.writeKey,
+			`{"assetType": "crypto", "symbol": "dogecoin", "threshold": -1, "direction": "up", "channels": ["pager"]}`,
+			http.StatusUnprocessableEntity, "validation_failed"},
+		{"bad status filter", http.MethodGet, "/api/v1/alerts?status=paused", s.readKey, "", http.StatusBadRequest, "invalid_parameter"},
+		{"missing alert", http.MethodGet, "/api/v1/alerts/missing", s.readKey, "", http.StatusNotFound, "not_found"},
+		{"unknown action", http.MethodPost, "/api/v1/alerts/missing/explode", s.writeKey, "", http.StatusNotFound, "not_found"},
+		{"missing notification", http.MethodPost, "/api/v1/notifications/missing/ack", s.writeKey, "", http.StatusNotFound, "not_found"},
+	}
+	for _, c := range cases {
+		var body auth.APIError
+		rec := s.do(c.method, c.path, c.key, c.body)
+		if rec.Code != c.status {
+			t.Errorf("%s: status %d, want %d: %s", c.name, rec.Code, c.status, rec.Body.String())
+			continue
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || body.Error.Code != c.code {
+			t.Errorf("%s: body %s, want error code %q", c.name, rec.Body.String(), c.code)
+		}
+		if c.code == "validation_failed" && len(body.Error.Details) != 4 {
+			t.Errorf("%s: details %q, want one per problem", c.name, body.Error.Details)
+		}
+	}
+
+	if allow := s.do(http.MethodPut, "/api/v1/alerts", s.writeKey, "").Header().Get("Allow"); allow != "GET, POST" {
+		t.Errorf("Allow = %q", allow)
+	}
+}
+
+func TestAPIAccount(t *testing.T) {
+	s := newAPIServer(t)
+	var account map[string]any
+	decode(t, s.do(http.MethodGet, "/api/v1/account", s.readKey, ""), http.StatusOK, &account)
+	if account["phone"] != testPhone || account["scope"] != storage.ScopeRead {
+		t.Errorf("account = %v", account)
+	}
+}
+
+func TestAPIAlreadyTrueWarning(t *testing.T) {
+	s := newAPIServer(t)
+	now := time.Now()
+	if err := s.store.SaveQuotes("crypto", map[string]storage.Quote{"bitcoin": {Price: 60000, FetchedAt: now}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.store.SaveQuotes("metal", map[string]storage.Quote{"gold": {Price: 3110.35, FetchedAt: now}}); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		body string
+		want string
+	}{
+		{`{"assetType": "crypto", "symbol": "bitcoin", "threshold": 50000.5, "direction": "above"}`,
+			"bitcoin is already above $50000.50 (last price $60000.00)"},
+		{`{"assetType": "metal", "symbol": "gold", "unit": "g", "threshold": 80.5, "direction": "above"}`,
+			"gold is already above $80.50 per gram (last price $100.00)"},
+		{`{"assetType": "metal", "symbol": "gold", "threshold": 3000, "direction": "above"}`,
+			"past $3000.00 per troy oz again"},
+	}
+	for _, c := range cases {
+		var a apiAlert
+		decode(t, s.do(http.MethodPost, "/api/v1/alerts", s.writeKey, c.body), http.StatusCreated, &a)
+		if len(a.Warnings) != 1 || !strings.Contains(a.Warnings[0], c.want) {
+			t.Errorf("warnings for %s = %q, want one mentioning %q", c.body, a.Warnings, c.want)
+		}
+	}
+
+	var a apiAlert
+	decode(t, s.do(http.MethodPost, "/api/v1/alerts", s.writeKey,
+		`{"assetType": "crypto", "symbol": "bitcoin", "threshold": 70000, "direction": "above"}`), http.StatusCreated, &a)
+	if len(a.Warnings) != 0 {
+		t.Errorf("warnings for an alert not yet past its level = %q", a.Warnings)
+	}
+}
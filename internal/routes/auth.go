@@ -1,7 +1,12 @@
 package routes
 
 import (
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"github.com/jasonmichels/Market-Sentry/internal/utils"
 	"html/template"
@@ -9,8 +14,9 @@ import (
 	"math/big"
 	"net/http"
 	"net/url"
-	"os"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/jasonmichels/Market-Sentry/internal/auth"
@@ -33,8 +39,73 @@ func generateOneTimeCode() string {
 	return string(code)
 }
 
+// AuthSettings tunes the login flow.
+type AuthSettings struct {
+	OTPLifetime    time.Duration // how long a texted code can be used
+	OTPMaxAttempts int           // wrong guesses before a code is thrown away
+	RateLimit      bool          // apply the per-phone login rate limit
+	CodeSecret     []byte        // HMAC key login codes are hashed with
+	LogCodes       bool          // log codes that can't be texted; dev builds only
+
+	// Verify failures from one IP address lock it out for VerifyLockout,
+	// doubling with each further lockout up to VerifyMaxLockout. The address
+	// is the connection's (see utils.ClientIP), so behind a reverse proxy all
+	// clients share the proxy's lockout.
+	VerifyFailuresPerIP int
+	VerifyLockout       time.Duration
+	VerifyMaxLockout    time.Duration
+}
+
+// verifyLocks serialises code checks per phone, so a code can't be used twice
+// while checks for other phones go ahead
+var verifyLocks = keyedMutex{locks: make(map[string]*keyedLock)}
+
+// keyedMutex is a mutex per key, each kept only while someone holds or awaits it
+type keyedMutex struct {
+	mu    sync.Mutex
+	locks map[string]*keyedLock
+}
+
+type keyedLock struct {
+	sync.Mutex
+	users int
+}
+
+// lock locks key and returns the function that unlocks it
+func (k *keyedMutex) lock(key string) (unlock func()) {
+	k.mu.Lock()
+	l, ok := k.locks[key]
+	if !ok {
+		l = &keyedLock{}
+		k.locks[key] = l
+	}
+	l.users++
+	k.mu.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+		k.mu.Lock()
+		defer k.mu.Unlock()
+		if l.users--; l.users == 0 {
+			delete(k.locks, key)
+		}
+	}
+}
+
+// hashCode is how login codes are stored: keyed with the server's secret, so a
+// leaked store can't be brute-forced offline, and salted with the phone so
+// equal codes for different users don't hash alike.
+func hashCode(secret []byte, phone, code string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(phone + ":" + code))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
 // RegisterAuthRoutes registers routes for public authentication.
-func RegisterAuthRoutes(mux *http.ServeMux, store *storage.MemoryStore) {
+func RegisterAuthRoutes(mux *http.ServeMux, store storage.Store, settings AuthSettings) {
+	verifyLockout := utils.NewLockout(settings.VerifyFailuresPerIP, settings.VerifyLockout, settings.VerifyMaxLockout)
+
 	// Home/Landing Page
 	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		tmpl := template.Must(template.ParseFiles("web/templates/index.html"))
@@ -60,8 +131,7 @@ func RegisterAuthRoutes(mux *http.ServeMux, store *storage.MemoryStore) {
 			}
 
 			// --- RATE LIMITING USING go-rate ---
-			environment := os.Getenv("ENVIRONMENT")
-			if environment != "local" {
+			if settings.RateLimit {
 				limiter := utils.GetLoginLimiter(phone)
 				if !limiter.Allow() {
 					http.Error(w, "Too many login attempts. Please try again later.", http.StatusTooManyRequests)
@@ -71,18 +141,27 @@ func RegisterAuthRoutes(mux *http.ServeMux, store *storage.MemoryStore) {
 			// --- END RATE LIMITING ---
 
 			// Get or create the user.
-			user := store.GetOrCreateUser(phone)
+			if _, err := store.GetOrCreateUser(phone); err != nil {
+				log.Printf("Error creating user %s: %v", phone, err)
+				http.Error(w, "Server error", http.StatusInternalServerError)
+				return
+			}
 			// Generate a 6-character one-time code.
 			code := generateOneTimeCode()
-			user.OneTimeCode = code
-			user.OneTimeCodeExpires = time.Now().Add(5 * time.Minute)
+			if err := store.SetOneTimeCode(phone, hashCode(settings.CodeSecret, phone, code), time.Now().Add(settings.OTPLifetime)); err != nil {
+				log.Printf("Error saving OneTimeCode for user %s: %v", phone, err)
+				http.Error(w, "Server error", http.StatusInternalServerError)
+				return
+			}
 
-			log.Printf("Generated OneTimeCode for user %s: %s", phone, code)
+			if settings.LogCodes && !twilio.Enabled() {
+				log.Printf("[Local] SMS is disabled; login code for %s is %s", phone, code)
+			}
 
 			// Build the SMS message.
 			message := fmt.Sprintf("Your Market Sentry verification code is: %s", code)
 			// Send the SMS via the Twilio module.
-			if err := twilio.SendSMS(phone, message); err != nil {
+			if err := twilio.SendSMS(r.Context(), phone, message); err != nil {
 				log.Printf("Error sending SMS to %s: %v", phone, err)
 				http.Error(w, "Failed to send verification code", http.StatusInternalServerError)
 				return
@@ -99,39 +178,149 @@ func RegisterAuthRoutes(mux *http.ServeMux, store *storage.MemoryStore) {
 	mux.HandleFunc("/login/verify", func(w http.ResponseWriter, r *http.Request) {
 		phone := r.URL.Query().Get("phone")
 		if r.Method == http.MethodPost {
-			code := strings.ToUpper(r.FormValue("code"))
-			user := store.Users[phone]
-			log.Printf("Verifying code for user %s: %s", phone, code)
+			ip := utils.ClientIP(r)
+			if until, locked := verifyLockout.Locked(ip); locked {
+				retryAfter(w, until)
+				http.Error(w, "Too many failed attempts. Please try again later.", http.StatusTooManyRequests)
+				return
+			}
+			// Every failure below counts against the IP, so guessing across many phones is slowed too
+			fail := func(msg string) {
+				if until := verifyLockout.Fail(ip); !until.IsZero() {
+					log.Printf("[Auth] Locking out %s from code verification until %s", ip, until.Format(time.RFC3339))
+				}
+				http.Error(w, msg, http.StatusUnauthorized)
+			}
+
+			// Check and clear the code as one step so it can't be used twice
+			defer verifyLocks.lock(phone)()
+
+			code := strings.ToUpper(strings.TrimSpace(r.FormValue("code")))
+			user := store.GetUser(phone)
 			if user == nil {
+				verifyLockout.Fail(ip)
 				http.Redirect(w, r, "/", http.StatusSeeOther)
 				return
 			}
-			if user.OneTimeCode == code && time.Now().Before(user.OneTimeCodeExpires) {
-				tokenStr, err := auth.GenerateJWT(phone)
+			if user.OneTimeCodeHash == "" || !time.Now().Before(user.OneTimeCodeExpires) {
+				fail("Invalid code or expired")
+				return
+			}
+
+			if subtle.ConstantTimeCompare([]byte(hashCode(settings.CodeSecret, phone, code)), []byte(user.OneTimeCodeHash)) != 1 {
+				failures, err := store.FailOneTimeCode(phone)
 				if err != nil {
-					log.Println("Error generating JWT:", err)
-					http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+					log.Printf("Error recording failed code for user %s: %v", phone, err)
+				}
+				if failures >= settings.OTPMaxAttempts {
+					// Too many guesses: the code is burned and a new one must be requested
+					log.Printf("[Auth] Invalidating login code for %s after %d failed attempts", phone, failures)
+					if err := store.ClearOneTimeCode(phone); err != nil {
+						log.Printf("Error clearing OneTimeCode for user %s: %v", phone, err)
+					}
+					fail("Too many incorrect attempts. Please request a new code.")
 					return
 				}
-				http.SetCookie(w, &http.Cookie{
-					Name:     "marketsentry",
-					Value:    tokenStr,
-					Path:     "/",
-					Expires:  time.Now().Add(8 * time.Hour),
-					HttpOnly: true,
-					Secure:   false,
-				})
-				// Clear the one-time code
-				user.OneTimeCode = ""
-				user.OneTimeCodeExpires = time.Time{}
-				http.Redirect(w, r, "/alerts", http.StatusSeeOther)
+				fail("Invalid code or expired")
+				return
+			}
+
+			if err := store.ClearOneTimeCode(phone); err != nil {
+				log.Printf("Error clearing OneTimeCode for user %s: %v", phone, err)
+				http.Error(w, "Server error", http.StatusInternalServerError)
+				return
+			}
+			tokens, err := auth.StartSession(phone, r)
+			if err != nil {
+				log.Println("Error starting session:", err)
+				http.Error(w, "Failed to generate token", http.StatusInternalServerError)
 				return
 			}
-			http.Error(w, "Invalid code or expired", http.StatusUnauthorized)
+			auth.SetSessionCookies(w, tokens)
+			http.Redirect(w, r, "/alerts", http.StatusSeeOther)
 			return
 		}
 		tmpl := template.Must(template.ParseFiles("web/templates/verify-phone.html"))
 		data := struct{ Phone string }{Phone: phone}
 		_ = tmpl.Execute(w, data)
 	})
+
+	// /logout ends the current session; its access and refresh tokens stop working at once.
+	mux.HandleFunc("/logout", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if id := requestSessionID(r); id != "" {
+			if err := auth.EndSession(id); err != nil {
+				log.Printf("Error ending session %s: %v", id, err)
+			}
+		}
+		auth.ClearSessionCookies(w)
+		if r.Header.Get("Authorization") != "" {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+	})
+
+	// /auth/refresh trades a refresh token (JSON body or cookie) for new tokens,
+	// for clients that keep their own tokens instead of cookies.
+	mux.HandleFunc("/auth/refresh", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var body struct {
+			RefreshToken string `json:"refreshToken"`
+		}
+		if r.Header.Get("Content-Type") == "application/json" {
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+				return
+			}
+		} else if c, err := r.Cookie(auth.RefreshCookie); err == nil {
+			body.RefreshToken = c.Value
+		}
+		if body.RefreshToken == "" {
+			http.Error(w, "Missing refresh token", http.StatusBadRequest)
+			return
+		}
+
+		tokens, err := auth.Refresh(body.RefreshToken)
+		if err != nil {
+			log.Printf("Refresh failed: %v", err)
+			auth.ClearSessionCookies(w)
+			http.Error(w, "Invalid refresh token", http.StatusUnauthorized)
+			return
+		}
+		auth.SetSessionCookies(w, tokens)
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(tokens)
+	})
+}
+
+// retryAfter tells the client when it may try again
+func retryAfter(w http.ResponseWriter, until time.Time) {
+	secs := int(time.Until(until).Seconds()) + 1
+	w.Header().Set("Retry-After", strconv.Itoa(secs))
+}
+
+// requestSessionID finds the session a request belongs to from its access
+// token, or from the refresh cookie once the access token has expired.
+func requestSessionID(r *http.Request) string {
+	tokenStr := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if c, err := r.Cookie(auth.AccessCookie); err == nil {
+		tokenStr = c.Value
+	}
+	if tokenStr != "" {
+		if _, id, err := auth.ParseJWT(tokenStr); err == nil {
+			return id
+		}
+	}
+	if c, err := r.Cookie(auth.RefreshCookie); err == nil {
+		id, _, _ := strings.Cut(c.Value, ".")
+		return id
+	}
+	return ""
 }
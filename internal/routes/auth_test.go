@@ -0,0 +1,152 @@
+package routes
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jasonmichels/Market-Sentry/internal/auth"
+	"github.com/jasonmichels/Market-Sentry/internal/storage"
+)
+
+const testPhone = "+15551234567"
+
+var testCodeSecret = []byte("a test secret that is at least 32 bytes long")
+
+// newAuthMux serves the auth routes from a store where testPhone has the
+// login code ABC123
+func newAuthMux(t *testing.T, settings AuthSettings) (*http.ServeMux, *storage.MemoryStore) {
+	t.Helper()
+	settings.CodeSecret = testCodeSecret
+	if settings.OTPMaxAttempts == 0 {
+		settings.OTPMaxAttempts = 100
+	}
+	if settings.VerifyFailuresPerIP == 0 {
+		settings.VerifyFailuresPerIP = 100
+	}
+	settings.VerifyLockout, settings.VerifyMaxLockout = time.Minute, time.Hour
+
+	store := storage.NewMemoryStore(nil)
+	if _, err := store.GetOrCreateUser(testPhone); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SetOneTimeCode(testPhone, hashCode(testCodeSecret, testPhone, "ABC123"), time.Now().Add(5*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	RegisterAuthRoutes(mux, store, settings)
+	return mux, store
+}
+
+// verify submits a login code for phone from ip
+func verify(mux *http.ServeMux, ip, phone, code string) *httptest.ResponseRecorder {
+	form := url.Values{"code": {code}}
+	req := httptest.NewRequest(http.MethodPost, "/login/verify?phone="+url.QueryEscape(phone), strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.RemoteAddr = ip + ":40000"
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	return rec
+}
+
+// configureTestAuth lets verified logins start sessions in store
+func configureTestAuth(t *testing.T, store auth.SessionStore) {
+	t.Helper()
+	ks := auth.NewKeySet()
+	k, err := auth.HMACKey("test", testCodeSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ks.Add(k); err != nil {
+		t.Fatal(err)
+	}
+	if err := ks.SetSigning("test"); err != nil {
+		t.Fatal(err)
+	}
+	auth.Configure(ks, time.Hour)
+	auth.ConfigureSessions(store, 0)
+	t.Cleanup(func() {
+		auth.Configure(nil, 0)
+		auth.ConfigureSessions(nil, 0)
+	})
+}
+
+func TestVerifyBurnsCodeAfterMaxAttempts(t *testing.T) {
+	mux, store := newAuthMux(t, AuthSettings{OTPMaxAttempts: 3})
+
+	// Guesses from different addresses still count against the code
+	for i, ip := range []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"} {
+		rec := verify(mux, ip, testPhone, "WRONG1")
+		if rec.Code != http.StatusUnauthorized {
+			t.Fatalf("guess %d = %d, want 401", i+1, rec.Code)
+		}
+		if last := i == 2; last != strings.Contains(rec.Body.String(), "request a new code") {
+			t.Errorf("guess %d said %q", i+1, rec.Body.String())
+		}
+	}
+
+	if u := store.GetUser(testPhone); u.OneTimeCodeHash != "" {
+		t.Error("code kept after the last allowed guess")
+	}
+	if rec := verify(mux, "10.0.0.4", testPhone, "ABC123"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("the right code after it was burned = %d, want 401", rec.Code)
+	}
+}
+
+func TestVerifyLocksOutIP(t *testing.T) {
+	mux, _ := newAuthMux(t, AuthSettings{VerifyFailuresPerIP: 3})
+
+	// Failures against any phone, known or not, count toward the address's lockout
+	verify(mux, "10.0.0.1", testPhone, "WRONG1")
+	verify(mux, "10.0.0.1", "+15550000000", "WRONG1")
+	if rec := verify(mux, "10.0.0.1", testPhone, "WRONG2"); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("third failure = %d, want 401", rec.Code)
+	}
+
+	rec := verify(mux, "10.0.0.1", testPhone, "ABC123")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("right code from a locked-out address = %d, want 429", rec.Code)
+	}
+	if retry := rec.Header().Get("Retry-After"); retry == "" || retry == "0" {
+		t.Errorf("Retry-After = %q, want the seconds left", retry)
+	}
+	if rec := verify(mux, "10.0.0.2", testPhone, "WRONG3"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("another address = %d, want 401 rather than locked out", rec.Code)
+	}
+}
+
+func TestVerifyCodeWorksOnce(t *testing.T) {
+	mux, store := newAuthMux(t, AuthSettings{})
+	configureTestAuth(t, store)
+
+	var wg sync.WaitGroup
+	codes := make(chan int, 10)
+	for i := 0; i < cap(codes); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes <- verify(mux, "10.0.0.1", testPhone, "abc123").Code
+		}()
+	}
+	wg.Wait()
+	close(codes)
+
+	signedIn := 0
+	for code := range codes {
+		if code == http.StatusSeeOther {
+			signedIn++
+		}
+	}
+	if signedIn != 1 {
+		t.Errorf("%d concurrent uses of one code signed in, want 1", signedIn)
+	}
+	verifyLocks.mu.Lock()
+	defer verifyLocks.mu.Unlock()
+	if len(verifyLocks.locks) != 0 {
+		t.Errorf("%d phone locks left after every check finished", len(verifyLocks.locks))
+	}
+}
@@ -0,0 +1,149 @@
+package routes
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/jasonmichels/Market-Sentry/internal/alerts"
+	"github.com/jasonmichels/Market-Sentry/internal/prices"
+	"github.com/jasonmichels/Market-Sentry/internal/storage"
+)
+
+// conditionInput is a compound alert's condition tree as submitted, as JSON,
+// by the create form's condition builder or the JSON API, before it has been
+// validated. Groups have an Op of "and", "or" or "not" and their Conditions;
+// leaves have an Op of "price" (or none) and compare one asset's price with a
+// threshold like a threshold alert does.
+type conditionInput struct {
+	Op         string           `json:"op,omitempty"`
+	Conditions []conditionInput `json:"conditions,omitempty"`
+
+	AssetType string      `json:"assetType,omitempty"`
+	Symbol    string      `json:"symbol,omitempty"`
+	Unit      string      `json:"unit,omitempty"`
+	Direction string      `json:"direction,omitempty"`
+	Threshold json.Number `json:"threshold,omitempty"`
+}
+
+// parseCondition reads the condition builder's form field
+func parseCondition(raw string) (*conditionInput, error) {
+	var c conditionInput
+	if err := json.Unmarshal([]byte(raw), &c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// validate checks the tree and returns one message per problem, naming the
+// condition it is about by its position ("Condition 2.1" is the first
+// condition of the second group). It normalizes symbols and units in place.
+func (c *conditionInput) validate(ctx context.Context, store storage.Store, providers *prices.Registry) []string {
+	leaves := 0
+	errs := c.validateNode(ctx, store, providers, "Condition", 1, &leaves)
+	if leaves > alerts.MaxConditionLeaves {
+		errs = append(errs, fmt.Sprintf("A condition can compare at most %d prices.", alerts.MaxConditionLeaves))
+	}
+	return errs
+}
+
+func (c *conditionInput) validateNode(ctx context.Context, store storage.Store, providers *prices.Registry, path string, depth int, leaves *int) []string {
+	c.Op = strings.ToLower(strings.TrimSpace(c.Op))
+	if c.Op == "" && len(c.Conditions) == 0 {
+		c.Op = storage.OpPrice
+	}
+	if depth > alerts.MaxConditionDepth {
+		return []string{fmt.Sprintf("%s: Conditions can be nested at most %d levels deep.", path, alerts.MaxConditionDepth)}
+	}
+
+	var errs []string
+	switch c.Op {
+	case storage.OpAnd, storage.OpOr, storage.OpNot:
+		if len(c.Conditions) == 0 {
+			return []string{fmt.Sprintf("%s: Add at least one condition to the group.", path)}
+		}
+		if c.Op == storage.OpNot && len(c.Conditions) != 1 {
+			errs = append(errs, fmt.Sprintf("%s: A 'not' group takes exactly one condition.", path))
+		}
+		for i := range c.Conditions {
+			child := fmt.Sprintf("%s.%d", path, i+1)
+			if path == "Condition" {
+				child = fmt.Sprintf("Condition %d", i+1)
+			}
+			errs = append(errs, c.Conditions[i].validateNode(ctx, store, providers, child, depth+1, leaves)...)
+		}
+
+	case storage.OpPrice:
+		*leaves++
+		for _, msg := range validateAsset(ctx, store, providers, c.AssetType, &c.Symbol, &c.Unit) {
+			errs = append(errs, path+": "+msg)
+		}
+		if threshold, err := strconv.ParseFloat(c.Threshold.String(), 64); err != nil || threshold <= 0 {
+			errs = append(errs, path+": Threshold must be a valid number greater than 0.")
+		}
+		if c.Direction != "above" && c.Direction != "below" {
+			errs = append(errs, path+": Invalid direction, must be 'above' or 'below'.")
+		}
+
+	default:
+		errs = append(errs, fmt.Sprintf("%s: Invalid operator %q, must be 'and', 'or', 'not' or 'price'.", path, c.Op))
+	}
+	return errs
+}
+
+// toCondition converts a validated tree for storage
+func (c conditionInput) toCondition() storage.Condition {
+	if c.Op == storage.OpPrice {
+		threshold, _ := strconv.ParseFloat(c.Threshold.String(), 64)
+		return storage.Condition{
+			Op:        storage.OpPrice,
+			AssetType: c.AssetType,
+			Symbol:    c.Symbol,
+			Unit:      c.Unit,
+			Above:     c.Direction == "above",
+			Threshold: threshold,
+		}
+	}
+	out := storage.Condition{Op: c.Op, Children: make([]storage.Condition, len(c.Conditions))}
+	for i, child := range c.Conditions {
+		out.Children[i] = child.toCondition()
+	}
+	return out
+}
+
+// newConditionInput is the input that would recreate a stored condition
+func newConditionInput(c storage.Condition) *conditionInput {
+	if c.Op == storage.OpPrice {
+		direction := "below"
+		if c.Above {
+			direction = "above"
+		}
+		return &conditionInput{
+			Op:        storage.OpPrice,
+			AssetType: c.AssetType,
+			Symbol:    c.Symbol,
+			Unit:      c.Unit,
+			Direction: direction,
+			Threshold: json.Number(strconv.FormatFloat(c.Threshold, 'f', -1, 64)),
+		}
+	}
+	out := &conditionInput{Op: c.Op, Conditions: make([]conditionInput, len(c.Children))}
+	for i, child := range c.Children {
+		out.Conditions[i] = *newConditionInput(child)
+	}
+	return out
+}
+
+// String is the tree as the condition builder's form field holds it
+func (c *conditionInput) String() string {
+	if c == nil {
+		return ""
+	}
+	b, err := json.Marshal(c)
+	if err != nil {
+		return ""
+	}
+	return string(b)
+}
@@ -0,0 +1,126 @@
+package routes
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jasonmichels/Market-Sentry/internal/auth"
+)
+
+// RegisterSessionRoutes registers the page where users see and revoke their signed-in devices.
+func RegisterSessionRoutes(mux *http.ServeMux) {
+	mux.Handle("/sessions", auth.JWTMiddleware(http.HandlerFunc(handleSessions)))
+	mux.Handle("/sessions/revoke", auth.JWTMiddleware(http.HandlerFunc(handleRevokeSession)))
+}
+
+func handleSessions(w http.ResponseWriter, r *http.Request) {
+	phone := auth.GetUserPhone(r.Context())
+	current := auth.GetSessionID(r.Context())
+
+	type sessionRow struct {
+		ID         string
+		Device     string
+		IP         string
+		CreatedAt  time.Time
+		LastSeenAt time.Time
+		ExpiresAt  time.Time
+		Current    bool
+	}
+	var rows []sessionRow
+	for _, s := range auth.UserSessions(phone) {
+		rows = append(rows, sessionRow{
+			ID:         s.ID,
+			Device:     deviceName(s.UserAgent),
+			IP:         s.IP,
+			CreatedAt:  s.CreatedAt,
+			LastSeenAt: s.LastSeenAt,
+			ExpiresAt:  s.ExpiresAt,
+			Current:    s.ID == current,
+		})
+	}
+
+	funcs := template.FuncMap{
+		"formatTime": func(t time.Time) string {
+			if t.IsZero() {
+				return ""
+			}
+			return t.Format("Jan 2 2006 3:04 PM")
+		},
+	}
+	tmpl := template.Must(template.New("sessions.html").Funcs(funcs).ParseFiles("web/templates/sessions.html"))
+	if err := tmpl.Execute(w, struct{ Sessions []sessionRow }{rows}); err != nil {
+		log.Printf("Error executing sessions template: %v", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+	}
+}
+
+// handleRevokeSession signs out one of the user's sessions, or with
+// "others" set, every session except the current one.
+func handleRevokeSession(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	phone := auth.GetUserPhone(r.Context())
+	current := auth.GetSessionID(r.Context())
+	id := r.FormValue("id")
+	others := r.FormValue("others") != ""
+
+	found := false
+	for _, s := range auth.UserSessions(phone) {
+		if (others && s.ID != current) || (!others && s.ID == id) {
+			if err := auth.EndSession(s.ID); err != nil {
+				log.Printf("Error revoking session %s: %v", s.ID, err)
+				http.Error(w, "Server error", http.StatusInternalServerError)
+				return
+			}
+			log.Printf("[Auth] %s revoked session %s", phone, s.ID)
+			found = true
+		}
+	}
+	if !others && !found {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+
+	if id == current {
+		auth.ClearSessionCookies(w)
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+	http.Redirect(w, r, "/sessions", http.StatusSeeOther)
+}
+
+// deviceName summarises a user agent as "Browser on OS"
+func deviceName(ua string) string {
+	if ua == "" {
+		return "Unknown device"
+	}
+	browser := "Unknown browser"
+	for _, b := range []struct{ token, name string }{
+		{"Edg/", "Edge"}, {"OPR/", "Opera"}, {"Firefox/", "Firefox"},
+		{"Chrome/", "Chrome"}, {"Safari/", "Safari"}, {"curl/", "curl"},
+	} {
+		if strings.Contains(ua, b.token) {
+			browser = b.name
+			break
+		}
+	}
+	platform := ""
+	for _, o := range []struct{ token, name string }{
+		{"iPhone", "iPhone"}, {"iPad", "iPad"}, {"Android", "Android"},
+		{"Windows", "Windows"}, {"Mac OS X", "macOS"}, {"Linux", "Linux"},
+	} {
+		if strings.Contains(ua, o.token) {
+			platform = o.name
+			break
+		}
+	}
+	if platform == "" {
+		return browser
+	}
+	return browser + " on " + platform
+}
@@ -0,0 +1,192 @@
+package rules
+
+import (
+	"math"
+	"time"
+
+	"github.com/jasonmichels/Market-Sentry/internal/history"
+	"github.com/jasonmichels/Market-Sentry/internal/storage"
+)
+
+// Env is what a rule is evaluated against.
+type Env struct {
+	Now time.Time
+
+	// Price looks up the current stored price of an asset; ok is false if there
+	// isn't a usable one.
+	Price func(assetType, symbol string) (price float64, ok bool)
+
+	// Hist is the price history; nil means none, so functions over a window
+	// can't be evaluated.
+	Hist *history.Store
+}
+
+// Eval reports whether the rule holds. ok is false if anything it needs, a
+// price or enough history, is unavailable, or a division by zero makes the
+// answer meaningless; every part of the rule is evaluated, so such a gap never
+// decides the outcome.
+func (p *Program) Eval(env Env) (met, ok bool) {
+	e := evaluator{program: p, env: env, ok: true}
+	met = e.boolean(p.root)
+	return met, e.ok
+}
+
+// evaluator walks a type-checked rule. Values it can't compute count as zero
+// and clear ok.
+type evaluator struct {
+	program *Program
+	env     Env
+	ok      bool
+}
+
+func (e *evaluator) fail() float64 {
+	e.ok = false
+	return 0
+}
+
+func (e *evaluator) boolean(n node) bool {
+	switch n := n.(type) {
+	case *unaryExpr:
+		return !e.boolean(n.x)
+	case *binaryExpr:
+		switch n.op {
+		case "&&":
+			x, y := e.boolean(n.x), e.boolean(n.y)
+			return x && y
+		case "||":
+			x, y := e.boolean(n.x), e.boolean(n.y)
+			return x || y
+		}
+		x, y := e.number(n.x), e.number(n.y)
+		switch n.op {
+		case "<":
+			return x < y
+		case "<=":
+			return x <= y
+		case ">":
+			return x > y
+		case ">=":
+			return x >= y
+		case "==":
+			return x == y
+		case "!=":
+			return x != y
+		}
+	}
+	e.ok = false
+	return false
+}
+
+func (e *evaluator) number(n node) float64 {
+	switch n := n.(type) {
+	case *numberLit:
+		return n.value
+	case *unaryExpr:
+		return -e.number(n.x)
+	case *binaryExpr:
+		x, y := e.number(n.x), e.number(n.y)
+		switch n.op {
+		case "+":
+			return x + y
+		case "-":
+			return x - y
+		case "*":
+			return x * y
+		case "/":
+			if y == 0 {
+				return e.fail()
+			}
+			return x / y
+		}
+	case *callExpr:
+		return e.call(n)
+	}
+	return e.fail()
+}
+
+// asset is the asset an argument names
+func (e *evaluator) asset(n node) storage.AssetRef {
+	return e.program.Bindings[n.(*assetName).name]
+}
+
+func (e *evaluator) price(ref storage.AssetRef) float64 {
+	price, ok := e.env.Price(ref.AssetType, ref.Symbol)
+	if !ok || price == 0 {
+		return e.fail()
+	}
+	return price
+}
+
+// window is the recorded history of an asset within a call's window
+func (e *evaluator) window(ref storage.AssetRef, n node) []history.Candle {
+	if e.env.Hist == nil {
+		e.fail()
+		return nil
+	}
+	candles := e.env.Hist.Span(ref.AssetType, ref.Symbol, e.env.Now.Add(-n.(*windowLit).value), e.env.Now)
+	if len(candles) == 0 {
+		e.fail()
+	}
+	return candles
+}
+
+func (e *evaluator) call(n *callExpr) float64 {
+	ref := e.asset(n.args[0])
+	switch n.name {
+	case "price":
+		return e.price(ref)
+
+	case "ratio":
+		x, y := e.price(ref), e.price(e.asset(n.args[1]))
+		if y == 0 {
+			return e.fail()
+		}
+		return x / y
+
+	case "pct_change":
+		price, candles := e.price(ref), e.window(ref, n.args[1])
+		if len(candles) == 0 || candles[0].Open == 0 {
+			return e.fail()
+		}
+		return (price - candles[0].Open) / candles[0].Open * 100
+
+	case "min", "max":
+		price, candles := e.price(ref), e.window(ref, n.args[1])
+		out := price
+		for _, c := range candles {
+			if n.name == "min" {
+				out = math.Min(out, c.Low)
+			} else {
+				out = math.Max(out, c.High)
+			}
+		}
+		return out
+
+	case "sma":
+		candles := e.window(ref, n.args[1])
+		if len(candles) == 0 {
+			return e.fail()
+		}
+		sum := 0.0
+		for _, c := range candles {
+			sum += c.Close
+		}
+		return sum / float64(len(candles))
+
+	case "ema":
+		// Each recorded price weighs less the older it is, by a factor of e per window
+		candles := e.window(ref, n.args[1])
+		if len(candles) == 0 {
+			return e.fail()
+		}
+		span := n.args[1].(*windowLit).value
+		sum, weights := 0.0, 0.0
+		for _, c := range candles {
+			w := math.Exp(-float64(e.env.Now.Sub(c.Start)) / float64(span))
+			sum += w * c.Close
+			weights += w
+		}
+		return sum / weights
+	}
+	return e.fail()
+}
@@ -0,0 +1,145 @@
+package rules
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/jasonmichels/Market-Sentry/internal/history"
+)
+
+var now = time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+// testEnv prices coins from prices, missing ones unavailable, with bitcoin
+// recorded at 100, 110 and 120 over the last hour
+func testEnv(prices map[string]float64) Env {
+	hist := history.New(history.RetentionPolicy{})
+	for i, price := range []float64{100, 110, 120} {
+		hist.Record("crypto", map[string]float64{"bitcoin": price}, now.Add(time.Duration(i-3)*20*time.Minute))
+	}
+	return Env{
+		Now: now,
+		Price: func(assetType, symbol string) (float64, bool) {
+			price, ok := prices[symbol]
+			return price, ok && assetType == "crypto"
+		},
+		Hist: hist,
+	}
+}
+
+// value evaluates a numeric rule expression
+func value(t *testing.T, expr string, env Env) (float64, bool) {
+	t.Helper()
+	p, err := Compile(expr+" == 0", resolveCrypto)
+	if err != nil {
+		t.Fatalf("Compile(%q): %v", expr, err)
+	}
+	e := evaluator{program: p, env: env, ok: true}
+	return e.number(p.root.(*binaryExpr).x), e.ok
+}
+
+func TestEvalFunctions(t *testing.T) {
+	env := testEnv(map[string]float64{"bitcoin": 130, "ethereum": 5})
+	// ema weighs each price by how far into the past hour it was recorded
+	w := func(age time.Duration) float64 { return math.Exp(-float64(age) / float64(time.Hour)) }
+	ema := (w(time.Hour)*100 + w(40*time.Minute)*110 + w(20*time.Minute)*120) / (w(time.Hour) + w(40*time.Minute) + w(20*time.Minute))
+
+	cases := []struct {
+		expr string
+		want float64
+	}{
+		{"price(bitcoin)", 130},
+		{"price(bitcoin) * 2 - 10 / 4", 257.5},
+		{"-price(bitcoin)", -130},
+		{"ratio(bitcoin, ethereum)", 26},
+		{"pct_change(bitcoin, 1h)", 30},
+		{"pct_change(bitcoin, 45m)", 130.0/110*100 - 100},
+		{"min(bitcoin, 1h)", 100},
+		{"max(bitcoin, 1h)", 130},
+		{"min(bitcoin, 45m)", 110},
+		{"sma(bitcoin, 1h)", 110},
+		{"sma(bitcoin, 45m)", 115},
+		{"ema(bitcoin, 1h)", ema},
+		{"ema(bitcoin, 45m)", (math.Exp(-40.0/45)*110 + math.Exp(-20.0/45)*120) / (math.Exp(-40.0/45) + math.Exp(-20.0/45))},
+	}
+	for _, c := range cases {
+		got, ok := value(t, c.expr, env)
+		if !ok || math.Abs(got-c.want) > 1e-9 {
+			t.Errorf("%s = %v, %v, want %v", c.expr, got, ok, c.want)
+		}
+	}
+}
+
+func TestEvalUnavailable(t *testing.T) {
+	env := testEnv(map[string]float64{"bitcoin": 130, "ethereum": 5, "tether": 0})
+	noHist := env
+	noHist.Hist = nil
+
+	cases := []struct {
+		expr string
+		env  Env
+	}{
+		{"price(missing)", env},
+		{"price(tether)", env},
+		{"price(bitcoin) / 0", env},
+		{"1 / (price(bitcoin) - price(bitcoin))", env},
+		{"ratio(bitcoin, tether)", env},
+		{"ratio(bitcoin, missing)", env},
+		{"pct_change(ethereum, 1h)", env},
+		{"pct_change(missing, 1h)", env},
+		{"sma(ethereum, 1h)", env},
+		{"min(bitcoin, 10m)", env},
+		{"ema(ethereum, 1h)", env},
+		{"min(bitcoin, 1h)", noHist},
+		{"sma(bitcoin, 1h)", noHist},
+		{"pct_change(bitcoin, 1h)", noHist},
+	}
+	for _, c := range cases {
+		if got, ok := value(t, c.expr, c.env); ok {
+			t.Errorf("%s = %v, ok, want it unavailable", c.expr, got)
+		}
+	}
+}
+
+func TestEvalPctChangeFromZero(t *testing.T) {
+	env := testEnv(nil)
+	env.Price = func(string, string) (float64, bool) { return 5, true }
+	env.Hist.Record("crypto", map[string]float64{"airdrop": 0}, now.Add(-time.Hour))
+	env.Hist.Record("crypto", map[string]float64{"airdrop": 5}, now)
+
+	if got, ok := value(t, "pct_change(airdrop, 2h)", env); ok {
+		t.Errorf("pct_change from a zero price = %v, ok, want it unavailable", got)
+	}
+}
+
+func TestEvalMissingPriceNeverDecides(t *testing.T) {
+	env := testEnv(map[string]float64{"bitcoin": 130})
+
+	cases := []struct {
+		rule   string
+		met    bool
+		wantOK bool
+	}{
+		{"price(bitcoin) > 100", true, true},
+		{"price(bitcoin) > 100 && sma(bitcoin, 1h) < 120", true, true},
+		{"price(bitcoin) > 200 || not (pct_change(bitcoin, 1h) > 50)", true, true},
+		// Either side alone would settle these, but a gap on the other leaves them undecided
+		{"price(bitcoin) > 100 || price(missing) > 1", false, false},
+		{"price(missing) > 1 || price(bitcoin) > 100", false, false},
+		{"price(bitcoin) > 200 && price(missing) > 1", false, false},
+		{"price(missing) > 1 && price(bitcoin) > 200", false, false},
+		{"!(price(missing) > 1)", false, false},
+		{"price(missing) != 0", false, false},
+		{"price(bitcoin) / 0 < 1 || price(bitcoin) > 100", false, false},
+	}
+	for _, c := range cases {
+		p, err := Compile(c.rule, resolveCrypto)
+		if err != nil {
+			t.Fatalf("Compile(%q): %v", c.rule, err)
+		}
+		met, ok := p.Eval(env)
+		if ok != c.wantOK || (ok && met != c.met) {
+			t.Errorf("%s = %v, %v, want %v, %v", c.rule, met, ok, c.met, c.wantOK)
+		}
+	}
+}
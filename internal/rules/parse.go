@@ -0,0 +1,368 @@
+package rules
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// node is a parsed rule expression
+type node interface {
+	column() int
+}
+
+type numberLit struct {
+	at    int
+	value float64
+}
+
+type windowLit struct {
+	at    int
+	value time.Duration
+}
+
+type assetName struct {
+	at   int
+	name string
+}
+
+type unaryExpr struct {
+	at int
+	op string // "-" or "!"
+	x  node
+}
+
+type binaryExpr struct {
+	at   int
+	op   string
+	x, y node
+}
+
+type callExpr struct {
+	at   int
+	name string
+	args []node
+}
+
+func (n *numberLit) column() int  { return n.at }
+func (n *windowLit) column() int  { return n.at }
+func (n *assetName) column() int  { return n.at }
+func (n *unaryExpr) column() int  { return n.at }
+func (n *binaryExpr) column() int { return n.at }
+func (n *callExpr) column() int   { return n.at }
+
+// Token kinds
+const (
+	tokEOF = iota
+	tokNumber
+	tokWindow
+	tokIdent
+	tokString
+	tokOp // operators, parentheses and commas
+)
+
+type token struct {
+	kind int
+	text string // operators as written; strings unquoted
+	at   int    // 1-based column
+	num  float64
+	win  time.Duration
+}
+
+// describe renders a token for error messages
+func (t token) describe() string {
+	switch t.kind {
+	case tokEOF:
+		return "the end of the rule"
+	case tokString:
+		return strconv.Quote(t.text)
+	}
+	return fmt.Sprintf("%q", t.text)
+}
+
+// windowUnits are the units time windows can be written in
+var windowUnits = map[string]time.Duration{
+	"m": time.Minute,
+	"h": time.Hour,
+	"d": 24 * time.Hour,
+	"w": 7 * 24 * time.Hour,
+}
+
+// word operators and the symbols they stand for
+var wordOps = map[string]string{
+	"and": "&&",
+	"or":  "||",
+	"not": "!",
+}
+
+// lex splits a rule into tokens
+func lex(src string) ([]token, error) {
+	var toks []token
+	i := 0
+	for i < len(src) {
+		c := src[i]
+		at := i + 1
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+
+		case isDigit(c) || (c == '.' && i+1 < len(src) && isDigit(src[i+1])):
+			start := i
+			for i < len(src) && (isDigit(src[i]) || src[i] == '.') {
+				i++
+			}
+			num, err := strconv.ParseFloat(src[start:i], 64)
+			if err != nil {
+				return nil, errorf(at, "invalid number %q", src[start:i])
+			}
+			unitStart := i
+			for i < len(src) && isIdentChar(src[i]) {
+				i++
+			}
+			if unitStart == i {
+				toks = append(toks, token{kind: tokNumber, text: src[start:i], at: at, num: num})
+				break
+			}
+			unit, ok := windowUnits[src[unitStart:i]]
+			if !ok {
+				return nil, errorf(at, "invalid number %q; time windows are written like 15m, 4h, 7d or 2w", src[start:i])
+			}
+			toks = append(toks, token{kind: tokWindow, text: src[start:i], at: at, win: time.Duration(num * float64(unit))})
+
+		case isIdentStart(c):
+			start := i
+			for i < len(src) && isIdentChar(src[i]) {
+				i++
+			}
+			word := src[start:i]
+			if op, ok := wordOps[strings.ToLower(word)]; ok {
+				toks = append(toks, token{kind: tokOp, text: op, at: at})
+				break
+			}
+			toks = append(toks, token{kind: tokIdent, text: word, at: at})
+
+		case c == '"' || c == '\'':
+			end := strings.IndexByte(src[i+1:], c)
+			if end < 0 {
+				return nil, errorf(at, "missing closing %c", c)
+			}
+			toks = append(toks, token{kind: tokString, text: src[i+1 : i+1+end], at: at})
+			i += end + 2
+
+		default:
+			op := ""
+			for _, candidate := range []string{"&&", "||", "<=", ">=", "==", "!=", "<", ">", "!", "+", "-", "*", "/", "(", ")", ","} {
+				if strings.HasPrefix(src[i:], candidate) {
+					op = candidate
+					break
+				}
+			}
+			if op == "" {
+				if c == '&' || c == '|' || c == '=' {
+					return nil, errorf(at, "unexpected %q; did you mean %q?", string(c), strings.Repeat(string(c), 2))
+				}
+				r := []rune(src[i:])[0]
+				if !unicode.IsPrint(r) {
+					return nil, errorf(at, "unexpected character %U", r)
+				}
+				return nil, errorf(at, "unexpected character %q", r)
+			}
+			toks = append(toks, token{kind: tokOp, text: op, at: at})
+			i += len(op)
+		}
+	}
+	return append(toks, token{kind: tokEOF, at: len(src) + 1}), nil
+}
+
+func isDigit(c byte) bool      { return c >= '0' && c <= '9' }
+func isIdentStart(c byte) bool { return c == '_' || (c|0x20 >= 'a' && c|0x20 <= 'z') }
+func isIdentChar(c byte) bool  { return isIdentStart(c) || isDigit(c) || c == '.' }
+
+// parser is a recursive descent parser over the tokens of a rule:
+//
+//	or      = and { "||" and }
+//	and     = not { "&&" not }
+//	not     = "!" not | compare
+//	compare = sum [ ("<" | "<=" | ">" | ">=" | "==" | "!=") sum ]
+//	sum     = product { ("+" | "-") product }
+//	product = unary { ("*" | "/") unary }
+//	unary   = "-" unary | primary
+//	primary = number | window | string | name [ "(" [ or { "," or } ] ")" ] | "(" or ")"
+type parser struct {
+	toks []token
+	pos  int
+}
+
+func parse(src string) (node, error) {
+	toks, err := lex(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{toks: toks}
+	n, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		if isComparison(t.text) {
+			return nil, errorf(t.at, "unexpected %s; comparisons can't be chained, combine them with &&", t.describe())
+		}
+		return nil, errorf(t.at, "unexpected %s", t.describe())
+	}
+	return n, nil
+}
+
+func (p *parser) peek() token {
+	return p.toks[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.toks[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+// accept consumes the next token if it is one of the operators
+func (p *parser) accept(ops ...string) (token, bool) {
+	t := p.peek()
+	if t.kind != tokOp {
+		return t, false
+	}
+	for _, op := range ops {
+		if t.text == op {
+			return p.next(), true
+		}
+	}
+	return t, false
+}
+
+// binary parses a left-associative chain of operators over operands
+func (p *parser) binary(operand func() (node, error), ops ...string) (node, error) {
+	x, err := operand()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t, ok := p.accept(ops...)
+		if !ok {
+			return x, nil
+		}
+		y, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		x = &binaryExpr{at: t.at, op: t.text, x: x, y: y}
+	}
+}
+
+func (p *parser) parseOr() (node, error) {
+	return p.binary(p.parseAnd, "||")
+}
+
+func (p *parser) parseAnd() (node, error) {
+	return p.binary(p.parseNot, "&&")
+}
+
+func (p *parser) parseNot() (node, error) {
+	if t, ok := p.accept("!"); ok {
+		x, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &unaryExpr{at: t.at, op: "!", x: x}, nil
+	}
+	return p.parseCompare()
+}
+
+func isComparison(op string) bool {
+	switch op {
+	case "<", "<=", ">", ">=", "==", "!=":
+		return true
+	}
+	return false
+}
+
+func (p *parser) parseCompare() (node, error) {
+	x, err := p.parseSum()
+	if err != nil {
+		return nil, err
+	}
+	t, ok := p.accept("<", "<=", ">", ">=", "==", "!=")
+	if !ok {
+		return x, nil
+	}
+	y, err := p.parseSum()
+	if err != nil {
+		return nil, err
+	}
+	return &binaryExpr{at: t.at, op: t.text, x: x, y: y}, nil
+}
+
+func (p *parser) parseSum() (node, error) {
+	return p.binary(p.parseProduct, "+", "-")
+}
+
+func (p *parser) parseProduct() (node, error) {
+	return p.binary(p.parseUnary, "*", "/")
+}
+
+func (p *parser) parseUnary() (node, error) {
+	if t, ok := p.accept("-"); ok {
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &unaryExpr{at: t.at, op: "-", x: x}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	t := p.next()
+	switch t.kind {
+	case tokNumber:
+		return &numberLit{at: t.at, value: t.num}, nil
+	case tokWindow:
+		return &windowLit{at: t.at, value: t.win}, nil
+	case tokString:
+		return &assetName{at: t.at, name: t.text}, nil
+	case tokIdent:
+		if _, ok := p.accept("("); !ok {
+			return &assetName{at: t.at, name: t.text}, nil
+		}
+		call := &callExpr{at: t.at, name: strings.ToLower(t.text)}
+		if _, ok := p.accept(")"); ok {
+			return call, nil
+		}
+		for {
+			arg, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			call.args = append(call.args, arg)
+			if _, ok := p.accept(","); ok {
+				continue
+			}
+			if _, ok := p.accept(")"); ok {
+				return call, nil
+			}
+			return nil, errorf(p.peek().at, "expected \",\" or \")\" in the call to %s, found %s", call.name, p.peek().describe())
+		}
+	case tokOp:
+		if t.text == "(" {
+			x, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := p.accept(")"); !ok {
+				return nil, errorf(p.peek().at, "expected \")\" to close the \"(\" at column %d, found %s", t.at, p.peek().describe())
+			}
+			return x, nil
+		}
+	}
+	return nil, errorf(t.at, "expected a number, name or \"(\", found %s", t.describe())
+}
@@ -0,0 +1,125 @@
+package rules
+
+import (
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLex(t *testing.T) {
+	toks, err := lex(`pct_change(bitcoin, 1.5h) <= -5 and not 'usd-coin' || BRK.B`)
+	if err != nil {
+		t.Fatalf("lex: %v", err)
+	}
+	want := []token{
+		{kind: tokIdent, text: "pct_change", at: 1},
+		{kind: tokOp, text: "(", at: 11},
+		{kind: tokIdent, text: "bitcoin", at: 12},
+		{kind: tokOp, text: ",", at: 19},
+		{kind: tokWindow, text: "1.5h", at: 21, win: 90 * time.Minute},
+		{kind: tokOp, text: ")", at: 25},
+		{kind: tokOp, text: "<=", at: 27},
+		{kind: tokOp, text: "-", at: 30},
+		{kind: tokNumber, text: "5", at: 31, num: 5},
+		{kind: tokOp, text: "&&", at: 33},
+		{kind: tokOp, text: "!", at: 37},
+		{kind: tokString, text: "usd-coin", at: 41},
+		{kind: tokOp, text: "||", at: 52},
+		{kind: tokIdent, text: "BRK.B", at: 55},
+		{kind: tokEOF, at: 60},
+	}
+	if !reflect.DeepEqual(toks, want) {
+		t.Errorf("tokens =\n%+v\nwant\n%+v", toks, want)
+	}
+}
+
+// show renders a parsed rule with every operation bracketed
+func show(n node) string {
+	switch n := n.(type) {
+	case *numberLit:
+		return strconv.FormatFloat(n.value, 'g', -1, 64)
+	case *windowLit:
+		return n.value.String()
+	case *assetName:
+		return n.name
+	case *unaryExpr:
+		return "(" + n.op + show(n.x) + ")"
+	case *binaryExpr:
+		return "(" + show(n.x) + " " + n.op + " " + show(n.y) + ")"
+	case *callExpr:
+		args := make([]string, len(n.args))
+		for i, arg := range n.args {
+			args[i] = show(arg)
+		}
+		return n.name + "(" + strings.Join(args, ", ") + ")"
+	}
+	return "?"
+}
+
+func TestParsePrecedence(t *testing.T) {
+	cases := []struct {
+		src, want string
+	}{
+		{"1 + 2 * 3", "(1 + (2 * 3))"},
+		{"1 - 2 - 3", "((1 - 2) - 3)"},
+		{"8 / 4 / 2", "((8 / 4) / 2)"},
+		{"(1 + 2) * 3", "((1 + 2) * 3)"},
+		{"-2 * 3", "((-2) * 3)"},
+		{"- -2", "(-(-2))"},
+		{"price(x) > 1 + 2", "(price(x) > (1 + 2))"},
+		{"a || b && c", "(a || (b && c))"},
+		{"a && b || c", "((a && b) || c)"},
+		{"!a && b", "((!a) && b)"},
+		{"not a or b and c", "((!a) || (b && c))"},
+		{"!!a", "(!(!a))"},
+		{"!(a || b)", "(!(a || b))"},
+		{"pct_change(bitcoin, 1h) < -5", "(pct_change(bitcoin, 1h0m0s) < (-5))"},
+		{`PRICE("usd-coin") >= 1`, "(price(usd-coin) >= 1)"},
+		{"f()", "f()"},
+	}
+	for _, c := range cases {
+		n, err := parse(c.src)
+		if err != nil {
+			t.Errorf("parse(%q): %v", c.src, err)
+			continue
+		}
+		if got := show(n); got != c.want {
+			t.Errorf("parse(%q) = %s, want %s", c.src, got, c.want)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	cases := []struct {
+		src    string
+		column int
+		msg    string
+	}{
+		{"price(bitcoin) > 50000 &", 24, `did you mean "&&"`},
+		{"price(bitcoin) = 1", 16, `did you mean "=="`},
+		{"price(bitcoin) # 1", 16, `unexpected character '#'`},
+		{"price(bitcoin) > 5x", 18, `invalid number "5x"`},
+		{"price(bitcoin) > 1.2.3", 18, `invalid number "1.2.3"`},
+		{"price('bitcoin) > 1", 7, "missing closing '"},
+		{"price(bitcoin", 14, `expected "," or ")" in the call to price, found the end of the rule`},
+		{"(price(bitcoin) > 1", 20, `expected ")" to close the "(" at column 1`},
+		{"1 < 2 < 3", 7, "comparisons can't be chained"},
+		{"price(bitcoin) > 1)", 19, `unexpected ")"`},
+		{"price(bitcoin) >", 17, "expected a number, name or \"(\", found the end of the rule"},
+		{"&& price(bitcoin)", 1, `expected a number, name or "(", found "&&"`},
+	}
+	for _, c := range cases {
+		_, err := parse(c.src)
+		var e *Error
+		if !errors.As(err, &e) {
+			t.Errorf("parse(%q) = %v, want an *Error", c.src, err)
+			continue
+		}
+		if e.Column != c.column || !strings.Contains(e.Msg, c.msg) {
+			t.Errorf("parse(%q) = %v, want column %d: ...%s...", c.src, err, c.column, c.msg)
+		}
+	}
+}
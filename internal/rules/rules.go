@@ -0,0 +1,266 @@
+// Package rules is the small expression language of rule alerts, e.g.
+//
+//	pct_change(bitcoin, 1h) < -5 && price(gold) > 2400
+//
+// A rule is a true/false condition built from numbers, time windows like 15m,
+// 4h, 7d or 2w, asset names, arithmetic (+ - * /), comparisons (< <= > >= ==
+// !=), && (or "and"), || (or "or") and ! (or "not"). Its functions read prices
+// and price history:
+//
+//	price(asset)              latest price
+//	pct_change(asset, window) percent change over the window
+//	min(asset, window)        lowest price within the window
+//	max(asset, window)        highest price within the window
+//	sma(asset, window)        simple moving average over the window
+//	ema(asset, window)        exponential moving average, decaying over the window
+//	ratio(asset, asset)       first price divided by the second
+//
+// Asset names are coin ids, metal names or stock tickers (in capitals, so GOLD
+// is the stock and gold the metal); names that aren't plain identifiers, like
+// "usd-coin", are written as strings, which may also name the asset type, like
+// "crypto:gold". How names resolve is up to the caller's Resolver. Rules have no
+// variables, loops or side effects and are bounded in size, so evaluating one
+// is cheap and safe.
+package rules
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jasonmichels/Market-Sentry/internal/storage"
+)
+
+// Limits that keep rules small
+const (
+	MaxLength = 500 // characters
+	MaxNodes  = 100 // numbers, names, operators and calls
+	MaxAssets = 10  // distinct assets
+	MaxWindow = 365 * 24 * time.Hour
+)
+
+// Error is a problem with a rule, at a 1-based column of its text.
+type Error struct {
+	Column int
+	Msg    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("column %d: %s", e.Column, e.Msg)
+}
+
+func errorf(column int, format string, args ...any) *Error {
+	return &Error{Column: column, Msg: fmt.Sprintf(format, args...)}
+}
+
+// Resolver looks up the asset an asset name in a rule refers to. Its error
+// message is shown to the user as is.
+type Resolver func(name string) (storage.AssetRef, error)
+
+// Bound resolves names from the bindings of a rule compiled earlier.
+func Bound(bindings map[string]storage.AssetRef) Resolver {
+	return func(name string) (storage.AssetRef, error) {
+		ref, ok := bindings[name]
+		if !ok {
+			return storage.AssetRef{}, fmt.Errorf("unknown asset %q", name)
+		}
+		return ref, nil
+	}
+}
+
+// Program is a parsed and type-checked rule. It is never modified once
+// compiled, so it can be evaluated concurrently.
+type Program struct {
+	Source   string
+	Bindings map[string]storage.AssetRef // asset name -> asset
+
+	root node
+}
+
+// Compile parses and type-checks a rule, resolving its asset names. Problems
+// are reported as *Error.
+func Compile(source string, resolve Resolver) (*Program, error) {
+	source = strings.TrimSpace(source)
+	if source == "" {
+		return nil, errorf(1, "the rule is empty")
+	}
+	if len(source) > MaxLength {
+		return nil, errorf(MaxLength+1, "rules can be at most %d characters long", MaxLength)
+	}
+	root, err := parse(source)
+	if err != nil {
+		return nil, err
+	}
+
+	c := checker{resolve: resolve, bindings: make(map[string]storage.AssetRef)}
+	t, err := c.check(root)
+	if err != nil {
+		return nil, err
+	}
+	if t != typeBool {
+		return nil, errorf(root.column(), "the rule must be true or false, like price(bitcoin) > 50000, but it is %s", t)
+	}
+	if c.nodes > MaxNodes {
+		return nil, errorf(1, "the rule is too long; use at most %d numbers, names, operators and calls", MaxNodes)
+	}
+	return &Program{Source: source, Bindings: c.bindings, root: root}, nil
+}
+
+// valueType is the type of a rule expression
+type valueType int
+
+const (
+	typeNumber valueType = iota
+	typeBool
+	typeWindow
+	typeAsset
+)
+
+func (t valueType) String() string {
+	switch t {
+	case typeNumber:
+		return "a number"
+	case typeBool:
+		return "true/false"
+	case typeWindow:
+		return "a time window"
+	case typeAsset:
+		return "an asset"
+	}
+	return "unknown"
+}
+
+// function is the signature of a built-in function
+type function struct {
+	params []valueType
+	usage  string
+}
+
+var functions = map[string]function{
+	"price":      {[]valueType{typeAsset}, "price(asset)"},
+	"pct_change": {[]valueType{typeAsset, typeWindow}, "pct_change(asset, window)"},
+	"min":        {[]valueType{typeAsset, typeWindow}, "min(asset, window)"},
+	"max":        {[]valueType{typeAsset, typeWindow}, "max(asset, window)"},
+	"sma":        {[]valueType{typeAsset, typeWindow}, "sma(asset, window)"},
+	"ema":        {[]valueType{typeAsset, typeWindow}, "ema(asset, window)"},
+	"ratio":      {[]valueType{typeAsset, typeAsset}, "ratio(asset, asset)"},
+}
+
+// functionNames lists the functions for error messages
+func functionNames() string {
+	names := make([]string, 0, len(functions))
+	for name := range functions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+func plural(n int, noun string) string {
+	if n == 1 {
+		return "1 " + noun
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
+
+// checker type-checks a parsed rule and resolves its asset names
+type checker struct {
+	resolve  Resolver
+	bindings map[string]storage.AssetRef
+	nodes    int
+}
+
+func (c *checker) check(n node) (valueType, error) {
+	c.nodes++
+	switch n := n.(type) {
+	case *numberLit:
+		return typeNumber, nil
+
+	case *windowLit:
+		if n.value <= 0 || n.value > MaxWindow {
+			return 0, errorf(n.at, "time windows must be longer than 0 and at most 365d")
+		}
+		return typeWindow, nil
+
+	case *assetName:
+		if _, ok := c.bindings[n.name]; !ok {
+			ref, err := c.resolve(n.name)
+			if err != nil {
+				return 0, errorf(n.at, "%v", err)
+			}
+			c.bindings[n.name] = ref
+			if len(c.bindings) > MaxAssets {
+				return 0, errorf(n.at, "a rule can use at most %d assets", MaxAssets)
+			}
+		}
+		return typeAsset, nil
+
+	case *unaryExpr:
+		t, err := c.check(n.x)
+		if err != nil {
+			return 0, err
+		}
+		want := typeNumber
+		if n.op == "!" {
+			want = typeBool
+		}
+		if t != want {
+			return 0, c.operandError(n.at, n.op, n.x, t, want, "")
+		}
+		return want, nil
+
+	case *binaryExpr:
+		left, err := c.check(n.x)
+		if err != nil {
+			return 0, err
+		}
+		right, err := c.check(n.y)
+		if err != nil {
+			return 0, err
+		}
+		want, result := typeNumber, typeNumber
+		switch n.op {
+		case "&&", "||":
+			want, result = typeBool, typeBool
+		case "<", "<=", ">", ">=", "==", "!=":
+			result = typeBool
+		}
+		if left != want {
+			return 0, c.operandError(n.at, n.op, n.x, left, want, " on the left")
+		}
+		if right != want {
+			return 0, c.operandError(n.at, n.op, n.y, right, want, " on the right")
+		}
+		return result, nil
+
+	case *callExpr:
+		fn, ok := functions[n.name]
+		if !ok {
+			return 0, errorf(n.at, "unknown function %q; the functions are %s", n.name, functionNames())
+		}
+		if len(n.args) != len(fn.params) {
+			return 0, errorf(n.at, "%s takes %s, not %d: %s", n.name, plural(len(fn.params), "argument"), len(n.args), fn.usage)
+		}
+		for i, arg := range n.args {
+			t, err := c.check(arg)
+			if err != nil {
+				return 0, err
+			}
+			if t != fn.params[i] {
+				return 0, errorf(arg.column(), "argument %d of %s must be %s, not %s: %s", i+1, n.name, fn.params[i], t, fn.usage)
+			}
+		}
+		return typeNumber, nil
+	}
+	return 0, errorf(n.column(), "unexpected expression")
+}
+
+// operandError explains an operand of the wrong type, with a hint for the
+// usual mistake of comparing an asset rather than its price
+func (c *checker) operandError(at int, op string, operand node, got, want valueType, side string) error {
+	if a, ok := operand.(*assetName); ok && want == typeNumber {
+		return errorf(a.at, "%s is an asset; use price(%s) for its price", a.name, a.name)
+	}
+	return errorf(at, "%q needs %s%s, not %s", op, want, side, got)
+}
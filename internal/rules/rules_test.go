@@ -0,0 +1,115 @@
+package rules
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/jasonmichels/Market-Sentry/internal/storage"
+)
+
+// resolveCrypto takes every name but "unknown" as a coin
+func resolveCrypto(name string) (storage.AssetRef, error) {
+	if name == "unknown" {
+		return storage.AssetRef{}, fmt.Errorf("unknown asset %q", name)
+	}
+	return storage.AssetRef{AssetType: "crypto", Symbol: name}, nil
+}
+
+func TestCompileBindsAssets(t *testing.T) {
+	p, err := Compile("  ratio(bitcoin, ethereum) > 20 && price(bitcoin) > 50000  ", resolveCrypto)
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+	if p.Source != "ratio(bitcoin, ethereum) > 20 && price(bitcoin) > 50000" {
+		t.Errorf("source = %q, want it trimmed", p.Source)
+	}
+	want := map[string]storage.AssetRef{
+		"bitcoin":  {AssetType: "crypto", Symbol: "bitcoin"},
+		"ethereum": {AssetType: "crypto", Symbol: "ethereum"},
+	}
+	if !reflect.DeepEqual(p.Bindings, want) {
+		t.Errorf("bindings = %+v, want %+v", p.Bindings, want)
+	}
+
+	// A rule compiled earlier recompiles from its bindings alone
+	if _, err := Compile(p.Source, Bound(p.Bindings)); err != nil {
+		t.Errorf("recompiling from bindings: %v", err)
+	}
+	if _, err := Compile("price(gold) > 1", Bound(p.Bindings)); err == nil {
+		t.Error("an unbound name compiled")
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	cases := []struct {
+		src    string
+		column int
+		msg    string
+	}{
+		{"   ", 1, "the rule is empty"},
+		{"price(bitcoin)", 1, "must be true or false"},
+		{"bitcoin > 5", 1, "bitcoin is an asset; use price(bitcoin) for its price"},
+		{"5 < bitcoin", 5, "bitcoin is an asset"},
+		{"price(bitcoin) + 1h > 0", 16, `"+" needs a number on the right, not a time window`},
+		{"1h > 0", 4, `">" needs a number on the left, not a time window`},
+		{"price(bitcoin) > 1 && 2", 20, `"&&" needs true/false on the right, not a number`},
+		{"!price(bitcoin)", 1, `"!" needs true/false, not a number`},
+		{"-(price(bitcoin) > 1) < 0", 1, `"-" needs a number, not true/false`},
+		{"pct_change(bitcoin, 5) > 0", 21, "argument 2 of pct_change must be a time window, not a number"},
+		{"ratio(bitcoin, 2) > 0", 16, "argument 2 of ratio must be an asset, not a number"},
+		{"price(bitcoin, 1h) > 0", 1, "price takes 1 argument, not 2: price(asset)"},
+		{"sma(bitcoin) > 0", 1, "sma takes 2 arguments, not 1"},
+		{"foo(bitcoin) > 0", 1, `unknown function "foo"`},
+		{"price(unknown) > 0", 7, `unknown asset "unknown"`},
+		{"sma(bitcoin, 0m) > 0", 14, "time windows must be longer than 0"},
+		{"sma(bitcoin, 366d) > 0", 14, "at most 365d"},
+	}
+	for _, c := range cases {
+		_, err := Compile(c.src, resolveCrypto)
+		var e *Error
+		if !errors.As(err, &e) {
+			t.Errorf("Compile(%q) = %v, want an *Error", c.src, err)
+			continue
+		}
+		if e.Column != c.column || !strings.Contains(e.Msg, c.msg) {
+			t.Errorf("Compile(%q) = %v, want column %d: ...%s...", c.src, err, c.column, c.msg)
+		}
+	}
+}
+
+func TestCompileLimits(t *testing.T) {
+	rule := "price(bitcoin) > 1"
+	padded := strings.Replace(rule, ">", strings.Repeat(" ", MaxLength-len(rule))+">", 1)
+	if len(padded) != MaxLength {
+		t.Fatalf("padded rule is %d characters, want %d", len(padded), MaxLength)
+	}
+	if _, err := Compile("\n "+padded+"\t", resolveCrypto); err != nil {
+		t.Errorf("rule of exactly %d characters after trimming: %v", MaxLength, err)
+	}
+
+	var e *Error
+	_, err := Compile(padded+"0", resolveCrypto)
+	if !errors.As(err, &e) || e.Column != MaxLength+1 || !strings.Contains(e.Msg, "at most 500 characters") {
+		t.Errorf("rule one character too long = %v, want column %d", err, MaxLength+1)
+	}
+
+	_, err = Compile("price(bitcoin) > 0"+strings.Repeat(" + 1", 50), resolveCrypto)
+	if !errors.As(err, &e) || !strings.Contains(e.Msg, "at most 100 numbers") {
+		t.Errorf("rule with too many nodes = %v, want the node limit", err)
+	}
+
+	assets := make([]string, MaxAssets+1)
+	for i := range assets {
+		assets[i] = fmt.Sprintf("price(coin%d)", i)
+	}
+	if _, err := Compile(strings.Join(assets[:MaxAssets], " + ")+" > 0", resolveCrypto); err != nil {
+		t.Errorf("rule with %d assets: %v", MaxAssets, err)
+	}
+	_, err = Compile(strings.Join(assets, " + ")+" > 0", resolveCrypto)
+	if !errors.As(err, &e) || !strings.Contains(e.Msg, "at most 10 assets") {
+		t.Errorf("rule with %d assets = %v, want the asset limit", MaxAssets+1, err)
+	}
+}
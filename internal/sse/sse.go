@@ -19,15 +19,33 @@ type SSEClient struct {
 type SSEHub struct {
 	mu      sync.RWMutex
 	clients map[string]map[*SSEClient]bool
+
+	// closed when the server shuts down; every stream sends final and ends
+	closing   chan struct{}
+	final     string
+	closeOnce sync.Once
 }
 
 // NewSSEHub initializes an SSEHub.
 func NewSSEHub() *SSEHub {
 	return &SSEHub{
 		clients: make(map[string]map[*SSEClient]bool),
+		closing: make(chan struct{}),
 	}
 }
 
+// Close sends final to every open stream and ends them, so an HTTP server
+// shutdown isn't held up by connections that would otherwise never finish.
+// Streams opened afterwards are refused.
+func (hub *SSEHub) Close(final string) {
+	hub.closeOnce.Do(func() {
+		hub.mu.Lock()
+		hub.final = final
+		hub.mu.Unlock()
+		close(hub.closing)
+	})
+}
+
 // AddClient registers a new client for a given phone.
 func (hub *SSEHub) AddClient(phone string) *SSEClient {
 	hub.mu.Lock()
@@ -95,6 +113,13 @@ func (hub *SSEHub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	select {
+	case <-hub.closing:
+		http.Error(w, "Server is shutting down", http.StatusServiceUnavailable)
+		return
+	default:
+	}
+
 	// Create a new client for this phone
 	client := hub.AddClient(phone)
 	defer hub.RemoveClient(phone, client)
@@ -115,6 +140,14 @@ func (hub *SSEHub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			// The client disconnected or request was canceled
 			return
 
+		case <-hub.closing:
+			hub.mu.RLock()
+			final := hub.final
+			hub.mu.RUnlock()
+			fmt.Fprintf(w, "data: %s\n\n", final)
+			flusher.Flush()
+			return
+
 		case msg := <-client.chanStream:
 			// SSE format: "data: <message>\n\n"
 			_, err := fmt.Fprintf(w, "data: %s\n\n", msg)
@@ -0,0 +1,452 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	usersBucket    = []byte("users")
+	pricesBucket   = []byte("prices")
+	outboxBucket   = []byte("outbox")
+	sessionsBucket = []byte("sessions")
+	apiKeysBucket  = []byte("api_keys")
+)
+
+// BoltStore persists users and prices to an embedded BoltDB file.
+// Reads are served from an in-memory cache that is loaded on open;
+// every mutation is written through to disk before it returns.
+type BoltStore struct {
+	*MemoryStore
+	db *bolt.DB
+
+	// writeMu serializes persist+mutate so the file never sees writes out of order
+	writeMu sync.Mutex
+}
+
+// OpenBoltStore opens (or creates) the database at path and loads its contents.
+func OpenBoltStore(path string, sc map[string]bool) (*BoltStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("create data dir: %w", err)
+	}
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("open bolt db: %w", err)
+	}
+
+	bs := &BoltStore{MemoryStore: NewMemoryStore(sc), db: db}
+	if err := bs.load(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return bs, nil
+}
+
+// load creates the buckets if needed and fills the memory cache
+func (bs *BoltStore) load() error {
+	return bs.db.Update(func(tx *bolt.Tx) error {
+		users, err := tx.CreateBucketIfNotExists(usersBucket)
+		if err != nil {
+			return err
+		}
+		prices, err := tx.CreateBucketIfNotExists(pricesBucket)
+		if err != nil {
+			return err
+		}
+		outbox, err := tx.CreateBucketIfNotExists(outboxBucket)
+		if err != nil {
+			return err
+		}
+		sessions, err := tx.CreateBucketIfNotExists(sessionsBucket)
+		if err != nil {
+			return err
+		}
+		apiKeys, err := tx.CreateBucketIfNotExists(apiKeysBucket)
+		if err != nil {
+			return err
+		}
+
+		err = users.ForEach(func(k, v []byte) error {
+			var u User
+			if err := json.Unmarshal(v, &u); err != nil {
+				return fmt.Errorf("decode user %s: %w", k, err)
+			}
+			bs.Users[string(k)] = &u
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		err = prices.ForEach(func(k, v []byte) error {
+			var q map[string]Quote
+			if err := json.Unmarshal(v, &q); err != nil {
+				return fmt.Errorf("decode %s prices: %w", k, err)
+			}
+			return bs.MemoryStore.SaveQuotes(string(k), q)
+		})
+		if err != nil {
+			return err
+		}
+
+		err = outbox.ForEach(func(k, v []byte) error {
+			var item OutboxItem
+			if err := json.Unmarshal(v, &item); err != nil {
+				return fmt.Errorf("decode outbox item %s: %w", k, err)
+			}
+			bs.Outbox[item.ID] = &item
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		err = sessions.ForEach(func(k, v []byte) error {
+			var session Session
+			if err := json.Unmarshal(v, &session); err != nil {
+				return fmt.Errorf("decode session %s: %w", k, err)
+			}
+			bs.Sessions[session.ID] = &session
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		return apiKeys.ForEach(func(k, v []byte) error {
+			var key APIKey
+			if err := json.Unmarshal(v, &key); err != nil {
+				return fmt.Errorf("decode API key %s: %w", k, err)
+			}
+			bs.APIKeys[key.ID] = &key
+			return nil
+		})
+	})
+}
+
+// updateUser runs mutate against a scratch copy of the user, writes the result
+// to disk and only then installs it in the cache, so a failed write never
+// leaves memory ahead of the file. Must be called with writeMu held.
+func (bs *BoltStore) updateUser(phone string, mutate func(scratch *MemoryStore) error) error {
+	scratch := NewMemoryStore(nil)
+	if user := bs.MemoryStore.GetUser(phone); user != nil {
+		scratch.Users[phone] = user
+	}
+	if err := mutate(scratch); err != nil {
+		return err
+	}
+	user, ok := scratch.Users[phone]
+	if !ok {
+		return ErrUserNotFound
+	}
+	data, err := json.Marshal(user)
+	if err != nil {
+		return err
+	}
+	err = bs.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(usersBucket).Put([]byte(phone), data)
+	})
+	if err != nil {
+		return err
+	}
+
+	bs.Mu.Lock()
+	bs.Users[phone] = user
+	bs.Mu.Unlock()
+	return nil
+}
+
+func (bs *BoltStore) GetOrCreateUser(phone string) (*User, error) {
+	if user := bs.MemoryStore.GetUser(phone); user != nil {
+		return user, nil
+	}
+
+	bs.writeMu.Lock()
+	defer bs.writeMu.Unlock()
+	err := bs.updateUser(phone, func(ms *MemoryStore) error {
+		_, err := ms.GetOrCreateUser(phone)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return bs.MemoryStore.GetUser(phone), nil
+}
+
+func (bs *BoltStore) SetOneTimeCode(phone, codeHash string, expires time.Time) error {
+	bs.writeMu.Lock()
+	defer bs.writeMu.Unlock()
+	return bs.updateUser(phone, func(ms *MemoryStore) error {
+		return ms.SetOneTimeCode(phone, codeHash, expires)
+	})
+}
+
+func (bs *BoltStore) ClearOneTimeCode(phone string) error {
+	return bs.SetOneTimeCode(phone, "", time.Time{})
+}
+
+func (bs *BoltStore) FailOneTimeCode(phone string) (int, error) {
+	bs.writeMu.Lock()
+	defer bs.writeMu.Unlock()
+	var failures int
+	err := bs.updateUser(phone, func(ms *MemoryStore) error {
+		var err error
+		failures, err = ms.FailOneTimeCode(phone)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return failures, nil
+}
+
+func (bs *BoltStore) SetContact(phone, email, webhookURL string) error {
+	bs.writeMu.Lock()
+	defer bs.writeMu.Unlock()
+	return bs.updateUser(phone, func(ms *MemoryStore) error {
+		return ms.SetContact(phone, email, webhookURL)
+	})
+}
+
+func (bs *BoltStore) AddAlert(phone string, alert Alert) error {
+	bs.writeMu.Lock()
+	defer bs.writeMu.Unlock()
+	return bs.updateUser(phone, func(ms *MemoryStore) error {
+		return ms.AddAlert(phone, alert)
+	})
+}
+
+func (bs *BoltStore) UpdateAlert(phone string, alert Alert) error {
+	bs.writeMu.Lock()
+	defer bs.writeMu.Unlock()
+	return bs.updateUser(phone, func(ms *MemoryStore) error {
+		return ms.UpdateAlert(phone, alert)
+	})
+}
+
+func (bs *BoltStore) DeleteAlert(phone, alertID string) error {
+	bs.writeMu.Lock()
+	defer bs.writeMu.Unlock()
+	return bs.updateUser(phone, func(ms *MemoryStore) error {
+		return ms.DeleteAlert(phone, alertID)
+	})
+}
+
+func (bs *BoltStore) SetAlertPaused(phone, alertID string, paused bool) error {
+	bs.writeMu.Lock()
+	defer bs.writeMu.Unlock()
+	return bs.updateUser(phone, func(ms *MemoryStore) error {
+		return ms.SetAlertPaused(phone, alertID, paused)
+	})
+}
+
+func (bs *BoltStore) SetAlertState(phone, alertID string, revision int, state AlertState) error {
+	bs.writeMu.Lock()
+	defer bs.writeMu.Unlock()
+	return bs.updateUser(phone, func(ms *MemoryStore) error {
+		return ms.SetAlertState(phone, alertID, revision, state)
+	})
+}
+
+func (bs *BoltStore) RearmAlert(phone, alertID string, revision int, state AlertState) error {
+	bs.writeMu.Lock()
+	defer bs.writeMu.Unlock()
+	return bs.updateUser(phone, func(ms *MemoryStore) error {
+		return ms.RearmAlert(phone, alertID, revision, state)
+	})
+}
+
+func (bs *BoltStore) TriggerAlert(phone, alertID string, revision int, note Notification) error {
+	bs.writeMu.Lock()
+	defer bs.writeMu.Unlock()
+	return bs.updateUser(phone, func(ms *MemoryStore) error {
+		return ms.TriggerAlert(phone, alertID, revision, note)
+	})
+}
+
+func (bs *BoltStore) FireAlert(phone, alertID string, revision int, state AlertState, note Notification) error {
+	bs.writeMu.Lock()
+	defer bs.writeMu.Unlock()
+	return bs.updateUser(phone, func(ms *MemoryStore) error {
+		return ms.FireAlert(phone, alertID, revision, state, note)
+	})
+}
+
+func (bs *BoltStore) RecordDelivery(phone, notificationID string, d Delivery) error {
+	bs.writeMu.Lock()
+	defer bs.writeMu.Unlock()
+	return bs.updateUser(phone, func(ms *MemoryStore) error {
+		return ms.RecordDelivery(phone, notificationID, d)
+	})
+}
+
+func (bs *BoltStore) AcknowledgeNotification(phone, notificationID string, at time.Time) error {
+	bs.writeMu.Lock()
+	defer bs.writeMu.Unlock()
+	return bs.updateUser(phone, func(ms *MemoryStore) error {
+		return ms.AcknowledgeNotification(phone, notificationID, at)
+	})
+}
+
+func (bs *BoltStore) SaveOutboxItem(item OutboxItem) error {
+	bs.writeMu.Lock()
+	defer bs.writeMu.Unlock()
+	data, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+	err = bs.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(outboxBucket).Put([]byte(item.ID), data)
+	})
+	if err != nil {
+		return err
+	}
+	return bs.MemoryStore.SaveOutboxItem(item)
+}
+
+func (bs *BoltStore) DeleteOutboxItem(id string) error {
+	bs.writeMu.Lock()
+	defer bs.writeMu.Unlock()
+	err := bs.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(outboxBucket).Delete([]byte(id))
+	})
+	if err != nil {
+		return err
+	}
+	return bs.MemoryStore.DeleteOutboxItem(id)
+}
+
+func (bs *BoltStore) SaveSession(session Session) error {
+	bs.writeMu.Lock()
+	defer bs.writeMu.Unlock()
+	data, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+	err = bs.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(sessionsBucket).Put([]byte(session.ID), data)
+	})
+	if err != nil {
+		return err
+	}
+	return bs.MemoryStore.SaveSession(session)
+}
+
+func (bs *BoltStore) UpdateSession(session Session) error {
+	bs.writeMu.Lock()
+	defer bs.writeMu.Unlock()
+	// Deletes also hold writeMu, so a session still in the cache is still on disk
+	if _, ok := bs.MemoryStore.GetSession(session.ID); !ok {
+		return ErrSessionNotFound
+	}
+	data, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+	err = bs.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(sessionsBucket).Put([]byte(session.ID), data)
+	})
+	if err != nil {
+		return err
+	}
+	return bs.MemoryStore.UpdateSession(session)
+}
+
+func (bs *BoltStore) DeleteSession(id string) error {
+	bs.writeMu.Lock()
+	defer bs.writeMu.Unlock()
+	err := bs.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(sessionsBucket).Delete([]byte(id))
+	})
+	if err != nil {
+		return err
+	}
+	return bs.MemoryStore.DeleteSession(id)
+}
+
+func (bs *BoltStore) SaveAPIKey(key APIKey) error {
+	bs.writeMu.Lock()
+	defer bs.writeMu.Unlock()
+	data, err := json.Marshal(key)
+	if err != nil {
+		return err
+	}
+	err = bs.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(apiKeysBucket).Put([]byte(key.ID), data)
+	})
+	if err != nil {
+		return err
+	}
+	return bs.MemoryStore.SaveAPIKey(key)
+}
+
+func (bs *BoltStore) DeleteAPIKey(id string) error {
+	bs.writeMu.Lock()
+	defer bs.writeMu.Unlock()
+	err := bs.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(apiKeysBucket).Delete([]byte(id))
+	})
+	if err != nil {
+		return err
+	}
+	return bs.MemoryStore.DeleteAPIKey(id)
+}
+
+func (bs *BoltStore) TouchAPIKey(id string, at time.Time) error {
+	bs.writeMu.Lock()
+	defer bs.writeMu.Unlock()
+	// Deletes also hold writeMu, so a key still in the cache is still on disk
+	key, ok := bs.MemoryStore.GetAPIKey(id)
+	if !ok {
+		return ErrAPIKeyNotFound
+	}
+	key.LastUsedAt = at
+	data, err := json.Marshal(key)
+	if err != nil {
+		return err
+	}
+	err = bs.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(apiKeysBucket).Put([]byte(id), data)
+	})
+	if err != nil {
+		return err
+	}
+	return bs.MemoryStore.TouchAPIKey(id, at)
+}
+
+func (bs *BoltStore) SaveQuotes(assetType string, quotes map[string]Quote) error {
+	bs.writeMu.Lock()
+	defer bs.writeMu.Unlock()
+	// Merge into a scratch copy first so the cache only changes once the file has
+	scratch := NewMemoryStore(nil)
+	if err := scratch.SaveQuotes(assetType, bs.MemoryStore.Quotes(assetType)); err != nil {
+		return err
+	}
+	if err := scratch.SaveQuotes(assetType, quotes); err != nil {
+		return err
+	}
+	data, err := json.Marshal(scratch.Quotes(assetType))
+	if err != nil {
+		return err
+	}
+	err = bs.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(pricesBucket).Put([]byte(assetType), data)
+	})
+	if err != nil {
+		return err
+	}
+	return bs.MemoryStore.SaveQuotes(assetType, quotes)
+}
+
+// Close flushes and closes the database file
+func (bs *BoltStore) Close() error {
+	bs.writeMu.Lock()
+	defer bs.writeMu.Unlock()
+	return bs.db.Close()
+}
@@ -0,0 +1,83 @@
+package storage
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func openBolt(t *testing.T, path string) *BoltStore {
+	t.Helper()
+	bs, err := OpenBoltStore(path, nil)
+	if err != nil {
+		t.Fatalf("OpenBoltStore: %v", err)
+	}
+	return bs
+}
+
+func TestBoltPersistsAcrossReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data", "market-sentry.db")
+	bs := openBolt(t, path)
+	populate(t, bs)
+	checkPopulated(t, bs)
+	if err := bs.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	bs = openBolt(t, path)
+	defer bs.Close()
+	checkPopulated(t, bs)
+}
+
+func TestBoltRejectedMutationChangesNothing(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "market-sentry.db")
+	bs := openBolt(t, path)
+	populate(t, bs)
+
+	if err := bs.SetAlertState(testPhone, "a2", 7, AlertState{LastSide: SideBelow}); !errors.Is(err, ErrAlertChanged) {
+		t.Errorf("stale SetAlertState = %v, want ErrAlertChanged", err)
+	}
+	if err := bs.RearmAlert(testPhone, "a2", 0, AlertState{}); !errors.Is(err, ErrAlertNotFound) {
+		t.Errorf("RearmAlert of an active alert = %v, want ErrAlertNotFound", err)
+	}
+	if err := bs.AddAlert("+15550000000", Alert{ID: "x"}); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("AddAlert for an unknown user = %v, want ErrUserNotFound", err)
+	}
+	if bs.GetUser("+15550000000") != nil {
+		t.Error("failed AddAlert created a user")
+	}
+	checkPopulated(t, bs)
+
+	if err := bs.Close(); err != nil {
+		t.Fatal(err)
+	}
+	bs = openBolt(t, path)
+	defer bs.Close()
+	checkPopulated(t, bs)
+}
+
+func TestBoltFailedWriteLeavesCacheUnchanged(t *testing.T) {
+	bs := openBolt(t, filepath.Join(t.TempDir(), "market-sentry.db"))
+	populate(t, bs)
+	if err := bs.db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// With the file gone every write fails, and the cache must not get ahead of it
+	if err := bs.SetContact(testPhone, "lost@example.com", ""); err == nil {
+		t.Error("SetContact succeeded without a database")
+	}
+	if err := bs.FireAlert(testPhone, "a2", 0, AlertState{}, Notification{ID: "lost"}); err == nil {
+		t.Error("FireAlert succeeded without a database")
+	}
+	if err := bs.SaveQuotes("crypto", map[string]Quote{"bitcoin": {Price: 1}}); err == nil {
+		t.Error("SaveQuotes succeeded without a database")
+	}
+	if err := bs.DeleteOutboxItem("o1"); err == nil {
+		t.Error("DeleteOutboxItem succeeded without a database")
+	}
+	if err := bs.DeleteSession("s1"); err == nil {
+		t.Error("DeleteSession succeeded without a database")
+	}
+	checkPopulated(t, bs)
+}
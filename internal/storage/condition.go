@@ -0,0 +1,67 @@
+package storage
+
+// Condition operators
+const (
+	OpAnd   = "and"   // all children hold
+	OpOr    = "or"    // at least one child holds
+	OpNot   = "not"   // the single child doesn't hold
+	OpPrice = "price" // leaf: an asset's price is above or below Threshold
+)
+
+// States of a compound alert's condition; see Alert.LastState
+const (
+	StateMet   = "met"
+	StateUnmet = "unmet"
+)
+
+// AssetRef names one priced asset.
+type AssetRef struct {
+	AssetType string
+	Symbol    string
+}
+
+// Condition is a node in a compound alert's condition tree. OpAnd, OpOr and
+// OpNot nodes combine Children; OpPrice leaves compare one asset's price
+// (in Unit, for metals) against Threshold.
+type Condition struct {
+	Op       string
+	Children []Condition
+
+	AssetType string
+	Symbol    string
+	Unit      string
+	Above     bool
+	Threshold float64
+}
+
+// Leaves returns the price comparisons in the tree, depth first.
+func (c Condition) Leaves() []Condition {
+	if c.Op == OpPrice {
+		return []Condition{c}
+	}
+	var leaves []Condition
+	for _, child := range c.Children {
+		leaves = append(leaves, child.Leaves()...)
+	}
+	return leaves
+}
+
+// Depth returns the number of levels in the tree; a single leaf is 1.
+func (c Condition) Depth() int {
+	depth := 0
+	for _, child := range c.Children {
+		depth = max(depth, child.Depth())
+	}
+	return depth + 1
+}
+
+func (c Condition) clone() *Condition {
+	out := c
+	if c.Children != nil {
+		out.Children = make([]Condition, len(c.Children))
+		for i, child := range c.Children {
+			out.Children[i] = *child.clone()
+		}
+	}
+	return &out
+}
@@ -0,0 +1,645 @@
+package storage
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Journal operations, one per MemoryStore mutation
+const (
+	opUserCreated    = "user_created"
+	opOTPIssued      = "otp_issued"
+	opOTPFailed      = "otp_failed"
+	opContactUpdated = "contact_updated"
+	opAlertAdded     = "alert_added"
+	opAlertUpdated   = "alert_updated"
+	opAlertPaused    = "alert_paused"
+	opAlertState     = "alert_state"
+	opAlertTriggered = "alert_triggered"
+	opAlertFired     = "alert_fired"
+	opAlertDeleted   = "alert_deleted"
+	opAlertRearmed   = "alert_rearmed"
+	opDelivery       = "delivery_recorded"
+	opNoteAcked      = "notification_acknowledged"
+	opOutboxSaved    = "outbox_saved"
+	opOutboxDeleted  = "outbox_deleted"
+	opSessionSaved   = "session_saved"
+	opSessionUpdated = "session_updated"
+	opSessionDeleted = "session_deleted"
+	opAPIKeySaved    = "api_key_saved"
+	opAPIKeyDeleted  = "api_key_deleted"
+	opAPIKeyTouched  = "api_key_touched"
+	opQuotesSaved    = "quotes_saved"
+)
+
+const (
+	journalFile  = "journal.log"
+	snapshotFile = "snapshot.json"
+)
+
+// journalEntry is one line of the append-only journal.
+// Seq increases monotonically so entries already folded into a snapshot can be skipped.
+type journalEntry struct {
+	Seq     uint64        `json:"seq"`
+	Op      string        `json:"op"`
+	Time    time.Time     `json:"time"`
+	Phone   string        `json:"phone"`
+	Code    string        `json:"code,omitempty"` // hash of the login code
+	Expires time.Time     `json:"expires,omitempty"`
+	Email   string        `json:"email,omitempty"`
+	Webhook string        `json:"webhook,omitempty"`
+	Alert   *Alert        `json:"alert,omitempty"`
+	AlertID string        `json:"alertId,omitempty"`
+	Note    *Notification `json:"note,omitempty"`
+	NoteID  string        `json:"noteId,omitempty"`
+	Deliv   *Delivery     `json:"delivery,omitempty"`
+	Item    *OutboxItem   `json:"item,omitempty"`
+	ItemID  string        `json:"itemId,omitempty"`
+	Session *Session      `json:"session,omitempty"`
+	APIKey  *APIKey       `json:"apiKey,omitempty"`
+	AckedAt time.Time     `json:"ackedAt,omitempty"`
+	UsedAt  time.Time     `json:"usedAt,omitempty"`
+
+	Revision int         `json:"revision,omitempty"`
+	State    *AlertState `json:"state,omitempty"`
+	Paused   bool        `json:"paused,omitempty"`
+
+	AssetType string           `json:"assetType,omitempty"`
+	Quotes    map[string]Quote `json:"quotes,omitempty"`
+}
+
+// snapshot is the compacted state written periodically to snapshot.json
+type snapshot struct {
+	LastSeq  uint64                      `json:"lastSeq"`
+	TakenAt  time.Time                   `json:"takenAt"`
+	Users    map[string]*User            `json:"users"`
+	Prices   map[string]map[string]Quote `json:"prices"`
+	Outbox   []OutboxItem                `json:"outbox"`
+	Sessions []Session                   `json:"sessions"`
+	APIKeys  []APIKey                    `json:"apiKeys"`
+}
+
+// JournalStore is a MemoryStore that records every mutation in an append-only
+// journal file and periodically compacts it into a snapshot. On open the latest
+// snapshot is loaded and the journal replayed on top, so a crash loses nothing
+// that was acknowledged.
+type JournalStore struct {
+	*MemoryStore
+	dir string
+
+	// writeMu serializes append+mutate and snapshotting
+	writeMu sync.Mutex
+	file    *os.File
+	size    int64 // journal length up to the last complete entry
+	seq     uint64
+	snapSeq uint64 // seq covered by the last snapshot
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// OpenJournalStore restores state from dir and starts snapshotting every snapshotEvery.
+// A zero snapshotEvery disables periodic snapshots; one is still taken on Close.
+func OpenJournalStore(dir string, sc map[string]bool, snapshotEvery time.Duration) (*JournalStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create journal dir: %w", err)
+	}
+
+	js := &JournalStore{
+		MemoryStore: NewMemoryStore(sc),
+		dir:         dir,
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+	if err := js.loadSnapshot(); err != nil {
+		return nil, err
+	}
+	good, err := js.replay()
+	if err != nil {
+		return nil, err
+	}
+
+	// Cut off a torn trailing entry so new entries don't get appended onto it
+	path := filepath.Join(dir, journalFile)
+	if err := os.Truncate(path, good); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("truncate journal: %w", err)
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("open journal: %w", err)
+	}
+	js.file = f
+	js.size = good
+
+	go js.snapshotLoop(snapshotEvery)
+	return js, nil
+}
+
+// loadSnapshot fills the memory store from snapshot.json, if one exists
+func (js *JournalStore) loadSnapshot() error {
+	data, err := os.ReadFile(filepath.Join(js.dir, snapshotFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read snapshot: %w", err)
+	}
+
+	var snap snapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return fmt.Errorf("decode snapshot: %w", err)
+	}
+	for phone, u := range snap.Users {
+		js.Users[phone] = u
+	}
+	for assetType, q := range snap.Prices {
+		_ = js.MemoryStore.SaveQuotes(assetType, q)
+	}
+	for _, item := range snap.Outbox {
+		_ = js.MemoryStore.SaveOutboxItem(item)
+	}
+	for _, session := range snap.Sessions {
+		_ = js.MemoryStore.SaveSession(session)
+	}
+	for _, key := range snap.APIKeys {
+		_ = js.MemoryStore.SaveAPIKey(key)
+	}
+	js.seq = snap.LastSeq
+	js.snapSeq = snap.LastSeq
+	log.Printf("[Journal] Loaded snapshot from %s (seq %d, %d users)", snap.TakenAt.Format(time.RFC3339), snap.LastSeq, len(snap.Users))
+	return nil
+}
+
+// replay applies every journal entry newer than the loaded snapshot and
+// returns the length of the journal up to the last complete entry. A torn final
+// line (crash mid-append) ends the replay; a corrupt entry before it fails it.
+func (js *JournalStore) replay() (int64, error) {
+	f, err := os.Open(filepath.Join(js.dir, journalFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("open journal: %w", err)
+	}
+	defer f.Close()
+
+	var good int64
+	replayed := 0
+	reader := bufio.NewReader(f)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			if len(line) > 0 {
+				log.Printf("[Journal] Dropping incomplete trailing entry (%d bytes)", len(line))
+			}
+			break
+		}
+		if err != nil {
+			return 0, fmt.Errorf("read journal: %w", err)
+		}
+
+		var entry journalEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return 0, fmt.Errorf("corrupt journal entry at byte %d: %w", good, err)
+		}
+		good += int64(len(line))
+		if entry.Seq <= js.seq {
+			continue
+		}
+		if err := applyEntry(js.MemoryStore, entry); err != nil {
+			log.Printf("[Journal] Failed to replay seq %d (%s): %v", entry.Seq, entry.Op, err)
+		}
+		js.seq = entry.Seq
+		replayed++
+	}
+	log.Printf("[Journal] Replayed %d entries", replayed)
+	return good, nil
+}
+
+// applyEntry performs a journal entry against ms
+func applyEntry(ms *MemoryStore, e journalEntry) error {
+	switch e.Op {
+	case opUserCreated:
+		_, err := ms.GetOrCreateUser(e.Phone)
+		return err
+	case opOTPIssued:
+		return ms.SetOneTimeCode(e.Phone, e.Code, e.Expires)
+	case opOTPFailed:
+		_, err := ms.FailOneTimeCode(e.Phone)
+		return err
+	case opContactUpdated:
+		return ms.SetContact(e.Phone, e.Email, e.Webhook)
+	case opAlertAdded:
+		if e.Alert == nil {
+			return errors.New("missing alert")
+		}
+		return ms.AddAlert(e.Phone, *e.Alert)
+	case opAlertUpdated:
+		if e.Alert == nil {
+			return errors.New("missing alert")
+		}
+		return ms.UpdateAlert(e.Phone, *e.Alert)
+	case opAlertPaused:
+		return ms.SetAlertPaused(e.Phone, e.AlertID, e.Paused)
+	case opAlertState:
+		if e.State == nil {
+			return errors.New("missing alert state")
+		}
+		return ms.SetAlertState(e.Phone, e.AlertID, e.Revision, *e.State)
+	case opAlertTriggered:
+		if e.Note == nil {
+			return errors.New("missing notification")
+		}
+		return ms.TriggerAlert(e.Phone, e.AlertID, e.Revision, *e.Note)
+	case opAlertFired:
+		if e.State == nil || e.Note == nil {
+			return errors.New("missing alert state or notification")
+		}
+		return ms.FireAlert(e.Phone, e.AlertID, e.Revision, *e.State, *e.Note)
+	case opAlertDeleted:
+		return ms.DeleteAlert(e.Phone, e.AlertID)
+	case opAlertRearmed:
+		if e.State == nil {
+			return errors.New("missing alert state")
+		}
+		return ms.RearmAlert(e.Phone, e.AlertID, e.Revision, *e.State)
+	case opNoteAcked:
+		at := e.AckedAt
+		if at.IsZero() {
+			at = e.Expires // entries written before AckedAt existed
+		}
+		return ms.AcknowledgeNotification(e.Phone, e.NoteID, at)
+	case opDelivery:
+		if e.Deliv == nil {
+			return errors.New("missing delivery")
+		}
+		return ms.RecordDelivery(e.Phone, e.NoteID, *e.Deliv)
+	case opOutboxSaved:
+		if e.Item == nil {
+			return errors.New("missing outbox item")
+		}
+		return ms.SaveOutboxItem(*e.Item)
+	case opOutboxDeleted:
+		return ms.DeleteOutboxItem(e.ItemID)
+	case opSessionSaved:
+		if e.Session == nil {
+			return errors.New("missing session")
+		}
+		return ms.SaveSession(*e.Session)
+	case opSessionUpdated:
+		if e.Session == nil {
+			return errors.New("missing session")
+		}
+		return ms.UpdateSession(*e.Session)
+	case opSessionDeleted:
+		return ms.DeleteSession(e.ItemID)
+	case opAPIKeySaved:
+		if e.APIKey == nil {
+			return errors.New("missing API key")
+		}
+		return ms.SaveAPIKey(*e.APIKey)
+	case opAPIKeyDeleted:
+		return ms.DeleteAPIKey(e.ItemID)
+	case opAPIKeyTouched:
+		return ms.TouchAPIKey(e.ItemID, e.UsedAt)
+	case opQuotesSaved:
+		return ms.SaveQuotes(e.AssetType, e.Quotes)
+	}
+	return fmt.Errorf("unknown op %q", e.Op)
+}
+
+// record tries the entry out on a scratch copy of the state it touches, appends
+// it to the journal and only then applies it, so memory never holds a change
+// that isn't durable and a rejected change is never journaled. Must be called
+// with writeMu held.
+func (js *JournalStore) record(e journalEntry) error {
+	if err := applyEntry(js.scratch(e), e); err != nil {
+		return err
+	}
+
+	e.Seq = js.seq + 1
+	e.Time = time.Now()
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+	if _, err := js.file.Write(line); err != nil {
+		js.discardPartial()
+		return fmt.Errorf("append journal: %w", err)
+	}
+	if err := js.file.Sync(); err != nil {
+		js.discardPartial()
+		return fmt.Errorf("sync journal: %w", err)
+	}
+	js.size += int64(len(line))
+	js.seq = e.Seq
+	return applyEntry(js.MemoryStore, e)
+}
+
+// scratch copies the user and the outbox item, session or API key an entry
+// touches into an empty store, where the entry can be tried without side effects.
+// Writers are serialized by writeMu, so the entry does the same to the real store.
+func (js *JournalStore) scratch(e journalEntry) *MemoryStore {
+	scratch := NewMemoryStore(nil)
+	ms := js.MemoryStore
+	ms.Mu.RLock()
+	defer ms.Mu.RUnlock()
+	if user, ok := ms.Users[e.Phone]; ok {
+		scratch.Users[e.Phone] = user.clone()
+	}
+	if item, ok := ms.Outbox[e.ItemID]; ok {
+		c := *item
+		scratch.Outbox[e.ItemID] = &c
+	}
+	if session, ok := ms.Sessions[e.ItemID]; ok {
+		c := *session
+		scratch.Sessions[e.ItemID] = &c
+	}
+	if key, ok := ms.APIKeys[e.ItemID]; ok {
+		c := *key
+		scratch.APIKeys[e.ItemID] = &c
+	}
+	return scratch
+}
+
+// discardPartial cuts off whatever a failed append left behind, so the next
+// entry doesn't land after half a line
+func (js *JournalStore) discardPartial() {
+	if err := js.file.Truncate(js.size); err != nil {
+		log.Printf("[Journal] Failed to discard partial entry: %v", err)
+	}
+}
+
+func (js *JournalStore) GetOrCreateUser(phone string) (*User, error) {
+	if user := js.MemoryStore.GetUser(phone); user != nil {
+		return user, nil
+	}
+
+	js.writeMu.Lock()
+	defer js.writeMu.Unlock()
+	if err := js.record(journalEntry{Op: opUserCreated, Phone: phone}); err != nil {
+		return nil, err
+	}
+	return js.MemoryStore.GetUser(phone), nil
+}
+
+func (js *JournalStore) SetOneTimeCode(phone, codeHash string, expires time.Time) error {
+	js.writeMu.Lock()
+	defer js.writeMu.Unlock()
+	return js.record(journalEntry{Op: opOTPIssued, Phone: phone, Code: codeHash, Expires: expires})
+}
+
+func (js *JournalStore) ClearOneTimeCode(phone string) error {
+	return js.SetOneTimeCode(phone, "", time.Time{})
+}
+
+func (js *JournalStore) FailOneTimeCode(phone string) (int, error) {
+	js.writeMu.Lock()
+	defer js.writeMu.Unlock()
+	if err := js.record(journalEntry{Op: opOTPFailed, Phone: phone}); err != nil {
+		return 0, err
+	}
+	return js.MemoryStore.GetUser(phone).OneTimeCodeFailures, nil
+}
+
+func (js *JournalStore) SetContact(phone, email, webhookURL string) error {
+	js.writeMu.Lock()
+	defer js.writeMu.Unlock()
+	return js.record(journalEntry{Op: opContactUpdated, Phone: phone, Email: email, Webhook: webhookURL})
+}
+
+func (js *JournalStore) AddAlert(phone string, alert Alert) error {
+	js.writeMu.Lock()
+	defer js.writeMu.Unlock()
+	return js.record(journalEntry{Op: opAlertAdded, Phone: phone, Alert: &alert})
+}
+
+func (js *JournalStore) UpdateAlert(phone string, alert Alert) error {
+	js.writeMu.Lock()
+	defer js.writeMu.Unlock()
+	return js.record(journalEntry{Op: opAlertUpdated, Phone: phone, Alert: &alert})
+}
+
+func (js *JournalStore) DeleteAlert(phone, alertID string) error {
+	js.writeMu.Lock()
+	defer js.writeMu.Unlock()
+	return js.record(journalEntry{Op: opAlertDeleted, Phone: phone, AlertID: alertID})
+}
+
+func (js *JournalStore) SetAlertPaused(phone, alertID string, paused bool) error {
+	js.writeMu.Lock()
+	defer js.writeMu.Unlock()
+	return js.record(journalEntry{Op: opAlertPaused, Phone: phone, AlertID: alertID, Paused: paused})
+}
+
+func (js *JournalStore) SetAlertState(phone, alertID string, revision int, state AlertState) error {
+	js.writeMu.Lock()
+	defer js.writeMu.Unlock()
+	return js.record(journalEntry{Op: opAlertState, Phone: phone, AlertID: alertID, Revision: revision, State: &state})
+}
+
+func (js *JournalStore) RearmAlert(phone, alertID string, revision int, state AlertState) error {
+	js.writeMu.Lock()
+	defer js.writeMu.Unlock()
+	return js.record(journalEntry{Op: opAlertRearmed, Phone: phone, AlertID: alertID, Revision: revision, State: &state})
+}
+
+func (js *JournalStore) TriggerAlert(phone, alertID string, revision int, note Notification) error {
+	js.writeMu.Lock()
+	defer js.writeMu.Unlock()
+	return js.record(journalEntry{Op: opAlertTriggered, Phone: phone, AlertID: alertID, Revision: revision, Note: &note})
+}
+
+func (js *JournalStore) FireAlert(phone, alertID string, revision int, state AlertState, note Notification) error {
+	js.writeMu.Lock()
+	defer js.writeMu.Unlock()
+	return js.record(journalEntry{Op: opAlertFired, Phone: phone, AlertID: alertID, Revision: revision, State: &state, Note: &note})
+}
+
+func (js *JournalStore) RecordDelivery(phone, notificationID string, d Delivery) error {
+	js.writeMu.Lock()
+	defer js.writeMu.Unlock()
+	return js.record(journalEntry{Op: opDelivery, Phone: phone, NoteID: notificationID, Deliv: &d})
+}
+
+func (js *JournalStore) AcknowledgeNotification(phone, notificationID string, at time.Time) error {
+	js.writeMu.Lock()
+	defer js.writeMu.Unlock()
+	return js.record(journalEntry{Op: opNoteAcked, Phone: phone, NoteID: notificationID, AckedAt: at})
+}
+
+func (js *JournalStore) SaveOutboxItem(item OutboxItem) error {
+	js.writeMu.Lock()
+	defer js.writeMu.Unlock()
+	return js.record(journalEntry{Op: opOutboxSaved, Item: &item})
+}
+
+func (js *JournalStore) DeleteOutboxItem(id string) error {
+	js.writeMu.Lock()
+	defer js.writeMu.Unlock()
+	return js.record(journalEntry{Op: opOutboxDeleted, ItemID: id})
+}
+
+func (js *JournalStore) SaveSession(session Session) error {
+	js.writeMu.Lock()
+	defer js.writeMu.Unlock()
+	return js.record(journalEntry{Op: opSessionSaved, Phone: session.Phone, Session: &session})
+}
+
+func (js *JournalStore) UpdateSession(session Session) error {
+	js.writeMu.Lock()
+	defer js.writeMu.Unlock()
+	return js.record(journalEntry{Op: opSessionUpdated, Phone: session.Phone, ItemID: session.ID, Session: &session})
+}
+
+func (js *JournalStore) DeleteSession(id string) error {
+	js.writeMu.Lock()
+	defer js.writeMu.Unlock()
+	return js.record(journalEntry{Op: opSessionDeleted, ItemID: id})
+}
+
+func (js *JournalStore) SaveAPIKey(key APIKey) error {
+	js.writeMu.Lock()
+	defer js.writeMu.Unlock()
+	return js.record(journalEntry{Op: opAPIKeySaved, Phone: key.Phone, APIKey: &key})
+}
+
+func (js *JournalStore) DeleteAPIKey(id string) error {
+	js.writeMu.Lock()
+	defer js.writeMu.Unlock()
+	return js.record(journalEntry{Op: opAPIKeyDeleted, ItemID: id})
+}
+
+func (js *JournalStore) TouchAPIKey(id string, at time.Time) error {
+	js.writeMu.Lock()
+	defer js.writeMu.Unlock()
+	return js.record(journalEntry{Op: opAPIKeyTouched, ItemID: id, UsedAt: at})
+}
+
+func (js *JournalStore) SaveQuotes(assetType string, quotes map[string]Quote) error {
+	js.writeMu.Lock()
+	defer js.writeMu.Unlock()
+	return js.record(journalEntry{Op: opQuotesSaved, AssetType: assetType, Quotes: quotes})
+}
+
+// Snapshot writes the full state to snapshot.json and truncates the journal.
+func (js *JournalStore) Snapshot() error {
+	js.writeMu.Lock()
+	defer js.writeMu.Unlock()
+	return js.snapshotLocked()
+}
+
+// snapshotLocked must be called with writeMu held
+func (js *JournalStore) snapshotLocked() error {
+	if js.seq == js.snapSeq {
+		// nothing new since the last snapshot
+		return nil
+	}
+
+	snap := snapshot{
+		LastSeq: js.seq,
+		TakenAt: time.Now(),
+		Users:   make(map[string]*User),
+		Prices:  make(map[string]map[string]Quote),
+	}
+	for _, u := range js.MemoryStore.ListUsers() {
+		snap.Users[u.PhoneNumber] = u
+	}
+	for _, assetType := range []string{"crypto", "metal", "stock"} {
+		snap.Prices[assetType] = js.MemoryStore.Quotes(assetType)
+	}
+	snap.Outbox = js.MemoryStore.ListOutbox()
+	js.MemoryStore.Mu.RLock()
+	for _, session := range js.MemoryStore.Sessions {
+		snap.Sessions = append(snap.Sessions, *session)
+	}
+	for _, key := range js.MemoryStore.APIKeys {
+		snap.APIKeys = append(snap.APIKeys, *key)
+	}
+	js.MemoryStore.Mu.RUnlock()
+
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+
+	// Write to a temp file and rename so a crash never leaves a half-written snapshot
+	tmp := filepath.Join(js.dir, snapshotFile+".tmp")
+	if err := writeFileSync(tmp, data); err != nil {
+		return fmt.Errorf("write snapshot: %w", err)
+	}
+	if err := os.Rename(tmp, filepath.Join(js.dir, snapshotFile)); err != nil {
+		return fmt.Errorf("install snapshot: %w", err)
+	}
+
+	// Everything up to LastSeq is in the snapshot, so the journal can start over.
+	// If we crash before this, replay skips the already-snapshotted entries by Seq.
+	if err := js.file.Truncate(0); err != nil {
+		return fmt.Errorf("truncate journal: %w", err)
+	}
+	js.size = 0
+	js.snapSeq = snap.LastSeq
+	log.Printf("[Journal] Snapshot taken at seq %d", snap.LastSeq)
+	return nil
+}
+
+func (js *JournalStore) snapshotLoop(every time.Duration) {
+	defer close(js.done)
+	if every <= 0 {
+		<-js.stop
+		return
+	}
+
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := js.Snapshot(); err != nil {
+				log.Printf("[Journal] Snapshot failed: %v", err)
+			}
+		case <-js.stop:
+			return
+		}
+	}
+}
+
+// Close takes a final snapshot and closes the journal file
+func (js *JournalStore) Close() error {
+	close(js.stop)
+	<-js.done
+
+	js.writeMu.Lock()
+	defer js.writeMu.Unlock()
+	err := js.snapshotLocked()
+	if cerr := js.file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// writeFileSync writes data to path and fsyncs it before returning
+func writeFileSync(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,206 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func openJournal(t *testing.T, dir string) *JournalStore {
+	t.Helper()
+	js, err := OpenJournalStore(dir, nil, 0)
+	if err != nil {
+		t.Fatalf("OpenJournalStore: %v", err)
+	}
+	return js
+}
+
+// crash stops the store without the snapshot Close takes, as a kill would
+func crash(t *testing.T, js *JournalStore) {
+	t.Helper()
+	close(js.stop)
+	<-js.done
+	if err := js.file.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func journalPath(dir string) string { return filepath.Join(dir, journalFile) }
+
+func TestJournalReplaysAfterCrash(t *testing.T) {
+	dir := t.TempDir()
+	js := openJournal(t, dir)
+	populate(t, js)
+	crash(t, js)
+
+	if _, err := os.Stat(filepath.Join(dir, snapshotFile)); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("snapshot exists before any was taken: %v", err)
+	}
+	js = openJournal(t, dir)
+	defer js.Close()
+	checkPopulated(t, js)
+}
+
+func TestJournalRestoresSnapshotAndLaterEntries(t *testing.T) {
+	dir := t.TempDir()
+	js := openJournal(t, dir)
+	populate(t, js)
+	if err := js.Snapshot(); err != nil {
+		t.Fatalf("Snapshot: %v", err)
+	}
+	if info, err := os.Stat(journalPath(dir)); err != nil || info.Size() != 0 {
+		t.Fatalf("journal after snapshot = %v, %v, want empty", info.Size(), err)
+	}
+
+	// Changes after the snapshot come back from the journal
+	if err := js.SetContact(testPhone, "new@example.com", ""); err != nil {
+		t.Fatal(err)
+	}
+	crash(t, js)
+
+	js = openJournal(t, dir)
+	defer js.Close()
+	if u := js.GetUser(testPhone); u.Email != "new@example.com" || u.WebhookURL != "" {
+		t.Errorf("contact = %q, %q, want the post-snapshot change", u.Email, u.WebhookURL)
+	}
+	if err := js.SetContact(testPhone, "me@example.com", "https://example.com/hook"); err != nil {
+		t.Fatal(err)
+	}
+	checkPopulated(t, js)
+}
+
+func TestJournalSkipsEntriesInSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	js := openJournal(t, dir)
+	populate(t, js)
+	journal, err := os.ReadFile(journalPath(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := js.Snapshot(); err != nil {
+		t.Fatalf("Snapshot: %v", err)
+	}
+	crash(t, js)
+
+	// A crash between installing the snapshot and truncating the journal
+	// leaves entries that are in both; they must not be applied twice
+	if err := os.WriteFile(journalPath(dir), journal, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	js = openJournal(t, dir)
+	defer js.Close()
+	checkPopulated(t, js)
+}
+
+func TestJournalCloseTakesSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	js := openJournal(t, dir)
+	populate(t, js)
+	if err := js.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if err := os.Remove(journalPath(dir)); err != nil {
+		t.Fatal(err)
+	}
+
+	js = openJournal(t, dir)
+	defer js.Close()
+	checkPopulated(t, js)
+}
+
+func TestJournalTruncatesTornTail(t *testing.T) {
+	dir := t.TempDir()
+	js := openJournal(t, dir)
+	populate(t, js)
+	crash(t, js)
+
+	good, err := os.ReadFile(journalPath(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	torn := append(bytes.Clone(good), `{"seq":999,"op":"contact_updated","phone":"`...)
+	if err := os.WriteFile(journalPath(dir), torn, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	js = openJournal(t, dir)
+	checkPopulated(t, js)
+	if info, err := os.Stat(journalPath(dir)); err != nil || info.Size() != int64(len(good)) {
+		t.Fatalf("journal size after open = %d, %v, want the torn entry cut to %d", info.Size(), err, len(good))
+	}
+
+	// The next entry starts on a fresh line and survives another crash
+	if err := js.SetContact(testPhone, "after@example.com", ""); err != nil {
+		t.Fatal(err)
+	}
+	crash(t, js)
+	js = openJournal(t, dir)
+	defer js.Close()
+	if u := js.GetUser(testPhone); u.Email != "after@example.com" {
+		t.Errorf("email = %q, want the entry written after the torn tail", u.Email)
+	}
+}
+
+func TestJournalRejectsCorruptEntry(t *testing.T) {
+	dir := t.TempDir()
+	js := openJournal(t, dir)
+	populate(t, js)
+	crash(t, js)
+
+	data, err := os.ReadFile(journalPath(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := bytes.SplitAfter(data, []byte("\n"))
+	lines[2] = []byte("{not json\n")
+	if err := os.WriteFile(journalPath(dir), bytes.Join(lines, nil), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = OpenJournalStore(dir, nil, 0)
+	if err == nil || !strings.Contains(err.Error(), "corrupt journal entry") {
+		t.Fatalf("open with a corrupt entry = %v, want a corrupt journal error", err)
+	}
+	// The journal is left alone for someone to inspect
+	if after, _ := os.ReadFile(journalPath(dir)); !bytes.Equal(after, bytes.Join(lines, nil)) {
+		t.Error("open changed the corrupt journal")
+	}
+}
+
+func TestJournalRejectedWriteNotJournaled(t *testing.T) {
+	dir := t.TempDir()
+	js := openJournal(t, dir)
+	populate(t, js)
+	if err := js.SetAlertState(testPhone, "a2", 0, AlertState{LastSide: SideBelow}); err != nil {
+		t.Fatal(err)
+	}
+	if err := js.UpdateAlert(testPhone, Alert{ID: "a2", AssetType: "stock", Symbol: "AAPL", Threshold: 250, Above: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A check that read revision 0 loses to the edit, and isn't journaled
+	before, err := os.Stat(journalPath(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := js.SetAlertState(testPhone, "a2", 0, AlertState{LastSide: SideAbove}); !errors.Is(err, ErrAlertChanged) {
+		t.Fatalf("stale SetAlertState = %v, want ErrAlertChanged", err)
+	}
+	if err := js.AcknowledgeNotification(testPhone, "missing", testTime); !errors.Is(err, ErrNotificationNotFound) {
+		t.Fatalf("acknowledging a missing notification = %v, want ErrNotificationNotFound", err)
+	}
+	if after, err := os.Stat(journalPath(dir)); err != nil || after.Size() != before.Size() {
+		t.Fatalf("journal grew from %d to %d bytes for rejected changes", before.Size(), after.Size())
+	}
+	crash(t, js)
+
+	js = openJournal(t, dir)
+	defer js.Close()
+	a2 := js.GetUser(testPhone).ActiveAlerts[0]
+	if a2.Revision != 1 || a2.Threshold != 250 || a2.LastSide != "" {
+		t.Errorf("a2 after replay = %+v, want the edit at revision 1 with no check state", a2)
+	}
+}
@@ -1,14 +1,115 @@
 package storage
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"sort"
 	"sync"
 	"time"
 )
 
+var (
+	// ErrUserNotFound is returned when a mutation targets a phone with no user record.
+	ErrUserNotFound = errors.New("user not found")
+	// ErrAlertNotFound is returned when a mutation targets an alert the user doesn't have.
+	ErrAlertNotFound = errors.New("alert not found")
+	// ErrNotificationNotFound is returned when a mutation targets a notification the user doesn't have.
+	ErrNotificationNotFound = errors.New("notification not found")
+	// ErrAlertChanged is returned when a mutation names an alert revision the user has since changed.
+	ErrAlertChanged = errors.New("alert changed")
+	// ErrSessionNotFound is returned when a mutation targets a session that has been deleted.
+	ErrSessionNotFound = errors.New("session not found")
+	// ErrAPIKeyNotFound is returned when a mutation targets an API key that has been deleted.
+	ErrAPIKeyNotFound = errors.New("API key not found")
+)
+
+// Store is the persistence boundary for users, alerts, notifications and prices.
+// Users returned from a Store are snapshots: change them through the Store methods,
+// never by mutating the returned value.
+type Store interface {
+	// Users
+	GetOrCreateUser(phone string) (*User, error)
+	GetUser(phone string) *User
+	ListUsers() []*User
+	SetOneTimeCode(phone, codeHash string, expires time.Time) error
+	ClearOneTimeCode(phone string) error
+	FailOneTimeCode(phone string) (int, error)
+	SetContact(phone, email, webhookURL string) error
+
+	// Alerts + notifications
+	AddAlert(phone string, alert Alert) error
+	UpdateAlert(phone string, alert Alert) error
+	SetAlertPaused(phone, alertID string, paused bool) error
+	SetAlertState(phone, alertID string, revision int, state AlertState) error
+	DeleteAlert(phone, alertID string) error
+	RearmAlert(phone, alertID string, revision int, state AlertState) error
+	TriggerAlert(phone, alertID string, revision int, note Notification) error
+	FireAlert(phone, alertID string, revision int, state AlertState, note Notification) error
+	RecordDelivery(phone, notificationID string, d Delivery) error
+	AcknowledgeNotification(phone, notificationID string, at time.Time) error
+
+	// Notification outbox
+	SaveOutboxItem(item OutboxItem) error
+	DeleteOutboxItem(id string) error
+	GetOutboxItem(id string) (OutboxItem, bool)
+	DueOutboxItems(now time.Time, limit int) []OutboxItem
+	ListOutbox() []OutboxItem
+
+	// Login sessions
+	SaveSession(session Session) error
+	UpdateSession(session Session) error
+	DeleteSession(id string) error
+	GetSession(id string) (Session, bool)
+	ListSessions(phone string) []Session
+
+	// API keys
+	SaveAPIKey(key APIKey) error
+	DeleteAPIKey(id string) error
+	TouchAPIKey(id string, at time.Time) error
+	GetAPIKey(id string) (APIKey, bool)
+	ListAPIKeys(phone string) []APIKey
+
+	// Prices
+	Prices(assetType string) map[string]float64
+	Quotes(assetType string) map[string]Quote
+	SaveQuotes(assetType string, quotes map[string]Quote) error
+	IsSupportedCoin(id string) bool
+
+	Close() error
+}
+
+// Quote is a stored price along with when and where it was fetched.
+type Quote struct {
+	Price     float64   `json:"price"`
+	FetchedAt time.Time `json:"fetchedAt"`
+	Source    string    `json:"source"` // price provider name
+}
+
+// UnmarshalJSON also accepts a bare number, the format prices were stored in
+// before they carried timestamps. Such quotes have a zero FetchedAt.
+func (q *Quote) UnmarshalJSON(data []byte) error {
+	var price float64
+	if err := json.Unmarshal(data, &price); err == nil {
+		*q = Quote{Price: price}
+		return nil
+	}
+	type plain Quote
+	return json.Unmarshal(data, (*plain)(q))
+}
+
 type User struct {
-	PhoneNumber        string
-	OneTimeCode        string
-	OneTimeCodeExpires time.Time
+	PhoneNumber string
+
+	// Pending login code, stored hashed, and how many wrong guesses it has had
+	OneTimeCodeHash     string
+	OneTimeCodeExpires  time.Time
+	OneTimeCodeFailures int
+
+	// Where the email and webhook notification channels deliver to
+	Email      string
+	WebhookURL string
 
 	// Active and triggered alerts
 	ActiveAlerts         []Alert
@@ -19,68 +120,805 @@ type User struct {
 	CountNotifications   int
 }
 
+// Alert kinds
+const (
+	KindThreshold  = "threshold"  // price crosses a fixed level
+	KindPercent    = "percent"    // price moves by a percentage
+	KindCompound   = "compound"   // a Condition tree over several prices
+	KindExpression = "expression" // a rule written in the rules expression language
+)
+
+// Sides of a threshold a price can be on; see Alert.LastSide
+const (
+	SideAbove = "above"
+	SideBelow = "below"
+)
+
 type Alert struct {
 	ID        string
-	AssetType string // "crypto", "metal", "stock"
+	Kind      string // KindThreshold (also when empty), KindPercent, KindCompound or KindExpression
+	AssetType string // "crypto", "metal", "stock"; empty for compound and expression alerts
 	Symbol    string
+	CreatedAt time.Time
+
+	// Threshold alerts
 	Threshold float64
 	Above     bool // true = alert if price > threshold, false = alert if price < threshold
+
+	// Threshold alerts fire when the price crosses to the alerting side: LastSide
+	// is where it was last seen (SideAbove/SideBelow, empty until first seen).
+	// FireImmediately also fires if the price is already past the threshold
+	// when it is first seen.
+	LastSide        string
+	FireImmediately bool
+
+	// Weight unit the alert's prices are in for metals: "toz", "g" or "kg" (empty means "toz")
+	Unit string
+
+	// Percent alerts: fire when the price moves ChangePct percent in the Move direction,
+	// either within the trailing Window, or since BasePrice was recorded when Window is 0.
+	ChangePct float64
+	Move      string // "up", "down" or "any"
+	Window    time.Duration
+	BasePrice float64
+
+	// Compound alerts fire when Condition becomes true: LastState is StateMet or
+	// StateUnmet as of the last check (empty until first checked). As with
+	// threshold alerts, FireImmediately also fires if it holds when first checked.
+	Condition *Condition
+	LastState string
+
+	// Expression alerts work like compound alerts, with the condition written as
+	// a rule (see package rules). Bindings maps each asset name in the rule to
+	// the asset it was resolved to when the alert was saved.
+	Expression string
+	Bindings   map[string]AssetRef
+
+	// Notification channels to fan out to when triggered ("sse", "sms", "email", "webhook").
+	// Empty means the default channels.
+	Channels []string
+
+	// Paused alerts stay in ActiveAlerts (and count as active) but aren't evaluated
+	Paused bool
+
+	// Recurring threshold alerts stay active after firing. They fire again once
+	// the price has moved back past the threshold by HysteresisPct percent and
+	// then crosses it again, but never sooner than Cooldown after LastFiredAt.
+	Recurring     bool
+	Cooldown      time.Duration
+	HysteresisPct float64
+	LastFiredAt   time.Time
+	AwaitingReset bool // fired, and the price hasn't moved back past the band yet
+
+	// Revision counts the user's changes to the alert: edits, pausing and
+	// re-arming. Alert checks name the revision they read, and their writes are
+	// dropped with ErrAlertChanged if the user has changed the alert since.
+	Revision int
+}
+
+// AlertState is what alert checks record on an alert between runs.
+type AlertState struct {
+	LastSide      string
+	LastState     string
+	BasePrice     float64
+	LastFiredAt   time.Time
+	AwaitingReset bool
+}
+
+// State returns the alert's check state.
+func (a Alert) State() AlertState {
+	return AlertState{
+		LastSide:      a.LastSide,
+		LastState:     a.LastState,
+		BasePrice:     a.BasePrice,
+		LastFiredAt:   a.LastFiredAt,
+		AwaitingReset: a.AwaitingReset,
+	}
+}
+
+// SetState replaces the alert's check state.
+func (a *Alert) SetState(state AlertState) {
+	a.LastSide = state.LastSide
+	a.LastState = state.LastState
+	a.BasePrice = state.BasePrice
+	a.LastFiredAt = state.LastFiredAt
+	a.AwaitingReset = state.AwaitingReset
+}
+
+// Assets returns the prices an alert depends on: its own asset, or every
+// asset named in a compound alert's condition or an expression alert's rule.
+func (a Alert) Assets() []AssetRef {
+	var refs []AssetRef
+	switch {
+	case a.Kind == KindCompound && a.Condition != nil:
+		for _, leaf := range a.Condition.Leaves() {
+			refs = append(refs, AssetRef{AssetType: leaf.AssetType, Symbol: leaf.Symbol})
+		}
+	case a.Kind == KindExpression:
+		names := make([]string, 0, len(a.Bindings))
+		for name := range a.Bindings {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			refs = append(refs, a.Bindings[name])
+		}
+	default:
+		return []AssetRef{{AssetType: a.AssetType, Symbol: a.Symbol}}
+	}
+
+	// Each asset once, in order of first mention
+	out := refs[:0]
+	seen := make(map[AssetRef]bool)
+	for _, ref := range refs {
+		if !seen[ref] {
+			seen[ref] = true
+			out = append(out, ref)
+		}
+	}
+	return out
 }
 
 type Notification struct {
+	ID        string
 	AlertID   string
 	Timestamp time.Time
 	Message   string
+
+	// Delivery status for each channel the notification fans out to
+	Deliveries []Delivery
+
+	// When the user marked the notification as seen; zero if they haven't
+	AcknowledgedAt time.Time
 }
 
+// Delivery statuses
+const (
+	DeliveryPending  = "pending"  // queued, not yet attempted
+	DeliveryRetrying = "retrying" // failed at least once, will be retried
+	DeliverySent     = "sent"
+	DeliveryDead     = "dead" // gave up; waiting for an admin to requeue
+)
+
+// Delivery is the latest status of a notification on one channel.
+type Delivery struct {
+	Channel  string // "sse", "sms", "email", "webhook"
+	Status   string // one of the Delivery* statuses
+	Attempts int
+	Error    string // last failure, if any
+	At       time.Time
+}
+
+// OutboxItem is a pending delivery of one notification over one channel.
+// Items stay in the outbox until they are sent; dead items stay until requeued.
+type OutboxItem struct {
+	ID             string
+	Phone          string
+	NotificationID string
+	Channel        string
+	Status         string // DeliveryPending, DeliveryRetrying or DeliveryDead
+	Attempts       int
+	LastError      string
+	NextAttempt    time.Time
+	LeasedUntil    time.Time // set while a worker is attempting the item
+	CreatedAt      time.Time
+}
+
+// Session is one signed-in device. Access tokens carry the session ID as their
+// jti, so deleting the session revokes every token issued for it.
+type Session struct {
+	ID    string
+	Phone string
+
+	// Refresh tokens are stored as SHA-256 hashes. The previous hash is kept
+	// briefly so requests racing a rotation aren't mistaken for token theft.
+	RefreshHash     string
+	PrevRefreshHash string
+	RotatedAt       time.Time
+
+	CreatedAt  time.Time
+	LastSeenAt time.Time // last sign-in or refresh
+	ExpiresAt  time.Time // the session ends unless refreshed before this
+	UserAgent  string
+	IP         string
+}
+
+// API key scopes
+const (
+	ScopeRead  = "read"  // GET requests only
+	ScopeWrite = "write" // everything a signed-in user can do through the API
+)
+
+// APIKey lets a script call the JSON API as its owner. Only a hash of the
+// secret part is stored; the full key is shown once, when it is created.
+type APIKey struct {
+	ID         string
+	Phone      string
+	Name       string
+	Scope      string // ScopeRead or ScopeWrite
+	Hash       string // SHA-256 of the secret
+	CreatedAt  time.Time
+	LastUsedAt time.Time
+}
+
+// clone returns a copy of the user that shares no slices with the original.
+func (u *User) clone() *User {
+	c := *u
+	c.ActiveAlerts = append([]Alert(nil), u.ActiveAlerts...)
+	c.TriggeredAlerts = append([]Alert(nil), u.TriggeredAlerts...)
+	for _, alerts := range [][]Alert{c.ActiveAlerts, c.TriggeredAlerts} {
+		for i := range alerts {
+			if alerts[i].Condition != nil {
+				alerts[i].Condition = alerts[i].Condition.clone()
+			}
+			if alerts[i].Bindings != nil {
+				alerts[i].Bindings = maps.Clone(alerts[i].Bindings)
+			}
+		}
+	}
+	c.Notifications = append([]Notification(nil), u.Notifications...)
+	for i := range c.Notifications {
+		c.Notifications[i].Deliveries = append([]Delivery(nil), c.Notifications[i].Deliveries...)
+	}
+	return &c
+}
+
+// MemoryStore keeps everything in process memory. It is the default Store and
+// the in-memory cache behind the persistent implementations.
 type MemoryStore struct {
 	Mu    sync.RWMutex
 	Users map[string]*User
 
 	// Price data (updated every 10 min)
-	Crypto         map[string]float64
-	Metals         map[string]float64
-	Stocks         map[string]float64
+	Crypto         map[string]Quote
+	Metals         map[string]Quote
+	Stocks         map[string]Quote
 	SupportedCoins map[string]bool
+
+	// Notification outbox, keyed by item ID
+	Outbox map[string]*OutboxItem
+
+	// Login sessions, keyed by session ID
+	Sessions map[string]*Session
+
+	// API keys, keyed by key ID
+	APIKeys map[string]*APIKey
 }
 
 func NewMemoryStore(sc map[string]bool) *MemoryStore {
 	return &MemoryStore{
 		Users:          make(map[string]*User),
-		Crypto:         make(map[string]float64),
-		Metals:         make(map[string]float64),
-		Stocks:         make(map[string]float64),
+		Crypto:         make(map[string]Quote),
+		Metals:         make(map[string]Quote),
+		Stocks:         make(map[string]Quote),
 		SupportedCoins: sc,
+		Outbox:         make(map[string]*OutboxItem),
+		Sessions:       make(map[string]*Session),
+		APIKeys:        make(map[string]*APIKey),
 	}
 }
 
-func (ms *MemoryStore) GetOrCreateUser(phone string) *User {
+func (ms *MemoryStore) GetOrCreateUser(phone string) (*User, error) {
 	ms.Mu.Lock()
 	defer ms.Mu.Unlock()
 	if user, ok := ms.Users[phone]; ok {
-		return user
+		return user.clone(), nil
 	}
 	newUser := &User{
 		PhoneNumber: phone,
 	}
 	ms.Users[phone] = newUser
-	return newUser
+	return newUser.clone(), nil
 }
 
-// GetUser Thread-safe retrieval
+// GetUser Thread-safe retrieval; returns nil if the user does not exist
 func (ms *MemoryStore) GetUser(phone string) *User {
 	ms.Mu.RLock()
 	defer ms.Mu.RUnlock()
-	return ms.Users[phone]
+	if user, ok := ms.Users[phone]; ok {
+		return user.clone()
+	}
+	return nil
 }
 
-// ListUsers Example: A function that lists all users (just to show RLock usage)
+// ListUsers returns a snapshot of every user
 func (ms *MemoryStore) ListUsers() []*User {
 	ms.Mu.RLock()
 	defer ms.Mu.RUnlock()
 	var result []*User
 	for _, u := range ms.Users {
-		result = append(result, u)
+		result = append(result, u.clone())
 	}
 	return result
 }
+
+// SetOneTimeCode stores the hash of a new login code for the user and resets its failure count
+func (ms *MemoryStore) SetOneTimeCode(phone, codeHash string, expires time.Time) error {
+	ms.Mu.Lock()
+	defer ms.Mu.Unlock()
+	user, ok := ms.Users[phone]
+	if !ok {
+		return ErrUserNotFound
+	}
+	user.OneTimeCodeHash = codeHash
+	user.OneTimeCodeExpires = expires
+	user.OneTimeCodeFailures = 0
+	return nil
+}
+
+// ClearOneTimeCode removes the user's login code once it has been used
+func (ms *MemoryStore) ClearOneTimeCode(phone string) error {
+	return ms.SetOneTimeCode(phone, "", time.Time{})
+}
+
+// FailOneTimeCode counts a wrong guess at the user's login code and returns the total so far
+func (ms *MemoryStore) FailOneTimeCode(phone string) (int, error) {
+	ms.Mu.Lock()
+	defer ms.Mu.Unlock()
+	user, ok := ms.Users[phone]
+	if !ok {
+		return 0, ErrUserNotFound
+	}
+	user.OneTimeCodeFailures++
+	return user.OneTimeCodeFailures, nil
+}
+
+// SetContact saves the user's email address and webhook URL
+func (ms *MemoryStore) SetContact(phone, email, webhookURL string) error {
+	ms.Mu.Lock()
+	defer ms.Mu.Unlock()
+	user, ok := ms.Users[phone]
+	if !ok {
+		return ErrUserNotFound
+	}
+	user.Email = email
+	user.WebhookURL = webhookURL
+	return nil
+}
+
+// AddAlert appends a new active alert for the user
+func (ms *MemoryStore) AddAlert(phone string, alert Alert) error {
+	ms.Mu.Lock()
+	defer ms.Mu.Unlock()
+	user, ok := ms.Users[phone]
+	if !ok {
+		return ErrUserNotFound
+	}
+	user.ActiveAlerts = append(user.ActiveAlerts, alert)
+	user.CountActiveAlerts++
+	return nil
+}
+
+// UpdateAlert replaces an active alert with the same ID, as the next revision
+func (ms *MemoryStore) UpdateAlert(phone string, alert Alert) error {
+	ms.Mu.Lock()
+	defer ms.Mu.Unlock()
+	user, ok := ms.Users[phone]
+	if !ok {
+		return ErrUserNotFound
+	}
+	for i := range user.ActiveAlerts {
+		if user.ActiveAlerts[i].ID == alert.ID {
+			alert.Revision = user.ActiveAlerts[i].Revision + 1
+			user.ActiveAlerts[i] = alert
+			return nil
+		}
+	}
+	return ErrAlertNotFound
+}
+
+// SetAlertPaused pauses or resumes an active alert
+func (ms *MemoryStore) SetAlertPaused(phone, alertID string, paused bool) error {
+	ms.Mu.Lock()
+	defer ms.Mu.Unlock()
+	alert, err := ms.activeAlert(phone, alertID)
+	if err != nil {
+		return err
+	}
+	alert.Paused = paused
+	alert.Revision++
+	return nil
+}
+
+// SetAlertState records the check state of an active alert. It returns
+// ErrAlertChanged if the alert is no longer at the given revision.
+func (ms *MemoryStore) SetAlertState(phone, alertID string, revision int, state AlertState) error {
+	ms.Mu.Lock()
+	defer ms.Mu.Unlock()
+	alert, err := ms.activeAlert(phone, alertID)
+	if err != nil {
+		return err
+	}
+	if alert.Revision != revision {
+		return ErrAlertChanged
+	}
+	alert.SetState(state)
+	return nil
+}
+
+// activeAlert finds one of the user's active alerts. Must be called with Mu held.
+func (ms *MemoryStore) activeAlert(phone, alertID string) (*Alert, error) {
+	user, ok := ms.Users[phone]
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+	for i := range user.ActiveAlerts {
+		if user.ActiveAlerts[i].ID == alertID {
+			return &user.ActiveAlerts[i], nil
+		}
+	}
+	return nil, ErrAlertNotFound
+}
+
+// DeleteAlert removes an active or triggered alert. Its notifications are kept.
+func (ms *MemoryStore) DeleteAlert(phone, alertID string) error {
+	ms.Mu.Lock()
+	defer ms.Mu.Unlock()
+	user, ok := ms.Users[phone]
+	if !ok {
+		return ErrUserNotFound
+	}
+	for i, a := range user.ActiveAlerts {
+		if a.ID == alertID {
+			user.ActiveAlerts = append(user.ActiveAlerts[:i:i], user.ActiveAlerts[i+1:]...)
+			user.CountActiveAlerts--
+			return nil
+		}
+	}
+	for i, a := range user.TriggeredAlerts {
+		if a.ID == alertID {
+			user.TriggeredAlerts = append(user.TriggeredAlerts[:i:i], user.TriggeredAlerts[i+1:]...)
+			user.CountTriggeredAlerts--
+			return nil
+		}
+	}
+	return ErrAlertNotFound
+}
+
+// RearmAlert moves a triggered alert back to ActiveAlerts, unpaused and with
+// the given check state. It returns ErrAlertNotFound if the alert hasn't
+// triggered and ErrAlertChanged if it is no longer at the given revision.
+func (ms *MemoryStore) RearmAlert(phone, alertID string, revision int, state AlertState) error {
+	ms.Mu.Lock()
+	defer ms.Mu.Unlock()
+	user, ok := ms.Users[phone]
+	if !ok {
+		return ErrUserNotFound
+	}
+	for i, a := range user.TriggeredAlerts {
+		if a.ID != alertID {
+			continue
+		}
+		if a.Revision != revision {
+			return ErrAlertChanged
+		}
+		a.Paused = false
+		a.SetState(state)
+		a.Revision++
+		user.TriggeredAlerts = append(user.TriggeredAlerts[:i:i], user.TriggeredAlerts[i+1:]...)
+		user.CountTriggeredAlerts--
+		user.ActiveAlerts = append(user.ActiveAlerts, a)
+		user.CountActiveAlerts++
+		return nil
+	}
+	return ErrAlertNotFound
+}
+
+// TriggerAlert moves an active alert to TriggeredAlerts and records its notification.
+// It returns ErrAlertNotFound if the alert is no longer active and
+// ErrAlertChanged if it is no longer at the given revision.
+func (ms *MemoryStore) TriggerAlert(phone, alertID string, revision int, note Notification) error {
+	ms.Mu.Lock()
+	defer ms.Mu.Unlock()
+	user, ok := ms.Users[phone]
+	if !ok {
+		return ErrUserNotFound
+	}
+
+	for i, a := range user.ActiveAlerts {
+		if a.ID != alertID {
+			continue
+		}
+		if a.Revision != revision {
+			return ErrAlertChanged
+		}
+		user.ActiveAlerts = append(user.ActiveAlerts[:i:i], user.ActiveAlerts[i+1:]...)
+		user.CountActiveAlerts--
+		user.TriggeredAlerts = append(user.TriggeredAlerts, a)
+		user.CountTriggeredAlerts++
+		user.Notifications = append(user.Notifications, note)
+		user.CountNotifications++
+		return nil
+	}
+	return ErrAlertNotFound
+}
+
+// FireAlert records a notification for a recurring alert, which stays active
+// with the given check state. It returns ErrAlertNotFound if the alert is no
+// longer active and ErrAlertChanged if it is no longer at the given revision.
+func (ms *MemoryStore) FireAlert(phone, alertID string, revision int, state AlertState, note Notification) error {
+	ms.Mu.Lock()
+	defer ms.Mu.Unlock()
+	alert, err := ms.activeAlert(phone, alertID)
+	if err != nil {
+		return err
+	}
+	if alert.Revision != revision {
+		return ErrAlertChanged
+	}
+	alert.SetState(state)
+	user := ms.Users[phone]
+	user.Notifications = append(user.Notifications, note)
+	user.CountNotifications++
+	return nil
+}
+
+// RecordDelivery sets the delivery status of one of the user's notifications
+// on d.Channel, replacing any earlier status for that channel.
+func (ms *MemoryStore) RecordDelivery(phone, notificationID string, d Delivery) error {
+	ms.Mu.Lock()
+	defer ms.Mu.Unlock()
+	user, ok := ms.Users[phone]
+	if !ok {
+		return ErrUserNotFound
+	}
+	for i := range user.Notifications {
+		note := &user.Notifications[i]
+		if note.ID != notificationID {
+			continue
+		}
+		for j := range note.Deliveries {
+			if note.Deliveries[j].Channel == d.Channel {
+				note.Deliveries[j] = d
+				return nil
+			}
+		}
+		note.Deliveries = append(note.Deliveries, d)
+		return nil
+	}
+	return ErrNotificationNotFound
+}
+
+// AcknowledgeNotification marks one of the user's notifications as seen at the given time
+func (ms *MemoryStore) AcknowledgeNotification(phone, notificationID string, at time.Time) error {
+	ms.Mu.Lock()
+	defer ms.Mu.Unlock()
+	user, ok := ms.Users[phone]
+	if !ok {
+		return ErrUserNotFound
+	}
+	for i := range user.Notifications {
+		if user.Notifications[i].ID == notificationID {
+			user.Notifications[i].AcknowledgedAt = at
+			return nil
+		}
+	}
+	return ErrNotificationNotFound
+}
+
+// SaveOutboxItem inserts or replaces an outbox item
+func (ms *MemoryStore) SaveOutboxItem(item OutboxItem) error {
+	ms.Mu.Lock()
+	defer ms.Mu.Unlock()
+	ms.Outbox[item.ID] = &item
+	return nil
+}
+
+// DeleteOutboxItem removes an item once it has been delivered
+func (ms *MemoryStore) DeleteOutboxItem(id string) error {
+	ms.Mu.Lock()
+	defer ms.Mu.Unlock()
+	delete(ms.Outbox, id)
+	return nil
+}
+
+// GetOutboxItem returns a copy of one outbox item
+func (ms *MemoryStore) GetOutboxItem(id string) (OutboxItem, bool) {
+	ms.Mu.RLock()
+	defer ms.Mu.RUnlock()
+	item, ok := ms.Outbox[id]
+	if !ok {
+		return OutboxItem{}, false
+	}
+	return *item, true
+}
+
+// DueOutboxItems returns up to limit live items whose NextAttempt has passed, oldest first
+func (ms *MemoryStore) DueOutboxItems(now time.Time, limit int) []OutboxItem {
+	ms.Mu.RLock()
+	defer ms.Mu.RUnlock()
+	var due []OutboxItem
+	for _, item := range ms.Outbox {
+		if item.Status != DeliveryDead && !item.NextAttempt.After(now) {
+			due = append(due, *item)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool { return due[i].NextAttempt.Before(due[j].NextAttempt) })
+	if limit > 0 && len(due) > limit {
+		due = due[:limit]
+	}
+	return due
+}
+
+// ListOutbox returns every item in the outbox, oldest first
+func (ms *MemoryStore) ListOutbox() []OutboxItem {
+	ms.Mu.RLock()
+	defer ms.Mu.RUnlock()
+	items := make([]OutboxItem, 0, len(ms.Outbox))
+	for _, item := range ms.Outbox {
+		items = append(items, *item)
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].CreatedAt.Before(items[j].CreatedAt) })
+	return items
+}
+
+// SaveSession inserts or replaces a session
+func (ms *MemoryStore) SaveSession(session Session) error {
+	ms.Mu.Lock()
+	defer ms.Mu.Unlock()
+	ms.Sessions[session.ID] = &session
+	return nil
+}
+
+// UpdateSession replaces a session that still exists. It returns
+// ErrSessionNotFound if the session has been deleted, so a refresh racing a
+// sign-out can't bring the session back.
+func (ms *MemoryStore) UpdateSession(session Session) error {
+	ms.Mu.Lock()
+	defer ms.Mu.Unlock()
+	if _, ok := ms.Sessions[session.ID]; !ok {
+		return ErrSessionNotFound
+	}
+	ms.Sessions[session.ID] = &session
+	return nil
+}
+
+// DeleteSession removes a session, revoking its tokens
+func (ms *MemoryStore) DeleteSession(id string) error {
+	ms.Mu.Lock()
+	defer ms.Mu.Unlock()
+	delete(ms.Sessions, id)
+	return nil
+}
+
+// GetSession returns a copy of one session
+func (ms *MemoryStore) GetSession(id string) (Session, bool) {
+	ms.Mu.RLock()
+	defer ms.Mu.RUnlock()
+	session, ok := ms.Sessions[id]
+	if !ok {
+		return Session{}, false
+	}
+	return *session, true
+}
+
+// ListSessions returns the user's sessions, most recently seen first
+func (ms *MemoryStore) ListSessions(phone string) []Session {
+	ms.Mu.RLock()
+	defer ms.Mu.RUnlock()
+	var sessions []Session
+	for _, session := range ms.Sessions {
+		if session.Phone == phone {
+			sessions = append(sessions, *session)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].LastSeenAt.After(sessions[j].LastSeenAt) })
+	return sessions
+}
+
+// SaveAPIKey inserts or replaces an API key
+func (ms *MemoryStore) SaveAPIKey(key APIKey) error {
+	ms.Mu.Lock()
+	defer ms.Mu.Unlock()
+	ms.APIKeys[key.ID] = &key
+	return nil
+}
+
+// DeleteAPIKey removes an API key, revoking it
+func (ms *MemoryStore) DeleteAPIKey(id string) error {
+	ms.Mu.Lock()
+	defer ms.Mu.Unlock()
+	delete(ms.APIKeys, id)
+	return nil
+}
+
+// TouchAPIKey records when a key was last used. It returns ErrAPIKeyNotFound
+// if the key has been deleted, so a request racing a revoke can't restore it.
+func (ms *MemoryStore) TouchAPIKey(id string, at time.Time) error {
+	ms.Mu.Lock()
+	defer ms.Mu.Unlock()
+	key, ok := ms.APIKeys[id]
+	if !ok {
+		return ErrAPIKeyNotFound
+	}
+	key.LastUsedAt = at
+	return nil
+}
+
+// GetAPIKey returns a copy of one API key
+func (ms *MemoryStore) GetAPIKey(id string) (APIKey, bool) {
+	ms.Mu.RLock()
+	defer ms.Mu.RUnlock()
+	key, ok := ms.APIKeys[id]
+	if !ok {
+		return APIKey{}, false
+	}
+	return *key, true
+}
+
+// ListAPIKeys returns the user's API keys, oldest first
+func (ms *MemoryStore) ListAPIKeys(phone string) []APIKey {
+	ms.Mu.RLock()
+	defer ms.Mu.RUnlock()
+	var keys []APIKey
+	for _, key := range ms.APIKeys {
+		if key.Phone == phone {
+			keys = append(keys, *key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].CreatedAt.Before(keys[j].CreatedAt) })
+	return keys
+}
+
+// Prices returns a copy of the latest prices for an asset type ("crypto", "metal", "stock")
+func (ms *MemoryStore) Prices(assetType string) map[string]float64 {
+	ms.Mu.RLock()
+	defer ms.Mu.RUnlock()
+	out := make(map[string]float64)
+	for k, q := range ms.priceMap(assetType) {
+		out[k] = q.Price
+	}
+	return out
+}
+
+// Quotes returns a copy of the latest prices for an asset type with their fetch times
+func (ms *MemoryStore) Quotes(assetType string) map[string]Quote {
+	ms.Mu.RLock()
+	defer ms.Mu.RUnlock()
+	out := make(map[string]Quote)
+	for k, q := range ms.priceMap(assetType) {
+		out[k] = q
+	}
+	return out
+}
+
+// SaveQuotes stores fresh quotes for an asset type. Symbols not in quotes keep
+// their previous quote, and its age, so a price a provider stopped returning
+// shows up as stale rather than disappearing.
+func (ms *MemoryStore) SaveQuotes(assetType string, quotes map[string]Quote) error {
+	ms.Mu.Lock()
+	defer ms.Mu.Unlock()
+	m := ms.priceMap(assetType)
+	if m == nil {
+		return fmt.Errorf("unknown asset type %q", assetType)
+	}
+	for symbol, q := range quotes {
+		m[symbol] = q
+	}
+	return nil
+}
+
+// IsSupportedCoin reports whether the coin ID is listed in coins.json
+func (ms *MemoryStore) IsSupportedCoin(id string) bool {
+	ms.Mu.RLock()
+	defer ms.Mu.RUnlock()
+	return ms.SupportedCoins[id]
+}
+
+// Close is a no-op for the in-memory store
+func (ms *MemoryStore) Close() error {
+	return nil
+}
+
+// priceMap must be called with Mu held
+func (ms *MemoryStore) priceMap(assetType string) map[string]Quote {
+	switch assetType {
+	case "crypto":
+		return ms.Crypto
+	case "metal":
+		return ms.Metals
+	case "stock":
+		return ms.Stocks
+	}
+	return nil
+}
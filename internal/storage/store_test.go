@@ -0,0 +1,130 @@
+package storage
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+const testPhone = "+15551234567"
+
+var testTime = time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+// populate makes one of every kind of change through s, failing the test on any error
+func populate(t *testing.T, s Store) {
+	t.Helper()
+	must := func(err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, err := s.GetOrCreateUser(testPhone)
+	must(err)
+	must(s.SetOneTimeCode(testPhone, "code-hash", testTime.Add(5*time.Minute)))
+	_, err = s.FailOneTimeCode(testPhone)
+	must(err)
+	must(s.SetContact(testPhone, "me@example.com", "https://example.com/hook"))
+
+	must(s.AddAlert(testPhone, Alert{ID: "a1", AssetType: "crypto", Symbol: "bitcoin", Threshold: 50000, Above: true, CreatedAt: testTime}))
+	must(s.AddAlert(testPhone, Alert{ID: "a2", AssetType: "stock", Symbol: "AAPL", Threshold: 200, Above: true, Recurring: true, Cooldown: time.Hour}))
+	must(s.AddAlert(testPhone, Alert{ID: "a3", AssetType: "metal", Symbol: "XAU", Threshold: 1000}))
+	must(s.SetAlertPaused(testPhone, "a1", true))
+	must(s.SetAlertPaused(testPhone, "a1", false))
+	must(s.SetAlertState(testPhone, "a1", 2, AlertState{LastSide: SideBelow}))
+	must(s.FireAlert(testPhone, "a2", 0, AlertState{LastSide: SideAbove, LastFiredAt: testTime, AwaitingReset: true},
+		Notification{ID: "n1", AlertID: "a2", Timestamp: testTime, Message: "AAPL above 200"}))
+	must(s.TriggerAlert(testPhone, "a1", 2, Notification{ID: "n2", AlertID: "a1", Timestamp: testTime, Message: "bitcoin above 50000"}))
+	must(s.RecordDelivery(testPhone, "n2", Delivery{Channel: "sms", Status: DeliverySent, Attempts: 1, At: testTime}))
+	must(s.AcknowledgeNotification(testPhone, "n1", testTime.Add(time.Minute)))
+	must(s.DeleteAlert(testPhone, "a3"))
+
+	must(s.SaveQuotes("crypto", map[string]Quote{"bitcoin": {Price: 50100, FetchedAt: testTime, Source: "coingecko"}}))
+	must(s.SaveOutboxItem(OutboxItem{ID: "o1", Phone: testPhone, NotificationID: "n2", Channel: "email", Status: DeliveryPending}))
+	must(s.SaveOutboxItem(OutboxItem{ID: "o2", Phone: testPhone, NotificationID: "n2", Channel: "sms", Status: DeliveryPending}))
+	must(s.DeleteOutboxItem("o2"))
+	must(s.SaveSession(Session{ID: "s1", Phone: testPhone, RefreshHash: "old-hash", ExpiresAt: testTime.Add(24 * time.Hour)}))
+	must(s.UpdateSession(Session{ID: "s1", Phone: testPhone, RefreshHash: "refresh-hash", PrevRefreshHash: "old-hash", ExpiresAt: testTime.Add(24 * time.Hour)}))
+	must(s.SaveSession(Session{ID: "s2", Phone: testPhone, RefreshHash: "ended-hash"}))
+	must(s.DeleteSession("s2"))
+	if err := s.UpdateSession(Session{ID: "s2", Phone: testPhone, RefreshHash: "rotated-hash"}); !errors.Is(err, ErrSessionNotFound) {
+		t.Fatalf("updating a deleted session = %v, want ErrSessionNotFound", err)
+	}
+	must(s.SaveAPIKey(APIKey{ID: "k1", Phone: testPhone, Name: "script", Scope: ScopeRead, Hash: "key-hash"}))
+	must(s.TouchAPIKey("k1", testTime.Add(2*time.Minute)))
+	must(s.SaveAPIKey(APIKey{ID: "k2", Phone: testPhone, Name: "revoked", Scope: ScopeWrite, Hash: "other-hash"}))
+	must(s.DeleteAPIKey("k2"))
+	if err := s.TouchAPIKey("k2", testTime); !errors.Is(err, ErrAPIKeyNotFound) {
+		t.Fatalf("touching a deleted API key = %v, want ErrAPIKeyNotFound", err)
+	}
+}
+
+// checkPopulated verifies that s holds exactly what populate wrote
+func checkPopulated(t *testing.T, s Store) {
+	t.Helper()
+	u := s.GetUser(testPhone)
+	if u == nil {
+		t.Fatal("user missing")
+	}
+	if u.OneTimeCodeHash != "code-hash" || !u.OneTimeCodeExpires.Equal(testTime.Add(5*time.Minute)) || u.OneTimeCodeFailures != 1 {
+		t.Errorf("login code = %q until %v with %d failures", u.OneTimeCodeHash, u.OneTimeCodeExpires, u.OneTimeCodeFailures)
+	}
+	if u.Email != "me@example.com" || u.WebhookURL != "https://example.com/hook" {
+		t.Errorf("contact = %q, %q", u.Email, u.WebhookURL)
+	}
+
+	if len(u.ActiveAlerts) != 1 || u.CountActiveAlerts != 1 {
+		t.Fatalf("active alerts = %+v (count %d), want a2 only", u.ActiveAlerts, u.CountActiveAlerts)
+	}
+	a2 := u.ActiveAlerts[0]
+	if a2.ID != "a2" || a2.LastSide != SideAbove || !a2.AwaitingReset || !a2.LastFiredAt.Equal(testTime) || a2.Cooldown != time.Hour {
+		t.Errorf("a2 = %+v, want fired and awaiting reset", a2)
+	}
+	if len(u.TriggeredAlerts) != 1 || u.CountTriggeredAlerts != 1 {
+		t.Fatalf("triggered alerts = %+v (count %d), want a1 only", u.TriggeredAlerts, u.CountTriggeredAlerts)
+	}
+	a1 := u.TriggeredAlerts[0]
+	if a1.ID != "a1" || a1.Revision != 2 || a1.Paused || a1.LastSide != SideBelow {
+		t.Errorf("a1 = %+v, want revision 2, unpaused, last seen below", a1)
+	}
+
+	if len(u.Notifications) != 2 || u.CountNotifications != 2 {
+		t.Fatalf("notifications = %+v (count %d), want 2", u.Notifications, u.CountNotifications)
+	}
+	n1, n2 := u.Notifications[0], u.Notifications[1]
+	if n1.ID != "n1" || !n1.AcknowledgedAt.Equal(testTime.Add(time.Minute)) {
+		t.Errorf("n1 = %+v, want acknowledged a minute after firing", n1)
+	}
+	if n2.ID != "n2" || len(n2.Deliveries) != 1 || n2.Deliveries[0].Status != DeliverySent {
+		t.Errorf("n2 = %+v, want one sent sms delivery", n2)
+	}
+
+	if q := s.Quotes("crypto")["bitcoin"]; q.Price != 50100 || !q.FetchedAt.Equal(testTime) || q.Source != "coingecko" {
+		t.Errorf("bitcoin quote = %+v", q)
+	}
+	if _, ok := s.GetOutboxItem("o1"); !ok {
+		t.Error("outbox item o1 missing")
+	}
+	if _, ok := s.GetOutboxItem("o2"); ok {
+		t.Error("deleted outbox item o2 still present")
+	}
+	if session, ok := s.GetSession("s1"); !ok || session.RefreshHash != "refresh-hash" || session.PrevRefreshHash != "old-hash" {
+		t.Errorf("session s1 = %+v, %v", session, ok)
+	}
+	if _, ok := s.GetSession("s2"); ok {
+		t.Error("deleted session s2 came back")
+	}
+	if key, ok := s.GetAPIKey("k1"); !ok || key.Scope != ScopeRead || !key.LastUsedAt.Equal(testTime.Add(2*time.Minute)) {
+		t.Errorf("API key k1 = %+v, %v", key, ok)
+	}
+	if _, ok := s.GetAPIKey("k2"); ok {
+		t.Error("deleted API key k2 came back")
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	ms := NewMemoryStore(nil)
+	populate(t, ms)
+	checkPopulated(t, ms)
+}
@@ -1,41 +1,58 @@
 package twilio
 
 import (
+	"context"
 	"fmt"
-	"os"
 
 	"github.com/twilio/twilio-go"
 	twilioApi "github.com/twilio/twilio-go/rest/api/v2010"
 )
 
-// SendSMS sends an SMS message using Twilio.
-// It expects the following environment variables to be set:
-// - TWILIO_ACCOUNT_SID
-// - TWILIO_AUTH_TOKEN
-// - TWILIO_FROM_NUMBER
-func SendSMS(to string, body string) error {
-	twilioEnabled := os.Getenv("TWILIO_ENABLED")
+// Config is the Twilio account SMS is sent from.
+type Config struct {
+	Enabled    bool
+	AccountSID string
+	AuthToken  string
+	FromNumber string
+}
+
+var cfg Config
+
+// Configure sets the account used by SendSMS.
+func Configure(c Config) {
+	cfg = c
+}
 
-	if twilioEnabled != "true" {
+// Enabled reports whether SMS sending is switched on.
+// When it is off, SendSMS silently does nothing.
+func Enabled() bool {
+	return cfg.Enabled
+}
+
+// SendSMS sends an SMS message using the configured Twilio account.
+//
+// The Twilio client can't be interrupted once a request starts, so ctx is only
+// checked before sending.
+func SendSMS(ctx context.Context, to string, body string) error {
+	if !Enabled() {
 		return nil
 	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 
-	accountSid := os.Getenv("TWILIO_ACCOUNT_SID")
-	authToken := os.Getenv("TWILIO_AUTH_TOKEN")
-	from := os.Getenv("TWILIO_FROM_NUMBER")
-
-	if accountSid == "" || authToken == "" || from == "" {
-		return fmt.Errorf("Twilio environment variables not set (TWILIO_ACCOUNT_SID, TWILIO_AUTH_TOKEN, TWILIO_FROM_NUMBER)")
+	if cfg.AccountSID == "" || cfg.AuthToken == "" || cfg.FromNumber == "" {
+		return fmt.Errorf("Twilio account not configured (account SID, auth token and from number)")
 	}
 
 	client := twilio.NewRestClientWithParams(twilio.ClientParams{
-		Username: accountSid,
-		Password: authToken,
+		Username: cfg.AccountSID,
+		Password: cfg.AuthToken,
 	})
 
 	params := &twilioApi.CreateMessageParams{}
 	params.SetTo(to)
-	params.SetFrom(from)
+	params.SetFrom(cfg.FromNumber)
 	params.SetBody(body)
 
 	_, err := client.Api.CreateMessage(params)
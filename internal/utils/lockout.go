@@ -0,0 +1,104 @@
+package utils
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Lockout counts failures per key (an IP address, say) and locks the key out
+// once too many pile up. Each lockout lasts twice as long as the one before,
+// up to a ceiling; a key that stays quiet for a day starts over.
+type Lockout struct {
+	maxFailures int
+	base        time.Duration
+	max         time.Duration
+	now         func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*lockoutEntry
+}
+
+type lockoutEntry struct {
+	failures    int
+	lockouts    int
+	lockedUntil time.Time
+	lastFailure time.Time
+}
+
+// lockoutMemory is how long a key's history is kept after its last failure
+const lockoutMemory = 24 * time.Hour
+
+// NewLockout locks a key for base after maxFailures failures, doubling on
+// each further lockout up to max.
+func NewLockout(maxFailures int, base, max time.Duration) *Lockout {
+	return &Lockout{
+		maxFailures: maxFailures,
+		base:        base,
+		max:         max,
+		now:         time.Now,
+		entries:     make(map[string]*lockoutEntry),
+	}
+}
+
+// Locked reports whether key is locked out and until when.
+func (l *Lockout) Locked(key string) (time.Time, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	e, ok := l.entries[key]
+	if !ok || !l.now().Before(e.lockedUntil) {
+		return time.Time{}, false
+	}
+	return e.lockedUntil, true
+}
+
+// Fail records a failure for key and returns when its lockout ends, or the
+// zero time if this failure didn't lock it.
+func (l *Lockout) Fail(key string) time.Time {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	l.prune(now)
+
+	e, ok := l.entries[key]
+	if !ok {
+		e = &lockoutEntry{}
+		l.entries[key] = e
+	}
+	e.failures++
+	e.lastFailure = now
+	if e.failures < l.maxFailures {
+		return time.Time{}
+	}
+
+	d := l.base << e.lockouts
+	if d > l.max || d <= 0 {
+		d = l.max
+	}
+	e.lockouts++
+	e.failures = 0
+	e.lockedUntil = now.Add(d)
+	return e.lockedUntil
+}
+
+// prune forgets keys with no recent failures; must be called with mu held
+func (l *Lockout) prune(now time.Time) {
+	for key, e := range l.entries {
+		if now.Sub(e.lastFailure) > lockoutMemory && !now.Before(e.lockedUntil) {
+			delete(l.entries, key)
+		}
+	}
+}
+
+// ClientIP returns the request's remote address without its port. Forwarding
+// headers like X-Forwarded-For are ignored, since any client can set them; so
+// behind a reverse proxy this is the proxy's address, and per-IP limits keyed
+// on it apply to all clients together.
+func ClientIP(r *http.Request) string {
+	host := r.RemoteAddr
+	if i := strings.LastIndex(host, ":"); i > 0 {
+		host = host[:i]
+	}
+	return strings.Trim(host, "[]")
+}
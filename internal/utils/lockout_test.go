@@ -0,0 +1,101 @@
+package utils
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+var testTime = time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+// newTestLockout locks after 3 failures for a minute, doubling up to 5 minutes,
+// on a clock the test moves with the returned function
+func newTestLockout() (*Lockout, func(time.Duration)) {
+	l := NewLockout(3, time.Minute, 5*time.Minute)
+	now := testTime
+	l.now = func() time.Time { return now }
+	return l, func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestLockoutLocksAfterMaxFailures(t *testing.T) {
+	l, advance := newTestLockout()
+	for i := 1; i < 3; i++ {
+		if until := l.Fail("1.2.3.4"); !until.IsZero() {
+			t.Fatalf("failure %d locked until %v", i, until)
+		}
+	}
+	if _, locked := l.Locked("1.2.3.4"); locked {
+		t.Fatal("locked before the third failure")
+	}
+
+	until := l.Fail("1.2.3.4")
+	if !until.Equal(testTime.Add(time.Minute)) {
+		t.Fatalf("third failure locked until %v, want a minute", until)
+	}
+	if got, locked := l.Locked("1.2.3.4"); !locked || !got.Equal(until) {
+		t.Errorf("Locked = %v, %v, want until %v", got, locked, until)
+	}
+	if _, locked := l.Locked("5.6.7.8"); locked {
+		t.Error("another key was locked too")
+	}
+
+	advance(time.Minute)
+	if _, locked := l.Locked("1.2.3.4"); locked {
+		t.Error("still locked after the lockout ended")
+	}
+}
+
+func TestLockoutDoublesUpToMax(t *testing.T) {
+	l, advance := newTestLockout()
+	for _, want := range []time.Duration{time.Minute, 2 * time.Minute, 4 * time.Minute, 5 * time.Minute, 5 * time.Minute} {
+		var until time.Time
+		for i := 0; i < 3; i++ {
+			until = l.Fail("1.2.3.4")
+		}
+		if _, locked := l.Locked("1.2.3.4"); !locked {
+			t.Fatal("not locked after 3 failures")
+		}
+		advance(want)
+		if _, locked := l.Locked("1.2.3.4"); locked {
+			t.Fatalf("lockout lasted past %v (until %v)", want, until)
+		}
+	}
+}
+
+func TestLockoutForgetsQuietKeys(t *testing.T) {
+	l, advance := newTestLockout()
+	for i := 0; i < 3; i++ {
+		l.Fail("1.2.3.4")
+	}
+	advance(lockoutMemory + time.Second)
+
+	// A day later the history is gone, so the next lockout is the first again
+	l.Fail("5.6.7.8")
+	if _, ok := l.entries["1.2.3.4"]; ok {
+		t.Fatal("quiet key still remembered")
+	}
+	for i := 0; i < 2; i++ {
+		l.Fail("1.2.3.4")
+	}
+	if until := l.Fail("1.2.3.4"); !until.Equal(testTime.Add(lockoutMemory + time.Second + time.Minute)) {
+		t.Errorf("lockout after a quiet day until %v, want a minute", until)
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	cases := []struct {
+		remoteAddr, want string
+	}{
+		{"1.2.3.4:5678", "1.2.3.4"},
+		{"[2001:db8::1]:443", "2001:db8::1"},
+		{"1.2.3.4", "1.2.3.4"},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest("POST", "/login/verify", nil)
+		r.RemoteAddr = c.remoteAddr
+		r.Header.Set("X-Forwarded-For", "9.9.9.9")
+		if got := ClientIP(r); got != c.want {
+			t.Errorf("ClientIP(%q) = %q, want %q", c.remoteAddr, got, c.want)
+		}
+	}
+}
@@ -8,18 +8,55 @@ import (
 var (
 	loginLimiters  = make(map[string]*rate.Limiter)
 	loginLimiterMu sync.Mutex
+
+	loginsPerDay = 20
+	loginBurst   = 20
 )
 
+// SetLoginLimit changes the per-phone login allowance for limiters created afterwards.
+func SetLoginLimit(perDay, burst int) {
+	loginLimiterMu.Lock()
+	defer loginLimiterMu.Unlock()
+	loginsPerDay, loginBurst = perDay, burst
+}
+
 // GetLoginLimiter returns the rate limiter for a given phone, creating one if needed.
-// It allows 20 requests per day (20/86400 tokens per second) with a burst capacity of 20.
+// By default it allows 20 requests per day (20/86400 tokens per second) with a burst capacity of 20.
 func GetLoginLimiter(phone string) *rate.Limiter {
 	loginLimiterMu.Lock()
 	defer loginLimiterMu.Unlock()
 	limiter, exists := loginLimiters[phone]
 	if !exists {
-		// 20 tokens per day equals 20/86400 tokens per second.
-		limiter = rate.NewLimiter(rate.Limit(20.0/86400.0), 20)
+		// N tokens per day equals N/86400 tokens per second.
+		limiter = rate.NewLimiter(rate.Limit(float64(loginsPerDay)/86400.0), loginBurst)
 		loginLimiters[phone] = limiter
 	}
 	return limiter
 }
+
+var (
+	apiKeyLimiters  = make(map[string]*rate.Limiter)
+	apiKeyLimiterMu sync.Mutex
+
+	apiKeyPerMinute = 60
+	apiKeyBurst     = 20
+)
+
+// SetAPIKeyLimit changes the per-key request allowance for limiters created afterwards.
+func SetAPIKeyLimit(perMinute, burst int) {
+	apiKeyLimiterMu.Lock()
+	defer apiKeyLimiterMu.Unlock()
+	apiKeyPerMinute, apiKeyBurst = perMinute, burst
+}
+
+// GetAPIKeyLimiter returns the rate limiter for an API key, creating one if needed.
+func GetAPIKeyLimiter(keyID string) *rate.Limiter {
+	apiKeyLimiterMu.Lock()
+	defer apiKeyLimiterMu.Unlock()
+	limiter, exists := apiKeyLimiters[keyID]
+	if !exists {
+		limiter = rate.NewLimiter(rate.Limit(float64(apiKeyPerMinute)/60.0), apiKeyBurst)
+		apiKeyLimiters[keyID] = limiter
+	}
+	return limiter
+}